@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -22,7 +23,7 @@ import (
 // @description
 // @description     ## System Architecture
 // @description     LoKey consists of three microservices:
-// @description     - **Controller Service**: Interfaces with the ATECC608A chip to harvest true random numbers and process SHA-256 hashes
+// @description     - **Controller Service**: Interfaces with the ATECC608A chip to harvest true random numbers, optionally conditioned with SHA-256 on the chip
 // @description     - **Fortuna Service**: Amplifies the entropy using the Fortuna algorithm for enhanced randomness
 // @description     - **API Service**: Provides endpoints for configuration and both raw TRNG and Fortuna-amplified data retrieval
 // @description
@@ -115,6 +116,16 @@ func main() {
 	}
 	fortunaPollInterval := time.Duration(fortunaPollIntervalMs) * time.Millisecond
 
+	requireSigned := false
+	if val, ok := os.LookupEnv("REQUIRE_SIGNED_TRNG"); ok && val != "" {
+		parsed, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Printf("Invalid REQUIRE_SIGNED_TRNG, using default: false")
+		} else {
+			requireSigned = parsed
+		}
+	}
+
 	// Initialize database using the factory function
 	db, err := database.NewDBHandler(dbPath, trngQueueSize, fortunaQueueSize)
 	if err != nil {
@@ -124,6 +135,8 @@ func main() {
 
 	// Create API server
 	server := api.NewServer(db, controllerAddr, fortunaAddr, port)
+	server.SetRequireSignedTRNG(requireSigned)
+	server.SetSeedToken(os.Getenv("SEED_TOKEN"))
 
 	// Create context for polling that can be cancelled
 	ctx, cancel := context.WithCancel(context.Background())
@@ -154,6 +167,7 @@ func main() {
 	log.Printf("  Fortuna Queue Size: %d", fortunaQueueSize)
 	log.Printf("  TRNG Poll Interval: %s", trngPollInterval)
 	log.Printf("  Fortuna Poll Interval: %s", fortunaPollInterval)
+	log.Printf("  Require Signed TRNG: %t", requireSigned)
 
 	if err := server.Run(); err != nil {
 		log.Fatalf("API server error: %v", err)
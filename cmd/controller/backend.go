@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/atecc608a"
+	"github.com/lokey/rng-service/pkg/healthtest"
+	"github.com/lokey/rng-service/pkg/hwrng"
+	"github.com/lokey/rng-service/pkg/jitter"
+)
+
+// Entropy backend types, as listed in ENTROPY_BACKENDS
+const (
+	BackendATECC608A = "atecc608a"
+	BackendHWRNG     = "hwrng"
+	BackendJitter    = "jitter"
+)
+
+// Assurance levels of backends, as reported in /health and /info
+const (
+	AssuranceHardware = "hardware" // a physical noise source
+	AssuranceLower    = "lower"    // software entropy; only used when no other backend is healthy
+	AssuranceEmulated = "emulated" // not an entropy source, for development only
+)
+
+// DefaultBackends is used when ENTROPY_BACKENDS is not set
+const DefaultBackends = BackendATECC608A
+
+// EntropyBackend is a source of random data the service serves /generate
+// from. Every backend returns blocks that passed the SP 800-90B continuous
+// health tests, and reports its state for /health and /info.
+type EntropyBackend interface {
+	// Name identifies the backend in logs and responses, e.g. "1:0x60" or "/dev/hwrng"
+	Name() string
+	// Type is the kind of backend, e.g. "atecc608a" or "hwrng"
+	Type() string
+	// Assurance is how far the output can be trusted to be unpredictable
+	Assurance() string
+
+	// Output
+	GenerateRandom() ([]byte, error)
+
+	// Health and statistics
+	HealthCheck() bool
+	State() string
+	HealthTestStats() healthtest.Stats
+	// Details returns backend-specific fields for /info and for unhealthy backends in /health
+	Details() gin.H
+
+	Close() error
+}
+
+// sourceName is the entropy source a backend's output is reported as in
+// /generate, which Fortuna credits by name. An emulated ATECC608A is reported
+// as "emulator", so its output is never taken for chip output.
+func sourceName(b EntropyBackend) string {
+	if b.Assurance() == AssuranceEmulated {
+		return "emulator"
+	}
+	return b.Type()
+}
+
+// ateccBackend serves an ATECC608A through the backend interface. Its
+// controller is also used directly by the ATECC608A-specific endpoints.
+type ateccBackend struct {
+	*atecc608a.Controller
+	address  atecc608a.DeviceAddress
+	emulated bool
+}
+
+func (b *ateccBackend) Type() string {
+	return BackendATECC608A
+}
+
+func (b *ateccBackend) Assurance() string {
+	if b.emulated {
+		return AssuranceEmulated
+	}
+	return AssuranceHardware
+}
+
+func (b *ateccBackend) State() string {
+	return b.GetState().String()
+}
+
+func (b *ateccBackend) Details() gin.H {
+	quarantined, _ := b.Quarantined()
+	return gin.H{
+		"last_probe":          b.LastProbe(),
+		"self_test":           b.SelfTestResult(),
+		"errors":              b.ErrorCounts(),
+		"quarantined_samples": quarantined,
+		"conditioning":        b.Conditioning(),
+		"variant":             b.Variant(),
+	}
+}
+
+// hwrngBackend serves a Linux hardware RNG device through the backend interface
+type hwrngBackend struct {
+	*hwrng.Source
+}
+
+func (b *hwrngBackend) Name() string {
+	return b.Path()
+}
+
+func (b *hwrngBackend) Type() string {
+	return BackendHWRNG
+}
+
+func (b *hwrngBackend) Assurance() string {
+	return AssuranceHardware
+}
+
+func (b *hwrngBackend) HealthCheck() bool {
+	return b.IsHealthy()
+}
+
+func (b *hwrngBackend) State() string {
+	return string(b.Source.State())
+}
+
+func (b *hwrngBackend) Details() gin.H {
+	details := gin.H{
+		"failures":            b.Failures(),
+		"quarantined_samples": b.Quarantined(),
+	}
+	if err := b.LastError(); err != nil {
+		details["last_error"] = err.Error()
+	}
+	return details
+}
+
+// jitterBackend serves the CPU jitter entropy source through the backend interface
+type jitterBackend struct {
+	*jitter.Source
+}
+
+func (b *jitterBackend) Name() string {
+	return BackendJitter
+}
+
+func (b *jitterBackend) Type() string {
+	return BackendJitter
+}
+
+func (b *jitterBackend) Assurance() string {
+	return AssuranceLower
+}
+
+func (b *jitterBackend) HealthCheck() bool {
+	return b.IsHealthy()
+}
+
+func (b *jitterBackend) State() string {
+	return string(b.Source.State())
+}
+
+func (b *jitterBackend) Details() gin.H {
+	details := gin.H{
+		"warning":           "software entropy from CPU timing jitter, lower assurance than a hardware TRNG",
+		"conditioning":      "sha256",
+		"samples_per_block": b.SamplesPerBlock(),
+		"raw_samples":       b.RawSamples(),
+		"failures":          b.Failures(),
+	}
+	if err := b.LastError(); err != nil {
+		details["last_error"] = err.Error()
+	}
+	return details
+}
+
+// backendTypesFromEnv returns the backend types listed in ENTROPY_BACKENDS,
+// e.g. "atecc608a,hwrng"
+func backendTypesFromEnv() ([]string, error) {
+	list := DefaultBackends
+	if val, ok := os.LookupEnv("ENTROPY_BACKENDS"); ok && val != "" {
+		list = val
+	}
+
+	var types []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		switch entry {
+		case BackendATECC608A, BackendHWRNG, BackendJitter:
+		default:
+			return nil, fmt.Errorf("unknown entropy backend %q in ENTROPY_BACKENDS", entry)
+		}
+		if seen[entry] {
+			return nil, fmt.Errorf("entropy backend %q listed more than once", entry)
+		}
+		seen[entry] = true
+		types = append(types, entry)
+	}
+
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no entropy backends in ENTROPY_BACKENDS %q", list)
+	}
+	return types, nil
+}
+
+// openHWRNG opens the hardware RNG at HWRNG_PATH, with the health test cutoffs
+// set by its claimed min-entropy in HWRNG_MIN_ENTROPY
+func openHWRNG() (EntropyBackend, error) {
+	path := hwrng.DefaultPath
+	if val, ok := os.LookupEnv("HWRNG_PATH"); ok && val != "" {
+		path = val
+	}
+
+	minEntropy := healthtest.DefaultMinEntropy
+	if val, ok := os.LookupEnv("HWRNG_MIN_ENTROPY"); ok {
+		if h, err := strconv.ParseFloat(val, 64); err == nil && h > 0 && h <= healthtest.MaxMinEntropy {
+			minEntropy = h
+		} else {
+			log.Printf("[WARN] Invalid HWRNG_MIN_ENTROPY %q, using default: %g", val, healthtest.DefaultMinEntropy)
+		}
+	}
+
+	source, err := hwrng.Open(path, minEntropy)
+	if err != nil {
+		return nil, err
+	}
+	return &hwrngBackend{Source: source}, nil
+}
+
+// openJitter starts the CPU jitter entropy source, with the health test
+// cutoffs and conditioning ratio set by JITTER_MIN_ENTROPY
+func openJitter() (EntropyBackend, error) {
+	minEntropy := jitter.DefaultMinEntropy
+	if val, ok := os.LookupEnv("JITTER_MIN_ENTROPY"); ok {
+		if h, err := strconv.ParseFloat(val, 64); err == nil && h > 0 && h <= healthtest.MaxMinEntropy {
+			minEntropy = h
+		} else {
+			log.Printf("[WARN] Invalid JITTER_MIN_ENTROPY %q, using default: %g", val, jitter.DefaultMinEntropy)
+		}
+	}
+
+	source, err := jitter.New(minEntropy)
+	if err != nil {
+		return nil, err
+	}
+	return &jitterBackend{Source: source}, nil
+}
+
+// openBackends opens the backends of the given types. A backend whose
+// start-up self-test fails is still returned, so /health can report it;
+// configuration and open errors close everything opened so far.
+func openBackends(types []string, emulate bool) ([]EntropyBackend, error) {
+	var backends []EntropyBackend
+	closeAll := func() {
+		for _, backend := range backends {
+			_ = backend.Close()
+		}
+	}
+
+	for _, backendType := range types {
+		switch backendType {
+		case BackendATECC608A:
+			devices, err := openATECCDevices(emulate)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			backends = append(backends, devices...)
+		case BackendHWRNG:
+			backend, err := openHWRNG()
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			backends = append(backends, backend)
+		case BackendJitter:
+			backend, err := openJitter()
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			backends = append(backends, backend)
+		}
+	}
+
+	return backends, nil
+}
+
+// openATECCDevices initializes the ATECC608A devices from I2C_DEVICES in
+// parallel, each runs its own start-up self-test
+func openATECCDevices(emulate bool) ([]EntropyBackend, error) {
+	addresses, err := addressesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*atecc608a.Controller, len(addresses))
+	errs := make([]error, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if emulate {
+				devices[i], errs[i] = newEmulatedDevice(address)
+			} else {
+				devices[i], errs[i] = atecc608a.NewControllerAt(address)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			for _, device := range devices {
+				if device != nil {
+					_ = device.Close()
+				}
+			}
+			return nil, fmt.Errorf("failed to initialize ATECC608A %s: %w", addresses[i], err)
+		}
+	}
+
+	backends := make([]EntropyBackend, len(devices))
+	for i, device := range devices {
+		backends[i] = &ateccBackend{Controller: device, address: addresses[i], emulated: emulate}
+	}
+	return backends, nil
+}
+
+// newEmulator returns an emulated chip in its factory state. It reports the
+// Info revision in I2C_EMULATOR_REVISION, e.g. 00006003 for an ATECC608B, or
+// that of an ATECC608A by default.
+func newEmulator() (*atecc608a.Emulator, error) {
+	emulator := atecc608a.NewEmulator()
+	if val := os.Getenv("I2C_EMULATOR_REVISION"); val != "" {
+		revision, err := hex.DecodeString(val)
+		if err != nil || len(revision) != 4 {
+			return nil, fmt.Errorf("invalid I2C_EMULATOR_REVISION %q, must be 4 bytes in hex", val)
+		}
+		emulator.SetRevision([4]byte(revision))
+	}
+	return emulator, nil
+}
+
+// newEmulatedDevice runs the controller against an in-memory ATECC608A that is
+// already configured and locked, so the service works without I2C hardware
+func newEmulatedDevice(address atecc608a.DeviceAddress) (*atecc608a.Controller, error) {
+	emulator, err := newEmulator()
+	if err != nil {
+		return nil, err
+	}
+	if err := emulator.Provision(atecc608a.CFG_TLS); err != nil {
+		return nil, fmt.Errorf("failed to provision emulator: %w", err)
+	}
+
+	return atecc608a.NewControllerWithBus(address.String(), func() (atecc608a.Bus, error) {
+		return emulator, nil
+	})
+}
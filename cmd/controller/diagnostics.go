@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/atecc608a"
+)
+
+// DefaultCaptureSamples is the number of samples "controller capture" records,
+// the minimum an SP 800-90B assessment of a noise source needs
+const DefaultCaptureSamples = 1000000
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// runScan implements "controller scan". It probes every address on a bus and
+// reports which ones answered, and which of those are CryptoAuth chips.
+func runScan(args []string) error {
+	addresses, err := addressesFromEnv()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	busNumber := flags.Int("bus", addresses[0].Bus, "I2C bus number (default: the bus of the first configured device)")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var results []atecc608a.ScanResult
+	if os.Getenv("I2C_EMULATOR") == "true" {
+		// Emulated chips answer at the configured addresses on the bus, nothing else does
+		configured := make(map[uint8]bool)
+		for _, address := range addresses {
+			if address.Bus == *busNumber {
+				configured[address.Address] = true
+			}
+		}
+		results, err = atecc608a.Scan(func(address uint8) (atecc608a.Bus, error) {
+			emulator := atecc608a.NewEmulator()
+			if !configured[address] {
+				emulator.InjectFault(atecc608a.FaultNACK)
+			}
+			return emulator, nil
+		})
+	} else {
+		results, err = atecc608a.ScanI2CBus(*busNumber)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(results)
+	}
+
+	cryptoAuth := 0
+	for _, result := range results {
+		kind := "other device"
+		if result.CryptoAuth {
+			kind = "CryptoAuth device (wake token)"
+			cryptoAuth++
+		}
+		fmt.Printf("%d:0x%02x  %-30s  read %s\n", *busNumber, result.Address, kind, result.Response)
+	}
+	fmt.Printf("\n%d addresses answered on bus %d, %d of them CryptoAuth devices\n", len(results), *busNumber, cryptoAuth)
+
+	return nil
+}
+
+// runInfo implements "controller info". It prints the identity, lock status
+// and slot configuration of a device, and whether it matches its pin.
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	asJSON := flags.Bool("json", false, "print the identity as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	controller, err := openOfflineDevice(*device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+	pinned, isPinned, pinErr := controller.VerifyPin(identity)
+
+	if *asJSON {
+		info := gin.H{"device": controller.Name(), "identity": identity}
+		if isPinned {
+			info["pinned_identity"] = pinned
+			info["pin_matches"] = pinErr == nil
+		}
+		return printJSON(info)
+	}
+
+	fmt.Printf("Device:               %s\n", controller.Name())
+	fmt.Printf("Variant:              %s\n", identity.Variant)
+	fmt.Printf("Serial:               %s\n", identity.Serial)
+	fmt.Printf("Revision:             %s\n", identity.Revision)
+	fmt.Printf("Configured address:   %s\n", identity.I2CAddress)
+	fmt.Printf("Chip mode:            0x%02x\n", identity.ChipMode)
+	fmt.Printf("Config zone locked:   %t\n", identity.ConfigLocked)
+	fmt.Printf("Data zone locked:     %t\n", identity.DataLocked)
+
+	var mismatchErr *atecc608a.IdentityMismatchError
+	switch {
+	case errors.As(pinErr, &mismatchErr):
+		fmt.Printf("Identity pin:         MISMATCH, %v\n", pinErr)
+	case pinErr != nil:
+		fmt.Printf("Identity pin:         %v\n", pinErr)
+	case isPinned:
+		fmt.Printf("Identity pin:         matches, pinned %s\n", pinned.PinnedAt.Format(time.RFC3339))
+	default:
+		fmt.Println("Identity pin:         not pinned")
+	}
+
+	fmt.Printf("\n%4s  %-6s  %-6s  %-11s  %-6s  %s\n", "Slot", "Slot", "Key", "Key type", "Locked", "Flags")
+	for _, slot := range identity.Slots {
+		var notes []string
+		if slot.Private {
+			notes = append(notes, "private")
+		}
+		if slot.IsSecret {
+			notes = append(notes, "secret")
+		}
+		if slot.EncryptRead {
+			notes = append(notes, "encrypt_read")
+		}
+		if slot.ReqAuth {
+			notes = append(notes, fmt.Sprintf("auth_key=%d", slot.AuthKey))
+		}
+		fmt.Printf("%4d  %-6s  %-6s  %-11s  %-6t  %s\n", slot.Slot, slot.SlotConfig, slot.KeyConfig, slot.KeyType, slot.Locked, strings.Join(notes, " "))
+	}
+
+	return nil
+}
+
+// runSelfTest implements "controller selftest". It runs wake, Info, Random and
+// the start-up health tests and fails unless every step passes.
+func runSelfTest(args []string) error {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	controller, err := openOfflineDevice(*device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	steps, err := controller.Diagnose()
+	if err != nil {
+		return err
+	}
+
+	passed := len(steps) > 0
+	for _, step := range steps {
+		passed = passed && step.Passed
+	}
+
+	if *asJSON {
+		if err := printJSON(gin.H{"device": controller.Name(), "passed": passed, "steps": steps}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Self-test of %s\n\n", controller.Name())
+		for _, step := range steps {
+			result, detail := "PASS", step.Detail
+			if !step.Passed {
+				result, detail = "FAIL", step.Error
+			}
+			fmt.Printf("%-4s  %-12s  %8s  %s\n", result, step.Name, step.Duration.Round(time.Millisecond), detail)
+		}
+	}
+
+	if !passed {
+		return fmt.Errorf("device %s failed its self-test", controller.Name())
+	}
+	if !*asJSON {
+		fmt.Println("\nAll steps passed")
+	}
+	return nil
+}
+
+// runCapture implements "controller capture". It writes raw Random output to
+// a file for an offline SP 800-90B entropy assessment; the samples bypass the
+// health tests, so the file shows the source as it is.
+func runCapture(args []string) error {
+	flags := flag.NewFlagSet("capture", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	samples := flags.Int("samples", DefaultCaptureSamples, "number of 8-bit samples to record")
+	out := flags.String("out", "", "file to write the samples to; it must not exist yet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if *samples <= 0 {
+		return fmt.Errorf("-samples must be positive, got %d", *samples)
+	}
+
+	controller, err := openOfflineDevice(*device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	// Reading the identity loads the command timings of the chip variant, and
+	// refuses Random on a chip of no known variant
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+	if identity.Variant == atecc608a.VariantUnknown {
+		return fmt.Errorf("%w: revision %s", atecc608a.ErrUnsupportedVariant, identity.Revision)
+	}
+
+	// Never overwrite an earlier capture
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) // #nosec G304 - path given by the operator
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+
+	fmt.Printf("Capturing %d samples from %s (%s) to %s\n", *samples, controller.Name(), identity.Variant, *out)
+
+	start := time.Now()
+	reported := 0
+	stats, captureErr := controller.CaptureRaw(writer, *samples, func(written int) {
+		// Report every 10%
+		if percent := written * 10 / *samples; percent > reported {
+			reported = percent
+			fmt.Printf("  %3d%%  %d samples, %v\n", percent*10, written, time.Since(start).Round(time.Second))
+		}
+	})
+
+	if err := writer.Flush(); err != nil && captureErr == nil {
+		captureErr = err
+	}
+	if err := file.Close(); err != nil && captureErr == nil {
+		captureErr = err
+	}
+	if captureErr != nil {
+		return captureErr
+	}
+
+	fmt.Printf("\nWrote %d samples in %v\n", *samples, time.Since(start).Round(time.Second))
+	fmt.Printf("Health tests (not applied to the file): %d repetition count, %d adaptive proportion, %d repeated block failures\n",
+		stats.RCTFailures, stats.APTFailures, stats.BlockRepeats)
+	fmt.Printf("Longest run %d (cutoff %d), max proportion %d/%d (cutoff %d)\n",
+		stats.LongestRun, stats.RCTCutoff, stats.MaxProportion, stats.APTWindow, stats.APTCutoff)
+
+	return nil
+}
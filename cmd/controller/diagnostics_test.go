@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lokey/rng-service/pkg/atecc608a"
+)
+
+// runSubcommand runs a subcommand against emulated chips and returns what it printed
+func runSubcommand(t *testing.T, run func([]string) error, args ...string) (string, error) {
+	t.Helper()
+	t.Setenv("I2C_EMULATOR", "true")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	runErr := run(args)
+	_ = w.Close()
+	return <-output, runErr
+}
+
+func TestScanSubcommand(t *testing.T) {
+	t.Setenv("I2C_DEVICES", "1:0x60,1:0x62,2:0x61")
+
+	output, err := runSubcommand(t, runScan, "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []atecc608a.ScanResult
+	if err := json.Unmarshal([]byte(output), &results); err != nil {
+		t.Fatalf("output %q: %v", output, err)
+	}
+
+	// Only the devices configured on the scanned bus answer
+	if len(results) != 2 || results[0].Address != 0x60 || results[1].Address != 0x62 {
+		t.Fatalf("got %+v, want the devices at 0x60 and 0x62", results)
+	}
+	for _, result := range results {
+		if !result.CryptoAuth {
+			t.Errorf("0x%02x is not a CryptoAuth device", result.Address)
+		}
+	}
+
+	output, err = runSubcommand(t, runScan, "-bus", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "2:0x61") || !strings.Contains(output, "1 addresses answered on bus 2, 1 of them CryptoAuth devices") {
+		t.Errorf("scan of bus 2 printed %q", output)
+	}
+}
+
+func TestInfoSubcommand(t *testing.T) {
+	output, err := runSubcommand(t, runInfo, "-device", "1:0x60", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info struct {
+		Device   string                   `json:"device"`
+		Identity atecc608a.DeviceIdentity `json:"identity"`
+	}
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		t.Fatalf("output %q: %v", output, err)
+	}
+	if info.Device != "1:0x60" || info.Identity.Variant != atecc608a.VariantATECC608A || !info.Identity.ConfigLocked {
+		t.Errorf("info is %+v", info)
+	}
+
+	output, err = runSubcommand(t, runInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Serial:               " + info.Identity.Serial, "Config zone locked:   true", "Identity pin:         not pinned"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("info printed %q, want %q", output, want)
+		}
+	}
+}
+
+func TestSelfTestSubcommand(t *testing.T) {
+	output, err := runSubcommand(t, runSelfTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "All steps passed") || strings.Contains(output, "FAIL") {
+		t.Errorf("self-test printed %q", output)
+	}
+
+	// A chip of unknown revision fails at the info step
+	t.Setenv("I2C_EMULATOR_REVISION", "00006004")
+	output, err = runSubcommand(t, runSelfTest, "-json")
+	if err == nil {
+		t.Fatal("self-test of an unsupported chip passed")
+	}
+
+	var report struct {
+		Passed bool                       `json:"passed"`
+		Steps  []atecc608a.DiagnosticStep `json:"steps"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatalf("output %q: %v", output, err)
+	}
+	if report.Passed || len(report.Steps) == 0 || report.Steps[len(report.Steps)-1].Name != "info" {
+		t.Errorf("report is %+v, want a failure at the info step", report)
+	}
+}
+
+func TestCaptureSubcommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "samples.bin")
+
+	if _, err := runSubcommand(t, runCapture, "-samples", "100", "-out", out); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 100 {
+		t.Errorf("captured %d samples, want 100", info.Size())
+	}
+
+	// An earlier capture is never overwritten
+	if _, err := runSubcommand(t, runCapture, "-samples", "100", "-out", out); !errors.Is(err, os.ErrExist) {
+		t.Errorf("capture to an existing file returned %v, want %v", err, os.ErrExist)
+	}
+
+	for _, args := range [][]string{
+		{"-samples", "100"},
+		{"-samples", "0", "-out", out + ".2"},
+	} {
+		if _, err := runSubcommand(t, runCapture, args...); err == nil {
+			t.Errorf("capture %v succeeded", args)
+		}
+	}
+
+	t.Setenv("I2C_EMULATOR_REVISION", "00006004")
+	if _, err := runSubcommand(t, runCapture, "-samples", "100", "-out", out+".3"); !errors.Is(err, atecc608a.ErrUnsupportedVariant) {
+		t.Errorf("capture from an unsupported chip returned %v, want %v", err, atecc608a.ErrUnsupportedVariant)
+	}
+	if _, err := os.Stat(out + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("capture from an unsupported chip left a file: %v", err)
+	}
+}
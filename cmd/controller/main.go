@@ -3,17 +3,22 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lokey/rng-service/pkg/atecc608a"
+	"github.com/lokey/rng-service/pkg/signing"
 )
 
 const (
@@ -21,10 +26,15 @@ const (
 	DefaultI2CBusNumber = 1
 )
 
+// errNoHealthyBackend is returned when no configured backend can serve a request
+var errNoHealthyBackend = errors.New("no healthy entropy backend")
+
 type Controller struct {
-	device *atecc608a.Controller
-	port   int
-	router *gin.Engine
+	backends []EntropyBackend
+	next     atomic.Uint64 // rotates the backend /generate starts with
+	signing  *batchSigning
+	port     int
+	router   *gin.Engine
 }
 
 // customLogger only logs non-200 responses
@@ -58,13 +68,7 @@ func customLogger() gin.HandlerFunc {
 	}
 }
 
-func NewController(i2cBusNumber int, port int) (*Controller, error) {
-	// Initialize ATECC608A controller
-	device, err := atecc608a.NewController(i2cBusNumber)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize ATECC608A: %w", err)
-	}
-
+func NewController(backends []EntropyBackend, signingConfig *batchSigning, port int) (*Controller, error) {
 	// Initialize router based on log level
 	var router *gin.Engine
 	logLevel := os.Getenv("LOG_LEVEL")
@@ -80,17 +84,123 @@ func NewController(i2cBusNumber int, port int) (*Controller, error) {
 	}
 
 	return &Controller{
-		device: device,
-		port:   port,
-		router: router,
+		backends: backends,
+		signing:  signingConfig,
+		port:     port,
+		router:   router,
 	}, nil
 }
 
+// ateccDevices returns the ATECC608A backends, for the endpoints specific to the chip
+func (c *Controller) ateccDevices() []*ateccBackend {
+	var devices []*ateccBackend
+	for _, backend := range c.backends {
+		if device, ok := backend.(*ateccBackend); ok {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// healthyBackends returns the backends that can serve requests, starting at a
+// different backend on every call so single values spread over all of them.
+// Lower-assurance backends are only returned when no other backend is healthy.
+func (c *Controller) healthyBackends() []EntropyBackend {
+	var healthy, fallback []EntropyBackend
+	for _, backend := range c.backends {
+		switch {
+		case !backend.HealthCheck():
+		case backend.Assurance() == AssuranceLower:
+			fallback = append(fallback, backend)
+		default:
+			healthy = append(healthy, backend)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = fallback
+	}
+
+	if len(healthy) > 1 {
+		start := int(c.next.Add(1) % uint64(len(healthy)))
+		healthy = append(healthy[start:], healthy[:start]...)
+	}
+
+	return healthy
+}
+
+// generate draws count values from the healthy backends in parallel. Each
+// backend takes the next value as soon as it is done with the previous one, so
+// faster backends serve more. Values a backend failed on are handed to the
+// backends that are still working. It also returns the source names (see
+// sourceName) of the backends that served the values.
+func (c *Controller) generate(count int) ([][]byte, []string, error) {
+	devices := c.healthyBackends()
+
+	data := make([][]byte, count)
+	types := make([]string, count)
+	pending := make(chan int, count)
+	for i := range count {
+		pending <- i
+	}
+
+	var errs []error
+	for len(pending) > 0 {
+		if len(devices) == 0 {
+			return nil, nil, errors.Join(append([]error{errNoHealthyBackend}, errs...)...)
+		}
+
+		failed := make([]error, len(devices))
+		var wg sync.WaitGroup
+		for i, device := range devices[:min(len(devices), len(pending))] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					var index int
+					select {
+					case index = <-pending:
+					default:
+						return
+					}
+
+					value, err := device.GenerateRandom()
+					if err != nil {
+						// Give the value back; pending has room for every index
+						pending <- index
+						failed[i] = fmt.Errorf("%s: %w", device.Name(), err)
+						return
+					}
+					data[index] = value
+					types[index] = sourceName(device)
+				}
+			}()
+		}
+		wg.Wait()
+
+		working := devices[:0]
+		for i, device := range devices {
+			if failed[i] != nil {
+				errs = append(errs, failed[i])
+			} else {
+				working = append(working, device)
+			}
+		}
+		devices = working
+	}
+
+	// Report each backend type once
+	slices.Sort(types)
+	return data, slices.Compact(types), nil
+}
+
 func (c *Controller) setupRoutes() {
 	// API routes
 	c.router.GET("/health", c.healthCheckHandler)
 	c.router.GET("/info", c.infoHandler)
 	c.router.GET("/generate", c.generateHandler)
+	c.router.GET("/events", c.eventsHandler)
+	c.router.GET("/device", c.deviceHandler)
+	c.router.GET("/signing-keys", c.signingKeysHandler)
 }
 
 func (c *Controller) Start() error {
@@ -137,8 +247,10 @@ func (c *Controller) Start() error {
 	}
 
 	// Close resources
-	if err := c.device.Close(); err != nil {
-		log.Printf("[WARN] Error closing ATECC608A: %v", err)
+	for _, backend := range c.backends {
+		if err := backend.Close(); err != nil {
+			log.Printf("[WARN] Error closing %s %s: %v", backend.Type(), backend.Name(), err)
+		}
 	}
 
 	return nil
@@ -147,26 +259,100 @@ func (c *Controller) Start() error {
 // HTTP Handlers
 
 func (c *Controller) healthCheckHandler(ctx *gin.Context) {
-	healthy := c.device.HealthCheck()
-	if healthy {
-		ctx.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"timestamp": time.Now().Format(time.RFC3339),
-		})
-	} else {
-		ctx.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":    "unhealthy",
-			"timestamp": time.Now().Format(time.RFC3339),
-			"details": gin.H{
-				"device": c.device.HealthCheck(),
-			},
-		})
+	devices := make([]gin.H, 0, len(c.backends))
+	healthyDevices := 0
+	for _, backend := range c.backends {
+		healthy := backend.HealthCheck()
+		details := gin.H{
+			"device":    backend.Name(),
+			"type":      backend.Type(),
+			"assurance": backend.Assurance(),
+			"healthy":   healthy,
+			"state":     backend.State(),
+		}
+		if healthy {
+			healthyDevices++
+		} else {
+			for key, value := range backend.Details() {
+				details[key] = value
+			}
+		}
+		devices = append(devices, details)
+	}
+
+	// Serve as long as one backend is healthy
+	status, code := "healthy", http.StatusOK
+	if healthyDevices == 0 {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	} else if healthyDevices < len(c.backends) {
+		status = "degraded"
 	}
+
+	ctx.JSON(code, gin.H{
+		"status":          status,
+		"timestamp":       time.Now().Format(time.RFC3339),
+		"healthy_devices": healthyDevices,
+		"devices":         devices,
+	})
 }
 
 func (c *Controller) infoHandler(ctx *gin.Context) {
+	devices := make([]gin.H, 0, len(c.backends))
+	for _, backend := range c.backends {
+		details := backend.Details()
+		details["device"] = backend.Name()
+		details["type"] = backend.Type()
+		details["assurance"] = backend.Assurance()
+		details["device_state"] = backend.State()
+		details["health_tests"] = backend.HealthTestStats()
+		devices = append(devices, details)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "running",
+		"devices": devices,
+		"signing": c.signing.info(),
+	})
+}
+
+// eventsHandler returns the recent state transitions of every ATECC608A, oldest first
+func (c *Controller) eventsHandler(ctx *gin.Context) {
+	devices := make([]gin.H, 0, len(c.backends))
+	for _, device := range c.ateccDevices() {
+		devices = append(devices, gin.H{
+			"device": device.Name(),
+			"state":  device.GetState(),
+			"events": device.Events(),
+		})
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"status": "running",
+		"devices": devices,
+	})
+}
+
+// deviceHandler returns the identity, revision, lock status and decoded slot
+// configuration of every ATECC608A, as read when it was last initialized
+func (c *Controller) deviceHandler(ctx *gin.Context) {
+	devices := make([]gin.H, 0, len(c.backends))
+	for _, device := range c.ateccDevices() {
+		details := gin.H{
+			"device":  device.Name(),
+			"bus":     device.address.Bus,
+			"address": fmt.Sprintf("0x%02x", device.address.Address),
+			"state":   device.GetState(),
+		}
+		if identity, ok := device.Identity(); ok {
+			details["identity"] = identity
+		}
+		if pinned, ok := device.PinnedIdentity(); ok {
+			details["pinned_identity"] = pinned
+		}
+		devices = append(devices, details)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"devices": devices,
 	})
 }
 
@@ -179,34 +365,42 @@ func (c *Controller) generateHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Generate multiple raw random data if requested
-	data := make([]string, 0, count)
+	// Generate raw random data across the healthy backends, or as one batch
+	// signed by the chip that produced it
+	var values [][]byte
+	var backends []string
+	var batch signing.SignedBatch
+	if c.signing.enabled() {
+		values, backends, batch, err = c.generateSigned(count)
+	} else {
+		values, backends, err = c.generate(count)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to generate random data: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate random data"})
+		return
+	}
 
-	for i := 0; i < count; i++ {
-		// Call GenerateRandom() to get raw random data
-		randomData, err := c.device.GenerateRandom()
-		if err != nil {
-			log.Printf("[ERROR] Failed to generate random data: %v", err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate random data"})
-			return
-		}
+	data := make([]string, 0, count)
+	for _, randomData := range values {
 		data = append(data, hex.EncodeToString(randomData))
 	}
 
 	// Return single data or array based on count
+	response := gin.H{"data": data, "backends": backends}
 	if count == 1 {
-		ctx.JSON(http.StatusOK, gin.H{
-			"data": data[0],
-		})
-	} else {
-		ctx.JSON(http.StatusOK, gin.H{
-			"data": data,
-		})
+		response["data"] = data[0]
+	}
+	if c.signing.enabled() {
+		response["signed_batch"] = batch
 	}
+	ctx.JSON(http.StatusOK, response)
 }
 
-func main() {
-	// Read configuration from environment variables
+// addressesFromEnv returns the devices listed in I2C_DEVICES as <bus>:<address>
+// pairs, e.g. "1:0x60,1:0x61". Without it a single chip at the default address
+// on I2C_BUS_NUMBER is used.
+func addressesFromEnv() ([]atecc608a.DeviceAddress, error) {
 	i2cBusNumber := DefaultI2CBusNumber
 	if val, ok := os.LookupEnv("I2C_BUS_NUMBER"); ok {
 		if n, err := fmt.Sscanf(val, "%d", &i2cBusNumber); n != 1 || err != nil {
@@ -215,6 +409,41 @@ func main() {
 		}
 	}
 
+	if val, ok := os.LookupEnv("I2C_DEVICES"); ok && val != "" {
+		addresses, err := atecc608a.ParseDeviceAddresses(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid I2C_DEVICES: %w", err)
+		}
+		return addresses, nil
+	}
+
+	return []atecc608a.DeviceAddress{{Bus: i2cBusNumber, Address: atecc608a.DefaultI2CAddress}}, nil
+}
+
+// subcommands are the maintenance commands of the controller binary, which
+// talk to a device directly and must not run next to the service
+var subcommands = map[string]func(args []string) error{
+	"provision": runProvision, // configure and lock a chip
+	"approve":   runApprove,   // pin a replacement chip after an identity mismatch
+	"scan":      runScan,      // probe the addresses on a bus
+	"info":      runInfo,      // print identity and lock status
+	"selftest":  runSelfTest,  // step-by-step pass/fail report
+	"capture":   runCapture,   // record raw samples for an entropy assessment
+	"genkey":    runGenKey,    // print or create the signing key of a slot
+}
+
+func main() {
+	// Maintenance subcommands run instead of the service, while it is stopped
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("[ERROR] controller %s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	// Read configuration from environment variables
 	port := DefaultPort
 	if val, ok := os.LookupEnv("PORT"); ok {
 		if n, err := fmt.Sscanf(val, "%d", &port); n != 1 || err != nil {
@@ -223,8 +452,26 @@ func main() {
 		}
 	}
 
+	types, err := backendTypesFromEnv()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// I2C_EMULATOR=true replaces the chips with emulators, e.g. for CI
+	emulate := os.Getenv("I2C_EMULATOR") == "true"
+
+	backends, err := openBackends(types, emulate)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to open entropy backends: %v", err)
+	}
+
+	signingConfig, err := signingFromEnv()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
 	// Create and start controller
-	controller, err := NewController(i2cBusNumber, port)
+	controller, err := NewController(backends, signingConfig, port)
 	if err != nil {
 		log.Fatalf("[ERROR] Failed to create controller: %v", err)
 	}
@@ -232,7 +479,19 @@ func main() {
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel == "DEBUG" || logLevel == "INFO" || logLevel == "" {
 		log.Printf("[INFO] Starting TRNG controller with configuration:")
-		log.Printf("[INFO]   I2C Bus Number: %d", i2cBusNumber)
+		for _, backend := range backends {
+			log.Printf("[INFO]   %s backend: %s", backend.Type(), backend.Name())
+		}
+		if emulate && slices.Contains(types, BackendATECC608A) {
+			log.Printf("[WARN]   I2C_EMULATOR is set: serving data from an emulated ATECC608A, not a hardware TRNG")
+		}
+		if slices.Contains(types, BackendJitter) {
+			log.Printf("[WARN]   jitter backend enabled: software entropy of lower assurance, used only when no other backend is healthy")
+		}
+		log.Printf("[INFO]   Batch signing: %s", signingConfig.mode)
+		if signingConfig.mode == SigningSoftware {
+			log.Printf("[WARN]   batches are signed with a software key: the signatures do not prove which device produced the data")
+		}
 		log.Printf("[INFO]   Port: %d", port)
 		log.Printf("[INFO]   Log Level: %s", logLevel)
 	}
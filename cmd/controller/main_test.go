@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/atecc608a"
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+// fakeBackend serves numbered blocks and fails once it has served failAfter of them
+type fakeBackend struct {
+	name      string
+	assurance string
+	healthy   bool
+	failAfter int // 0 never fails
+	delay     time.Duration
+
+	mutex  sync.Mutex
+	served int
+}
+
+func (b *fakeBackend) Name() string                      { return b.name }
+func (b *fakeBackend) Type() string                      { return b.name }
+func (b *fakeBackend) Assurance() string                 { return b.assurance }
+func (b *fakeBackend) HealthCheck() bool                 { return b.healthy }
+func (b *fakeBackend) State() string                     { return "healthy" }
+func (b *fakeBackend) HealthTestStats() healthtest.Stats { return healthtest.Stats{} }
+func (b *fakeBackend) Details() gin.H                    { return nil }
+func (b *fakeBackend) Close() error                      { return nil }
+
+func (b *fakeBackend) GenerateRandom() ([]byte, error) {
+	time.Sleep(b.delay)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failAfter > 0 && b.served == b.failAfter {
+		return nil, errors.New("device failed")
+	}
+	b.served++
+	return []byte(fmt.Sprintf("%s-%d", b.name, b.served)), nil
+}
+
+func (b *fakeBackend) count() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.served
+}
+
+func newFakeBackend(name string) *fakeBackend {
+	return &fakeBackend{name: name, assurance: AssuranceHardware, healthy: true, delay: time.Millisecond}
+}
+
+func newTestController(backends ...EntropyBackend) *Controller {
+	return &Controller{backends: backends}
+}
+
+// emulatedDevice returns an ATECC608A backend on a provisioned emulator
+func emulatedDevice(t *testing.T, address uint8) (*ateccBackend, *atecc608a.Emulator) {
+	t.Helper()
+
+	emulator := atecc608a.NewEmulator()
+	if err := emulator.Provision(atecc608a.CFG_TLS); err != nil {
+		t.Fatal(err)
+	}
+	deviceAddress := atecc608a.DeviceAddress{Bus: 1, Address: address}
+	controller, err := atecc608a.NewControllerWithBus(deviceAddress.String(), func() (atecc608a.Bus, error) {
+		return emulator, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = controller.Close() })
+
+	return &ateccBackend{Controller: controller, address: deviceAddress}, emulator
+}
+
+func TestHealthyBackends(t *testing.T) {
+	a, b, c := newFakeBackend("a"), newFakeBackend("b"), newFakeBackend("c")
+	lower := newFakeBackend("jitter")
+	lower.assurance = AssuranceLower
+	controller := newTestController(a, lower, b, c)
+
+	// Each call starts at the next backend; the lower-assurance one is held back
+	starts := make(map[string]int)
+	for range 6 {
+		healthy := controller.healthyBackends()
+		if len(healthy) != 3 {
+			t.Fatalf("got %d healthy backends, want 3", len(healthy))
+		}
+		starts[healthy[0].Name()]++
+	}
+	if starts["a"] != 2 || starts["b"] != 2 || starts["c"] != 2 {
+		t.Errorf("calls started at %v, want each backend twice", starts)
+	}
+
+	// Unhealthy backends are left out
+	b.healthy = false
+	for _, backend := range controller.healthyBackends() {
+		if backend == EntropyBackend(b) || backend == EntropyBackend(lower) {
+			t.Errorf("%s was returned", backend.Name())
+		}
+	}
+
+	// The lower-assurance backend is used once nothing else is healthy
+	a.healthy, c.healthy = false, false
+	if healthy := controller.healthyBackends(); len(healthy) != 1 || healthy[0] != EntropyBackend(lower) {
+		t.Errorf("got %v, want only the lower-assurance backend", healthy)
+	}
+
+	lower.healthy = false
+	if healthy := controller.healthyBackends(); len(healthy) != 0 {
+		t.Errorf("got %v with no healthy backend", healthy)
+	}
+}
+
+func TestGenerateSpreadsLoad(t *testing.T) {
+	a, b, c := newFakeBackend("a"), newFakeBackend("b"), newFakeBackend("c")
+	controller := newTestController(a, b, c)
+
+	data, sources, err := controller.generate(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 60 {
+		t.Fatalf("got %d values, want 60", len(data))
+	}
+	seen := make(map[string]bool)
+	for i, value := range data {
+		if value == nil || seen[string(value)] {
+			t.Fatalf("value %d is %q", i, value)
+		}
+		seen[string(value)] = true
+	}
+
+	// The backends draw values in parallel, so each serves a share
+	for _, backend := range []*fakeBackend{a, b, c} {
+		if backend.count() == 0 {
+			t.Errorf("%s served no values", backend.name)
+		}
+	}
+	if a.count()+b.count()+c.count() != 60 {
+		t.Errorf("backends served %d values, want 60", a.count()+b.count()+c.count())
+	}
+	if len(sources) != 3 || sources[0] != "a" || sources[1] != "b" || sources[2] != "c" {
+		t.Errorf("sources are %v, want [a b c]", sources)
+	}
+
+	// Single values rotate over the backends
+	before := []int{a.count(), b.count(), c.count()}
+	for range 3 {
+		if _, _, err := controller.generate(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, backend := range []*fakeBackend{a, b, c} {
+		if backend.count() != before[i]+1 {
+			t.Errorf("%s served %d of 3 single values", backend.name, backend.count()-before[i])
+		}
+	}
+}
+
+func TestGenerateFailover(t *testing.T) {
+	a, b := newFakeBackend("a"), newFakeBackend("b")
+	a.failAfter = 2
+	controller := newTestController(a, b)
+
+	data, sources, err := controller.generate(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range data {
+		if value == nil {
+			t.Fatalf("value %d was not filled after a backend failed", i)
+		}
+	}
+	if a.count() > 2 || a.count()+b.count() != 20 {
+		t.Errorf("a served %d and b %d values, want b to take over", a.count(), b.count())
+	}
+	if len(sources) == 0 || sources[len(sources)-1] != "b" {
+		t.Errorf("sources are %v", sources)
+	}
+}
+
+func TestGenerateAllBackendsFail(t *testing.T) {
+	a, b := newFakeBackend("a"), newFakeBackend("b")
+	a.failAfter, b.failAfter = 1, 1
+	controller := newTestController(a, b)
+
+	_, _, err := controller.generate(10)
+	if !errors.Is(err, errNoHealthyBackend) {
+		t.Fatalf("got %v, want %v", err, errNoHealthyBackend)
+	}
+	for _, name := range []string{"a: device failed", "b: device failed"} {
+		if !slices.Contains(strings.Split(err.Error(), "\n"), name) {
+			t.Errorf("error %q does not report %q", err, name)
+		}
+	}
+
+	if _, _, err := newTestController().generate(1); !errors.Is(err, errNoHealthyBackend) {
+		t.Errorf("without backends got %v, want %v", err, errNoHealthyBackend)
+	}
+}
+
+func TestGenerateFailoverBetweenEmulatedDevices(t *testing.T) {
+	first, firstEmulator := emulatedDevice(t, 0x60)
+	second, _ := emulatedDevice(t, 0x61)
+	controller := newTestController(first, second)
+
+	data, sources, err := controller.generate(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 8 || len(sources) != 1 || sources[0] != BackendATECC608A {
+		t.Fatalf("got %d values from %v", len(data), sources)
+	}
+
+	// A chip that stops answering is failed over to the other one; one of
+	// two single-value requests starts at the first chip
+	firstEmulator.InjectFault(atecc608a.FaultNACK)
+	for range 2 {
+		data, _, err = controller.generate(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data[0]) != 32 {
+			t.Errorf("got %x", data[0])
+		}
+	}
+	if first.IsHealthy() {
+		t.Error("the failed chip is still healthy")
+	}
+	if healthy := controller.healthyBackends(); len(healthy) != 1 || healthy[0] != EntropyBackend(second) {
+		t.Errorf("healthy backends are %v, want the second chip", healthy)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/atecc608a"
+)
+
+// openOfflineDevice opens a single device for maintenance while the service is
+// stopped. With I2C_EMULATOR=true it opens an emulator instead, factory-fresh
+// or, with provisioned, configured and locked like the one the service uses.
+func openOfflineDevice(device string, provisioned bool) (*atecc608a.Controller, error) {
+	var address atecc608a.DeviceAddress
+	if device != "" {
+		addresses, err := atecc608a.ParseDeviceAddresses(device)
+		if err != nil {
+			return nil, err
+		}
+		if len(addresses) != 1 {
+			return nil, fmt.Errorf("expected a single device, got %d", len(addresses))
+		}
+		address = addresses[0]
+	} else {
+		addresses, err := addressesFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		address = addresses[0]
+	}
+
+	if os.Getenv("I2C_EMULATOR") == "true" {
+		emulator, err := newEmulator()
+		if err != nil {
+			return nil, err
+		}
+		if provisioned {
+			if err := emulator.Provision(atecc608a.CFG_TLS); err != nil {
+				return nil, fmt.Errorf("failed to provision emulator: %w", err)
+			}
+		}
+		return atecc608a.NewOfflineController(address.String(), func() (atecc608a.Bus, error) {
+			return emulator, nil
+		})
+	}
+
+	return atecc608a.NewOfflineController(address.String(), func() (atecc608a.Bus, error) {
+		return atecc608a.OpenI2CBus(address.Bus, address.Address)
+	})
+}
+
+// confirm returns the confirmation phrase given with -confirm, or asks for it on the terminal
+func confirm(given, phrase, action string) (string, error) {
+	if given != "" {
+		return given, nil
+	}
+
+	fmt.Printf("\nThis will %s. This cannot be undone.\n", action)
+	fmt.Printf("Type %q to continue: ", phrase)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no confirmation read: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// runProvision implements "controller provision". It shows how a profile
+// differs from the configuration zone of a device; with -apply and the
+// confirmation phrase it writes the profile and locks the configuration zone.
+// With -lock-data it locks the data zone of a provisioned device instead.
+func runProvision(args []string) error {
+	flags := flag.NewFlagSet("provision", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	profileName := flags.String("profile", "tls", "built-in profile name or path of a profile file")
+	apply := flags.Bool("apply", false, "write and lock; without it nothing is written")
+	lockData := flags.Bool("lock-data", false, "lock the data zone of a device whose configuration zone is locked")
+	confirmation := flags.String("confirm", "", "confirmation phrase; asked for on the terminal when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	controller, err := openOfflineDevice(*device, false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+
+	fmt.Printf("Device %s: %s, serial %s, revision %s\n", controller.Name(), identity.Variant, identity.Serial, identity.Revision)
+	fmt.Printf("Configuration zone locked: %t, data zone locked: %t\n", identity.ConfigLocked, identity.DataLocked)
+
+	if *lockData {
+		return provisionDataLock(controller, identity, *apply, *confirmation)
+	}
+
+	profile, err := atecc608a.LoadProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Profile %s: %s\n", profile.Name, profile.Description)
+	variantErr := profile.CheckVariant(identity.Variant)
+	if variantErr != nil {
+		fmt.Printf("Warning: %v\n", variantErr)
+	}
+	fmt.Println()
+
+	current, err := hex.DecodeString(identity.Config)
+	if err != nil {
+		return err
+	}
+	printConfigDiff(profile.Diff(current))
+	if err := profile.Validate(current); err != nil {
+		return err
+	}
+
+	if identity.ConfigLocked {
+		fmt.Println("\nThe configuration zone is already locked, nothing can be written.")
+		if *apply {
+			return atecc608a.ErrConfigLocked
+		}
+		return nil
+	}
+
+	fmt.Printf("\nLock CRC of the resulting configuration zone: %04x\n", profile.LockCRC(current))
+
+	if !*apply {
+		fmt.Println("Dry run, nothing was written. Run again with -apply to write the profile and lock the configuration zone.")
+		return nil
+	}
+	if variantErr != nil {
+		return variantErr
+	}
+
+	phrase, err := confirm(*confirmation, atecc608a.ConfigLockPhrase(identity.Serial),
+		fmt.Sprintf("write profile %s to chip %s and lock its configuration zone", profile.Name, identity.Serial))
+	if err != nil {
+		return err
+	}
+
+	if err := controller.ProvisionConfig(profile, phrase); err != nil {
+		return err
+	}
+
+	fmt.Println("Configuration written and locked. Lock the data zone as a separate step with -lock-data if the slots are final.")
+	return nil
+}
+
+// provisionDataLock is the optional second provisioning step
+func provisionDataLock(controller *atecc608a.Controller, identity atecc608a.DeviceIdentity, apply bool, confirmation string) error {
+	switch {
+	case !identity.ConfigLocked:
+		return atecc608a.ErrConfigUnlocked
+	case identity.DataLocked:
+		fmt.Println("The data zone is already locked.")
+		if apply {
+			return atecc608a.ErrDataLocked
+		}
+		return nil
+	case !apply:
+		fmt.Println("Dry run, nothing was written. Run again with -apply to lock the data zone.")
+		return nil
+	}
+
+	phrase, err := confirm(confirmation, atecc608a.DataLockPhrase(identity.Serial),
+		fmt.Sprintf("lock the data zone of chip %s; slot contents can then only change as their configuration allows", identity.Serial))
+	if err != nil {
+		return err
+	}
+
+	if err := controller.LockDataZone(phrase); err != nil {
+		if errors.Is(err, atecc608a.ErrNotConfirmed) {
+			return fmt.Errorf("%w, nothing was written", err)
+		}
+		return err
+	}
+
+	fmt.Println("Data zone locked.")
+	return nil
+}
+
+// runApprove implements "controller approve". It pins the chip now on a device
+// after an identity mismatch, e.g. a deliberate replacement, so that the
+// service uses it again once restarted. Without -apply it only shows the chip
+// and the pin it would replace.
+func runApprove(args []string) error {
+	flags := flag.NewFlagSet("approve", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	apply := flags.Bool("apply", false, "write the pin; without it nothing is written")
+	confirmation := flags.String("confirm", "", "confirmation phrase; asked for on the terminal when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	controller, err := openOfflineDevice(*device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+
+	fmt.Printf("Device %s: %s, serial %s, revision %s\n", controller.Name(), identity.Variant, identity.Serial, identity.Revision)
+	fmt.Printf("Configuration zone locked: %t, data zone locked: %t\n", identity.ConfigLocked, identity.DataLocked)
+
+	pinned, isPinned, pinErr := controller.VerifyPin(identity)
+	var mismatchErr *atecc608a.IdentityMismatchError
+	switch {
+	case errors.As(pinErr, &mismatchErr):
+		fmt.Printf("Pinned: serial %s, revision %s, pinned %s\n", pinned.Serial, pinned.Revision, pinned.PinnedAt.Format(time.RFC3339))
+		fmt.Printf("Mismatch: %v\n", pinErr)
+	case pinErr != nil:
+		return pinErr
+	case isPinned:
+		fmt.Println("The chip already matches its pin, nothing to approve.")
+		return nil
+	default:
+		fmt.Println("No chip has been pinned on this device yet.")
+	}
+
+	if !*apply {
+		fmt.Println("Dry run, nothing was written. Run again with -apply to pin this chip.")
+		return nil
+	}
+
+	phrase, err := confirm(*confirmation, atecc608a.ApprovePhrase(identity.Serial),
+		fmt.Sprintf("pin chip %s to device %s in place of the chip pinned before", identity.Serial, controller.Name()))
+	if err != nil {
+		return err
+	}
+
+	if _, err := controller.ApproveIdentity(identity, phrase); err != nil {
+		if errors.Is(err, atecc608a.ErrNotConfirmed) {
+			return fmt.Errorf("%w, nothing was written", err)
+		}
+		return err
+	}
+
+	fmt.Println("Chip pinned. Start the service to put the device back into use.")
+	return nil
+}
+
+// printConfigDiff prints the differing bytes of a device configuration and a profile
+func printConfigDiff(diffs []atecc608a.ConfigDiff) {
+	if len(diffs) == 0 {
+		fmt.Println("The configuration zone already matches the profile.")
+		return
+	}
+
+	fmt.Printf("%6s  %-22s  %6s  %7s\n", "Offset", "Field", "Device", "Profile")
+
+	kept, mismatched := 0, 0
+	for _, diff := range diffs {
+		note := ""
+		switch {
+		case diff.Writable:
+		case diff.DontCare:
+			note = "  read-only, don't care"
+			kept++
+		default:
+			note = "  read-only, MISMATCH"
+			mismatched++
+		}
+		fmt.Printf("%6d  %-22s  %6s  %7s%s\n", diff.Offset, diff.Field,
+			fmt.Sprintf("%02x", diff.Device), fmt.Sprintf("%02x", diff.Profile), note)
+	}
+
+	fmt.Printf("\n%d bytes differ: %d will be written, %d are read-only bytes the profile does not care about",
+		len(diffs), len(diffs)-kept-mismatched, kept)
+	if mismatched > 0 {
+		fmt.Printf(", %d are read-only bytes that the profile expects to match the device", mismatched)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/atecc608a"
+	"github.com/lokey/rng-service/pkg/signing"
+)
+
+// Signing modes, as set by SIGNING_KEY_SLOT or SIGNING_SOFTWARE_KEY and reported in /info
+const (
+	SigningDisabled  = "disabled"
+	SigningATECC608A = "atecc608a" // each chip signs the batches it produced with a slot key
+	SigningSoftware  = "software"  // a key file signs every batch, for tests only
+)
+
+// signingCounter is the monotonic counter of the chip that numbers signer
+// epochs; it is incremented once each time the service starts signing with a chip
+const signingCounter = 0
+
+// errNoSigningDevice is returned when no chip can produce a signed batch
+var errNoSigningDevice = errors.New("no healthy ATECC608A could sign the batch")
+
+// ateccSigner signs with the private key in a slot of an ATECC608A
+type ateccSigner struct {
+	device    *ateccBackend
+	slot      int
+	serial    string
+	publicKey *ecdsa.PublicKey
+}
+
+func (s *ateccSigner) Serial() string {
+	return s.serial
+}
+
+func (s *ateccSigner) PublicKey() *ecdsa.PublicKey {
+	return s.publicKey
+}
+
+func (s *ateccSigner) SignDigest(digest []byte) ([]byte, error) {
+	return s.device.SignDigest(s.slot, digest)
+}
+
+// batchSigning signs /generate batches as configured for the service
+type batchSigning struct {
+	mode     string
+	slot     int                  // key slot of the chips in SigningATECC608A mode
+	software *signing.BatchSigner // signer in SigningSoftware mode
+
+	// Signers of the chips in SigningATECC608A mode, created on first use
+	mutex   sync.Mutex
+	devices map[*ateccBackend]*signing.BatchSigner
+}
+
+// signingFromEnv reads the signing mode. SIGNING_KEY_SLOT signs with the
+// private key in that slot of each ATECC608A; SIGNING_SOFTWARE_KEY signs with
+// the P-256 key in that PEM file, which is created if it does not exist.
+func signingFromEnv() (*batchSigning, error) {
+	slotVal := os.Getenv("SIGNING_KEY_SLOT")
+	keyPath := os.Getenv("SIGNING_SOFTWARE_KEY")
+
+	switch {
+	case slotVal != "" && keyPath != "":
+		return nil, fmt.Errorf("SIGNING_KEY_SLOT and SIGNING_SOFTWARE_KEY cannot both be set")
+
+	case slotVal != "":
+		slot, err := strconv.Atoi(slotVal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIGNING_KEY_SLOT %q: %w", slotVal, err)
+		}
+		if slot < 0 || slot > 15 {
+			return nil, fmt.Errorf("invalid SIGNING_KEY_SLOT %d, must be 0-15", slot)
+		}
+		return &batchSigning{
+			mode:    SigningATECC608A,
+			slot:    slot,
+			devices: make(map[*ateccBackend]*signing.BatchSigner),
+		}, nil
+
+	case keyPath != "":
+		signer, created, err := signing.LoadOrCreateSoftwareSigner(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			log.Printf("[WARN] Created software signing key %s", keyPath)
+		}
+		// Without a hardware counter the epoch is the start time in seconds,
+		// which keeps counters increasing across restarts as long as the clock does
+		epoch := uint32(time.Now().Unix()) // #nosec G115 - fits until 2106
+		return &batchSigning{
+			mode:     SigningSoftware,
+			software: signing.NewBatchSigner(signer, epoch),
+		}, nil
+
+	default:
+		return &batchSigning{mode: SigningDisabled}, nil
+	}
+}
+
+// enabled reports whether batches are signed
+func (s *batchSigning) enabled() bool {
+	return s.mode != SigningDisabled
+}
+
+// deviceSigner returns the batch signer of a chip. On first use, or once a
+// different chip has been approved on the device, it reads the public key of
+// the slot and starts a new epoch from the monotonic counter of the chip.
+func (s *batchSigning) deviceSigner(device *ateccBackend) (*signing.BatchSigner, error) {
+	identity, ok := device.Identity()
+	if !ok {
+		return nil, fmt.Errorf("identity of ATECC608A %s not read yet", device.Name())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if signer, ok := s.devices[device]; ok && signer.Signer().Serial() == identity.Serial {
+		return signer, nil
+	}
+
+	point, err := device.PublicKey(s.slot)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := signing.PublicKeyFromPoint(point)
+	if err != nil {
+		return nil, fmt.Errorf("slot %d: %w", s.slot, err)
+	}
+
+	epoch, err := device.IncrementCounter(signingCounter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start a signing epoch: %w", err)
+	}
+
+	signer := signing.NewBatchSigner(&ateccSigner{
+		device:    device,
+		slot:      s.slot,
+		serial:    identity.Serial,
+		publicKey: publicKey,
+	}, epoch)
+	s.devices[device] = signer
+
+	log.Printf("[INFO] Signing batches of ATECC608A %s (serial %s) with slot %d, epoch %d",
+		device.Name(), identity.Serial, s.slot, epoch)
+	return signer, nil
+}
+
+// publicKeyInfo describes the key of a batch signer
+func publicKeyInfo(signer *signing.BatchSigner, source string) signing.PublicKeyInfo {
+	return signing.PublicKeyInfo{
+		Serial:    signer.Signer().Serial(),
+		Algorithm: signing.Algorithm,
+		PublicKey: signing.EncodePublicKey(signer.Signer().PublicKey()),
+		Source:    source,
+	}
+}
+
+// info returns the signing configuration for /info
+func (s *batchSigning) info() gin.H {
+	info := gin.H{"mode": s.mode}
+	switch s.mode {
+	case SigningATECC608A:
+		info["algorithm"] = signing.Algorithm
+		info["slot"] = s.slot
+	case SigningSoftware:
+		info["algorithm"] = signing.Algorithm
+		info["serial"] = s.software.Signer().Serial()
+		info["warning"] = "software key, signatures do not prove which device produced the data"
+	}
+	return info
+}
+
+// generateSigned draws count values and signs them as one batch. A chip only
+// signs values it produced itself, so in SigningATECC608A mode the whole batch
+// comes from one chip; the healthy chips are tried in turn until one succeeds.
+// Like generate, it also returns the source names of the backends that served the values.
+func (c *Controller) generateSigned(count int) ([][]byte, []string, signing.SignedBatch, error) {
+	if c.signing.mode == SigningSoftware {
+		values, backends, err := c.generate(count)
+		if err != nil {
+			return nil, nil, signing.SignedBatch{}, err
+		}
+		batch, err := c.signing.software.Sign(values)
+		return values, backends, batch, err
+	}
+
+	var errs []error
+	for _, backend := range c.healthyBackends() {
+		device, ok := backend.(*ateccBackend)
+		if !ok {
+			continue
+		}
+
+		values, batch, err := c.generateSignedOn(device, count)
+		if err == nil {
+			return values, []string{sourceName(device)}, batch, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", device.Name(), err))
+	}
+
+	return nil, nil, signing.SignedBatch{}, errors.Join(append([]error{errNoSigningDevice}, errs...)...)
+}
+
+// generateSignedOn draws count values from one chip and signs them with its key
+func (c *Controller) generateSignedOn(device *ateccBackend, count int) ([][]byte, signing.SignedBatch, error) {
+	signer, err := c.signing.deviceSigner(device)
+	if err != nil {
+		return nil, signing.SignedBatch{}, err
+	}
+
+	values := make([][]byte, count)
+	for i := range values {
+		if values[i], err = device.GenerateRandom(); err != nil {
+			return nil, signing.SignedBatch{}, err
+		}
+	}
+
+	batch, err := signer.Sign(values)
+	if err != nil {
+		return nil, signing.SignedBatch{}, err
+	}
+	return values, batch, nil
+}
+
+// signingKeysHandler returns the public keys that batches are signed with.
+// In SigningATECC608A mode these are the keys of the healthy chips.
+func (c *Controller) signingKeysHandler(ctx *gin.Context) {
+	keys := []signing.PublicKeyInfo{}
+
+	switch c.signing.mode {
+	case SigningSoftware:
+		keys = append(keys, publicKeyInfo(c.signing.software, SigningSoftware))
+
+	case SigningATECC608A:
+		for _, device := range c.ateccDevices() {
+			if !device.IsHealthy() {
+				continue
+			}
+			signer, err := c.signing.deviceSigner(device)
+			if err != nil {
+				log.Printf("[WARN] No signing key for ATECC608A %s: %v", device.Name(), err)
+				continue
+			}
+			info := publicKeyInfo(signer, SigningATECC608A)
+			info.Device = device.Name()
+			info.Slot = &c.signing.slot
+			keys = append(keys, info)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"mode": c.signing.mode,
+		"keys": keys,
+	})
+}
+
+// runGenKey implements "controller genkey". It prints the public key of a
+// slot; with -apply and the confirmation phrase it creates a new private key
+// in the slot first, replacing any key that was there.
+func runGenKey(args []string) error {
+	flags := flag.NewFlagSet("genkey", flag.ContinueOnError)
+	device := flags.String("device", "", "device as <bus>:<address> (default: the first of I2C_DEVICES, or I2C_BUS_NUMBER at 0x60)")
+	slot := flags.Int("slot", -1, "key slot, 0-15 (required)")
+	apply := flags.Bool("apply", false, "create a new private key in the slot; without it the current public key is printed")
+	confirmation := flags.String("confirm", "", "confirmation phrase; asked for on the terminal when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slot < 0 {
+		return fmt.Errorf("-slot is required")
+	}
+
+	controller, err := openOfflineDevice(*device, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = controller.Close() }()
+
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to read device: %w", err)
+	}
+	fmt.Printf("Device %s: %s, serial %s\n", controller.Name(), identity.Variant, identity.Serial)
+
+	var point []byte
+	if *apply {
+		phrase, err := confirm(*confirmation, atecc608a.GenKeyPhrase(identity.Serial, *slot),
+			fmt.Sprintf("create a new private key in slot %d of chip %s; batches signed with the old key can no longer be traced to this chip", *slot, identity.Serial))
+		if err != nil {
+			return err
+		}
+		if point, err = controller.GenerateKey(*slot, phrase); err != nil {
+			return err
+		}
+		fmt.Printf("Private key created in slot %d.\n", *slot)
+	} else if point, err = controller.PublicKey(*slot); err != nil {
+		return err
+	}
+
+	publicKey, err := signing.PublicKeyFromPoint(point)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Public key of slot %d (%s): %s\n", *slot, signing.Algorithm, signing.EncodePublicKey(publicKey))
+	return nil
+}
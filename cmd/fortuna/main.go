@@ -2,31 +2,50 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/drbg"
 	"github.com/lokey/rng-service/pkg/fortuna"
 )
 
 const (
 	DefaultPort                = 8082
 	DefaultAmplificationFactor = 4
+	DefaultSeedFilePath        = "/data/fortuna.seed"
+	DefaultSeedFileInterval    = 10 * time.Minute
+	DefaultSeedThreshold       = fortuna.DefaultSeedThreshold
+	DefaultControllerAddr      = "http://controller:8081"
+	DefaultGenerator           = "fortuna"
+	DefaultCore                = fortuna.CoreAESCTR
+
+	// predictionResistanceSamples is the number of TRNG samples fetched for a prediction-resistant reseed
+	predictionResistanceSamples = 2
 )
 
 type FortunaProcessor struct {
-	generator           *fortuna.Generator
+	generator           fortuna.RandomGenerator
+	coreName            string
 	port                int
 	amplificationFactor int
+	seedFilePath        string
+	seedFileInterval    time.Duration
+	controllerAddr      string
+	seedToken           string // shared secret the API poller sends to credit its seeds
+	httpClient          *http.Client
 	router              *gin.Engine
-	lastReseedTime      time.Time
 }
 
 // customLogger only logs non-200 responses
@@ -60,7 +79,7 @@ func customLogger() gin.HandlerFunc {
 	}
 }
 
-func NewFortunaProcessor(port int, amplificationFactor int) (*FortunaProcessor, error) {
+func NewFortunaProcessor(port int, amplificationFactor int, generatorName string, core fortuna.Core, personalization []byte, seedFilePath string, seedFileInterval time.Duration, seedThreshold uint64, controllerAddr string) (*FortunaProcessor, error) {
 	// Initialize router based on log level
 	var router *gin.Engine
 	logLevel := os.Getenv("LOG_LEVEL")
@@ -75,24 +94,109 @@ func NewFortunaProcessor(port int, amplificationFactor int) (*FortunaProcessor,
 		router = gin.Default()
 	}
 
-	// Initialize Fortuna with a temporary seed (will be reseeded by API service)
-	initialSeed := make([]byte, 32)
-	for i := range initialSeed {
-		initialSeed[i] = byte(i)
+	generator, err := newGenerator(generatorName, core, personalization)
+	if err != nil {
+		return nil, err
 	}
+	generator.SetSeedThreshold(seedThreshold)
 
-	generator, err := fortuna.NewGenerator(initialSeed)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Fortuna generator: %w", err)
+	// The DRBGs have a single fixed core, named after the mechanism
+	coreName := generator.Name()
+	if g, ok := generator.(*fortuna.Generator); ok {
+		coreName = string(g.Core())
 	}
 
-	return &FortunaProcessor{
+	processor := &FortunaProcessor{
 		generator:           generator,
+		coreName:            coreName,
 		port:                port,
 		amplificationFactor: amplificationFactor,
+		seedFilePath:        seedFilePath,
+		seedFileInterval:    seedFileInterval,
+		controllerAddr:      controllerAddr,
+		httpClient:          &http.Client{Timeout: 10 * time.Second},
 		router:              router,
-		lastReseedTime:      time.Now(),
-	}, nil
+	}
+
+	// Mix in the seed file left by the previous run, so restarts don't start from the fixed key
+	processor.loadSeedFile()
+
+	return processor, nil
+}
+
+// newGenerator verifies the selected generator against its known-answer
+// vectors and creates it. Output is refused until the generator is reseeded
+// with real entropy from the seed file or the API service.
+func newGenerator(name string, core fortuna.Core, personalization []byte) (fortuna.RandomGenerator, error) {
+	switch name {
+	case "fortuna":
+		if err := fortuna.SelfTest(); err != nil {
+			return nil, fmt.Errorf("Fortuna self-test failed: %w", err)
+		}
+
+		// Fortuna starts from a temporary seed; the personalization string is mixed in as well
+		initialSeed := make([]byte, 32)
+		for i := range initialSeed {
+			initialSeed[i] = byte(i)
+		}
+		initialSeed = append(initialSeed, personalization...)
+
+		generator, err := fortuna.NewGeneratorWithCore(initialSeed, core)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Fortuna generator: %w", err)
+		}
+		return generator, nil
+
+	case string(drbg.HMACSHA256), string(drbg.CTRAES256):
+		if err := drbg.SelfTest(); err != nil {
+			return nil, fmt.Errorf("DRBG self-test failed: %w", err)
+		}
+
+		generator, err := drbg.NewGenerator(drbg.Algorithm(name), personalization)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize %s generator: %w", name, err)
+		}
+		return generator, nil
+
+	default:
+		return nil, fmt.Errorf("unknown generator %q (expected fortuna, %s or %s)", name, drbg.HMACSHA256, drbg.CTRAES256)
+	}
+}
+
+// loadSeedFile mixes the seed file into the generator and rewrites it right away
+func (p *FortunaProcessor) loadSeedFile() {
+	if p.seedFilePath == "" {
+		return
+	}
+
+	err := fortuna.UpdateSeedFile(p.generator, p.seedFilePath)
+	switch {
+	case err == nil:
+		log.Printf("Reseeded from seed file %s", p.seedFilePath)
+	case errors.Is(err, os.ErrNotExist):
+		// Don't write a seed file derived from the fixed initial key; the refresh loop
+		// creates it once the API service has seeded the generator
+		log.Printf("No seed file at %s yet, it will be created on the next refresh", p.seedFilePath)
+	default:
+		log.Printf("Warning: could not use seed file %s: %v", p.seedFilePath, err)
+	}
+}
+
+// refreshSeedFile periodically replaces the seed file with fresh generator output
+func (p *FortunaProcessor) refreshSeedFile(ctx context.Context) {
+	ticker := time.NewTicker(p.seedFileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fortuna.WriteSeedFile(p.generator, p.seedFilePath); err != nil {
+				log.Printf("Warning: failed to refresh seed file: %v", err)
+			}
+		}
+	}
 }
 
 func (p *FortunaProcessor) setupRoutes() {
@@ -117,6 +221,13 @@ func (p *FortunaProcessor) Start() error {
 
 	logLevel := os.Getenv("LOG_LEVEL")
 
+	// Keep the seed file fresh while the server runs
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	if p.seedFilePath != "" {
+		go p.refreshSeedFile(refreshCtx)
+	}
+
 	go func() {
 		if logLevel == "DEBUG" || logLevel == "INFO" || logLevel == "" {
 			log.Printf("Starting Fortuna processor server on port %d", p.port)
@@ -147,25 +258,40 @@ func (p *FortunaProcessor) Start() error {
 		return fmt.Errorf("server shutdown error: %w", err)
 	}
 
+	// Write a final seed file for the next start
+	stopRefresh()
+	if p.seedFilePath != "" {
+		if err := fortuna.WriteSeedFile(p.generator, p.seedFilePath); err != nil {
+			log.Printf("Warning: failed to write seed file on shutdown: %v", err)
+		}
+	}
+
 	return nil
 }
 
 //---------------------- HTTP Handlers ----------------------
 
-// healthCheckHandler checks if the generator is healthy
+// healthCheckHandler checks if the generator is healthy and seeded
 func (p *FortunaProcessor) healthCheckHandler(ctx *gin.Context) {
 	healthy := p.generator.HealthCheck()
-	if healthy {
+	seeded := p.generator.IsSeeded()
+	if healthy && seeded {
 		ctx.JSON(http.StatusOK, gin.H{
 			"status":    "healthy",
 			"timestamp": time.Now().Format(time.RFC3339),
 		})
 	} else {
+		status := "unhealthy"
+		if !seeded {
+			status = "not_ready"
+		}
+
 		ctx.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":    "unhealthy",
+			"status":    status,
 			"timestamp": time.Now().Format(time.RFC3339),
 			"details": gin.H{
-				"generator": p.generator.HealthCheck(),
+				"generator": healthy,
+				"seeded":    seeded,
 			},
 		})
 	}
@@ -173,11 +299,27 @@ func (p *FortunaProcessor) healthCheckHandler(ctx *gin.Context) {
 
 // infoHandler returns information about the Fortuna processor
 func (p *FortunaProcessor) infoHandler(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{
+	info := gin.H{
 		"status":               "running",
+		"generator":            p.generator.Name(),
+		"core":                 p.coreName,
+		"seeded":               p.generator.IsSeeded(),
 		"amplification_factor": p.amplificationFactor,
-		"last_reseeded":        p.lastReseedTime.Format(time.RFC3339),
-	})
+		"last_reseeded":        p.generator.GetLastReseedTime().Format(time.RFC3339),
+		"reseed_count":         p.generator.GetReseedCount(),
+		"reseed_events":        p.generator.GetReseedEvents(),
+		"sources":              p.generator.GetSourceStats(),
+	}
+
+	// Generator-specific state
+	switch g := p.generator.(type) {
+	case *fortuna.Generator:
+		info["pools"] = g.GetPoolStats()
+	case *drbg.Generator:
+		info["reseed_counter"] = g.GetReseedCounter()
+	}
+
+	ctx.JSON(http.StatusOK, info)
 }
 
 // generateDataHandler generates random data using the Fortuna algorithm
@@ -190,24 +332,113 @@ func (p *FortunaProcessor) generateDataHandler(ctx *gin.Context) {
 		return
 	}
 
+	// Optionally reseed with fresh TRNG output right before generating
+	if ctx.Query("prediction_resistance") == "true" {
+		p.generatePredictionResistant(ctx, size)
+		return
+	}
+
 	// Generate random data
 	data, err := p.generator.GenerateRandomData(size)
-	if err != nil {
+	if errors.Is(err, fortuna.ErrNotSeeded) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fortuna generator not yet seeded"})
+		return
+	} else if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate data"})
 		return
 	}
 
+	ctx.Header("X-Generator-Core", p.coreName)
 	ctx.JSON(http.StatusOK, gin.H{
 		"data": hex.EncodeToString(data),
 		"size": len(data),
 	})
 }
 
+// generatePredictionResistant reseeds with fresh controller output and generates size bytes under the new key
+func (p *FortunaProcessor) generatePredictionResistant(ctx *gin.Context, size int) {
+	entropy, backends, err := p.fetchTRNGEntropy(predictionResistanceSamples)
+	if err != nil {
+		log.Printf("Prediction resistance unavailable: %v", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fresh TRNG entropy unavailable for prediction resistance"})
+		return
+	}
+	defer clear(entropy)
+
+	data, reseedEvent, err := p.generator.GeneratePredictionResistant(entropy, size)
+	if errors.Is(err, fortuna.ErrNotSeeded) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fortuna generator not yet seeded"})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate data"})
+		return
+	}
+
+	ctx.Header("X-Generator-Core", p.coreName)
+	ctx.Header("X-Prediction-Resistance", "applied")
+	ctx.Header("X-Reseed-Event", strconv.FormatUint(reseedEvent, 10))
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":                  hex.EncodeToString(data),
+		"size":                  len(data),
+		"prediction_resistance": true,
+		"reseed_event":          reseedEvent,
+		"reseed_source":         strings.Join(backends, ","),
+	})
+}
+
+// fetchTRNGEntropy fetches count fresh samples from the controller service,
+// along with the types of the backends that produced them
+func (p *FortunaProcessor) fetchTRNGEntropy(count int) ([]byte, []string, error) {
+	// URL is constructed from service configuration, not user input
+	resp, err := p.httpClient.Get(fmt.Sprintf("%s/generate?count=%d", p.controllerAddr, count)) // #nosec G107
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to TRNG controller: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Error closing controller response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("TRNG controller returned status %d", resp.StatusCode)
+	}
+
+	// Controller returns an array when count > 1
+	var result struct {
+		Data     []string `json:"data"`
+		Backends []string `json:"backends"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, fmt.Errorf("error parsing TRNG response: %w", err)
+	}
+
+	var entropy []byte
+	for _, sample := range result.Data {
+		sampleBytes, err := hex.DecodeString(sample)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding data from controller: %w", err)
+		}
+		entropy = append(entropy, sampleBytes...)
+	}
+
+	if len(entropy) == 0 {
+		return nil, nil, fmt.Errorf("TRNG controller returned no samples")
+	}
+	if len(result.Backends) == 0 {
+		return nil, nil, fmt.Errorf("TRNG controller did not report its backends")
+	}
+
+	return entropy, result.Backends, nil
+}
+
 // seedHandler processes incoming TRNG seeds to reseed the Fortuna generator
 func (p *FortunaProcessor) seedHandler(ctx *gin.Context) {
 	// Parse request body
 	var request struct {
-		Seeds []string `json:"seeds" binding:"required"`
+		Seeds  []string `json:"seeds" binding:"required"`
+		Source string   `json:"source"`
 	}
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -220,34 +451,65 @@ func (p *FortunaProcessor) seedHandler(ctx *gin.Context) {
 		return
 	}
 
-	// Add each seed to a different pool
-	for i, seedHex := range request.Seeds {
+	// Seeds are client data unless the API poller names the source they came from
+	source := fortuna.SourceClient
+	if request.Source != "" {
+		var ok bool
+		source, ok = fortuna.SourceByName(request.Source)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown entropy source"})
+			return
+		}
+	}
+
+	// Credited seeds can mark the generator as seeded, so only the API poller may send them
+	if source.Credited() && !p.seedAuthorized(ctx) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Credited entropy sources require the seed token"})
+		return
+	}
+
+	// Spread the seeds over the pools as events from the source
+	for _, seedHex := range request.Seeds {
 		seed, err := hex.DecodeString(seedHex)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seed format (not hex)"})
 			return
 		}
 
-		p.generator.AddRandomEvent(byte(i%32), seed)
+		if err := p.generator.AddRandomData(source, seed); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seed (empty)"})
+			return
+		}
 	}
 
-	// Reseed the generator
+	// Reseed the generator only once the pool thresholds have been reached
+	status := "reseeded"
 	err := p.generator.ReseedFromPools()
-	if err != nil {
+	if errors.Is(err, fortuna.ErrReseedNotDue) {
+		status = "pooled"
+	} else if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reseed generator"})
 		return
 	}
 
-	// Update last reseed time
-	p.lastReseedTime = time.Now()
-
 	ctx.JSON(http.StatusOK, gin.H{
-		"status":        "reseeded",
+		"status":        status,
 		"count":         len(request.Seeds),
-		"last_reseeded": p.lastReseedTime.Format(time.RFC3339),
+		"reseed_count":  p.generator.GetReseedCount(),
+		"last_reseeded": p.generator.GetLastReseedTime().Format(time.RFC3339),
 	})
 }
 
+// seedAuthorized reports whether the request carries the seed token as a
+// bearer token. Without SEED_TOKEN no request is authorized.
+func (p *FortunaProcessor) seedAuthorized(ctx *gin.Context) bool {
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if p.seedToken == "" || !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(p.seedToken)) == 1
+}
+
 // amplifyDataHandler amplifies provided seed data using the Fortuna algorithm
 func (p *FortunaProcessor) amplifyDataHandler(ctx *gin.Context) {
 	// Parse request body
@@ -281,11 +543,15 @@ func (p *FortunaProcessor) amplifyDataHandler(ctx *gin.Context) {
 	}
 
 	amplifiedData, err := p.generator.AmplifyRandomData(seed, outputLength)
-	if err != nil {
+	if errors.Is(err, fortuna.ErrNotSeeded) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fortuna generator not yet seeded"})
+		return
+	} else if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to amplify data"})
 		return
 	}
 
+	ctx.Header("X-Generator-Core", p.coreName)
 	ctx.JSON(http.StatusOK, gin.H{
 		"data": hex.EncodeToString(amplifiedData),
 		"size": len(amplifiedData),
@@ -312,17 +578,78 @@ func main() {
 		}
 	}
 
+	// An empty SEED_FILE disables the seed file
+	seedFilePath := DefaultSeedFilePath
+	if val, ok := os.LookupEnv("SEED_FILE"); ok {
+		seedFilePath = val
+	}
+
+	seedFileIntervalMs := DefaultSeedFileInterval.Milliseconds()
+	if val, ok := os.LookupEnv("SEED_FILE_INTERVAL_MS"); ok {
+		if n, err := fmt.Sscanf(val, "%d", &seedFileIntervalMs); n != 1 || err != nil || seedFileIntervalMs <= 0 {
+			log.Printf("Invalid SEED_FILE_INTERVAL_MS, using default: %d", DefaultSeedFileInterval.Milliseconds())
+			seedFileIntervalMs = DefaultSeedFileInterval.Milliseconds()
+		}
+	}
+	seedFileInterval := time.Duration(seedFileIntervalMs) * time.Millisecond
+
+	seedThreshold := uint64(DefaultSeedThreshold)
+	if val, ok := os.LookupEnv("SEED_THRESHOLD_BYTES"); ok {
+		if n, err := fmt.Sscanf(val, "%d", &seedThreshold); n != 1 || err != nil || seedThreshold == 0 {
+			log.Printf("Invalid SEED_THRESHOLD_BYTES, using default: %d", DefaultSeedThreshold)
+			seedThreshold = DefaultSeedThreshold
+		}
+	}
+
+	controllerAddr := DefaultControllerAddr
+	if val, ok := os.LookupEnv("CONTROLLER_ADDR"); ok && val != "" {
+		controllerAddr = val
+	}
+
+	generatorName := DefaultGenerator
+	if val, ok := os.LookupEnv("GENERATOR"); ok && val != "" {
+		generatorName = val
+	}
+
+	core := DefaultCore
+	if val, ok := os.LookupEnv("FORTUNA_CORE"); ok && val != "" {
+		parsed, err := fortuna.ParseCore(val)
+		if err != nil {
+			log.Printf("Invalid FORTUNA_CORE, using default: %s", DefaultCore)
+		} else {
+			core = parsed
+		}
+	}
+
+	// The personalization string separates instances that could otherwise share state
+	personalization := os.Getenv("PERSONALIZATION")
+	if personalization == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			personalization = "lokey-fortuna/" + hostname
+		}
+	}
+
 	// Create and start Fortuna processor
-	processor, err := NewFortunaProcessor(port, amplificationFactor)
+	processor, err := NewFortunaProcessor(port, amplificationFactor, generatorName, core, []byte(personalization), seedFilePath, seedFileInterval, seedThreshold, controllerAddr)
 	if err != nil {
 		log.Fatalf("Failed to create Fortuna processor: %v", err)
 	}
 
+	processor.seedToken = os.Getenv("SEED_TOKEN")
+	if processor.seedToken == "" {
+		log.Printf("Warning: SEED_TOKEN is not set, seeds from the API service are not credited as entropy")
+	}
+
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel == "DEBUG" || logLevel == "INFO" || logLevel == "" {
 		log.Printf("Starting Fortuna processor with configuration:")
 		log.Printf("  Port: %d", port)
+		log.Printf("  Generator: %s", generatorName)
+		log.Printf("  Core: %s", processor.coreName)
 		log.Printf("  Amplification Factor: %d", amplificationFactor)
+		log.Printf("  Seed File: %s (refresh every %s)", seedFilePath, seedFileInterval)
+		log.Printf("  Seed Threshold: %d bytes", seedThreshold)
+		log.Printf("  Controller Address: %s", controllerAddr)
 		log.Printf("Note: Fortuna will be seeded by the API service via /seed endpoint")
 	}
 
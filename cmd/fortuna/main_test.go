@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/fortuna"
+)
+
+// newTestProcessor returns a processor with an unseeded Fortuna generator
+// that fetches fresh entropy from controller
+func newTestProcessor(t *testing.T, controller http.Handler) *FortunaProcessor {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	generator, err := fortuna.NewGenerator(make([]byte, fortuna.MinimumSeedLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(controller)
+	t.Cleanup(ts.Close)
+
+	p := &FortunaProcessor{
+		generator:      generator,
+		coreName:       string(generator.Core()),
+		controllerAddr: ts.URL,
+		httpClient:     ts.Client(),
+		router:         gin.New(),
+	}
+	p.setupRoutes()
+	return p
+}
+
+// trngController serves count samples of 32 bytes from the given backends
+func trngController(backends ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		data := make([]string, count)
+		for i := range data {
+			sample := make([]byte, 32)
+			sample[0] = byte(i + 1)
+			data[i] = hex.EncodeToString(sample)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": data, "backends": backends})
+	})
+}
+
+func get(p *FortunaProcessor, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestGeneratePredictionResistant(t *testing.T) {
+	p := newTestProcessor(t, trngController("atecc608a", "jitter"))
+
+	// Without prediction resistance the unseeded generator refuses output
+	if w := get(p, "/generate?size=16"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unseeded generator returned status %d", w.Code)
+	}
+
+	events := p.generator.GetReseedEvents()
+	w := get(p, "/generate?size=16&prediction_resistance=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Data                 string `json:"data"`
+		Size                 int    `json:"size"`
+		PredictionResistance bool   `json:"prediction_resistance"`
+		ReseedEvent          uint64 `json:"reseed_event"`
+		ReseedSource         string `json:"reseed_source"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Size != 16 || len(response.Data) != 32 || !response.PredictionResistance {
+		t.Errorf("got response %+v", response)
+	}
+	if response.ReseedEvent != events+1 || p.generator.GetReseedEvents() != events+1 {
+		t.Errorf("reseed event is %d, want %d", response.ReseedEvent, events+1)
+	}
+	if response.ReseedSource != "atecc608a,jitter" {
+		t.Errorf("reseed source is %q, want %q", response.ReseedSource, "atecc608a,jitter")
+	}
+	if got := w.Header().Get("X-Prediction-Resistance"); got != "applied" {
+		t.Errorf("X-Prediction-Resistance is %q, want %q", got, "applied")
+	}
+	if got := w.Header().Get("X-Reseed-Event"); got != strconv.FormatUint(events+1, 10) {
+		t.Errorf("X-Reseed-Event is %q, want %d", got, events+1)
+	}
+
+	// The next request reseeds again
+	w = get(p, "/generate?size=16&prediction_resistance=true")
+	if got := w.Header().Get("X-Reseed-Event"); got != strconv.FormatUint(events+2, 10) {
+		t.Errorf("second X-Reseed-Event is %q, want %d", got, events+2)
+	}
+}
+
+func TestGeneratePredictionResistantWithoutEntropy(t *testing.T) {
+	for name, controller := range map[string]http.Handler{
+		"unavailable": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no healthy backend", http.StatusServiceUnavailable)
+		}),
+		"no backends": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []string{hex.EncodeToString(make([]byte, 64))}})
+		}),
+		"no samples": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []string{}, "backends": []string{"atecc608a"}})
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newTestProcessor(t, controller)
+			events := p.generator.GetReseedEvents()
+
+			w := get(p, "/generate?size=16&prediction_resistance=true")
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+			if w.Header().Get("X-Prediction-Resistance") != "" {
+				t.Error("refused request reports prediction resistance")
+			}
+			if p.generator.IsSeeded() || p.generator.GetReseedEvents() != events {
+				t.Error("refused request reseeded the generator")
+			}
+		})
+	}
+}
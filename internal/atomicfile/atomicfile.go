@@ -0,0 +1,64 @@
+// Package atomicfile replaces files atomically, so that a crash or power loss
+// leaves either the old or the new contents behind, never a truncated file.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Write writes data to a temporary file in the same directory as path, syncs
+// it and renames it over path. The file gets mode perm before any data is
+// written to it.
+func Write(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	// CreateTemp creates the file with mode 0600
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	SyncDir(dir)
+	return nil
+}
+
+// SyncDir syncs a directory so a rename or removal in it survives a power loss
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil { // #nosec G304 - directory of a file the caller writes
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
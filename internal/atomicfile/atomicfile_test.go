@@ -0,0 +1,69 @@
+package atomicfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	for _, data := range [][]byte{[]byte("first"), []byte("second, longer contents"), []byte("3")} {
+		if err := Write(path, data, 0o640); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("file holds %q, want %q", got, data)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o640 {
+			t.Errorf("file mode is %v, want %v", info.Mode().Perm(), os.FileMode(0o640))
+		}
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the file", len(entries))
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	// A directory in the way makes the rename fail after the data is written
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(filepath.Join(target, "child"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(target, []byte("new"), 0o600); err == nil {
+		t.Fatal("replaced a non-empty directory")
+	}
+
+	// The temporary file is removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the directory in the way", len(entries))
+	}
+
+	if err := Write(filepath.Join(dir, "missing", "file"), []byte("new"), 0o600); err == nil {
+		t.Error("wrote into a missing directory")
+	}
+}
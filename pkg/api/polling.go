@@ -5,12 +5,19 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"time"
+
+	"github.com/lokey/rng-service/pkg/signing"
 )
 
+// errFortunaNotSeeded is returned while the Fortuna service refuses output because it has not been seeded yet
+var errFortunaNotSeeded = errors.New("Fortuna service not yet seeded")
+
 // StartPolling initiates background polling of external services for random data
 func (s *Server) StartPolling(ctx context.Context, trngPollInterval, fortunaPollInterval time.Duration) {
 	// Start TRNG polling
@@ -63,9 +70,11 @@ func (s *Server) fetchAndStoreTRNGData() error {
 		return fmt.Errorf("TRNG controller returned status %d", resp.StatusCode)
 	}
 
-	// Read and parse response body which contains a data field
+	// Read and parse response body which contains a data field, and a
+	// signed_batch field when the controller signs its output
 	var result struct {
-		Data string `json:"data"`
+		Data        string               `json:"data"`
+		SignedBatch *signing.SignedBatch `json:"signed_batch"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -78,6 +87,21 @@ func (s *Server) fetchAndStoreTRNGData() error {
 		return fmt.Errorf("error decoding data from controller: %w", err)
 	}
 
+	// Only data covered by a valid signature is stored with its batch
+	if batch := result.SignedBatch; batch != nil {
+		if !batch.Contains(dataBytes) {
+			return fmt.Errorf("refusing TRNG data: signed batch does not hold it")
+		}
+		if err := s.verifyBatch(*batch); err != nil {
+			return fmt.Errorf("refusing TRNG data: %w", err)
+		}
+		if err := s.db.StoreSignedBatch(*batch); err != nil {
+			return fmt.Errorf("error storing signed batch: %w", err)
+		}
+	} else if s.requireSignedTRNG() {
+		return fmt.Errorf("refusing unsigned TRNG data, REQUIRE_SIGNED_TRNG is set")
+	}
+
 	// Store the data in database
 	if err := s.db.StoreTRNGData(dataBytes); err != nil {
 		return fmt.Errorf("error storing TRNG data: %w", err)
@@ -98,14 +122,26 @@ func (s *Server) pollFortunaService(ctx context.Context, interval time.Duration)
 
 	log.Printf("Starting Fortuna polling from %s with interval %s", s.fortunaAddr, interval)
 
+	waitingForSeed := false
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("Fortuna polling stopped")
 			return
 		case <-ticker.C:
-			if err := s.fetchAndStoreFortunaData(); err != nil {
+			err := s.fetchAndStoreFortunaData()
+			switch {
+			case errors.Is(err, errFortunaNotSeeded):
+				// Log once instead of on every tick until Fortuna has been seeded
+				if !waitingForSeed {
+					log.Printf("Waiting for Fortuna to be seeded before storing its data")
+					waitingForSeed = true
+				}
+			case err != nil:
 				log.Printf("Fortuna polling error: %v", err)
+			case waitingForSeed:
+				log.Printf("Fortuna is seeded, storing its data")
+				waitingForSeed = false
 			}
 		}
 	}
@@ -125,6 +161,10 @@ func (s *Server) fetchAndStoreFortunaData() error {
 		}
 	}()
 
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return errFortunaNotSeeded
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("Fortuna service returned status %d", resp.StatusCode)
 	}
@@ -158,6 +198,52 @@ func (s *Server) fetchAndStoreFortunaData() error {
 	return nil
 }
 
+// fetchPredictionResistantData asks Fortuna to reseed with fresh TRNG output and generate size bytes
+func (s *Server) fetchPredictionResistantData(size int) ([]byte, uint64, error) {
+	url := fmt.Sprintf("%s/generate?size=%d&prediction_resistance=true", s.fortunaAddr, size)
+
+	// URL is constructed from validated server configuration and a validated size
+	resp, err := http.Get(url) // #nosec G107
+	if err != nil {
+		return nil, 0, fmt.Errorf("error connecting to Fortuna service: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Error closing Fortuna response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("Fortuna service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data                 string `json:"data"`
+		PredictionResistance bool   `json:"prediction_resistance"`
+		ReseedEvent          uint64 `json:"reseed_event"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("error parsing Fortuna response: %w", err)
+	}
+
+	if !result.PredictionResistance {
+		return nil, 0, fmt.Errorf("Fortuna service did not apply prediction resistance")
+	}
+
+	data, err := hex.DecodeString(result.Data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decoding data from Fortuna: %w", err)
+	}
+
+	return data, result.ReseedEvent, nil
+}
+
+// SetSeedToken sets the token Fortuna requires before it credits TRNG seeds as entropy
+func (s *Server) SetSeedToken(token string) {
+	s.seedToken = token
+}
+
 // seedFortunaWithTRNG periodically seeds Fortuna generator with hardware TRNG data
 func (s *Server) seedFortunaWithTRNG(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
@@ -165,6 +251,11 @@ func (s *Server) seedFortunaWithTRNG(ctx context.Context, interval time.Duration
 
 	log.Printf("Starting Fortuna seeding with TRNG data every %s", interval)
 
+	// Seed right away so Fortuna doesn't wait a full interval after startup
+	if err := s.seedFortuna(); err != nil {
+		log.Printf("Fortuna seeding error: %v", err)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -197,7 +288,8 @@ func (s *Server) seedFortuna() error {
 
 	// Parse controller response (returns array when count > 1)
 	var result struct {
-		Data []string `json:"data"`
+		Data     []string `json:"data"`
+		Backends []string `json:"backends"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -210,9 +302,11 @@ func (s *Server) seedFortuna() error {
 
 	// Send seeds to Fortuna's /seed endpoint
 	seedRequest := struct {
-		Seeds []string `json:"seeds"`
+		Seeds  []string `json:"seeds"`
+		Source string   `json:"source"`
 	}{
-		Seeds: result.Data,
+		Seeds:  result.Data,
+		Source: seedSource(result.Backends),
 	}
 
 	seedData, err := json.Marshal(seedRequest)
@@ -220,11 +314,17 @@ func (s *Server) seedFortuna() error {
 		return fmt.Errorf("error marshaling seed request: %w", err)
 	}
 
-	seedResp, err := http.Post(
-		s.fortunaAddr+"/seed",
-		"application/json",
-		bytes.NewBuffer(seedData),
-	)
+	seedReq, err := http.NewRequest(http.MethodPost, s.fortunaAddr+"/seed", bytes.NewReader(seedData))
+	if err != nil {
+		return fmt.Errorf("error creating seed request: %w", err)
+	}
+	seedReq.Header.Set("Content-Type", "application/json")
+	// Fortuna only credits seeds that carry its seed token
+	if s.seedToken != "" {
+		seedReq.Header.Set("Authorization", "Bearer "+s.seedToken)
+	}
+
+	seedResp, err := http.DefaultClient.Do(seedReq)
 	if err != nil {
 		return fmt.Errorf("error seeding Fortuna: %w", err)
 	}
@@ -238,6 +338,29 @@ func (s *Server) seedFortuna() error {
 		return fmt.Errorf("Fortuna seeding failed with status: %d", seedResp.StatusCode)
 	}
 
-	log.Printf("Successfully seeded Fortuna with %d TRNG samples", len(result.Data))
+	log.Printf("Successfully seeded Fortuna with %d %s samples", len(result.Data), seedRequest.Source)
 	return nil
 }
+
+// seedSources are the Fortuna sources of the controller backends, least
+// trusted first. Fortuna credits each of them as it sees fit: jitter output,
+// for example, is mixed in but not counted as entropy.
+var seedSources = []string{"jitter", "emulator", "hwrng", "atecc608a"}
+
+// seedSource returns the Fortuna source controller values are seeded as. The
+// controller names the backends that served them; if it names several, the
+// values are credited to the least trusted one, and values from unknown
+// backends are seeded as uncredited client data.
+func seedSource(backends []string) string {
+	for _, backend := range backends {
+		if !slices.Contains(seedSources, backend) {
+			return "client"
+		}
+	}
+	for _, source := range seedSources {
+		if slices.Contains(backends, source) {
+			return source
+		}
+	}
+	return "client"
+}
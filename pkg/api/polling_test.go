@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestSeedSource(t *testing.T) {
+	for _, tc := range []struct {
+		backends []string
+		want     string
+	}{
+		{[]string{"atecc608a"}, "atecc608a"},
+		{[]string{"hwrng"}, "hwrng"},
+		{[]string{"jitter"}, "jitter"},
+		{[]string{"emulator"}, "emulator"},
+		{[]string{"atecc608a", "hwrng"}, "hwrng"},
+		{[]string{"atecc608a", "jitter"}, "jitter"},
+		{[]string{"atecc608a", "unknown"}, "client"},
+		{nil, "client"},
+	} {
+		if got := seedSource(tc.backends); got != tc.want {
+			t.Errorf("backends %v are seeded as %q, want %q", tc.backends, got, tc.want)
+		}
+	}
+}
+
+func TestSeedFortuna(t *testing.T) {
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data":     []string{"0102", "0304"},
+			"backends": []string{"jitter"},
+		})
+	}))
+	t.Cleanup(controller.Close)
+
+	var request struct {
+		Seeds  []string `json:"seeds"`
+		Source string   `json:"source"`
+	}
+	var authorization string
+	fortuna := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(fortuna.Close)
+
+	s := &Server{controllerAddr: controller.URL, fortunaAddr: fortuna.URL}
+	s.SetSeedToken("token")
+	if err := s.seedFortuna(); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Source != "jitter" || len(request.Seeds) != 2 {
+		t.Errorf("Fortuna was sent %+v, want two jitter seeds", request)
+	}
+	if authorization != "Bearer token" {
+		t.Errorf("Fortuna was sent authorization %q", authorization)
+	}
+}
+
+func TestGetRandomDataPredictionResistance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requested string
+	fortuna := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.RawQuery
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data":                  "0102030405060708",
+			"prediction_resistance": true,
+			"reseed_event":          42,
+		})
+	}))
+	t.Cleanup(fortuna.Close)
+
+	s := &Server{fortunaAddr: fortuna.URL, validate: validator.New()}
+	router := gin.New()
+	router.POST("/data", s.GetRandomData)
+
+	request := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/data", strings.NewReader(body)))
+		return w
+	}
+
+	w := request(`{"format": "binary", "limit": 4, "source": "fortuna", "prediction_resistance": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if requested != "size=4&prediction_resistance=true" {
+		t.Errorf("Fortuna was asked for %q", requested)
+	}
+	if got := w.Header().Get("X-Prediction-Resistance"); got != "applied" {
+		t.Errorf("X-Prediction-Resistance is %q, want %q", got, "applied")
+	}
+	if got := w.Header().Get("X-Reseed-Event"); got != "42" {
+		t.Errorf("X-Reseed-Event is %q, want %q", got, "42")
+	}
+	if !bytes.Equal(w.Body.Bytes(), []byte{1, 2, 3, 4}) {
+		t.Errorf("got data %x, want 01020304", w.Body.Bytes())
+	}
+
+	// Prediction resistance is a Fortuna feature
+	if w := request(`{"format": "binary", "limit": 4, "source": "trng", "prediction_resistance": true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("TRNG request got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetRandomDataPredictionResistanceUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"not seeded": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not seeded", http.StatusServiceUnavailable)
+		},
+		"not applied": func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": "01020304"})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fortuna := httptest.NewServer(handler)
+			t.Cleanup(fortuna.Close)
+
+			s := &Server{fortunaAddr: fortuna.URL, validate: validator.New()}
+			router := gin.New()
+			router.POST("/data", s.GetRandomData)
+
+			w := httptest.NewRecorder()
+			body := `{"format": "uint8", "limit": 4, "source": "fortuna", "prediction_resistance": true}`
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/data", strings.NewReader(body)))
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+			if w.Header().Get("X-Prediction-Resistance") != "" || w.Header().Get("X-Reseed-Event") != "" {
+				t.Error("refused request reports prediction resistance")
+			}
+		})
+	}
+}
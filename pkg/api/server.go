@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -28,6 +30,14 @@ type Server struct {
 	metrics        *Metrics
 	consumeMode    bool         // Global consume setting
 	consumeMutex   sync.RWMutex // Protects consumeMode
+
+	// Batch signing: keys pinned per serial on first use, and whether unsigned TRNG batches are dropped
+	signingMutex  sync.RWMutex
+	signingKeys   map[string]*trustedKey
+	requireSigned bool
+
+	// seedToken authorizes the TRNG seeds sent to Fortuna as credited entropy
+	seedToken string
 }
 
 // QueueConfig represents the queue configuration
@@ -47,6 +57,8 @@ type DataRequest struct {
 	Count  int    `json:"limit" validate:"required,min=1,max=100000"`
 	Offset int    `json:"offset" validate:"min=0"`
 	Source string `json:"source" validate:"required,oneof=trng fortuna"`
+	// PredictionResistance bypasses the Fortuna queue and reseeds Fortuna with fresh TRNG output right before generating
+	PredictionResistance bool `json:"prediction_resistance"`
 }
 
 // HealthCheckResponse represents the health check response
@@ -58,9 +70,39 @@ type HealthCheckResponse struct {
 		Controller bool `json:"controller"`
 		Fortuna    bool `json:"fortuna"`
 		Database   bool `json:"database"`
+		// ControllerDevices is the state of each ATECC608A behind the controller
+		ControllerDevices []ControllerDeviceHealth `json:"controller_devices,omitempty"`
+		// IdentityMismatch is set when a chip is not the one pinned to its device
+		IdentityMismatch bool `json:"identity_mismatch"`
 	} `json:"details"`
 }
 
+// ControllerDeviceHealth represents the health of one ATECC608A as reported by the controller
+type ControllerDeviceHealth struct {
+	Device  string `json:"device"`
+	Healthy bool   `json:"healthy"`
+	State   string `json:"state"`
+}
+
+// StatusResponse represents the system status: queue and storage statistics
+// and the entropy sources that have fed Fortuna's pools
+type StatusResponse struct {
+	database.DetailedStats
+	// EntropySources is the contribution of each entropy source as reported by Fortuna
+	EntropySources []EntropySourceStats `json:"entropy_sources,omitempty"`
+}
+
+// EntropySourceStats represents the events one entropy source has added to Fortuna's pools
+type EntropySourceStats struct {
+	Source string `json:"source"`
+	ID     byte   `json:"id"`
+	Events uint64 `json:"events"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// controllerDeviceStateIdentityMismatch is the controller state of a device whose chip was not the pinned one
+const controllerDeviceStateIdentityMismatch = "identity_mismatch"
+
 type Metrics struct {
 	TRNGQueueCurrent    prometheus.Gauge
 	TRNGQueueCapacity   prometheus.Gauge
@@ -158,6 +200,7 @@ func NewServer(db database.DBHandler, controllerAddr, fortunaAddr string, port i
 		validate:       validate,
 		metrics:        metrics,
 		consumeMode:    false, // Default: don't consume (read-only mode)
+		signingKeys:    make(map[string]*trustedKey),
 	}
 
 	server.setupRoutes()
@@ -198,6 +241,10 @@ func (s *Server) setupRoutes() {
 		// Data retrieval endpoints
 		api.POST("/data", s.GetRandomData)
 
+		// Signature endpoints
+		api.GET("/signatures", s.GetSignature)
+		api.GET("/signatures/keys", s.GetSigningKeys)
+
 		// Status endpoints
 		api.GET("/status", s.GetStatus)
 		api.GET("/health", s.HealthCheck)
@@ -313,16 +360,22 @@ func (s *Server) UpdateConsumeConfig(c *gin.Context) {
 }
 
 // @Summary Get random data
-// @Description Retrieve random data in various formats with pagination
+// @Description Retrieve random data in various formats with pagination.
+// @Description With prediction_resistance (source fortuna only) the data is generated on demand right after
+// @Description Fortuna was reseeded with fresh ATECC608A output; the X-Prediction-Resistance and X-Reseed-Event
+// @Description response headers report the reseed that was used.
 // @Tags data
 // @Accept json
 // @Produce json
 // @Produce application/octet-stream
 // @Param request body DataRequest true "Data request parameters"
 // @Success 200 {array} interface{} "Random data in requested format"
+// @Header 200 {string} X-Prediction-Resistance "\"applied\" when prediction resistance was requested"
+// @Header 200 {integer} X-Reseed-Event "Fortuna reseed event used for prediction resistance"
 // @Failure 400 {object} map[string]string "Invalid request"
 // @Failure 404 {object} map[string]string "Not enough data available"
 // @Failure 500 {object} map[string]string "Server error"
+// @Failure 503 {object} map[string]string "Prediction resistance unavailable"
 // @Router /data [post]
 func (s *Server) GetRandomData(c *gin.Context) {
 	var request DataRequest
@@ -346,12 +399,31 @@ func (s *Server) GetRandomData(c *gin.Context) {
 	estimatedBytesNeeded := request.Count * bytesPerValue
 	estimatedChunksNeeded := (estimatedBytesNeeded / 31) + 5
 
+	if request.PredictionResistance && request.Source != "fortuna" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prediction_resistance is only supported for source fortuna"})
+		return
+	}
+
 	// Retrieve data
 	var rawData [][]byte
 	var err error
-	if request.Source == "trng" {
+	switch {
+	case request.PredictionResistance:
+		// Bypass the pre-generated queue and generate fresh data after a reseed
+		var data []byte
+		var reseedEvent uint64
+		data, reseedEvent, err = s.fetchPredictionResistantData(estimatedBytesNeeded)
+		if err != nil {
+			log.Printf("Prediction-resistant request failed: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Prediction resistance unavailable"})
+			return
+		}
+		rawData = [][]byte{data}
+		c.Header("X-Prediction-Resistance", "applied")
+		c.Header("X-Reseed-Event", strconv.FormatUint(reseedEvent, 10))
+	case request.Source == "trng":
 		rawData, err = s.db.GetTRNGData(estimatedChunksNeeded, request.Offset, consumeData)
-	} else {
+	default:
 		rawData, err = s.db.GetFortunaData(estimatedChunksNeeded, request.Offset, consumeData)
 	}
 
@@ -470,11 +542,11 @@ func convertToIntFormat(data [][]byte, maxCount, bytesPerValue int, signed bool)
 }
 
 // @Summary         Get system status
-// @Description     Get detailed status of TRNG and Fortuna systems with comprehensive metrics
+// @Description     Get detailed status of TRNG and Fortuna systems with comprehensive metrics and the events contributed by each entropy source
 // @Tags            status
 // @Accept          json
 // @Produce         json
-// @Success         200 {object} database.DetailedStats
+// @Success         200 {object} StatusResponse
 // @Failure         500 {object} map[string]string "Server error"
 // @Router          /status [get]
 func (s *Server) GetStatus(c *gin.Context) {
@@ -499,7 +571,40 @@ func (s *Server) GetStatus(c *gin.Context) {
 
 	s.metrics.DatabaseSizeBytes.Set(float64(stats.Database.SizeBytes))
 
-	c.JSON(http.StatusOK, stats)
+	response := StatusResponse{DetailedStats: *stats}
+	response.EntropySources, err = s.fetchEntropySources()
+	if err != nil {
+		log.Printf("Warning: failed to get entropy sources from Fortuna: %v", err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// fetchEntropySources returns the per-source event counts from Fortuna's /info
+func (s *Server) fetchEntropySources() ([]EntropySourceStats, error) {
+	// URL is constructed from validated server configuration, not user input
+	resp, err := http.Get(s.fortunaAddr + "/info") // #nosec G107
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Warning: failed to close Fortuna info response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fortuna returned status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Sources []EntropySourceStats `json:"sources"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode Fortuna info: %w", err)
+	}
+
+	return info.Sources, nil
 }
 
 // @Summary Health check endpoint
@@ -521,14 +626,20 @@ func (s *Server) HealthCheck(c *gin.Context) {
 	// Check API (always true if we got here)
 	response.Details.API = true
 
-	// Check controller service
-	response.Details.Controller = s.checkServiceHealth(s.controllerAddr)
+	// Check controller service and the devices behind it
+	response.Details.Controller, response.Details.ControllerDevices = s.checkControllerHealth()
+	for _, device := range response.Details.ControllerDevices {
+		if device.State == controllerDeviceStateIdentityMismatch {
+			response.Details.IdentityMismatch = true
+		}
+	}
 
 	// Check Fortuna service
 	response.Details.Fortuna = s.checkServiceHealth(s.fortunaAddr)
 
 	// Determine overall status
-	if !response.Details.Database || !response.Details.Controller || !response.Details.Fortuna {
+	if !response.Details.Database || !response.Details.Controller || !response.Details.Fortuna ||
+		response.Details.IdentityMismatch {
 		response.Status = "degraded"
 	}
 
@@ -554,6 +665,31 @@ func (s *Server) checkServiceHealth(serviceAddr string) bool {
 	return resp.StatusCode == http.StatusOK
 }
 
+// checkControllerHealth checks if the controller is reachable and healthy and
+// returns the state of its devices. The device list is also decoded from an
+// unhealthy response, which is where a failed or substituted chip shows up.
+func (s *Server) checkControllerHealth() (bool, []ControllerDeviceHealth) {
+	// URL is constructed from validated server configuration, not user input
+	resp, err := http.Get(s.controllerAddr + "/health") // #nosec G107
+	if err != nil {
+		return false, nil
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Warning: failed to close health check response body: %v", closeErr)
+		}
+	}()
+
+	var health struct {
+		Devices []ControllerDeviceHealth `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		log.Printf("Warning: failed to decode controller health response: %v", err)
+	}
+
+	return resp.StatusCode == http.StatusOK, health.Devices
+}
+
 // MetricsHandler serves Prometheus metrics
 func (s *Server) MetricsHandler(c *gin.Context) {
 	promhttp.Handler().ServeHTTP(c.Writer, c.Request)
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lokey/rng-service/pkg/fortuna"
+)
+
+func TestFetchEntropySources(t *testing.T) {
+	g, err := fortuna.NewGenerator(make([]byte, fortuna.MinimumSeedLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddRandomData(fortuna.SourceATECC608A, make([]byte, 40)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddRandomEvent(fortuna.SourceJitter, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fortuna's /info reports the stats of its generator under "sources"
+	fortunaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":  "running",
+			"sources": g.GetSourceStats(),
+		})
+	}))
+	t.Cleanup(fortunaServer.Close)
+
+	s := &Server{fortunaAddr: fortunaServer.URL}
+	sources, err := s.fetchEntropySources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EntropySourceStats{
+		{Source: "atecc608a", ID: byte(fortuna.SourceATECC608A), Events: 2, Bytes: 40},
+		{Source: "jitter", ID: byte(fortuna.SourceJitter), Events: 1, Bytes: 32},
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("got %+v, want %+v", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("source %d is %+v, want %+v", i, sources[i], want[i])
+		}
+	}
+}
+
+func TestFetchEntropySourcesFortunaUnavailable(t *testing.T) {
+	fortunaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(fortunaServer.Close)
+
+	s := &Server{fortunaAddr: fortunaServer.URL}
+	if sources, err := s.fetchEntropySources(); err == nil {
+		t.Errorf("got %+v from an unavailable Fortuna service", sources)
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokey/rng-service/pkg/signing"
+)
+
+// errUnknownSigner is returned for a batch whose serial has no key on the controller
+var errUnknownSigner = errors.New("no signing key for the batch serial")
+
+// SigningKey is a controller signing key the API accepts batches from. Keys
+// are trusted on first use: the first key seen for a serial is kept until the
+// API restarts, so a controller cannot swap the key of a chip silently.
+type SigningKey struct {
+	signing.PublicKeyInfo
+	TrustedSince time.Time `json:"trusted_since"`
+	LastCounter  uint64    `json:"last_counter,string"` // counter of the last batch accepted
+}
+
+// SigningKeysResponse lists the trusted signing keys
+type SigningKeysResponse struct {
+	RequireSigned bool         `json:"require_signed"`
+	Keys          []SigningKey `json:"keys"`
+}
+
+// SignatureResponse is the signed batch a TRNG value was delivered in
+type SignatureResponse struct {
+	Value       string              `json:"value"`
+	SignedBatch signing.SignedBatch `json:"signed_batch"`
+	PublicKey   string              `json:"public_key"` // uncompressed P-256 point, hex
+	Source      string              `json:"source"`     // "atecc608a" or "software"
+}
+
+// trustedKey is a pinned signing key and the state used to refuse replayed batches
+type trustedKey struct {
+	SigningKey
+	publicKey   *ecdsa.PublicKey
+	hasAccepted bool
+}
+
+// SetRequireSignedTRNG makes TRNG polling drop batches that are not signed
+func (s *Server) SetRequireSignedTRNG(require bool) {
+	s.signingMutex.Lock()
+	s.requireSigned = require
+	s.signingMutex.Unlock()
+}
+
+// requireSignedTRNG reports whether unsigned TRNG batches are dropped
+func (s *Server) requireSignedTRNG() bool {
+	s.signingMutex.RLock()
+	defer s.signingMutex.RUnlock()
+	return s.requireSigned
+}
+
+// verifyBatch checks a batch against the key pinned for its serial, fetching
+// the controller keys first if the serial is new. The counter of each batch
+// must be higher than that of the last batch accepted from the same serial.
+func (s *Server) verifyBatch(batch signing.SignedBatch) error {
+	s.signingMutex.RLock()
+	_, known := s.signingKeys[batch.Serial]
+	s.signingMutex.RUnlock()
+
+	if !known {
+		if err := s.fetchSigningKeys(); err != nil {
+			return err
+		}
+	}
+
+	s.signingMutex.Lock()
+	defer s.signingMutex.Unlock()
+
+	key, ok := s.signingKeys[batch.Serial]
+	if !ok {
+		return fmt.Errorf("%w %q", errUnknownSigner, batch.Serial)
+	}
+	if err := signing.Verify(batch, key.publicKey); err != nil {
+		return fmt.Errorf("batch %d of %s: %w", batch.Counter, batch.Serial, err)
+	}
+	if key.hasAccepted && batch.Counter <= key.LastCounter {
+		return fmt.Errorf("batch %d of %s is not newer than batch %d, refusing a replayed batch",
+			batch.Counter, batch.Serial, key.LastCounter)
+	}
+
+	key.LastCounter = batch.Counter
+	key.hasAccepted = true
+	return nil
+}
+
+// fetchSigningKeys reads the signing keys from the controller and pins those
+// of serials not seen before. A different key for a pinned serial is ignored.
+func (s *Server) fetchSigningKeys() error {
+	resp, err := http.Get(s.controllerAddr + "/signing-keys")
+	if err != nil {
+		return fmt.Errorf("error fetching signing keys: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Error closing signing keys response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("controller returned status %d for signing keys", resp.StatusCode)
+	}
+
+	var result struct {
+		Keys []signing.PublicKeyInfo `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing signing keys: %w", err)
+	}
+
+	s.signingMutex.Lock()
+	defer s.signingMutex.Unlock()
+
+	for _, info := range result.Keys {
+		if info.Algorithm != signing.Algorithm {
+			log.Printf("Warning: ignoring signing key of %s with unknown algorithm %q", info.Serial, info.Algorithm)
+			continue
+		}
+
+		if pinned, ok := s.signingKeys[info.Serial]; ok {
+			if pinned.PublicKey != info.PublicKey {
+				log.Printf("Warning: controller reports a new signing key for %s, keeping the key trusted since %s; restart the API to accept it",
+					info.Serial, pinned.TrustedSince.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		publicKey, err := signing.ParsePublicKey(info.PublicKey)
+		if err != nil {
+			log.Printf("Warning: ignoring signing key of %s: %v", info.Serial, err)
+			continue
+		}
+
+		s.signingKeys[info.Serial] = &trustedKey{
+			SigningKey: SigningKey{PublicKeyInfo: info, TrustedSince: time.Now().UTC()},
+			publicKey:  publicKey,
+		}
+		log.Printf("Trusting %s signing key of %s on first use: %s", info.Source, info.Serial, info.PublicKey)
+		if info.Source == "software" {
+			log.Printf("Warning: batches of %s are signed with a software key and do not prove which device produced them", info.Serial)
+		}
+	}
+
+	return nil
+}
+
+// signingKey returns the key pinned for a serial
+func (s *Server) signingKey(serial string) (SigningKey, bool) {
+	s.signingMutex.RLock()
+	defer s.signingMutex.RUnlock()
+	key, ok := s.signingKeys[serial]
+	if !ok {
+		return SigningKey{}, false
+	}
+	return key.SigningKey, true
+}
+
+// @Summary         List signing keys
+// @Description     List the controller signing keys the API trusts. A key is trusted the first time a batch
+// @Description     signed by its serial arrives and stays trusted until the API restarts.
+// @Tags            signatures
+// @Produce         json
+// @Success         200 {object} SigningKeysResponse
+// @Router          /signatures/keys [get]
+func (s *Server) GetSigningKeys(c *gin.Context) {
+	s.signingMutex.RLock()
+	response := SigningKeysResponse{
+		RequireSigned: s.requireSigned,
+		Keys:          make([]SigningKey, 0, len(s.signingKeys)),
+	}
+	for _, key := range s.signingKeys {
+		response.Keys = append(response.Keys, key.SigningKey)
+	}
+	s.signingMutex.RUnlock()
+
+	sort.Slice(response.Keys, func(i, j int) bool {
+		return response.Keys[i].Serial < response.Keys[j].Serial
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary         Get the signature of a TRNG value
+// @Description     Return the signed batch a TRNG value was delivered in, with the public key to verify it.
+// @Description     Values are 32 bytes; split binary output into 32-byte chunks to look each of them up.
+// @Description     Batches are kept for as many batches as the TRNG queue holds values.
+// @Tags            signatures
+// @Produce         json
+// @Param           value query string true "TRNG value, hex"
+// @Success         200 {object} SignatureResponse
+// @Failure         400 {object} map[string]string "Invalid value"
+// @Failure         404 {object} map[string]string "No signed batch holds the value"
+// @Failure         500 {object} map[string]string "Database error"
+// @Router          /signatures [get]
+func (s *Server) GetSignature(c *gin.Context) {
+	value, err := hex.DecodeString(c.Query("value"))
+	if err != nil || len(value) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value must be a hex-encoded TRNG value"})
+		return
+	}
+
+	batch, err := s.db.GetSignedBatch(value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read signed batch: %v", err)})
+		return
+	}
+	if batch == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No signed batch holds this value"})
+		return
+	}
+
+	key, ok := s.signingKey(batch.Serial)
+	if !ok {
+		// Stored batches were verified, so their key is pinned unless the API
+		// restarted since; then it is pinned again from the controller
+		if err := s.fetchSigningKeys(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if key, ok = s.signingKey(batch.Serial); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Signing key of %s is not known", batch.Serial)})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, SignatureResponse{
+		Value:       hex.EncodeToString(value),
+		SignedBatch: *batch,
+		PublicKey:   key.PublicKey,
+		Source:      key.Source,
+	})
+}
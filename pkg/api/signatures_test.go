@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lokey/rng-service/pkg/signing"
+)
+
+// testController serves /signing-keys like the controller does
+type testController struct {
+	mutex sync.Mutex
+	keys  []signing.PublicKeyInfo
+}
+
+func (c *testController) setSigners(signers ...signing.Signer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.keys = nil
+	for _, signer := range signers {
+		c.keys = append(c.keys, signing.PublicKeyInfo{
+			Serial:    signer.Serial(),
+			Algorithm: signing.Algorithm,
+			PublicKey: signing.EncodePublicKey(signer.PublicKey()),
+			Source:    "software",
+		})
+	}
+}
+
+func (c *testController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/signing-keys" {
+		http.NotFound(w, r)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]any{"mode": "software", "keys": c.keys})
+}
+
+// newSigningTestServer returns a server whose controller publishes the keys of signers
+func newSigningTestServer(t *testing.T, signers ...signing.Signer) (*Server, *testController) {
+	t.Helper()
+
+	controller := &testController{}
+	controller.setSigners(signers...)
+	ts := httptest.NewServer(controller)
+	t.Cleanup(ts.Close)
+
+	return &Server{controllerAddr: ts.URL, signingKeys: map[string]*trustedKey{}}, controller
+}
+
+func newSoftwareSigner(t *testing.T) *signing.SoftwareSigner {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.NewSoftwareSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signBatch(t *testing.T, batches *signing.BatchSigner) signing.SignedBatch {
+	t.Helper()
+
+	batch, err := batches.Sign([][]byte{{0x01, 0x02}, {0x03, 0x04}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func TestVerifyBatch(t *testing.T) {
+	signer := newSoftwareSigner(t)
+	s, _ := newSigningTestServer(t, signer)
+	batches := signing.NewBatchSigner(signer, 1)
+
+	first := signBatch(t, batches)
+	if err := s.verifyBatch(first); err != nil {
+		t.Fatal(err)
+	}
+
+	key, ok := s.signingKey(signer.Serial())
+	if !ok || key.LastCounter != first.Counter || key.PublicKey != signing.EncodePublicKey(signer.PublicKey()) {
+		t.Fatalf("pinned key is %+v", key)
+	}
+
+	tampered := signBatch(t, batches)
+	tampered.Data = append([]string(nil), tampered.Data...)
+	tampered.Data[0] = "0102ff"
+	if err := s.verifyBatch(tampered); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("tampered batch: got %v, want %v", err, signing.ErrInvalidSignature)
+	}
+
+	// A rejected batch does not move the counter
+	if key, _ := s.signingKey(signer.Serial()); key.LastCounter != first.Counter {
+		t.Errorf("last counter is %d after a rejected batch, want %d", key.LastCounter, first.Counter)
+	}
+}
+
+func TestVerifyBatchRejectsNonIncreasingCounter(t *testing.T) {
+	signer := newSoftwareSigner(t)
+	s, _ := newSigningTestServer(t, signer)
+	batches := signing.NewBatchSigner(signer, 1)
+
+	older := signBatch(t, batches)
+	newer := signBatch(t, batches)
+
+	if err := s.verifyBatch(newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifyBatch(newer); err == nil {
+		t.Error("accepted a replayed batch")
+	}
+	if err := s.verifyBatch(older); err == nil {
+		t.Error("accepted a batch with a lower counter")
+	}
+
+	if err := s.verifyBatch(signBatch(t, batches)); err != nil {
+		t.Errorf("next batch: %v", err)
+	}
+
+	// A restarted signer starts a higher epoch
+	restarted := signing.NewBatchSigner(signer, 2)
+	if err := s.verifyBatch(signBatch(t, restarted)); err != nil {
+		t.Errorf("batch of a new epoch: %v", err)
+	}
+	if err := s.verifyBatch(signBatch(t, signing.NewBatchSigner(signer, 1))); err == nil {
+		t.Error("accepted a batch of an earlier epoch")
+	}
+}
+
+func TestVerifyBatchUnknownSigner(t *testing.T) {
+	s, _ := newSigningTestServer(t, newSoftwareSigner(t))
+
+	batch := signBatch(t, signing.NewBatchSigner(newSoftwareSigner(t), 1))
+	if err := s.verifyBatch(batch); !errors.Is(err, errUnknownSigner) {
+		t.Errorf("got %v, want %v", err, errUnknownSigner)
+	}
+}
+
+// swappedSigner reports the serial of another signer
+type swappedSigner struct {
+	*signing.SoftwareSigner
+	serial string
+}
+
+func (s swappedSigner) Serial() string {
+	return s.serial
+}
+
+func TestVerifyBatchKeepsPinnedKey(t *testing.T) {
+	signer := newSoftwareSigner(t)
+	s, controller := newSigningTestServer(t, signer)
+
+	if err := s.verifyBatch(signBatch(t, signing.NewBatchSigner(signer, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The controller now publishes a different key for the same serial
+	impostor := swappedSigner{newSoftwareSigner(t), signer.Serial()}
+	controller.setSigners(impostor)
+	if err := s.fetchSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.verifyBatch(signBatch(t, signing.NewBatchSigner(impostor, 2))); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("batch signed with a swapped key: got %v, want %v", err, signing.ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,152 @@
+package atecc608a
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d2r2/go-i2c"
+)
+
+// Reserved addresses 0x00-0x07 and 0x78-0x7F cannot be assigned to a device
+const (
+	minI2CAddress = 0x08
+	maxI2CAddress = 0x77
+)
+
+// Bus is the connection the controller uses to talk to a single device. The
+// first byte of every write is the ATECC608A word address.
+type Bus interface {
+	// Wake holds SDA low long enough to wake the device from sleep or idle
+	Wake() error
+	// Write sends a word address followed by its payload
+	Write(data []byte) error
+	// Read reads len(buf) bytes from the device
+	Read(buf []byte) error
+	// Delay waits for the device, e.g. while a command executes
+	Delay(d time.Duration)
+	// Close releases the bus
+	Close() error
+}
+
+// DeviceAddress locates a device by I2C bus number and 7-bit address
+type DeviceAddress struct {
+	Bus     int
+	Address uint8
+}
+
+// String returns the address as <bus>:0x<address>, the format ParseDeviceAddresses accepts
+func (a DeviceAddress) String() string {
+	return fmt.Sprintf("%d:0x%02x", a.Bus, a.Address)
+}
+
+// ParseDeviceAddresses parses a comma-separated list of <bus>:<address>
+// pairs such as "1:0x60,1:0x61". The address may be decimal or 0x-prefixed
+// hex; a pair without an address uses DefaultI2CAddress.
+func ParseDeviceAddresses(list string) ([]DeviceAddress, error) {
+	var addresses []DeviceAddress
+	seen := make(map[DeviceAddress]bool)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		busPart, addressPart, hasAddress := strings.Cut(entry, ":")
+		busNumber, err := strconv.Atoi(strings.TrimSpace(busPart))
+		if err != nil || busNumber < 0 {
+			return nil, fmt.Errorf("invalid I2C bus in %q", entry)
+		}
+
+		address := uint64(DefaultI2CAddress)
+		if hasAddress {
+			address, err = strconv.ParseUint(strings.TrimSpace(addressPart), 0, 7)
+			if err != nil || address < minI2CAddress || address > maxI2CAddress {
+				return nil, fmt.Errorf("invalid I2C address in %q", entry)
+			}
+		}
+
+		device := DeviceAddress{Bus: busNumber, Address: uint8(address)}
+		if seen[device] {
+			return nil, fmt.Errorf("device %s listed more than once", device)
+		}
+		seen[device] = true
+		addresses = append(addresses, device)
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no I2C devices in %q", list)
+	}
+
+	return addresses, nil
+}
+
+// i2cBus talks to a device through the Linux I2C character device
+type i2cBus struct {
+	device      *i2c.I2C
+	generalCall *i2c.I2C // address 0x00, used to send the wake pulse
+}
+
+// OpenI2CBus opens /dev/i2c-<busNumber> for the device at address. A second
+// handle on the general call address is kept open to send wake pulses.
+func OpenI2CBus(busNumber int, address uint8) (Bus, error) {
+	path := fmt.Sprintf("/dev/i2c-%d", busNumber)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("I2C device %s does not exist in container", path)
+	} else if err == nil {
+		logDebug("I2C device permissions: %s", info.Mode())
+	}
+
+	logInfo("Initializing I2C connection to 0x%02x on bus %d", address, busNumber)
+	return openI2CBus(busNumber, address)
+}
+
+// openI2CBus opens the bus without logging, for probing many addresses
+func openI2CBus(busNumber int, address uint8) (Bus, error) {
+	device, err := i2c.NewI2C(address, busNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize I2C: %w", err)
+	}
+
+	generalCall, err := i2c.NewI2C(0x00, busNumber)
+	if err != nil {
+		_ = device.Close()
+		return nil, fmt.Errorf("failed to open I2C general call address: %w", err)
+	}
+
+	return &i2cBus{device: device, generalCall: generalCall}, nil
+}
+
+// Wake writes a zero byte to the general call address. At 100 kHz this holds
+// SDA low for longer than tWLO; the write itself is not acknowledged, so the
+// returned error is expected and only useful for debugging.
+func (b *i2cBus) Wake() error {
+	_, err := b.generalCall.WriteBytes([]byte{0x00})
+	return err
+}
+
+func (b *i2cBus) Write(data []byte) error {
+	_, err := b.device.WriteBytes(data)
+	return err
+}
+
+func (b *i2cBus) Read(buf []byte) error {
+	_, err := b.device.ReadBytes(buf)
+	return err
+}
+
+func (b *i2cBus) Delay(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (b *i2cBus) Close() error {
+	generalErr := b.generalCall.Close()
+	if err := b.device.Close(); err != nil {
+		return err
+	}
+	return generalErr
+}
@@ -0,0 +1,227 @@
+package atecc608a
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Conditioning modes of the output, set with TRNG_CONDITIONING
+const (
+	ConditioningNone     = "none"        // raw Random output
+	ConditioningSoftware = "sha256"      // SHA-256 of raw Random blocks, computed by the host
+	ConditioningChip     = "chip-sha256" // SHA-256 of raw Random blocks, computed by the chip and checked by the host
+)
+
+const (
+	// SHA command modes
+	shaModeStart  = 0x00 // initialize the SHA context
+	shaModeUpdate = 0x01 // add a 64-byte block to the context
+	shaModeEnd    = 0x02 // add the last 0-63 bytes and return the digest
+
+	// shaBlockSize is the size of a SHA-256 message block
+	shaBlockSize = 64
+
+	// randomBlockSize is the size of the output of a Random command
+	randomBlockSize = 32
+
+	// conditioningMargin is the min-entropy in bits the input of a 256-bit
+	// output needs beyond 256 for the output to count as full entropy, as in
+	// SP 800-90B section 3.1.5.1.2
+	conditioningMargin = 64
+
+	// MaxConditioningBlocks is the highest number of Random blocks conditioned
+	// into one output; more would not fit in the watchdog period of the SHA commands
+	MaxConditioningBlocks = 16
+)
+
+// ErrDigestMismatch is returned when the chip computes a different SHA-256
+// than the host for the same input
+var ErrDigestMismatch = errors.New("on-chip SHA-256 digest differs from the host's")
+
+// shaKnownAnswers are the SHA-256 examples of FIPS 180-2, and the empty
+// message; the long message takes an Update before End
+var shaKnownAnswers = []struct {
+	message string
+	digest  string
+}{
+	{message: "", digest: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{message: "abc", digest: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	{
+		message: "abcdefghbcdefghicdefghijdefghijkefghijklfghijklmghijklmnhijklmnoijklmnopjklmnopqklmnopqrlmnopqrsmnopqrstnopqrstu",
+		digest:  "cf5b16a778af8380036ce59e7b0492370b249b11e8f07a51afac45037afee9d1",
+	},
+}
+
+// ConditioningInfo describes how the output of a device is conditioned
+type ConditioningInfo struct {
+	Mode string `json:"mode"`
+	// InputBlocks is the number of 32-byte Random blocks hashed into each 32-byte output
+	InputBlocks int `json:"input_blocks,omitempty"`
+	// InputMinEntropy is the claimed min-entropy of the input of one output in bits
+	InputMinEntropy float64 `json:"input_min_entropy_bits,omitempty"`
+	// FullEntropy is set when the input carries 64 bits of min-entropy more than the output size
+	FullEntropy bool   `json:"full_entropy"`
+	Outputs     uint64 `json:"outputs"`
+	// DigestMismatches counts on-chip digests that differed from the host's
+	DigestMismatches uint64 `json:"digest_mismatches,omitempty"`
+}
+
+// defaultConditioningBlocks is the number of Random blocks whose claimed
+// min-entropy, at minEntropy bits per byte, makes a full-entropy output
+func defaultConditioningBlocks(minEntropy float64) int {
+	blocks := int(math.Ceil((sha256.Size*8 + conditioningMargin) / (randomBlockSize * minEntropy)))
+	return min(max(blocks, 1), MaxConditioningBlocks)
+}
+
+// configureConditioningFromEnv sets the conditioning mode from
+// TRNG_CONDITIONING and the number of Random blocks per output from
+// TRNG_CONDITIONING_BLOCKS, by default enough for a full-entropy output
+func (c *Controller) configureConditioningFromEnv(minEntropy float64) {
+	c.conditioning = ConditioningNone
+	if val, ok := os.LookupEnv("TRNG_CONDITIONING"); ok && val != "" {
+		switch val {
+		case ConditioningNone, ConditioningSoftware, ConditioningChip:
+			c.conditioning = val
+		default:
+			logWarn("Invalid TRNG_CONDITIONING %q, using default: %s", val, ConditioningNone)
+		}
+	}
+
+	c.conditioningBlocks = defaultConditioningBlocks(minEntropy)
+	if val, ok := os.LookupEnv("TRNG_CONDITIONING_BLOCKS"); ok {
+		if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= MaxConditioningBlocks {
+			c.conditioningBlocks = n
+		} else {
+			logWarn("Invalid TRNG_CONDITIONING_BLOCKS %q, using default: %d", val, c.conditioningBlocks)
+		}
+	}
+
+	if c.conditioning != ConditioningNone {
+		info := c.Conditioning()
+		logInfo("ATECC608A %s conditioning: %s of %d Random blocks, %g bits claimed min-entropy per output, full entropy: %t",
+			c.name, c.conditioning, c.conditioningBlocks, info.InputMinEntropy, info.FullEntropy)
+	}
+}
+
+// Conditioning returns the conditioning mode and how many outputs it produced
+func (c *Controller) Conditioning() ConditioningInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	info := ConditioningInfo{
+		Mode:             c.conditioning,
+		Outputs:          c.conditioned,
+		DigestMismatches: c.digestMismatches,
+	}
+	if c.conditioning != ConditioningNone {
+		info.InputBlocks = c.conditioningBlocks
+		info.InputMinEntropy = float64(c.conditioningBlocks*randomBlockSize) * c.healthTests.Stats().MinEntropy
+		info.FullEntropy = info.InputMinEntropy >= sha256.Size*8+conditioningMargin
+	}
+	return info
+}
+
+// condition hashes the concatenated Random blocks of one output, on the chip
+// in ConditioningChip mode. The chip's digest must match the host's: a chip
+// that computes wrong digests is faulty, and the device is failed.
+func (c *Controller) condition(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+
+	if c.conditioning == ConditioningChip {
+		chipDigest, err := c.chipSHA256(input)
+		if err != nil {
+			err = fmt.Errorf("sha command failed: %w", err)
+			c.setLastError(err)
+			c.handleCommandError(err)
+			return nil, err
+		}
+		c.idle()
+
+		if !bytes.Equal(chipDigest, digest[:]) {
+			c.mutex.Lock()
+			c.digestMismatches++
+			c.mutex.Unlock()
+
+			// The digests are output, so they are not logged
+			err := fmt.Errorf("%w, the chip is not used until it recovers", ErrDigestMismatch)
+			logError("ATECC608A %s: %v", c.name, err)
+			c.setLastError(err)
+			c.fail(err)
+			return nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	c.conditioned++
+	c.mutex.Unlock()
+
+	return digest[:], nil
+}
+
+// chipSHA256 computes the SHA-256 of message with the SHA command. The SHA
+// context does not survive sleep, so after a transient error the whole
+// sequence is sent again.
+func (c *Controller) chipSHA256(message []byte) ([]byte, error) {
+	var digest []byte
+	var err error
+	for retry := 0; retry <= maxCommandRetries; retry++ {
+		if retry > 0 {
+			logDebug("SHA retry %d after transient error: %v", retry, err)
+			c.errorCounts.recordRetry()
+			if errors.Is(err, ErrWatchdog) {
+				c.sleep()
+			}
+		}
+
+		digest, err = c.sha256Sequence(message)
+		if err == nil || !isTransient(err) {
+			break
+		}
+	}
+	return digest, err
+}
+
+// sha256Sequence sends Start, an Update for every full 64-byte block and End
+// with the remaining bytes
+func (c *Controller) sha256Sequence(message []byte) ([]byte, error) {
+	if _, err := c.execute(cmdSHA, shaModeStart, 0x0000, nil, 1); err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+
+	for len(message) >= shaBlockSize {
+		if _, err := c.execute(cmdSHA, shaModeUpdate, shaBlockSize, message[:shaBlockSize], 1); err != nil {
+			return nil, fmt.Errorf("update: %w", err)
+		}
+		message = message[shaBlockSize:]
+	}
+
+	digest, err := c.execute(cmdSHA, shaModeEnd, uint16(len(message)), message, sha256.Size) // #nosec G115 - less than 64 bytes
+	if err != nil {
+		return nil, fmt.Errorf("end: %w", err)
+	}
+	return digest, nil
+}
+
+// shaSelfTest checks the SHA command against known answers. Communication
+// errors are returned as they are; a wrong digest is returned as a *SelfTestError.
+func (c *Controller) shaSelfTest() error {
+	for _, kat := range shaKnownAnswers {
+		digest, err := c.chipSHA256([]byte(kat.message))
+		if err != nil {
+			return fmt.Errorf("start-up test sha command failed: %w", err)
+		}
+		if hex.EncodeToString(digest) != kat.digest {
+			return &SelfTestError{
+				Test: selfTestSHA256,
+				Err:  fmt.Errorf("SHA-256 of %q is %x, expected %s", kat.message, digest, kat.digest),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package atecc608a
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// chipSHA256On runs chipSHA256 on the supervisor goroutine of the controller
+func chipSHA256On(t *testing.T, controller *Controller, message []byte) []byte {
+	t.Helper()
+
+	var digest []byte
+	var err error
+	if doErr := controller.do(func() {
+		digest, err = controller.chipSHA256(message)
+		controller.idle()
+	}); doErr != nil {
+		t.Fatal(doErr)
+	}
+	if err != nil {
+		t.Fatalf("SHA-256 of %d bytes: %v", len(message), err)
+	}
+	return digest
+}
+
+func TestChipSHA256KnownAnswers(t *testing.T) {
+	controller := newTestController(t, provisionedEmulator(t))
+
+	for _, kat := range shaKnownAnswers {
+		if digest := chipSHA256On(t, controller, []byte(kat.message)); hex.EncodeToString(digest) != kat.digest {
+			t.Errorf("SHA-256 of %q is %x, want %s", kat.message, digest, kat.digest)
+		}
+	}
+}
+
+// TestChipSHA256BlockBoundaries checks messages around the 64-byte blocks
+// that are sent with Update, up to the longest conditioning input
+func TestChipSHA256BlockBoundaries(t *testing.T) {
+	controller := newTestController(t, provisionedEmulator(t))
+
+	message := make([]byte, MaxConditioningBlocks*randomBlockSize+1)
+	_, _ = rand.NewChaCha8([32]byte{1}).Read(message)
+
+	for _, n := range []int{1, 63, 64, 65, 127, 128, 129, MaxConditioningBlocks * randomBlockSize, len(message)} {
+		want := sha256.Sum256(message[:n])
+		if digest := chipSHA256On(t, controller, message[:n]); !bytes.Equal(digest, want[:]) {
+			t.Errorf("SHA-256 of %d bytes is %x, want %x", n, digest, want)
+		}
+	}
+}
+
+func TestSHASelfTestFailsOnWrongDigest(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	controller := newTestController(t, emulator)
+	emulator.InjectFault(FaultSHADigest)
+
+	var err error
+	if doErr := controller.do(func() {
+		err = controller.shaSelfTest()
+		controller.idle()
+	}); doErr != nil {
+		t.Fatal(doErr)
+	}
+
+	var selfTestErr *SelfTestError
+	if !errors.As(err, &selfTestErr) || selfTestErr.Test != selfTestSHA256 {
+		t.Errorf("got %v, want a %s self-test failure", err, selfTestSHA256)
+	}
+}
+
+func TestChipConditioning(t *testing.T) {
+	t.Setenv("TRNG_CONDITIONING", ConditioningChip)
+	t.Setenv("TRNG_CONDITIONING_BLOCKS", "3")
+
+	var seed [32]byte
+	emulator := provisionedEmulator(t)
+	emulator.SetRandomSource(rand.NewChaCha8(seed))
+	controller := newTestController(t, emulator)
+
+	if !controller.IsHealthy() {
+		t.Fatalf("device is %s, want healthy", controller.GetState())
+	}
+
+	// The start-up test consumes the first startupSamples bytes of the source
+	expected := rand.NewChaCha8(seed)
+	if _, err := io.CopyN(io.Discard, expected, startupSamples); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 2 {
+		got, err := controller.GenerateRandom()
+		if err != nil {
+			t.Fatalf("output %d: %v", i, err)
+		}
+
+		input := make([]byte, 3*randomBlockSize)
+		if _, err := io.ReadFull(expected, input); err != nil {
+			t.Fatal(err)
+		}
+		if want := sha256.Sum256(input); !bytes.Equal(got, want[:]) {
+			t.Fatalf("output %d is %x, want the SHA-256 of three Random blocks %x", i, got, want)
+		}
+	}
+
+	if info := controller.Conditioning(); info.Mode != ConditioningChip || info.InputBlocks != 3 || info.Outputs != 2 || info.DigestMismatches != 0 {
+		t.Errorf("conditioning info is %+v", info)
+	}
+}
+
+func TestChipConditioningDigestMismatch(t *testing.T) {
+	fastRecovery(t)
+	t.Setenv("TRNG_CONDITIONING", ConditioningChip)
+
+	emulator := provisionedEmulator(t)
+	controller := newTestController(t, emulator)
+	if !controller.IsHealthy() {
+		t.Fatalf("device is %s, want healthy", controller.GetState())
+	}
+
+	emulator.InjectFault(FaultSHADigest)
+	if _, err := controller.GenerateRandom(); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrDigestMismatch)
+	}
+	if controller.IsHealthy() {
+		t.Fatal("device still healthy after a digest mismatch")
+	}
+	if info := controller.Conditioning(); info.DigestMismatches != 1 || info.Outputs != 0 {
+		t.Errorf("conditioning info is %+v, want one mismatch and no outputs", info)
+	}
+
+	// Recovery runs the SHA known-answer test, which the chip fails again
+	waitForState(t, controller, DeviceStateSelfTestFailed)
+	if result := controller.SelfTestResult(); result.Passed || result.Test != selfTestSHA256 {
+		t.Errorf("self-test result is %+v, want %s failed", result, selfTestSHA256)
+	}
+	if _, err := controller.GenerateRandom(); err == nil {
+		t.Error("request served by a chip that computes wrong digests")
+	}
+}
+
+func TestStartupTestFailsOnWrongDigest(t *testing.T) {
+	t.Setenv("TRNG_CONDITIONING", ConditioningChip)
+
+	emulator := provisionedEmulator(t)
+	emulator.InjectFault(FaultSHADigest)
+	controller := newTestController(t, emulator)
+
+	if state := controller.GetState(); state != DeviceStateSelfTestFailed {
+		t.Fatalf("device is %s, want %s", state, DeviceStateSelfTestFailed)
+	}
+	if result := controller.SelfTestResult(); result.Passed || result.Test != selfTestSHA256 {
+		t.Errorf("self-test result is %+v, want %s failed", result, selfTestSHA256)
+	}
+}
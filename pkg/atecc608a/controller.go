@@ -1,41 +1,47 @@
 package atecc608a
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/d2r2/go-i2c"
 	goi2clogger "github.com/d2r2/go-logger"
+	"github.com/lokey/rng-service/pkg/healthtest"
 )
 
 const (
 	DefaultI2CAddress = 0x60 // Default I2C address for ATECC608A
 
-	// ATECC608A commands (following Adafruit implementation)
-	cmdInfo   = 0x30
-	cmdRandom = 0x1B
-	cmdIdle   = 0x02
-	cmdSleep  = 0x01
-	cmdWakeup = 0x00 // Wake command
-	cmdLock   = 0x17 // Lock command
-	cmdConfig = 0x47 // Config command
-	cmdWrite  = 0x12 // Write command
-
-	// Timing constants (from Adafruit implementation)
-	wakeupDelay    = 1 * time.Millisecond  // 1ms like Adafruit
-	randomExecTime = 50 * time.Millisecond // 50ms for random command
-	infoExecTime   = 1 * time.Millisecond  // 1ms for info command
-	configExecTime = 35 * time.Millisecond // Config command timing
-	lockExecTime   = 32 * time.Millisecond // Lock command timing
-	writeExecTime  = 26 * time.Millisecond // Write command timing
+	// ATECC608A command opcodes
+	cmdInfo    = 0x30
+	cmdRandom  = 0x1B
+	cmdRead    = 0x02 // Read command
+	cmdLock    = 0x17 // Lock command
+	cmdWrite   = 0x12 // Write command
+	cmdNonce   = 0x16 // Nonce command, loads TempKey
+	cmdSign    = 0x41 // Sign command
+	cmdGenKey  = 0x40 // GenKey command
+	cmdCounter = 0x24 // Counter command
+	cmdSHA     = 0x47 // SHA command
+
+	// Timing constants (from Adafruit implementation); command execution
+	// times depend on the chip variant, see variant.go
+	wakeupDelay = 1 * time.Millisecond // 1ms like Adafruit
 
 	// Retry constants
 	maxRetries        = 10
 	initialRetryDelay = 100 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+	slowRetryInterval = time.Minute
+	maxCommandRetries = 3 // resends of a command after a transient error
+
+	// maxQuarantined is the number of failed random blocks kept for inspection
+	maxQuarantined = 16
 )
 
 // LogLevel represents the logging verbosity level
@@ -56,10 +62,39 @@ const (
 	DeviceStateHealthy
 	DeviceStateFailed
 	DeviceStateRecovering
+	DeviceStateSelfTestFailed   // a start-up self-test failed; the device is not used again
+	DeviceStateIdentityMismatch // the chip is not the pinned one; the device is not used until approved
+	DeviceStateUnsupported      // the Info revision matches no variant table; the device is not used again
 )
 
+// String returns the name of the state as reported by the controller service
+func (s DeviceState) String() string {
+	switch s {
+	case DeviceStateHealthy:
+		return "healthy"
+	case DeviceStateFailed:
+		return "failed"
+	case DeviceStateRecovering:
+		return "recovering"
+	case DeviceStateSelfTestFailed:
+		return "self_test_failed"
+	case DeviceStateIdentityMismatch:
+		return "identity_mismatch"
+	case DeviceStateUnsupported:
+		return "unsupported_variant"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the state by its name, e.g. in JSON responses
+func (s DeviceState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 var (
 	currentLogLevel = LogLevelInfo // Default to Info
+	loggingOnce     sync.Once
 )
 
 // SetLogLevel configures the logging verbosity
@@ -92,33 +127,8 @@ func logError(format string, args ...interface{}) {
 	}
 }
 
-// TLS configuration template based on Adafruit implementation
-var CFG_TLS = []byte{
-	0x01, 0x23, 0x00, 0x00, 0x00, 0x00, 0x50, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xC0, 0x71, 0x00,
-	0xC0, 0x00, 0x55, 0x00, 0x83, 0x20, 0x87, 0x20, 0x87, 0x20, 0x87, 0x2F, 0x87, 0x2F, 0x8F, 0x8F,
-	0x9F, 0x8F, 0xAF, 0x8F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF,
-	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
-	0x00, 0x00, 0x55, 0x55, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x33, 0x00, 0x33, 0x00,
-}
-
-// Controller represents the ATECC608A device controller
-type Controller struct {
-	i2c              *i2c.I2C
-	LastError        error
-	mutex            sync.Mutex
-	busNumber        int
-	state            DeviceState
-	autoConfig       bool
-	recoveryInFlight bool
-	recoveryMutex    sync.Mutex
-}
-
-// NewController creates a new ATECC608A controller
-func NewController(busNumber int) (*Controller, error) {
-	// Set log level from environment
+// configureLogging sets the package and i2c library log levels from LOG_LEVEL
+func configureLogging() {
 	logLevelStr := os.Getenv("LOG_LEVEL")
 	if logLevelStr != "" {
 		switch logLevelStr {
@@ -154,6 +164,139 @@ func NewController(busNumber int) (*Controller, error) {
 			logInfo("Failed to set i2c logger level: %v", err)
 		}
 	}
+}
+
+// TLS configuration template based on Adafruit implementation
+var CFG_TLS = []byte{
+	0x01, 0x23, 0x00, 0x00, 0x00, 0x00, 0x50, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xC0, 0x71, 0x00,
+	0xC0, 0x00, 0x55, 0x00, 0x83, 0x20, 0x87, 0x20, 0x87, 0x20, 0x87, 0x2F, 0x87, 0x2F, 0x8F, 0x8F,
+	0x9F, 0x8F, 0xAF, 0x8F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0x00, 0x00, 0x55, 0x55, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x33, 0x00, 0x33, 0x00,
+}
+
+// Controller represents the ATECC608A device controller. All device I/O runs
+// on a single supervisor goroutine; the exported methods queue requests for it
+// or read state that it publishes under the mutex.
+type Controller struct {
+	name   string // identifies the device in logs, e.g. its bus and address
+	pinDir string // directory of identity pin files, empty when pinning is disabled
+
+	// Owned by the supervisor goroutine
+	bus             Bus
+	openBus         func() (Bus, error) // opens the bus again during recovery
+	requests        chan request
+	stopped         chan struct{} // closed when the supervisor has exited
+	closing         bool
+	policy          RecoveryPolicy
+	probeInterval   time.Duration
+	recoveryTimer   *time.Timer // pending recovery attempt, nil when none is scheduled
+	recoveryAttempt int
+	recoveryDelay   time.Duration
+	errorCounts     errorCounter
+	healthTests     *healthtest.Tester
+
+	// Set at construction
+	conditioning       string // ConditioningNone, ConditioningSoftware or ConditioningChip
+	conditioningBlocks int    // Random blocks hashed into each output when conditioning
+
+	// Shared with callers, protected by mutex
+	LastError   error
+	mutex       sync.Mutex
+	state       DeviceState
+	quarantine  [][]byte // most recent blocks that failed a health test
+	quarantined uint64   // blocks that failed a health test since startup
+	selfTest    SelfTestResult
+	lastProbe   ProbeResult
+	events      []StateEvent    // most recent state transitions, oldest first
+	identity    *DeviceIdentity // read during the last initialization, nil until then
+	variant     *Variant        // selected from the last Info revision read, nil until then
+	pinned      *PinnedIdentity // identity the chip is pinned to, nil until pinned
+
+	conditioned      uint64 // conditioned outputs since startup
+	digestMismatches uint64 // on-chip digests that differed from the host's
+}
+
+// NewController creates a new ATECC608A controller for the device at the default address on an I2C bus
+func NewController(busNumber int) (*Controller, error) {
+	return NewControllerAt(DeviceAddress{Bus: busNumber, Address: DefaultI2CAddress})
+}
+
+// NewControllerAt creates a new ATECC608A controller for the device at address
+func NewControllerAt(address DeviceAddress) (*Controller, error) {
+	return NewControllerWithBus(address.String(), func() (Bus, error) {
+		return OpenI2CBus(address.Bus, address.Address)
+	})
+}
+
+// NewControllerWithBus creates a controller that talks to the device through
+// the bus returned by openBus, e.g. an Emulator. openBus is called again
+// whenever the controller reconnects during recovery. name identifies the
+// device in logs.
+func NewControllerWithBus(name string, openBus func() (Bus, error)) (*Controller, error) {
+	controller, err := newController(name, openBus)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize the device. The supervisor is not running yet, so this
+	// goroutine still owns the bus.
+	var selfTestErr *SelfTestError
+	var mismatchErr *IdentityMismatchError
+	var variantErr *UnsupportedVariantError
+	if err := controller.initialize(); errors.As(err, &selfTestErr) {
+		// Degenerate output does not get better by retrying, keep the device out of use
+		logError("ATECC608A %s initialization failed: %v", controller.name, err)
+		controller.setState(DeviceStateSelfTestFailed, err.Error())
+	} else if errors.As(err, &mismatchErr) {
+		// A different chip needs an operator to approve it before it is used
+		logError("ATECC608A %s initialization failed: %v", controller.name, err)
+		controller.setState(DeviceStateIdentityMismatch, err.Error())
+	} else if errors.As(err, &variantErr) {
+		// Timings and layout of an unknown chip would be guesses, keep it out of use
+		logError("ATECC608A %s initialization failed: %v", controller.name, err)
+		controller.setState(DeviceStateUnsupported, err.Error())
+	} else if err != nil {
+		logError("ATECC608A %s initialization failed: %v", controller.name, err)
+		controller.setState(DeviceStateFailed, err.Error())
+		// Let the supervisor recover the device in the background
+		controller.startRecovery()
+		// Don't return error - let the controller exist but in failed state
+	} else {
+		controller.recordProbe(nil)
+		controller.setState(DeviceStateHealthy, "initialized")
+		logWarn("ATECC608A %s device initialized successfully", controller.name)
+	}
+
+	go controller.supervise()
+
+	return controller, nil
+}
+
+// NewOfflineController opens a device for maintenance, e.g. provisioning, while
+// the service is stopped. The device is not initialized or tested, it never
+// becomes healthy and is not recovered, so GenerateRandom is refused; only the
+// maintenance methods talk to it.
+func NewOfflineController(name string, openBus func() (Bus, error)) (*Controller, error) {
+	controller, err := newController(name, openBus)
+	if err != nil {
+		return nil, err
+	}
+
+	go controller.supervise()
+
+	return controller, nil
+}
+
+// newController opens the bus and sets up a controller from the environment,
+// without talking to the device
+func newController(name string, openBus func() (Bus, error)) (*Controller, error) {
+	// Set log level from environment, once for all controllers
+	loggingOnce.Do(configureLogging)
+	logLevelStr := os.Getenv("LOG_LEVEL")
 
 	// Optionally disable i2c logging output completely for production
 	var logOutput *os.File
@@ -164,81 +307,96 @@ func NewController(busNumber int) (*Controller, error) {
 
 	logDebug("Container I2C Debug - UID: %d, GID: %d", os.Getuid(), os.Getgid())
 
-	// Check if I2C device exists
-	if _, err := os.Stat("/dev/i2c-1"); os.IsNotExist(err) {
-		if logOutput != nil {
-			log.SetOutput(logOutput)
-		}
-		return nil, fmt.Errorf("I2C device /dev/i2c-1 does not exist in container")
-	}
+	bus, err := openBus()
 
-	// Check I2C device permissions
-	info, err := os.Stat("/dev/i2c-1")
-	if err == nil {
-		logDebug("I2C device permissions: %s", info.Mode())
+	// Restore log output after i2c init
+	if logOutput != nil {
+		log.SetOutput(logOutput)
 	}
 
-	logInfo("Initializing I2C connection to 0x%02x on bus %d", DefaultI2CAddress, busNumber)
-	i2c, err := i2c.NewI2C(DefaultI2CAddress, busNumber)
 	if err != nil {
-		if logOutput != nil {
-			log.SetOutput(logOutput)
+		return nil, err
+	}
+
+	// Claimed min-entropy of the raw output in bits per byte, which sets the health test cutoffs
+	minEntropy := healthtest.DefaultMinEntropy
+	if val, ok := os.LookupEnv("TRNG_MIN_ENTROPY"); ok {
+		if h, err := strconv.ParseFloat(val, 64); err == nil && h > 0 && h <= healthtest.MaxMinEntropy {
+			minEntropy = h
+		} else {
+			logWarn("Invalid TRNG_MIN_ENTROPY %q, using default: %g", val, healthtest.DefaultMinEntropy)
 		}
-		return nil, fmt.Errorf("failed to initialize I2C: %w", err)
 	}
 
-	// Restore log output after i2c init
-	if logOutput != nil {
-		log.SetOutput(logOutput)
+	healthTests, err := healthtest.New(minEntropy)
+	if err != nil {
+		_ = bus.Close()
+		return nil, err
 	}
 
 	controller := &Controller{
-		i2c:              i2c,
-		LastError:        nil,
-		busNumber:        busNumber,
-		state:            DeviceStateUnknown,
-		autoConfig:       true, // Enable auto-configuration by default
-		recoveryInFlight: false,
+		name:          name,
+		bus:           bus,
+		openBus:       openBus,
+		requests:      make(chan request),
+		stopped:       make(chan struct{}),
+		policy:        DefaultRecoveryPolicy,
+		probeInterval: DefaultProbeInterval,
+		LastError:     nil,
+		state:         DeviceStateUnknown,
+		healthTests:   healthTests,
+	}
+
+	stats := healthTests.Stats()
+	logInfo("ATECC608A %s health tests: claimed min-entropy %g bits/byte, RCT cutoff %d, APT cutoff %d/%d",
+		controller.name, stats.MinEntropy, stats.RCTCutoff, stats.APTCutoff, stats.APTWindow)
+
+	// IDENTITY_PIN_DIR enables identity pinning, with one pin file per device
+	if val, ok := os.LookupEnv("IDENTITY_PIN_DIR"); ok && val != "" {
+		if err := checkPinDir(val); err != nil {
+			_ = bus.Close()
+			return nil, fmt.Errorf("invalid IDENTITY_PIN_DIR: %w", err)
+		}
+		controller.pinDir = val
 	}
 
-	// Read environment variable to check if auto-config is disabled
-	if val, ok := os.LookupEnv("DISABLE_AUTO_CONFIG"); ok && val == "true" {
-		controller.autoConfig = false
-		logInfo("Auto-configuration disabled by environment variable")
-	}
-
-	// Initialize the device
-	if err := controller.initialize(); err != nil {
-		logError("Device initialization failed: %v", err)
-		controller.setState(DeviceStateFailed)
-		// Start recovery in background
-		go controller.startRecovery()
-		// Don't return error - let the controller exist but in failed state
-	} else {
-		controller.setState(DeviceStateHealthy)
-		logWarn("ATECC608A device initialized successfully")
-	}
+	controller.configureSupervisorFromEnv()
+	controller.configureConditioningFromEnv(minEntropy)
 
 	return controller, nil
 }
 
 // setState changes the device state and logs only on state changes
-func (c *Controller) setState(newState DeviceState) {
+func (c *Controller) setState(newState DeviceState, reason string) {
 	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.setStateUnlocked(newState, reason)
+}
+
+// setStateUnlocked changes the device state without acquiring the mutex and
+// records the transition in the event history
+func (c *Controller) setStateUnlocked(newState DeviceState, reason string) {
 	oldState := c.state
 	c.state = newState
-	c.mutex.Unlock()
 
 	if oldState != newState {
+		c.recordEventUnlocked(oldState, newState, reason)
+
 		switch newState {
 		case DeviceStateHealthy:
-			logWarn("ATECC608A device state changed to HEALTHY")
+			logWarn("ATECC608A %s device state changed to HEALTHY", c.name)
 		case DeviceStateFailed:
-			logError("ATECC608A device state changed to FAILED")
+			logError("ATECC608A %s device state changed to FAILED", c.name)
 		case DeviceStateRecovering:
-			logWarn("ATECC608A device state changed to RECOVERING")
+			logWarn("ATECC608A %s device state changed to RECOVERING", c.name)
+		case DeviceStateSelfTestFailed:
+			logError("ATECC608A %s device state changed to SELF_TEST_FAILED", c.name)
+		case DeviceStateIdentityMismatch:
+			logError("ATECC608A %s device state changed to IDENTITY_MISMATCH", c.name)
+		case DeviceStateUnsupported:
+			logError("ATECC608A %s device state changed to UNSUPPORTED_VARIANT", c.name)
 		case DeviceStateUnknown:
-			logWarn("ATECC608A device state changed to UNKNOWN")
+			logWarn("ATECC608A %s device state changed to UNKNOWN", c.name)
 		}
 	}
 }
@@ -250,274 +408,186 @@ func (c *Controller) getState() DeviceState {
 	return c.state
 }
 
-// startRecovery initiates the recovery process with exponential backoff
-func (c *Controller) startRecovery() {
-	c.recoveryMutex.Lock()
-	if c.recoveryInFlight {
-		c.recoveryMutex.Unlock()
-		return
-	}
-	c.recoveryInFlight = true
-	c.recoveryMutex.Unlock()
-
-	c.setState(DeviceStateRecovering)
-
-	delay := initialRetryDelay
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		logWarn("ATECC608A recovery attempt %d/%d (delay: %v)", attempt, maxRetries, delay)
-		time.Sleep(delay)
-
-		// Try to reinitialize
-		if err := c.reinitialize(); err != nil {
-			logWarn("ATECC608A recovery attempt %d/%d failed: %v", attempt, maxRetries, err)
-			delay *= 2 // Exponential backoff
-		} else {
-			logWarn("ATECC608A recovery successful on attempt %d/%d", attempt, maxRetries)
-			c.setState(DeviceStateHealthy)
-			c.recoveryMutex.Lock()
-			c.recoveryInFlight = false
-			c.recoveryMutex.Unlock()
-			return
-		}
-	}
-
-	// All retries exhausted
-	logError("ATECC608A recovery failed after %d attempts. Exiting.", maxRetries)
-	c.setState(DeviceStateFailed)
-	c.recoveryMutex.Lock()
-	c.recoveryInFlight = false
-	c.recoveryMutex.Unlock()
-
-	// Exit the process
-	os.Exit(1)
-}
-
 // reinitialize attempts to reinitialize the device
 func (c *Controller) reinitialize() error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	// Close existing connection if any
-	if c.i2c != nil {
+	if c.bus != nil {
+		// The device may be idle, and only accepts the sleep word address when awake
+		c.wakeup()
 		c.sleep()
-		_ = c.i2c.Close()
+		_ = c.bus.Close()
+		c.bus = nil
 	}
 
-	// Recreate I2C connection
-	i2c, err := i2c.NewI2C(DefaultI2CAddress, c.busNumber)
+	// Recreate the bus connection
+	bus, err := c.openBus()
 	if err != nil {
 		return fmt.Errorf("failed to reinitialize I2C: %w", err)
 	}
 
-	c.i2c = i2c
+	c.bus = bus
+
+	// Output from before the restart must not count toward runs or windows after it
+	c.healthTests.Reset()
 
 	// Initialize the device
-	return c.initializeUnlocked()
+	return c.initialize()
 }
 
 // initialize configures the device for random number generation
 func (c *Controller) initialize() error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	return c.initializeUnlocked()
-}
+	// Check the CRC and framing code before trusting anything read from the device
+	if err := SelfTestFraming(); err != nil {
+		c.recordSelfTest(0, err)
+		return err
+	}
 
-// initializeUnlocked performs initialization without acquiring the mutex
-func (c *Controller) initializeUnlocked() error {
 	// Wake up the device first
 	c.wakeup()
 
 	// Check device info
-	logInfo("Checking device information...")
-	if err := c.sendCommand(cmdInfo, 0x00, 0x0000, nil); err != nil {
-		return fmt.Errorf("failed to send info command: %w", err)
-	}
-
-	infoResponse, err := c.getResponse(4, infoExecTime)
+	logInfo("ATECC608A %s: checking device information...", c.name)
+	infoResponse, err := c.executeWithRetry(cmdInfo, 0x00, 0x0000, nil, 4)
 	if err != nil {
-		return fmt.Errorf("failed to get info response: %w", err)
+		return fmt.Errorf("info command failed: %w", err)
 	}
 
-	if len(infoResponse) < 4 {
-		return fmt.Errorf("info response too short: %d bytes", len(infoResponse))
+	// The timings of every later command depend on the variant
+	if err := c.selectVariant(infoResponse); err != nil {
+		c.idle()
+		return err
 	}
 
-	logDebug("Device info: %x", infoResponse)
-
 	// Check if device is locked by reading config zone lock bytes
-	isLocked, err := c.isDeviceLockedUnlocked()
-	if err != nil {
-		logWarn("Could not determine lock status: %v", err)
+	isLocked, lockErr := c.isDeviceLocked()
+	if lockErr != nil {
+		logWarn("Could not determine lock status: %v", lockErr)
 	}
 
 	// Try to read the device's current configuration
-	logInfo("Reading device configuration...")
-	if err := c.sendCommand(cmdConfig, 0x02, 0x0000, nil); err != nil {
-		return fmt.Errorf("failed to send config read command: %w", err)
-	}
-
-	configResponse, err := c.getResponse(32, configExecTime)
+	logInfo("ATECC608A %s: reading device configuration...", c.name)
+	configZone, err := c.readConfigZone()
 	if err != nil {
 		logWarn("Failed to read configuration: %v", err)
-		// Continue anyway, we'll try to configure
 	} else {
-		logDebug("Current configuration: %x", configResponse)
+		logDebug("Current configuration: %x", configZone)
 	}
 
-	// If device is not locked and auto-config is enabled, try to configure it
-	if !isLocked && c.autoConfig {
-		logInfo("Device not locked. Auto-configuration is enabled.")
-		if err := c.configureDeviceUnlocked(); err != nil {
-			logWarn("Failed to auto-configure device: %v", err)
-			logInfo("The device will continue to operate without being locked")
-		} else {
-			logInfo("Device successfully configured and locked!")
-		}
-	} else if !isLocked {
-		logInfo("Device not locked but auto-configuration is disabled.")
+	// Check the chip against its pin before drawing any output from it
+	if err := c.identify(infoResponse, configZone, err); err != nil {
+		c.idle()
+		return err
 	}
 
-	// Put device in idle
-	c.idle()
-
-	return nil
-}
-
-// isDeviceLockedUnlocked checks lock status without acquiring mutex
-func (c *Controller) isDeviceLockedUnlocked() (bool, error) {
-	// Read the lock bytes from config zone (address 0x15)
-	if err := c.sendCommand(cmdConfig, 0x02, 0x0015, nil); err != nil {
-		return false, fmt.Errorf("failed to send config read command: %w", err)
+	// The service never writes the configuration; that is an irreversible
+	// step left to the provision command
+	if lockErr == nil && !isLocked {
+		logWarn("ATECC608A %s: configuration zone not locked, unlocked devices return a fixed pattern instead of random data; run \"controller provision\" to configure it", c.name)
 	}
 
-	response, err := c.getResponse(4, configExecTime)
-	if err != nil {
-		return false, fmt.Errorf("failed to get lock status: %w", err)
+	// Draw samples through the health tests before the device is declared
+	// healthy; some devices answer Info correctly but return degenerate output
+	logInfo("ATECC608A %s: running start-up self-test on %d samples...", c.name, startupSamples)
+	samples, err := c.startupTest()
+	if err == nil && c.conditioning == ConditioningChip {
+		// Outputs are checked against the host as well, but a chip that
+		// computes wrong digests should not become healthy in the first place
+		c.idle()
+		err = c.shaSelfTest()
 	}
-
-	// Check lock bytes (0x00 = locked)
-	return response[2] == 0x00 && response[3] == 0x00, nil
-}
-
-// configureDeviceUnlocked configures without acquiring mutex
-func (c *Controller) configureDeviceUnlocked() error {
-	logInfo("Starting device configuration...")
-
-	// Ask for user confirmation to protect against accidental locking
-	logWarn("About to configure and lock the ATECC608A device.")
-	logWarn("This operation is IRREVERSIBLE and will permanently configure the device.")
-	logWarn("If you want to proceed, set FORCE_CONFIG=true in environment variables.")
-
-	// Check for force configuration environment variable
-	if val, ok := os.LookupEnv("FORCE_CONFIG"); !ok || val != "true" {
-		logWarn("Configuration aborted. FORCE_CONFIG environment variable not set to 'true'.")
-		return fmt.Errorf("configuration aborted due to missing confirmation")
+	c.recordSelfTest(samples, err)
+	if err != nil {
+		c.idle()
+		return err
 	}
+	logInfo("ATECC608A %s: start-up self-test passed", c.name)
 
-	logInfo("FORCE_CONFIG is set. Proceeding with device configuration...")
-
-	// Write configuration (skipping first 16 bytes which are read-only)
-	for i := 16; i < 128; i += 4 {
-		// Skip certain addresses that can't be written
-		if i == 84 {
-			continue
-		}
-
-		// Get the 4-byte block to write
-		blockData := make([]byte, 4)
-		end := i + 4
-		if end > len(CFG_TLS) {
-			end = len(CFG_TLS)
-		}
-		copy(blockData, CFG_TLS[i:end])
-
-		// Write the block - safe conversion as i/4 is always < 32
-		addr := uint16(i / 4) // #nosec G115 - i is bounded 16-128, i/4 always fits in uint16
-		logDebug("Writing block at address %d: %x", i, blockData)
-		if err := c.sendCommand(cmdWrite, 0x00, addr, blockData); err != nil {
-			return fmt.Errorf("failed to write configuration block: %w", err)
-		}
+	// Put device in idle
+	c.idle()
 
-		// Wait for write to complete
-		time.Sleep(writeExecTime)
+	return nil
+}
 
-		// Check status
-		status, err := c.getResponse(1, 1*time.Millisecond)
+// readConfigZone reads the 128-byte configuration zone as four 32-byte blocks
+func (c *Controller) readConfigZone() ([]byte, error) {
+	config := make([]byte, 0, configZoneSize)
+	for block := uint16(0); block < configZoneSize/32; block++ {
+		// Param1 bit 7 selects a 32-byte read, param2 holds the block number in bits 3-7
+		response, err := c.executeWithRetry(cmdRead, 0x80|zoneConfig, block<<3, nil, 32)
 		if err != nil {
-			return fmt.Errorf("failed to get write status: %w", err)
-		}
-
-		if len(status) > 0 && status[0] != 0x00 {
-			return fmt.Errorf("write failed with status: %x", status[0])
+			return nil, fmt.Errorf("failed to read config block %d: %w", block, err)
 		}
+		config = append(config, response...)
 	}
 
-	// Lock the configuration zone
-	logInfo("Configuration written. Now locking the configuration zone...")
-
-	// Send lock command for config zone (0x00)
-	if err := c.sendCommand(cmdLock, 0x80, 0x0000, nil); err != nil {
-		return fmt.Errorf("failed to send lock command: %w", err)
-	}
-
-	// Wait for lock to complete
-	time.Sleep(lockExecTime)
+	return config, nil
+}
 
-	// Check status
-	status, err := c.getResponse(1, 1*time.Millisecond)
+// isDeviceLocked checks lock status without acquiring mutex
+func (c *Controller) isDeviceLocked() (bool, error) {
+	// Read the lock bytes from config zone (bytes 84-87: block 2, word 5)
+	response, err := c.executeWithRetry(cmdRead, zoneConfig, 0x0015, nil, 4)
 	if err != nil {
-		return fmt.Errorf("failed to get lock status: %w", err)
-	}
-
-	if len(status) > 0 && status[0] != 0x00 {
-		return fmt.Errorf("lock failed with status: %x", status[0])
+		return false, fmt.Errorf("failed to get lock status: %w", err)
 	}
 
-	logInfo("Device configuration zone successfully locked!")
-	return nil
+	// Byte 87 (LockConfig) is 0x00 once the configuration zone is locked. The data
+	// zone lock in byte 86 does not matter here: configuration only needs the
+	// config zone to be unlocked.
+	return response[3] == 0x00, nil
 }
 
 // wakeup follows Adafruit's approach - always wake before operations
 func (c *Controller) wakeup() {
-	// Adafruit approach: try general call but ignore errors
-	wakeupI2C, err := i2c.NewI2C(0x00, c.busNumber)
-	if err == nil {
-		// Try to send wakeup, error is expected and can be ignored per Adafruit implementation
-		if _, err := wakeupI2C.WriteBytes([]byte{0x00}); err != nil {
-			logInfo("Wakeup signal error (expected): %v", err)
-		}
-		if err := wakeupI2C.Close(); err != nil {
-			logInfo("Wakeup I2C close error: %v", err)
-		}
+	// The wake pulse is not acknowledged, so an error is expected and can be ignored
+	if err := c.bus.Wake(); err != nil {
+		logInfo("Wakeup signal error (expected): %v", err)
 	}
 	// Always wait after wakeup attempt
-	time.Sleep(wakeupDelay)
+	c.bus.Delay(wakeupDelay)
 }
 
 // idle puts device in idle mode (following Adafruit)
 func (c *Controller) idle() {
-	if _, err := c.i2c.WriteBytes([]byte{cmdIdle}); err != nil {
+	if err := c.bus.Write([]byte{wordAddressIdle}); err != nil {
 		logError("I2C idle command failed: %v", err)
 	}
-	time.Sleep(wakeupDelay)
+	c.bus.Delay(wakeupDelay)
 }
 
 // sleep puts device in sleep mode (following Adafruit)
 func (c *Controller) sleep() {
-	if _, err := c.i2c.WriteBytes([]byte{cmdSleep}); err != nil {
+	if err := c.bus.Write([]byte{wordAddressSleep}); err != nil {
 		logError("I2C sleep command failed: %v", err)
 	}
-	time.Sleep(wakeupDelay)
+	c.bus.Delay(wakeupDelay)
 }
 
 // sendCommand builds and sends a command packet following Adafruit's structure
 func (c *Controller) sendCommand(opcode byte, param1 byte, param2 uint16, data []byte) error {
-	// Build command packet like Adafruit
+	commandPacket := buildCommandPacket(opcode, param1, param2, data)
+
+	// Always wake up before sending command
+	c.wakeup()
+
+	logDebug("Sending command packet: %x", commandPacket)
+
+	// Send command
+	if err := c.bus.Write(commandPacket); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+
+	c.bus.Delay(wakeupDelay)
+	return nil
+}
+
+// buildCommandPacket frames a command like Adafruit: word address, count,
+// opcode, param1, param2, data and CRC-16
+func buildCommandPacket(opcode byte, param1 byte, param2 uint16, data []byte) []byte {
 	commandPacket := make([]byte, 8+len(data))
 
 	// Word address
-	commandPacket[0] = 0x03
+	commandPacket[0] = wordAddressCommand
 	// Count (total packet length - 1)
 	commandPacket[1] = byte(len(commandPacket) - 1)
 	// Opcode
@@ -532,29 +602,47 @@ func (c *Controller) sendCommand(opcode byte, param1 byte, param2 uint16, data [
 	copy(commandPacket[6:], data)
 
 	// Calculate CRC on everything except word address and CRC itself
-	crc := c.calculateAdafruitCRC(commandPacket[1 : len(commandPacket)-2])
+	crc := calculateCRC(commandPacket[1 : len(commandPacket)-2])
 	commandPacket[len(commandPacket)-2] = byte(crc & 0xFF)
 	commandPacket[len(commandPacket)-1] = byte(crc >> 8)
 
-	// Always wake up before sending command
-	c.wakeup()
-
-	logDebug("Sending command packet: %x", commandPacket)
+	return commandPacket
+}
 
-	// Send command
-	_, err := c.i2c.WriteBytes(commandPacket)
+// execute sends a command and returns the data of its response, waiting the
+// execution time of the command on the chip variant
+func (c *Controller) execute(opcode byte, param1 byte, param2 uint16, data []byte, expectedLength int) ([]byte, error) {
+	execTime, err := c.execTime(opcode)
 	if err != nil {
-		return fmt.Errorf("failed to send command: %w", err)
+		return nil, err
 	}
+	if err := c.sendCommand(opcode, param1, param2, data); err != nil {
+		return nil, err
+	}
+	return c.getResponse(expectedLength, execTime)
+}
 
-	time.Sleep(wakeupDelay)
-	return nil
+// executeWithRetry runs a command that can safely be sent more than once,
+// resending it after transient errors. A watchdog status puts the device to
+// sleep first, so the next wake starts a full watchdog period.
+func (c *Controller) executeWithRetry(opcode byte, param1 byte, param2 uint16, data []byte, expectedLength int) ([]byte, error) {
+	response, err := c.execute(opcode, param1, param2, data, expectedLength)
+	for retry := 1; retry <= maxCommandRetries && err != nil && isTransient(err); retry++ {
+		logDebug("Command 0x%02x retry %d after transient error: %v", opcode, retry, err)
+		c.errorCounts.recordRetry()
+		if errors.Is(err, ErrWatchdog) {
+			c.sleep()
+		}
+		response, err = c.execute(opcode, param1, param2, data, expectedLength)
+	}
+	return response, err
 }
 
-// getResponse reads response with retries (following Adafruit)
+// getResponse reads a response with retries (following Adafruit) and checks
+// its length, CRC and status
 func (c *Controller) getResponse(expectedLength int, execTime time.Duration) ([]byte, error) {
 	// Wait for command execution
-	time.Sleep(execTime)
+	c.bus.Delay(execTime)
 
 	// Try to read response with retries
 	response := make([]byte, expectedLength+3) // +3 for length byte and 2 CRC bytes
@@ -563,25 +651,33 @@ func (c *Controller) getResponse(expectedLength int, execTime time.Duration) ([]
 	logDebug("Attempting to read %d byte response", len(response))
 
 	for retry := 0; retry < 20; retry++ {
-		_, err = c.i2c.ReadBytes(response)
+		err = c.bus.Read(response)
 		if err == nil {
 			logDebug("Read successful on retry %d: %x", retry, response)
 			break
 		}
 		logDebug("Retry %d failed: %v", retry, err)
-		time.Sleep(wakeupDelay)
+		c.bus.Delay(wakeupDelay)
 	}
 
 	if err != nil {
+		c.errorCounts.recordReadFailure()
 		return nil, fmt.Errorf("failed to read response after retries: %w", err)
 	}
 
-	// Return data portion (skip length byte and CRC)
-	return response[1 : len(response)-2], nil
+	data, err := parseResponse(response, expectedLength)
+	if err != nil {
+		c.errorCounts.record(err)
+		logDebug("Invalid response %x: %v", response, err)
+		return nil, err
+	}
+
+	return data, nil
 }
 
-// calculateAdafruitCRC implements Adafruit's exact CRC calculation
-func (c *Controller) calculateAdafruitCRC(data []byte) uint16 {
+// calculateCRC implements the CRC-16 (polynomial 0x8005, bit-reflected input)
+// that frames command and response packets, following Adafruit's implementation
+func calculateCRC(data []byte) uint16 {
 	if len(data) == 0 {
 		return 0
 	}
@@ -607,154 +703,144 @@ func (c *Controller) calculateAdafruitCRC(data []byte) uint16 {
 // GenerateRandom generates random bytes - ONLY from ATECC608A, no fallback
 func (c *Controller) GenerateRandom() ([]byte, error) {
 	// Check device state first - fail fast if not healthy
-	if c.getState() != DeviceStateHealthy {
-		return nil, fmt.Errorf("ATECC608A device not healthy (state: %d)", c.getState())
+	if state := c.getState(); state != DeviceStateHealthy {
+		return nil, fmt.Errorf("ATECC608A device not healthy (state: %s)", state)
 	}
 
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	// Send random command (opcode 0x1B, param1 0x00, param2 0x0000)
-	err := c.sendCommand(cmdRandom, 0x00, 0x0000, nil)
-	if err != nil {
-		c.LastError = fmt.Errorf("failed to send random command: %w", err)
-		// Trigger recovery
-		go c.handleDeviceFailure()
-		return nil, c.LastError
+	var randomData []byte
+	var err error
+	if doErr := c.do(func() { randomData, err = c.generateRandom() }); doErr != nil {
+		return nil, doErr
 	}
+	return randomData, err
+}
 
-	// Get response (32 bytes of random data)
-	response, err := c.getResponse(32, randomExecTime)
-	if err != nil {
-		c.LastError = fmt.Errorf("failed to get random response: %w", err)
-		// Trigger recovery
-		go c.handleDeviceFailure()
-		return nil, c.LastError
+// generateRandom produces one 32-byte output on the supervisor goroutine: a
+// Random block as it is, or the SHA-256 of conditioningBlocks of them
+func (c *Controller) generateRandom() ([]byte, error) {
+	if c.conditioning == ConditioningNone {
+		return c.randomBlock()
 	}
 
-	logDebug("Random response length: %d bytes", len(response))
-
-	if len(response) > 1 {
-		// Skip the status byte and use the rest as random data
-		randomData := response[1:]
-
-		// VALIDATION: Check if data is actually random (not error patterns)
-		if isRepeatingPattern(randomData) {
-			c.idle()
-			c.LastError = fmt.Errorf("ATECC608A returned invalid/repeating pattern - hardware failure detected")
-			// Trigger recovery
-			go c.handleDeviceFailure()
-			return nil, c.LastError
+	input := make([]byte, 0, c.conditioningBlocks*randomBlockSize)
+	defer clear(input)
+	for range c.conditioningBlocks {
+		block, err := c.randomBlock()
+		if err != nil {
+			return nil, err
 		}
-
-		c.idle()
-		return randomData, nil
+		input = append(input, block...)
+		clear(block)
 	}
 
-	// Response too short
-	c.idle()
-	c.LastError = fmt.Errorf("ATECC608A response too short: %d bytes", len(response))
-	// Trigger recovery
-	go c.handleDeviceFailure()
-	return nil, c.LastError
+	return c.condition(input)
 }
 
-// handleDeviceFailure marks device as failed and starts recovery
-func (c *Controller) handleDeviceFailure() {
-	if c.getState() == DeviceStateHealthy {
-		c.setState(DeviceStateFailed)
-		c.startRecovery()
+// randomBlock runs a Random command and the health tests on its output
+func (c *Controller) randomBlock() ([]byte, error) {
+	// The device may have failed while the request was queued
+	if state := c.getState(); state != DeviceStateHealthy {
+		return nil, fmt.Errorf("ATECC608A device not healthy (state: %s)", state)
 	}
-}
 
-// isRepeatingPattern checks if data has suspicious repeating patterns
-func isRepeatingPattern(data []byte) bool {
-	if len(data) < 4 {
-		return false
+	// Send random command (opcode 0x1B, param1 0x00, param2 0x0000) and get
+	// 32 bytes of random data. Random can be resent, so transient errors are
+	// retried before they count as a failure.
+	randomData, err := c.executeWithRetry(cmdRandom, 0x00, 0x0000, nil, randomBlockSize)
+	if err != nil {
+		err = fmt.Errorf("random command failed: %w", err)
+		c.setLastError(err)
+		c.handleCommandError(err)
+		return nil, err
 	}
 
-	// Check if all bytes are the same (common error pattern)
-	allSame := true
-	firstByte := data[0]
-	for _, b := range data {
-		if b != firstByte {
-			allSame = false
-			break
-		}
-	}
-	if allSame {
-		logError("Hardware failure: all bytes identical (0x%02x)", firstByte)
-		return true
-	}
+	c.idle()
 
-	// Check for 0xFF repeating (common I2C error - bus not responding)
-	ffCount := 0
-	for _, b := range data {
-		if b == 0xFF {
-			ffCount++
-		}
-	}
-	if float64(ffCount)/float64(len(data)) > 0.9 {
-		logError("Hardware failure: 0xFF pattern detected (%d/%d bytes)", ffCount, len(data))
-		return true
+	// VALIDATION: Run the SP 800-90B continuous health tests on every sample
+	if err := c.healthTests.Test(randomData); err != nil {
+		c.quarantineBlock(randomData)
+		err = fmt.Errorf("ATECC608A output failed health test - hardware failure detected: %w", err)
+		logError("%v", err)
+		c.setLastError(err)
+		// The device is marked failed before the next queued request runs, so no
+		// further block is served from a failed source
+		c.fail(err)
+		return nil, err
 	}
 
-	return false
+	return randomData, nil
 }
 
-// Close closes the I2C connection
-func (c *Controller) Close() error {
+// quarantineBlock keeps a block that failed a health test out of the output,
+// holding on to the most recent ones for inspection
+func (c *Controller) quarantineBlock(block []byte) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	// Put device to sleep before closing
-	c.sleep()
-
-	return c.i2c.Close()
-}
-
-// HealthCheck verifies the device is responsive
-func (c *Controller) HealthCheck() bool {
-	// Check state first
-	if c.getState() != DeviceStateHealthy {
-		return false
+	c.quarantined++
+	if len(c.quarantine) == maxQuarantined {
+		c.quarantine = c.quarantine[1:]
 	}
+	c.quarantine = append(c.quarantine, block)
+}
 
+// setLastError records the most recent device error
+func (c *Controller) setLastError(err error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	c.LastError = err
+}
 
-	// Wake up the device
-	c.wakeup()
-
-	// Send info command
-	err := c.sendCommand(cmdInfo, 0x00, 0x0000, nil)
-	if err != nil {
-		c.LastError = fmt.Errorf("health check failed (send): %w", err)
-		// Trigger recovery
-		go c.handleDeviceFailure()
-		return false
+// handleCommandError reacts to a command that failed after any retries.
+// Parse and checkmac errors mean the device rejected the command itself, so
+// the device is left alone; everything else starts recovery.
+func (c *Controller) handleCommandError(err error) {
+	if isPermanent(err) {
+		logError("ATECC608A %s rejected the command, not starting recovery: %v", c.name, err)
+		c.idle()
+		return
 	}
 
-	// Try to get response
-	_, err = c.getResponse(4, infoExecTime)
-	if err != nil {
-		c.LastError = fmt.Errorf("health check failed (receive): %w", err)
-		// Trigger recovery
-		go c.handleDeviceFailure()
-		return false
-	}
+	// Communication failures that outlasted retries, execution errors and ECC faults
+	c.fail(err)
+}
 
-	// Put device back to idle
-	c.idle()
+// Close stops the supervisor, puts the device to sleep and closes the bus
+func (c *Controller) Close() error {
+	var err error
+	doErr := c.do(func() {
+		c.closing = true
+		c.stopRecovery()
+
+		if c.bus == nil {
+			return
+		}
+
+		// Put device to sleep before closing
+		c.wakeup()
+		c.sleep()
 
-	return true
+		err = c.bus.Close()
+		c.bus = nil
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
 }
 
-// SetAutoConfig enables or disables auto-configuration
-func (c *Controller) SetAutoConfig(enabled bool) {
+// HealthCheck reports whether the device is healthy and passed its last
+// background probe. It does not talk to the device, so it never waits for
+// random generation.
+func (c *Controller) HealthCheck() bool {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.autoConfig = enabled
+	return c.state == DeviceStateHealthy && c.lastProbe.OK
+}
+
+// Name returns the name identifying the device, e.g. its bus and address
+func (c *Controller) Name() string {
+	return c.name
 }
 
 // GetState returns the current device state (for API exposure)
@@ -762,6 +848,29 @@ func (c *Controller) GetState() DeviceState {
 	return c.getState()
 }
 
+// ErrorCounts returns how often each kind of response error has occurred
+func (c *Controller) ErrorCounts() ErrorCounts {
+	return c.errorCounts.snapshot()
+}
+
+// HealthTestStats returns the counters of the continuous health tests
+func (c *Controller) HealthTestStats() healthtest.Stats {
+	return c.healthTests.Stats()
+}
+
+// Quarantined returns the number of random blocks withheld because they failed
+// a health test, and copies of the most recent ones
+func (c *Controller) Quarantined() (uint64, [][]byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	blocks := make([][]byte, len(c.quarantine))
+	for i, block := range c.quarantine {
+		blocks[i] = append([]byte(nil), block...)
+	}
+	return c.quarantined, blocks
+}
+
 // IsHealthy returns true if device is in healthy state
 func (c *Controller) IsHealthy() bool {
 	return c.getState() == DeviceStateHealthy
@@ -0,0 +1,340 @@
+package atecc608a
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+// emulatorSerial is the serial number of a new Emulator
+const emulatorSerial = "01234c4b65790001ee"
+
+// fastRecovery shortens the recovery delays for controllers created by the test
+func fastRecovery(t *testing.T) {
+	t.Helper()
+
+	policy := DefaultRecoveryPolicy
+	t.Cleanup(func() { DefaultRecoveryPolicy = policy })
+
+	DefaultRecoveryPolicy = RecoveryPolicy{
+		MaxRetries:        3,
+		InitialDelay:      time.Millisecond,
+		MaxDelay:          5 * time.Millisecond,
+		SlowRetryInterval: 5 * time.Millisecond,
+	}
+}
+
+// provisionedEmulator returns an emulator configured with the tls profile and locked
+func provisionedEmulator(t *testing.T) *Emulator {
+	t.Helper()
+
+	emulator := NewEmulator()
+	if err := emulator.Provision(CFG_TLS); err != nil {
+		t.Fatal(err)
+	}
+	return emulator
+}
+
+// newTestController initializes a controller on the emulator and closes it when the test ends
+func newTestController(t *testing.T, emulator *Emulator) *Controller {
+	t.Helper()
+
+	controller, err := NewControllerWithBus("test", func() (Bus, error) { return emulator, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = controller.Close() })
+	return controller
+}
+
+// newOfflineTestController opens the emulator for maintenance and closes it when the test ends
+func newOfflineTestController(t *testing.T, emulator *Emulator) *Controller {
+	t.Helper()
+
+	controller, err := NewOfflineController("test", func() (Bus, error) { return emulator, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = controller.Close() })
+	return controller
+}
+
+// waitForState waits for the controller to reach state
+func waitForState(t *testing.T, controller *Controller, state DeviceState) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for controller.GetState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("device is %s, want %s", controller.GetState(), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestControllerInitialize(t *testing.T) {
+	controller := newTestController(t, provisionedEmulator(t))
+
+	if state := controller.GetState(); state != DeviceStateHealthy {
+		t.Fatalf("device is %s after initialization, want healthy", state)
+	}
+	if !controller.HealthCheck() {
+		t.Error("health check fails after initialization")
+	}
+
+	identity, ok := controller.Identity()
+	if !ok {
+		t.Fatal("identity not read during initialization")
+	}
+	if identity.Serial != emulatorSerial || identity.Variant != VariantATECC608A {
+		t.Errorf("identity is %s serial %s, want %s serial %s", identity.Variant, identity.Serial, VariantATECC608A, emulatorSerial)
+	}
+	if !identity.ConfigLocked || !identity.DataLocked {
+		t.Errorf("lock status is config %t, data %t, want both locked", identity.ConfigLocked, identity.DataLocked)
+	}
+
+	if result := controller.SelfTestResult(); !result.Passed || result.Samples != startupSamples {
+		t.Errorf("self-test result is %+v, want passed on %d samples", result, startupSamples)
+	}
+
+	if info := controller.Variant(); !info.Detected || info.Variant != VariantATECC608A {
+		t.Errorf("variant is %+v, want detected %s", info, VariantATECC608A)
+	}
+}
+
+func TestControllerGenerateRandom(t *testing.T) {
+	var seed [32]byte
+	emulator := provisionedEmulator(t)
+	emulator.SetRandomSource(rand.NewChaCha8(seed))
+
+	controller := newTestController(t, emulator)
+
+	// The start-up test consumes the first startupSamples bytes of the source
+	expected := rand.NewChaCha8(seed)
+	if _, err := io.CopyN(io.Discard, expected, startupSamples); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 4 {
+		got, err := controller.GenerateRandom()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+
+		want := make([]byte, randomBlockSize)
+		if _, err := io.ReadFull(expected, want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("request %d returned %x, want %x", i, got, want)
+		}
+	}
+
+	if stats := controller.HealthTestStats(); stats.RCTFailures+stats.APTFailures+stats.BlockRepeats != 0 {
+		t.Errorf("health tests failed: %+v", stats)
+	}
+}
+
+func TestControllerRecoversFromFaults(t *testing.T) {
+	fastRecovery(t)
+
+	for _, fault := range []struct {
+		name  string
+		fault Fault
+	}{
+		{"nack", FaultNACK},
+		{"no wake", FaultNoWake},
+		{"response crc", FaultCRC},
+		{"execution error", FaultExecutionError},
+	} {
+		t.Run(fault.name, func(t *testing.T) {
+			emulator := provisionedEmulator(t)
+			controller := newTestController(t, emulator)
+
+			emulator.InjectFault(fault.fault)
+			if _, err := controller.GenerateRandom(); err == nil {
+				t.Fatal("request succeeded with a faulty device")
+			}
+			if controller.IsHealthy() {
+				t.Fatal("device still healthy after a failed request")
+			}
+			if _, err := controller.GenerateRandom(); err == nil {
+				t.Fatal("request served by a device that is not healthy")
+			}
+
+			// Recovery keeps failing until the fault goes away
+			waitForState(t, controller, DeviceStateFailed)
+
+			emulator.ClearFaults()
+			waitForState(t, controller, DeviceStateHealthy)
+
+			if _, err := controller.GenerateRandom(); err != nil {
+				t.Fatalf("request failed after recovery: %v", err)
+			}
+
+			var recovering, recovered bool
+			for _, event := range controller.Events() {
+				recovering = recovering || event.To == DeviceStateRecovering
+				recovered = recovered || event.From != DeviceStateUnknown && event.To == DeviceStateHealthy
+			}
+			if !recovering || !recovered {
+				t.Errorf("events %+v do not show the recovery", controller.Events())
+			}
+		})
+	}
+}
+
+func TestControllerRecoversMissingDevice(t *testing.T) {
+	fastRecovery(t)
+
+	emulator := provisionedEmulator(t)
+	emulator.InjectFault(FaultNACK)
+	controller := newTestController(t, emulator)
+
+	if controller.IsHealthy() {
+		t.Fatal("device healthy without answering")
+	}
+
+	emulator.ClearFaults()
+	waitForState(t, controller, DeviceStateHealthy)
+
+	if _, ok := controller.Identity(); !ok {
+		t.Error("identity not read during recovery")
+	}
+	if _, err := controller.GenerateRandom(); err != nil {
+		t.Fatalf("request failed after recovery: %v", err)
+	}
+}
+
+func TestControllerClosed(t *testing.T) {
+	controller := newTestController(t, provisionedEmulator(t))
+	if err := controller.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.GenerateRandom(); !errors.Is(err, ErrControllerClosed) {
+		t.Errorf("request after close returned %v, want %v", err, ErrControllerClosed)
+	}
+}
+
+func TestOfflineControllerRefusesRandom(t *testing.T) {
+	controller := newOfflineTestController(t, provisionedEmulator(t))
+
+	if _, err := controller.GenerateRandom(); err == nil {
+		t.Error("offline controller served random data")
+	}
+}
+
+func TestProvisionConfig(t *testing.T) {
+	emulator := NewEmulator()
+	controller := newOfflineTestController(t, emulator)
+
+	identity, err := controller.ReadIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ConfigLocked || identity.DataLocked {
+		t.Fatalf("new emulator is locked: config %t, data %t", identity.ConfigLocked, identity.DataLocked)
+	}
+
+	profile, err := LoadProfile("tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.LockDataZone(DataLockPhrase(identity.Serial)); !errors.Is(err, ErrConfigUnlocked) {
+		t.Errorf("data lock of an unlocked configuration returned %v, want %v", err, ErrConfigUnlocked)
+	}
+
+	if err := controller.ProvisionConfig(profile, "LOCK CONFIG"); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("provisioning with a wrong phrase returned %v, want %v", err, ErrNotConfirmed)
+	}
+	if config := emulator.ConfigZone(); config[lockConfigOffset] != 0x55 {
+		t.Fatal("configuration zone locked without confirmation")
+	}
+
+	if err := controller.ProvisionConfig(profile, ConfigLockPhrase(identity.Serial)); err != nil {
+		t.Fatal(err)
+	}
+
+	config := emulator.ConfigZone()
+	if config[lockConfigOffset] != 0x00 {
+		t.Error("configuration zone not locked")
+	}
+	if config[lockValueOffset] != 0x55 {
+		t.Error("data zone locked along with the configuration zone")
+	}
+	if !bytes.Equal(config[16:84], CFG_TLS[16:84]) || !bytes.Equal(config[88:], CFG_TLS[88:]) {
+		t.Errorf("configuration zone is %x, want the tls profile %x", config, CFG_TLS)
+	}
+	if !bytes.Equal(config[:16], NewEmulator().ConfigZone()[:16]) {
+		t.Error("read-only bytes 0-15 changed")
+	}
+
+	if err := controller.ProvisionConfig(profile, ConfigLockPhrase(identity.Serial)); !errors.Is(err, ErrConfigLocked) {
+		t.Errorf("second provisioning returned %v, want %v", err, ErrConfigLocked)
+	}
+
+	if err := controller.LockDataZone(ConfigLockPhrase(identity.Serial)); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("data lock with a wrong phrase returned %v, want %v", err, ErrNotConfirmed)
+	}
+	if err := controller.LockDataZone(DataLockPhrase(identity.Serial)); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err = controller.ReadIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.ConfigLocked || !identity.DataLocked {
+		t.Errorf("lock status is config %t, data %t after provisioning, want both locked", identity.ConfigLocked, identity.DataLocked)
+	}
+	if identity.Config != hex.EncodeToString(emulator.ConfigZone()) {
+		t.Errorf("identity config %s does not match the emulator", identity.Config)
+	}
+
+	if err := controller.LockDataZone(DataLockPhrase(identity.Serial)); !errors.Is(err, ErrDataLocked) {
+		t.Errorf("second data lock returned %v, want %v", err, ErrDataLocked)
+	}
+}
+
+func TestControllerQuarantinesFailedBlocks(t *testing.T) {
+	fastRecovery(t)
+
+	emulator := provisionedEmulator(t)
+	controller := newTestController(t, emulator)
+
+	// The first block after the fault is served, the next ones repeat it
+	emulator.InjectFault(FaultStuckRandom)
+	first, err := controller.GenerateRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.GenerateRandom(); !errors.Is(err, healthtest.ErrRepeatedBlock) {
+		t.Fatalf("repeated block returned %v, want %v", err, healthtest.ErrRepeatedBlock)
+	}
+	if controller.IsHealthy() {
+		t.Fatal("device still healthy after a failed health test")
+	}
+
+	count, blocks := controller.Quarantined()
+	if count != 1 || len(blocks) != 1 || !bytes.Equal(blocks[0], first) {
+		t.Errorf("quarantined %d blocks %x, want the repeated block %x", count, blocks, first)
+	}
+	if stats := controller.HealthTestStats(); stats.BlockRepeats != 1 {
+		t.Errorf("%d block repeats counted, want 1", stats.BlockRepeats)
+	}
+
+	// Recovery runs the start-up test, which the stuck output fails again
+	waitForState(t, controller, DeviceStateSelfTestFailed)
+	if _, err := controller.GenerateRandom(); err == nil {
+		t.Error("request served after a failed start-up test")
+	}
+}
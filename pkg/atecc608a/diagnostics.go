@@ -0,0 +1,257 @@
+package atecc608a
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+// Names of the steps of a diagnostic run, in the order they run
+const (
+	diagnosticFraming     = "framing"
+	diagnosticWake        = "wake"
+	diagnosticInfo        = "info"
+	diagnosticConfig      = "config"
+	diagnosticRandom      = "random"
+	diagnosticHealthTests = "health_tests"
+	diagnosticSHA256      = "sha256"
+)
+
+// ScanResult is an address that answered a scan
+type ScanResult struct {
+	Address    uint8  `json:"address"`
+	CryptoAuth bool   `json:"cryptoauth"` // answered the wake pulse with the wake token
+	Response   string `json:"response"`   // first bytes read after the wake pulse, hex
+}
+
+// ScanI2CBus probes every assignable address on /dev/i2c-<busNumber>
+func ScanI2CBus(busNumber int) ([]ScanResult, error) {
+	return Scan(func(address uint8) (Bus, error) {
+		return openI2CBus(busNumber, address)
+	})
+}
+
+// Scan wakes each assignable address on a bus and reads from it; open returns
+// the bus for one address. An address that acknowledges the read holds a
+// device, and a CryptoAuth device (ATECC508A/608A/608B) answers with the wake
+// token and is put back to sleep. Other devices on the bus only see a read.
+func Scan(open func(address uint8) (Bus, error)) ([]ScanResult, error) {
+	loggingOnce.Do(configureLogging)
+
+	var results []ScanResult
+	for address := uint8(minI2CAddress); address <= maxI2CAddress; address++ {
+		bus, err := open(address)
+		if err != nil {
+			return results, fmt.Errorf("failed to open address 0x%02x: %w", address, err)
+		}
+
+		// The wake pulse is not acknowledged, so its error is ignored
+		_ = bus.Wake()
+		bus.Delay(wakeupDelay)
+
+		response := make([]byte, 4)
+		if err := bus.Read(response); err != nil {
+			logDebug("Scan 0x%02x: no answer: %v", address, err)
+			_ = bus.Close()
+			continue
+		}
+
+		_, err = parseResponse(response, 1)
+		result := ScanResult{
+			Address:    address,
+			CryptoAuth: errors.Is(err, ErrWakeToken),
+			Response:   hex.EncodeToString(response),
+		}
+		if result.CryptoAuth {
+			if err := bus.Write([]byte{wordAddressSleep}); err != nil {
+				logDebug("Scan 0x%02x: sleep failed: %v", address, err)
+			}
+		}
+		results = append(results, result)
+
+		_ = bus.Close()
+	}
+
+	return results, nil
+}
+
+// DiagnosticStep is the outcome of one step of a diagnostic run
+type DiagnosticStep struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Detail   string        `json:"detail,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Diagnose checks the device step by step: the framing known answers, the
+// wake token, Info, the configuration zone, a single Random command, the
+// start-up health tests and the SHA command. It stops at the first failing step, since later
+// steps depend on it. Samples drawn are discarded.
+func (c *Controller) Diagnose() ([]DiagnosticStep, error) {
+	var steps []DiagnosticStep
+	err := c.do(func() {
+		run := func(name string, step func() (string, error)) bool {
+			start := time.Now()
+			detail, err := step()
+			result := DiagnosticStep{Name: name, Passed: err == nil, Detail: detail, Duration: time.Since(start)}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			steps = append(steps, result)
+			return err == nil
+		}
+
+		_ = run(diagnosticFraming, c.diagnoseFraming) &&
+			run(diagnosticWake, c.diagnoseWake) &&
+			run(diagnosticInfo, c.diagnoseInfo) &&
+			run(diagnosticConfig, c.diagnoseConfig) &&
+			run(diagnosticRandom, c.diagnoseRandom) &&
+			run(diagnosticHealthTests, c.diagnoseHealthTests) &&
+			run(diagnosticSHA256, c.diagnoseSHA256)
+
+		c.idle()
+	})
+	return steps, err
+}
+
+// diagnoseFraming runs the CRC and framing known-answer tests
+func (c *Controller) diagnoseFraming() (string, error) {
+	if err := SelfTestFraming(); err != nil {
+		return "", err
+	}
+	return "CRC and framing known answers match", nil
+}
+
+// diagnoseWake puts the device to sleep and checks that a wake pulse brings
+// it back with the wake token
+func (c *Controller) diagnoseWake() (string, error) {
+	// The device may be idle, and only accepts the sleep word address when awake
+	c.wakeup()
+	c.sleep()
+
+	if err := c.bus.Wake(); err != nil {
+		logDebug("Wakeup signal error (expected): %v", err)
+	}
+	c.bus.Delay(wakeupDelay)
+
+	response := make([]byte, 4)
+	if err := c.bus.Read(response); err != nil {
+		return "", fmt.Errorf("no answer after wake pulse: %w", err)
+	}
+	if _, err := parseResponse(response, 1); !errors.Is(err, ErrWakeToken) {
+		return "", fmt.Errorf("read %x after wake pulse instead of the wake token", response)
+	}
+
+	return fmt.Sprintf("wake token %x", response), nil
+}
+
+// diagnoseInfo reads the Info revision and loads the variant table the later
+// steps run with; an unsupported variant fails the step
+func (c *Controller) diagnoseInfo() (string, error) {
+	revision, err := c.executeWithRetry(cmdInfo, 0x00, 0x0000, nil, 4)
+	if err != nil {
+		return "", fmt.Errorf("info command failed: %w", err)
+	}
+	if err := c.selectVariant(revision); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("revision %x, %s", revision, variantFromRevision(revision)), nil
+}
+
+// diagnoseConfig reads the configuration zone and reports its lock status.
+// An unlocked chip passes this step; its fixed output fails the health tests.
+func (c *Controller) diagnoseConfig() (string, error) {
+	identity, err := c.readIdentity()
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("serial %s, configuration zone locked: %t, data zone locked: %t",
+		identity.Serial, identity.ConfigLocked, identity.DataLocked)
+	if !identity.ConfigLocked {
+		detail += "; run \"controller provision\" before use"
+	}
+	return detail, nil
+}
+
+// diagnoseRandom runs a single Random command
+func (c *Controller) diagnoseRandom() (string, error) {
+	c.idle()
+	block, err := c.executeWithRetry(cmdRandom, 0x00, 0x0000, nil, 32)
+	if err != nil {
+		return "", fmt.Errorf("random command failed: %w", err)
+	}
+	clear(block)
+	return fmt.Sprintf("%d bytes", len(block)), nil
+}
+
+// diagnoseHealthTests runs the start-up self-test
+func (c *Controller) diagnoseHealthTests() (string, error) {
+	c.idle()
+	samples, err := c.startupTest()
+	c.recordSelfTest(samples, err)
+
+	stats := c.healthTests.Stats()
+	detail := fmt.Sprintf("%d samples, longest run %d (cutoff %d), max proportion %d/%d (cutoff %d)",
+		samples, stats.LongestRun, stats.RCTCutoff, stats.MaxProportion, stats.APTWindow, stats.APTCutoff)
+	return detail, err
+}
+
+// diagnoseSHA256 runs the SHA command known-answer tests that the start-up
+// self-test runs when the output is conditioned on the chip
+func (c *Controller) diagnoseSHA256() (string, error) {
+	c.idle()
+	if err := c.shaSelfTest(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d known answers match", len(shaKnownAnswers)), nil
+}
+
+// CaptureRaw writes samples bytes of raw Random output to w for an offline
+// entropy assessment, e.g. with the SP 800-90B tools. The output is not
+// filtered: blocks that fail a health test are written too, and the returned
+// statistics of a separate set of health tests show whether any did. progress,
+// if not nil, is called with the number of bytes written after each block.
+func (c *Controller) CaptureRaw(w io.Writer, samples int, progress func(written int)) (healthtest.Stats, error) {
+	tester, err := healthtest.New(c.healthTests.Stats().MinEntropy)
+	if err != nil {
+		return healthtest.Stats{}, err
+	}
+
+	written := 0
+	for written < samples {
+		var block []byte
+		var cmdErr error
+		err := c.do(func() {
+			block, cmdErr = c.executeWithRetry(cmdRandom, 0x00, 0x0000, nil, 32)
+			c.idle()
+		})
+		if err != nil {
+			return tester.Stats(), err
+		}
+		if cmdErr != nil {
+			return tester.Stats(), fmt.Errorf("random command failed after %d bytes: %w", written, cmdErr)
+		}
+
+		if err := tester.Test(block); err != nil {
+			logWarn("ATECC608A %s: captured block failed a health test: %v", c.name, err)
+		}
+
+		block = block[:min(len(block), samples-written)]
+		if _, err := w.Write(block); err != nil {
+			return tester.Stats(), fmt.Errorf("failed to write samples: %w", err)
+		}
+		written += len(block)
+
+		if progress != nil {
+			progress(written)
+		}
+	}
+
+	return tester.Stats(), nil
+}
@@ -0,0 +1,183 @@
+package atecc608a
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// otherDevice is a device that acknowledges reads but does not speak CryptoAuth
+type otherDevice struct {
+	writes int
+}
+
+func (d *otherDevice) Wake() error { return nil }
+func (d *otherDevice) Write([]byte) error {
+	d.writes++
+	return nil
+}
+func (d *otherDevice) Read(buf []byte) error {
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+	return nil
+}
+func (d *otherDevice) Delay(time.Duration) {}
+func (d *otherDevice) Close() error        { return nil }
+
+func TestScan(t *testing.T) {
+	other := &otherDevice{}
+
+	results, err := Scan(func(address uint8) (Bus, error) {
+		switch address {
+		case 0x60:
+			return NewEmulator(), nil
+		case 0x61:
+			return other, nil
+		}
+		emulator := NewEmulator()
+		emulator.InjectFault(FaultNACK)
+		return emulator, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %+v, want the devices at 0x60 and 0x61", results)
+	}
+	if results[0].Address != 0x60 || !results[0].CryptoAuth || results[0].Response != "04113343" {
+		t.Errorf("0x60 is %+v, want a CryptoAuth device", results[0])
+	}
+	if results[1].Address != 0x61 || results[1].CryptoAuth || results[1].Response != "ffffffff" {
+		t.Errorf("0x61 is %+v, want another device", results[1])
+	}
+	if other.writes != 0 {
+		t.Errorf("the other device got %d writes, want only a read", other.writes)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	all := []string{diagnosticFraming, diagnosticWake, diagnosticInfo, diagnosticConfig, diagnosticRandom, diagnosticHealthTests, diagnosticSHA256}
+
+	for name, tc := range map[string]struct {
+		emulator func(t *testing.T) *Emulator
+		steps    int    // number of steps run
+		failed   string // step that fails, empty if all pass
+	}{
+		"provisioned": {
+			emulator: provisionedEmulator,
+			steps:    len(all),
+		},
+		"unprovisioned": {
+			// An unlocked chip returns a fixed pattern, which the health tests reject
+			emulator: func(t *testing.T) *Emulator { return NewEmulator() },
+			steps:    6,
+			failed:   diagnosticHealthTests,
+		},
+		"stuck output": {
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.InjectFault(FaultStuckRandom)
+				return e
+			},
+			steps:  6,
+			failed: diagnosticHealthTests,
+		},
+		"unknown revision": {
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.SetRevision([4]byte{0x00, 0x00, 0x60, 0x04})
+				return e
+			},
+			steps:  3,
+			failed: diagnosticInfo,
+		},
+		"no wake token": {
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.InjectFault(FaultNoWake)
+				return e
+			},
+			steps:  2,
+			failed: diagnosticWake,
+		},
+		"broken SHA engine": {
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.InjectFault(FaultSHADigest)
+				return e
+			},
+			steps:  len(all),
+			failed: diagnosticSHA256,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			controller := newOfflineTestController(t, tc.emulator(t))
+
+			steps, err := controller.Diagnose()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != tc.steps {
+				t.Fatalf("ran %d steps, want %d: %+v", len(steps), tc.steps, steps)
+			}
+
+			// Steps run in order and stop at the first failure
+			for i, step := range steps {
+				if step.Name != all[i] {
+					t.Errorf("step %d is %s, want %s", i, step.Name, all[i])
+				}
+				if failed := step.Name == tc.failed; step.Passed == failed || failed != (step.Error != "") {
+					t.Errorf("step %s passed %t with error %q", step.Name, step.Passed, step.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureRaw(t *testing.T) {
+	var seed [32]byte
+	emulator := provisionedEmulator(t)
+	emulator.SetRandomSource(rand.NewChaCha8(seed))
+
+	controller := newOfflineTestController(t, emulator)
+	if _, err := controller.ReadIdentity(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last block is cut to the number of samples asked for
+	var out bytes.Buffer
+	var progress []int
+	stats, err := controller.CaptureRaw(&out, 100, func(written int) { progress = append(progress, written) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 100)
+	if _, err := io.ReadFull(rand.NewChaCha8(seed), want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("captured %x, want the raw Random output %x", out.Bytes(), want)
+	}
+	if len(progress) != 4 || progress[0] != 32 || progress[3] != 100 {
+		t.Errorf("progress reported %v, want 32, 64, 96, 100", progress)
+	}
+	if stats.RCTFailures != 0 || stats.APTFailures != 0 {
+		t.Errorf("health tests failed on good data: %+v", stats)
+	}
+
+	// Degenerate output is written as it is, and shows in the statistics
+	emulator.InjectFault(FaultStuckRandom)
+	out.Reset()
+	stats, err = controller.CaptureRaw(&out, 256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 256 || stats.RCTFailures+stats.BlockRepeats == 0 {
+		t.Errorf("captured %d bytes of stuck output with stats %+v", out.Len(), stats)
+	}
+}
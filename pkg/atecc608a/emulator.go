@@ -0,0 +1,606 @@
+package atecc608a
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// Word addresses, the first byte of every write
+	wordAddressReset   = 0x00
+	wordAddressSleep   = 0x01
+	wordAddressIdle    = 0x02
+	wordAddressCommand = 0x03
+
+	// Zone selectors for Read, Write and Lock
+	zoneConfig = 0x00
+	zoneData   = 0x01
+
+	// configZoneSize is the size of the ATECC608A configuration zone in bytes
+	configZoneSize = 128
+	// Offsets of the lock bytes in the configuration zone (0x00 = locked, 0x55 = unlocked)
+	lockValueOffset  = 86
+	lockConfigOffset = 87
+
+	// watchdogTimeout is how long the device stays awake before it falls asleep on its own
+	watchdogTimeout = 1300 * time.Millisecond
+)
+
+// errNACK is returned by the emulator when the device would not acknowledge a transfer
+var errNACK = errors.New("remote I/O error: device did not acknowledge")
+
+// EmulatorRevision is the Info revision reported by the emulator by default, that of an ATECC608A
+var EmulatorRevision = [4]byte{0x00, 0x00, 0x60, 0x02}
+
+// Fault is a failure the emulator can be told to simulate
+type Fault int
+
+// Faults that can be injected into the emulator
+const (
+	FaultNACK           Fault = iota + 1 // every transfer fails, as if the device were missing
+	FaultNoWake                          // wake pulses are ignored
+	FaultCRC                             // responses carry a corrupted CRC
+	FaultStuckRandom                     // Random returns the same 32 bytes every time
+	FaultExecutionError                  // every command fails with status 0x0F
+	FaultSHADigest                       // SHA returns a corrupted digest
+)
+
+// emulatorState is the power state of the emulated device
+type emulatorState int
+
+const (
+	emulatorAsleep emulatorState = iota
+	emulatorAwake
+	emulatorIdle
+)
+
+// Emulator is an in-memory ATECC608A that implements Bus. It follows the
+// wake/sleep/idle power states, word addresses and CRC-16 framing of the real
+// device and implements the Info, Random, Read, Write and Lock commands on the
+// configuration zone, Nonce, Sign, GenKey and Counter with P-256 keys held in
+// memory, and SHA, so the controller can run without I2C hardware.
+type Emulator struct {
+	mutex    sync.Mutex
+	state    emulatorState
+	wokeAt   time.Time
+	config   [configZoneSize]byte
+	response []byte // pending response packet, consumed by the next read
+	random   io.Reader
+	faults   map[Fault]bool
+	stuck    []byte // output repeated while FaultStuckRandom is active
+	keys     [numSlots]*ecdsa.PrivateKey
+	tempKey  []byte    // loaded by Nonce and lost on sleep, nil when not valid
+	sha      hash.Hash // SHA context started by SHA Start and lost on sleep, nil when not started
+	counters [NumCounters]uint32
+}
+
+// NewEmulator returns an emulated ATECC608A in its factory state: a fixed
+// serial number, I2C address 0xC0 and unlocked configuration and data zones
+func NewEmulator() *Emulator {
+	e := &Emulator{
+		random: rand.Reader,
+		faults: make(map[Fault]bool),
+	}
+
+	// Serial number bytes 0-3 and 8-12, revision in bytes 4-7
+	copy(e.config[0:4], []byte{0x01, 0x23, 0x4C, 0x4B})
+	copy(e.config[4:8], EmulatorRevision[:])
+	copy(e.config[8:13], []byte{0x65, 0x79, 0x00, 0x01, 0xEE})
+	e.config[14] = 0x01 // I2C enabled
+	e.config[16] = DefaultI2CAddress << 1
+	e.config[lockValueOffset] = 0x55
+	e.config[lockConfigOffset] = 0x55
+
+	return e
+}
+
+// Provision writes config (bytes 16-127 except 84-87), locks both zones and
+// creates a key in every slot configured for a P-256 private key, like a
+// device that has already been configured
+func (e *Emulator) Provision(config []byte) error {
+	if len(config) != configZoneSize {
+		return fmt.Errorf("configuration must be %d bytes long, got %d", configZoneSize, len(config))
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	copy(e.config[16:84], config[16:84])
+	copy(e.config[88:], config[88:])
+	e.config[lockValueOffset] = 0x00
+	e.config[lockConfigOffset] = 0x00
+
+	for slot := range numSlots {
+		if e.privateKeySlotUnlocked(slot) {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				return fmt.Errorf("failed to create key for slot %d: %w", slot, err)
+			}
+			e.keys[slot] = key
+		}
+	}
+
+	return nil
+}
+
+// SetSerialNumber replaces the 9-byte serial number, e.g. to simulate a
+// different chip showing up on the bus
+func (e *Emulator) SetSerialNumber(serial []byte) error {
+	if len(serial) != 9 {
+		return fmt.Errorf("serial number must be 9 bytes long, got %d", len(serial))
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	copy(e.config[0:4], serial[0:4])
+	copy(e.config[8:13], serial[4:9])
+
+	return nil
+}
+
+// SetRevision replaces the Info revision, which is also the RevNum of the
+// configuration zone, e.g. to emulate an ATECC608B or an unknown chip
+func (e *Emulator) SetRevision(revision [4]byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	copy(e.config[4:8], revision[:])
+}
+
+// SetRandomSource replaces the source of the Random command output
+func (e *Emulator) SetRandomSource(r io.Reader) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.random = r
+}
+
+// InjectFault makes the emulator simulate a failure until ClearFaults is called
+func (e *Emulator) InjectFault(f Fault) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.faults[f] = true
+}
+
+// ClearFaults removes all injected faults
+func (e *Emulator) ClearFaults() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	clear(e.faults)
+	e.stuck = nil
+}
+
+// ConfigZone returns a copy of the configuration zone
+func (e *Emulator) ConfigZone() []byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]byte(nil), e.config[:]...)
+}
+
+// Wake wakes the device from sleep or idle, after which it answers the next
+// read with the wake token. An awake device ignores the pulse.
+func (e *Emulator) Wake() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.faults[FaultNACK] || e.faults[FaultNoWake] {
+		return errNACK
+	}
+
+	e.checkWatchdogUnlocked()
+	if e.state == emulatorAwake {
+		return nil
+	}
+
+	e.response = e.packetUnlocked([]byte{statusAfterWake})
+	e.state = emulatorAwake
+	e.wokeAt = time.Now()
+
+	return nil
+}
+
+// Write handles a word address and, for commands, the command packet
+func (e *Emulator) Write(data []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.checkWatchdogUnlocked()
+	if e.faults[FaultNACK] || e.state != emulatorAwake || len(data) == 0 {
+		return errNACK
+	}
+
+	switch data[0] {
+	case wordAddressReset:
+		e.response = nil
+	case wordAddressSleep:
+		e.state = emulatorAsleep
+		e.response = nil
+		e.tempKey = nil
+		e.sha = nil
+	case wordAddressIdle:
+		e.state = emulatorIdle
+		e.response = nil
+	case wordAddressCommand:
+		e.response = e.packetUnlocked(e.executeUnlocked(data[1:]))
+	default:
+		return errNACK
+	}
+
+	return nil
+}
+
+// Read returns the pending response. Bytes beyond the end of the packet read as 0xFF.
+func (e *Emulator) Read(buf []byte) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.checkWatchdogUnlocked()
+	if e.faults[FaultNACK] || e.state != emulatorAwake || e.response == nil {
+		return errNACK
+	}
+
+	n := copy(buf, e.response)
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0xFF
+	}
+	e.response = nil
+
+	return nil
+}
+
+// Delay returns immediately; the emulator executes commands without delay
+func (e *Emulator) Delay(time.Duration) {}
+
+// Close puts the device to sleep. The emulator can be used again after Close,
+// which lets the controller reopen it during recovery.
+func (e *Emulator) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.state = emulatorAsleep
+	e.response = nil
+	e.tempKey = nil
+	e.sha = nil
+
+	return nil
+}
+
+// checkWatchdogUnlocked puts the device to sleep once the watchdog has expired
+func (e *Emulator) checkWatchdogUnlocked() {
+	if e.state == emulatorAwake && time.Since(e.wokeAt) > watchdogTimeout {
+		e.state = emulatorAsleep
+		e.response = nil
+		e.tempKey = nil
+		e.sha = nil
+	}
+}
+
+// packetUnlocked frames a response as count, data and CRC-16
+func (e *Emulator) packetUnlocked(data []byte) []byte {
+	packet := make([]byte, len(data)+3)
+	packet[0] = byte(len(packet))
+	copy(packet[1:], data)
+
+	crc := calculateCRC(packet[:len(packet)-2])
+	if e.faults[FaultCRC] {
+		crc ^= 0xFFFF
+	}
+	packet[len(packet)-2] = byte(crc & 0xFF)
+	packet[len(packet)-1] = byte(crc >> 8)
+
+	return packet
+}
+
+// executeUnlocked checks the framing of a command packet (count, opcode,
+// param1, param2, data, CRC) and returns the response data
+func (e *Emulator) executeUnlocked(packet []byte) []byte {
+	if len(packet) < 7 || int(packet[0]) != len(packet) {
+		return []byte{statusParseError}
+	}
+
+	crc := calculateCRC(packet[:len(packet)-2])
+	if packet[len(packet)-2] != byte(crc&0xFF) || packet[len(packet)-1] != byte(crc>>8) {
+		return []byte{statusCRCError}
+	}
+
+	if e.faults[FaultExecutionError] {
+		return []byte{statusExecutionError}
+	}
+
+	opcode, param1 := packet[1], packet[2]
+	param2 := uint16(packet[3]) | uint16(packet[4])<<8
+	data := packet[5 : len(packet)-2]
+
+	switch opcode {
+	case cmdInfo:
+		return e.infoUnlocked(param1)
+	case cmdRandom:
+		return e.randomUnlocked()
+	case cmdRead:
+		return e.readUnlocked(param1, param2)
+	case cmdWrite:
+		return e.writeUnlocked(param1, param2, data)
+	case cmdLock:
+		return e.lockUnlocked(param1, param2)
+	case cmdNonce:
+		return e.nonceUnlocked(param1, data)
+	case cmdSign:
+		return e.signUnlocked(param1, param2)
+	case cmdGenKey:
+		return e.genKeyUnlocked(param1, param2)
+	case cmdCounter:
+		return e.counterUnlocked(param1, param2)
+	case cmdSHA:
+		return e.shaUnlocked(param1, param2, data)
+	default:
+		return []byte{statusParseError}
+	}
+}
+
+// infoUnlocked implements Info; only the revision mode is supported
+func (e *Emulator) infoUnlocked(mode byte) []byte {
+	if mode != 0x00 {
+		return []byte{statusParseError}
+	}
+	return append([]byte(nil), e.config[4:8]...)
+}
+
+// randomUnlocked implements Random. Like the real device it returns a fixed
+// FF FF 00 00 pattern until the configuration zone is locked.
+func (e *Emulator) randomUnlocked() []byte {
+	out := make([]byte, 32)
+
+	if e.config[lockConfigOffset] != 0x00 {
+		for i := 0; i < len(out); i += 4 {
+			out[i], out[i+1] = 0xFF, 0xFF
+		}
+		return out
+	}
+
+	if e.faults[FaultStuckRandom] && e.stuck != nil {
+		copy(out, e.stuck)
+		return out
+	}
+
+	if _, err := io.ReadFull(e.random, out); err != nil {
+		return []byte{statusExecutionError}
+	}
+
+	if e.faults[FaultStuckRandom] {
+		e.stuck = append([]byte(nil), out...)
+	}
+
+	return out
+}
+
+// configRange returns the configuration zone byte range addressed by a Read or Write
+func configRange(param1 byte, param2 uint16) (start, length int, ok bool) {
+	if param1&0x03 != zoneConfig {
+		return 0, 0, false
+	}
+
+	block := int(param2>>3) & 0x1F
+	offset := int(param2) & 0x07
+
+	length = 4
+	if param1&0x80 != 0 {
+		length = 32
+		if offset != 0 {
+			return 0, 0, false
+		}
+	}
+
+	start = block*32 + offset*4
+	if start+length > configZoneSize {
+		return 0, 0, false
+	}
+
+	return start, length, true
+}
+
+// readUnlocked implements Read on the configuration zone
+func (e *Emulator) readUnlocked(param1 byte, param2 uint16) []byte {
+	start, length, ok := configRange(param1, param2)
+	if !ok {
+		return []byte{statusParseError}
+	}
+	return append([]byte(nil), e.config[start:start+length]...)
+}
+
+// writeUnlocked implements Write on the configuration zone. Bytes 0-15 are
+// read-only and bytes 84-87 can only be changed by UpdateExtra and Lock.
+func (e *Emulator) writeUnlocked(param1 byte, param2 uint16, data []byte) []byte {
+	start, length, ok := configRange(param1, param2)
+	if !ok || len(data) != length {
+		return []byte{statusParseError}
+	}
+
+	if e.config[lockConfigOffset] == 0x00 {
+		return []byte{statusExecutionError}
+	}
+
+	for i := start; i < start+length; i++ {
+		if i < 16 || (i >= 84 && i < 88) {
+			return []byte{statusExecutionError}
+		}
+	}
+
+	copy(e.config[start:], data)
+	return []byte{statusSuccess}
+}
+
+// lockUnlocked implements Lock for the configuration and data zones. Unless
+// bit 7 of the mode is set, param2 must hold the CRC-16 of the zone contents.
+func (e *Emulator) lockUnlocked(mode byte, summary uint16) []byte {
+	zone := mode & 0x03
+
+	switch zone {
+	case zoneConfig:
+		if e.config[lockConfigOffset] == 0x00 {
+			return []byte{statusExecutionError}
+		}
+		if mode&0x80 == 0 && calculateCRC(e.config[:]) != summary {
+			return []byte{statusExecutionError}
+		}
+		e.config[lockConfigOffset] = 0x00
+
+	case zoneData:
+		// The data zone can only be locked after the configuration zone. The
+		// emulator does not store the data zone, so its summary CRC is not checked.
+		if e.config[lockConfigOffset] != 0x00 || e.config[lockValueOffset] == 0x00 {
+			return []byte{statusExecutionError}
+		}
+		e.config[lockValueOffset] = 0x00
+
+	default:
+		return []byte{statusParseError}
+	}
+
+	return []byte{statusSuccess}
+}
+
+// privateKeySlotUnlocked reports whether the KeyConfig of slot is that of a P-256 private key
+func (e *Emulator) privateKeySlotUnlocked(slot int) bool {
+	keyConfig := uint16(e.config[keyConfigOffset+2*slot]) | uint16(e.config[keyConfigOffset+2*slot+1])<<8
+	return keyConfig&0x01 != 0 && int(keyConfig>>2)&0x07 == keyTypeP256
+}
+
+// publicKeyUnlocked returns the public key of slot as X || Y
+func (e *Emulator) publicKeyUnlocked(slot int) []byte {
+	publicKey := make([]byte, PublicKeySize)
+	e.keys[slot].X.FillBytes(publicKey[:32])
+	e.keys[slot].Y.FillBytes(publicKey[32:])
+	return publicKey
+}
+
+// nonceUnlocked implements Nonce in pass-through mode, which loads 32 bytes into TempKey
+func (e *Emulator) nonceUnlocked(mode byte, data []byte) []byte {
+	if mode != nonceModePassThrough || len(data) != DigestSize {
+		return []byte{statusParseError}
+	}
+	e.tempKey = append([]byte(nil), data...)
+	return []byte{statusSuccess}
+}
+
+// signUnlocked implements Sign of an external message: it signs the digest in
+// TempKey with the private key in a slot, once both zones are locked
+func (e *Emulator) signUnlocked(mode byte, keyID uint16) []byte {
+	if mode != signModeExternal || keyID >= numSlots {
+		return []byte{statusParseError}
+	}
+
+	slot := int(keyID)
+	if e.config[lockConfigOffset] != 0x00 || e.config[lockValueOffset] != 0x00 ||
+		e.tempKey == nil || e.keys[slot] == nil {
+		return []byte{statusExecutionError}
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, e.keys[slot], e.tempKey)
+	e.tempKey = nil
+	if err != nil {
+		return []byte{statusExecutionError}
+	}
+
+	signature := make([]byte, SignatureSize)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+// genKeyUnlocked implements GenKey: the public key of the private key in a
+// slot, or a new private key in a slot configured for one
+func (e *Emulator) genKeyUnlocked(mode byte, keyID uint16) []byte {
+	if keyID >= numSlots {
+		return []byte{statusParseError}
+	}
+	slot := int(keyID)
+
+	switch mode {
+	case genKeyModePublic:
+		if e.keys[slot] == nil {
+			return []byte{statusExecutionError}
+		}
+
+	case genKeyModeCreate:
+		if e.config[lockConfigOffset] != 0x00 || !e.privateKeySlotUnlocked(slot) {
+			return []byte{statusExecutionError}
+		}
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return []byte{statusExecutionError}
+		}
+		e.keys[slot] = key
+
+	default:
+		return []byte{statusParseError}
+	}
+
+	return e.publicKeyUnlocked(slot)
+}
+
+// counterUnlocked implements Counter: read or increment one of the monotonic counters
+func (e *Emulator) counterUnlocked(mode byte, counter uint16) []byte {
+	if counter >= NumCounters {
+		return []byte{statusParseError}
+	}
+
+	switch mode {
+	case counterModeRead:
+	case counterModeIncrement:
+		if e.counters[counter] >= MaxCounterValue {
+			return []byte{statusExecutionError}
+		}
+		e.counters[counter]++
+	default:
+		return []byte{statusParseError}
+	}
+
+	return binary.LittleEndian.AppendUint32(nil, e.counters[counter])
+}
+
+// shaUnlocked implements the Start, Update and End modes of SHA. Update takes
+// exactly one 64-byte block; End takes the remaining 0-63 bytes, their count
+// in param2, and returns the digest.
+func (e *Emulator) shaUnlocked(mode byte, length uint16, data []byte) []byte {
+	switch mode {
+	case shaModeStart:
+		e.sha = sha256.New()
+		return []byte{statusSuccess}
+
+	case shaModeUpdate:
+		if int(length) != shaBlockSize || len(data) != shaBlockSize {
+			return []byte{statusParseError}
+		}
+		if e.sha == nil {
+			return []byte{statusExecutionError}
+		}
+		e.sha.Write(data)
+		return []byte{statusSuccess}
+
+	case shaModeEnd:
+		if int(length) >= shaBlockSize || len(data) != int(length) {
+			return []byte{statusParseError}
+		}
+		if e.sha == nil {
+			return []byte{statusExecutionError}
+		}
+		e.sha.Write(data)
+		digest := e.sha.Sum(nil)
+		e.sha = nil
+		if e.faults[FaultSHADigest] {
+			digest[0] ^= 0x01
+		}
+		return digest
+
+	default:
+		return []byte{statusParseError}
+	}
+}
@@ -0,0 +1,217 @@
+package atecc608a
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	// Configuration zone offsets of the fields decoded into a DeviceIdentity
+	i2cAddressOffset = 16
+	chipModeOffset   = 19
+	slotConfigOffset = 20
+	slotLockedOffset = 88
+	keyConfigOffset  = 96
+
+	// numSlots is the number of key and data slots in the data zone
+	numSlots = 16
+
+	// KeyConfig key types
+	keyTypeP256 = 4
+	keyTypeAES  = 6
+	keyTypeSHA  = 7
+)
+
+// Variants of the chip as reported by the Info revision
+const (
+	VariantATECC508A = "ATECC508A"
+	VariantATECC608A = "ATECC608A"
+	VariantATECC608B = "ATECC608B"
+	VariantUnknown   = "unknown"
+)
+
+// DeviceIdentity describes the chip behind a controller, as read from the Info
+// revision and the configuration zone during initialization
+type DeviceIdentity struct {
+	Serial       string       `json:"serial"`   // 9-byte serial number, hex
+	Revision     string       `json:"revision"` // 4-byte Info revision, hex
+	Variant      string       `json:"variant"`
+	ConfigLocked bool         `json:"config_locked"`
+	DataLocked   bool         `json:"data_locked"`
+	I2CAddress   string       `json:"i2c_address"` // 7-bit address configured in the chip
+	ChipMode     byte         `json:"chip_mode"`
+	Slots        []SlotConfig `json:"slots"`
+	Config       string       `json:"config_zone"` // all 128 bytes, hex
+	ReadAt       time.Time    `json:"read_at"`
+}
+
+// SlotConfig is the decoded SlotConfig and KeyConfig of one data zone slot
+type SlotConfig struct {
+	Slot       int    `json:"slot"`
+	SlotConfig string `json:"slot_config"` // raw 16-bit value, hex
+	KeyConfig  string `json:"key_config"`  // raw 16-bit value, hex
+	Locked     bool   `json:"locked"`
+
+	// SlotConfig fields
+	ReadKey     int  `json:"read_key"`
+	NoMac       bool `json:"no_mac"`
+	LimitedUse  bool `json:"limited_use"`
+	EncryptRead bool `json:"encrypt_read"`
+	IsSecret    bool `json:"is_secret"`
+	WriteKey    int  `json:"write_key"`
+	WriteConfig int  `json:"write_config"`
+
+	// KeyConfig fields
+	Private           bool   `json:"private"`
+	PubInfo           bool   `json:"pub_info"`
+	KeyType           string `json:"key_type"`
+	Lockable          bool   `json:"lockable"`
+	ReqRandom         bool   `json:"req_random"`
+	ReqAuth           bool   `json:"req_auth"`
+	AuthKey           int    `json:"auth_key"`
+	PersistentDisable bool   `json:"persistent_disable"`
+	X509ID            int    `json:"x509_id"`
+}
+
+// knownRevisions maps the Info revisions of released chips to their variant.
+// Byte 2 is the device family and byte 3 the silicon revision; the 608B is
+// revision 0x03 of the 608 silicon.
+var knownRevisions = map[[4]byte]string{
+	{0x00, 0x00, 0x50, 0x00}: VariantATECC508A,
+	{0x00, 0x00, 0x60, 0x01}: VariantATECC608A,
+	{0x00, 0x00, 0x60, 0x02}: VariantATECC608A,
+	{0x00, 0x00, 0x60, 0x03}: VariantATECC608B,
+}
+
+// variantFromRevision returns the chip variant for an Info revision, or
+// VariantUnknown for a revision that is not known exactly, e.g. a later
+// silicon revision whose timings may differ
+func variantFromRevision(revision []byte) string {
+	if len(revision) != 4 {
+		return VariantUnknown
+	}
+	if variant, ok := knownRevisions[[4]byte(revision)]; ok {
+		return variant
+	}
+	return VariantUnknown
+}
+
+// keyTypeName returns the name of a KeyConfig key type
+func keyTypeName(keyType int) string {
+	switch keyType {
+	case keyTypeP256:
+		return "p256"
+	case keyTypeAES:
+		return "aes"
+	case keyTypeSHA:
+		return "sha_or_data"
+	default:
+		return fmt.Sprintf("reserved_%d", keyType)
+	}
+}
+
+// decodeIdentity decodes the Info revision and a 128-byte configuration zone
+func decodeIdentity(revision, config []byte) (DeviceIdentity, error) {
+	if len(config) != configZoneSize {
+		return DeviceIdentity{}, fmt.Errorf("configuration zone must be %d bytes long, got %d", configZoneSize, len(config))
+	}
+
+	// The serial number is split around the revision: bytes 0-3 and 8-12
+	serial := append(append([]byte(nil), config[0:4]...), config[8:13]...)
+
+	identity := DeviceIdentity{
+		Serial:       hex.EncodeToString(serial),
+		Revision:     hex.EncodeToString(revision),
+		Variant:      variantFromRevision(revision),
+		ConfigLocked: config[lockConfigOffset] == 0x00,
+		DataLocked:   config[lockValueOffset] == 0x00,
+		I2CAddress:   fmt.Sprintf("0x%02x", config[i2cAddressOffset]>>1),
+		ChipMode:     config[chipModeOffset],
+		Slots:        make([]SlotConfig, numSlots),
+		Config:       hex.EncodeToString(config),
+		ReadAt:       time.Now(),
+	}
+
+	// SlotLocked has one bit per slot, cleared when the slot is locked
+	slotLocked := uint16(config[slotLockedOffset]) | uint16(config[slotLockedOffset+1])<<8
+
+	for slot := range numSlots {
+		slotConfig := uint16(config[slotConfigOffset+2*slot]) | uint16(config[slotConfigOffset+2*slot+1])<<8
+		keyConfig := uint16(config[keyConfigOffset+2*slot]) | uint16(config[keyConfigOffset+2*slot+1])<<8
+
+		identity.Slots[slot] = SlotConfig{
+			Slot:       slot,
+			SlotConfig: fmt.Sprintf("%04x", slotConfig),
+			KeyConfig:  fmt.Sprintf("%04x", keyConfig),
+			Locked:     slotLocked&(1<<slot) == 0,
+
+			ReadKey:     int(slotConfig & 0x0F),
+			NoMac:       slotConfig&(1<<4) != 0,
+			LimitedUse:  slotConfig&(1<<5) != 0,
+			EncryptRead: slotConfig&(1<<6) != 0,
+			IsSecret:    slotConfig&(1<<7) != 0,
+			WriteKey:    int(slotConfig>>8) & 0x0F,
+			WriteConfig: int(slotConfig>>12) & 0x0F,
+
+			Private:           keyConfig&(1<<0) != 0,
+			PubInfo:           keyConfig&(1<<1) != 0,
+			KeyType:           keyTypeName(int(keyConfig>>2) & 0x07),
+			Lockable:          keyConfig&(1<<5) != 0,
+			ReqRandom:         keyConfig&(1<<6) != 0,
+			ReqAuth:           keyConfig&(1<<7) != 0,
+			AuthKey:           int(keyConfig>>8) & 0x0F,
+			PersistentDisable: keyConfig&(1<<12) != 0,
+			X509ID:            int(keyConfig>>14) & 0x03,
+		}
+	}
+
+	return identity, nil
+}
+
+// identify records the identity read during initialization and, if pinning
+// is enabled, checks it against the pin. readErr is the error reading the
+// config zone; without the config zone a pinned device cannot be verified
+// and is not used.
+func (c *Controller) identify(revision, config []byte, readErr error) error {
+	if readErr != nil {
+		if c.pinDir != "" {
+			return fmt.Errorf("cannot verify device identity: %w", readErr)
+		}
+		return nil
+	}
+
+	identity, err := decodeIdentity(revision, config)
+	if err != nil {
+		if c.pinDir != "" {
+			return fmt.Errorf("cannot verify device identity: %w", err)
+		}
+		logWarn("ATECC608A %s: could not decode identity: %v", c.name, err)
+		return nil
+	}
+
+	logInfo("ATECC608A %s: %s, serial %s, revision %s", c.name, identity.Variant, identity.Serial, identity.Revision)
+
+	c.mutex.Lock()
+	c.identity = &identity
+	c.mutex.Unlock()
+
+	if c.pinDir == "" {
+		return nil
+	}
+	return c.checkIdentityPin(identity)
+}
+
+// Identity returns the identity read when the device was last initialized,
+// and false if it has not been read yet
+func (c *Controller) Identity() (DeviceIdentity, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.identity == nil {
+		return DeviceIdentity{}, false
+	}
+	identity := *c.identity
+	identity.Slots = append([]SlotConfig(nil), identity.Slots...)
+	return identity, true
+}
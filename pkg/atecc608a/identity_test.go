@@ -0,0 +1,156 @@
+package atecc608a
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodeIdentity(t *testing.T) {
+	config := make([]byte, configZoneSize)
+	copy(config[0:4], []byte{0x01, 0x23, 0xA1, 0xA2})        // serial bytes 0-3
+	copy(config[4:8], []byte{0x00, 0x00, 0x60, 0x02})        // revision, not part of the serial
+	copy(config[8:13], []byte{0xB1, 0xB2, 0xB3, 0xB4, 0xEE}) // serial bytes 4-8
+	config[i2cAddressOffset] = 0xC0
+	config[chipModeOffset] = 0x01
+	config[lockValueOffset] = 0x55
+	config[lockConfigOffset] = 0x00
+
+	// Slot 2 is a secret P256 key, slot 15 a limited-use SHA key; both are locked
+	copy(config[slotConfigOffset+2*2:], []byte{0xC7, 0x83})
+	copy(config[keyConfigOffset+2*2:], []byte{0x33, 0x10})
+	copy(config[slotConfigOffset+2*15:], []byte{0x30, 0x00})
+	copy(config[keyConfigOffset+2*15:], []byte{0x5C, 0xCF})
+	config[slotLockedOffset] = 0xFB
+	config[slotLockedOffset+1] = 0x7F
+
+	identity, err := decodeIdentity(config[4:8], config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Serial != "0123a1a2b1b2b3b4ee" {
+		t.Errorf("serial is %s, want 0123a1a2b1b2b3b4ee", identity.Serial)
+	}
+	if identity.Revision != "00006002" || identity.Variant != VariantATECC608A {
+		t.Errorf("revision %s is %s, want 00006002 %s", identity.Revision, identity.Variant, VariantATECC608A)
+	}
+	if !identity.ConfigLocked || identity.DataLocked {
+		t.Errorf("lock status is config %t, data %t, want only the config locked", identity.ConfigLocked, identity.DataLocked)
+	}
+	if identity.I2CAddress != "0x60" || identity.ChipMode != 0x01 {
+		t.Errorf("I2C address %s, chip mode %d, want 0x60 and 1", identity.I2CAddress, identity.ChipMode)
+	}
+	if identity.Config != hex.EncodeToString(config) {
+		t.Errorf("config is %s", identity.Config)
+	}
+	if len(identity.Slots) != numSlots {
+		t.Fatalf("got %d slots, want %d", len(identity.Slots), numSlots)
+	}
+
+	for _, want := range []SlotConfig{
+		{
+			Slot: 2, SlotConfig: "83c7", KeyConfig: "1033", Locked: true,
+			ReadKey: 7, EncryptRead: true, IsSecret: true, WriteKey: 3, WriteConfig: 8,
+			Private: true, PubInfo: true, KeyType: "p256", Lockable: true, PersistentDisable: true,
+		},
+		{Slot: 3, SlotConfig: "0000", KeyConfig: "0000", KeyType: "reserved_0"},
+		{
+			Slot: 15, SlotConfig: "0030", KeyConfig: "cf5c", Locked: true,
+			NoMac: true, LimitedUse: true,
+			KeyType: "sha_or_data", ReqRandom: true, AuthKey: 15, X509ID: 3,
+		},
+	} {
+		if got := identity.Slots[want.Slot]; got != want {
+			t.Errorf("slot %d is %+v, want %+v", want.Slot, got, want)
+		}
+	}
+
+	for _, length := range []int{0, configZoneSize - 1, configZoneSize + 1} {
+		if _, err := decodeIdentity(config[4:8], make([]byte, length)); err == nil {
+			t.Errorf("a %d-byte configuration zone was decoded", length)
+		}
+	}
+}
+
+func TestControllerIdentitySerial(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	if err := emulator.SetSerialNumber([]byte{0x01, 0x23, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0xEE}); err != nil {
+		t.Fatal(err)
+	}
+
+	controller := newTestController(t, emulator)
+	identity, ok := controller.Identity()
+	if !ok {
+		t.Fatal("identity not read during initialization")
+	}
+	if identity.Serial != "0123112233445566ee" {
+		t.Errorf("serial is %s, want 0123112233445566ee", identity.Serial)
+	}
+}
+
+func TestVariantFromRevision(t *testing.T) {
+	for _, tc := range []struct {
+		revision []byte
+		want     string
+	}{
+		{[]byte{0x00, 0x00, 0x50, 0x00}, VariantATECC508A},
+		{[]byte{0x00, 0x00, 0x60, 0x01}, VariantATECC608A},
+		{[]byte{0x00, 0x00, 0x60, 0x02}, VariantATECC608A},
+		{[]byte{0x00, 0x00, 0x60, 0x03}, VariantATECC608B},
+
+		// Later silicon revisions of a known family may have other timings
+		{[]byte{0x00, 0x00, 0x60, 0x04}, VariantUnknown},
+		{[]byte{0x00, 0x00, 0x60, 0xFF}, VariantUnknown},
+		{[]byte{0x00, 0x00, 0x50, 0x01}, VariantUnknown},
+		{[]byte{0x00, 0x00, 0x60, 0x00}, VariantUnknown},
+		{[]byte{0x00, 0x01, 0x60, 0x02}, VariantUnknown},
+		{[]byte{0x01, 0x00, 0x60, 0x02}, VariantUnknown},
+		{[]byte{0x00, 0x00, 0x70, 0x01}, VariantUnknown},
+		{[]byte{0x00, 0x00, 0x60}, VariantUnknown},
+		{nil, VariantUnknown},
+	} {
+		if got := variantFromRevision(tc.revision); got != tc.want {
+			t.Errorf("revision %x is %q, want %q", tc.revision, got, tc.want)
+		}
+	}
+}
+
+func TestVariantForRevision(t *testing.T) {
+	for _, tc := range []struct {
+		revision []byte
+		want     *Variant
+	}{
+		{[]byte{0x00, 0x00, 0x50, 0x00}, variantATECC508A},
+		{[]byte{0x00, 0x00, 0x60, 0x02}, variantATECC608A},
+		{[]byte{0x00, 0x00, 0x60, 0x03}, variantATECC608B},
+		{[]byte{0x00, 0x00, 0x60, 0x04}, variantUnsupported},
+	} {
+		if got := variantForRevision(tc.revision); got != tc.want {
+			t.Errorf("revision %x uses the %s table, want %s", tc.revision, got.Name, tc.want.Name)
+		}
+	}
+
+	// An unknown revision only gets Info and Read, with the longest time of any variant
+	if len(variantUnsupported.execTimes) != 2 ||
+		variantUnsupported.execTimes[cmdInfo] != variantUndetected.execTimes[cmdInfo] ||
+		variantUnsupported.execTimes[cmdRead] != variantUndetected.execTimes[cmdRead] {
+		t.Errorf("unsupported variant times are %v", variantUnsupported.execTimes)
+	}
+}
+
+func TestControllerUnknownRevision(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	emulator.SetRevision([4]byte{0x00, 0x00, 0x60, 0x04})
+
+	controller := newTestController(t, emulator)
+
+	if state := controller.GetState(); state != DeviceStateUnsupported {
+		t.Errorf("device is %s, want %s", state, DeviceStateUnsupported)
+	}
+	if info := controller.Variant(); info.Variant != VariantUnknown {
+		t.Errorf("variant is %+v, want %s", info, VariantUnknown)
+	}
+	if _, err := controller.GenerateRandom(); err == nil {
+		t.Error("a chip of unknown revision served data")
+	}
+}
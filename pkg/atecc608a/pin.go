@@ -0,0 +1,268 @@
+package atecc608a
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lokey/rng-service/internal/atomicfile"
+)
+
+// pinFileMode restricts identity pin files to the owner
+const pinFileMode os.FileMode = 0o600
+
+// ErrIdentityMismatch is returned when the chip found on the bus is not the
+// one that was pinned, e.g. because it was swapped or reconfigured
+var ErrIdentityMismatch = errors.New("device identity does not match the pinned identity")
+
+// PinnedIdentity is the part of a DeviceIdentity that must not change over the
+// life of a provisioned chip
+type PinnedIdentity struct {
+	Serial   string    `json:"serial"`
+	Revision string    `json:"revision"`
+	Config   string    `json:"config_sha256"` // SHA-256 of the static part of the config zone, hex
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// IdentityMismatchError describes a chip that does not match its pin. Unlike
+// a communication error it is not retried: the device stays unavailable until
+// an operator approves the new chip with ApproveIdentity and the service is
+// restarted.
+type IdentityMismatchError struct {
+	Pinned PinnedIdentity
+	Found  PinnedIdentity
+}
+
+func (e *IdentityMismatchError) Error() string {
+	var fields []string
+	if e.Found.Serial != e.Pinned.Serial {
+		fields = append(fields, fmt.Sprintf("serial %s, pinned %s", e.Found.Serial, e.Pinned.Serial))
+	}
+	if e.Found.Revision != e.Pinned.Revision {
+		fields = append(fields, fmt.Sprintf("revision %s, pinned %s", e.Found.Revision, e.Pinned.Revision))
+	}
+	if e.Found.Config != e.Pinned.Config {
+		fields = append(fields, "configuration zone changed")
+	}
+	return fmt.Sprintf("%v: %s", ErrIdentityMismatch, strings.Join(fields, "; "))
+}
+
+func (e *IdentityMismatchError) Unwrap() error {
+	return ErrIdentityMismatch
+}
+
+// pinFromIdentity returns the pinned part of an identity. The configuration
+// hash leaves out the serial number and revision (0-15), which are pinned on
+// their own, and the counters (52-67), UserExtra and lock bytes (84-87) and
+// SlotLocked (88-89), which legitimately change after provisioning.
+func pinFromIdentity(identity DeviceIdentity) (PinnedIdentity, error) {
+	config, err := hex.DecodeString(identity.Config)
+	if err != nil || len(config) != configZoneSize {
+		return PinnedIdentity{}, fmt.Errorf("invalid configuration zone in identity")
+	}
+
+	h := sha256.New()
+	h.Write(config[16:52])
+	h.Write(config[68:84])
+	h.Write(config[90:])
+
+	return PinnedIdentity{
+		Serial:   identity.Serial,
+		Revision: identity.Revision,
+		Config:   hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// matches reports whether two pins describe the same chip
+func (p PinnedIdentity) matches(other PinnedIdentity) bool {
+	return p.Serial == other.Serial && p.Revision == other.Revision && p.Config == other.Config
+}
+
+// pinPath returns the pin file of a device in dir; the device name is made
+// safe for use as a file name, e.g. "1:0x60" becomes identity-1-0x60.json
+func pinPath(dir, name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+	return filepath.Join(dir, "identity-"+safe+".json")
+}
+
+// loadPin reads a pin file. It returns an error wrapping os.ErrNotExist when
+// no chip has been pinned yet.
+func loadPin(path string) (PinnedIdentity, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - path comes from service configuration
+	if err != nil {
+		return PinnedIdentity{}, fmt.Errorf("failed to read identity pin: %w", err)
+	}
+
+	var pin PinnedIdentity
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return PinnedIdentity{}, fmt.Errorf("failed to parse identity pin %s: %w", path, err)
+	}
+	if pin.Serial == "" || pin.Config == "" {
+		return PinnedIdentity{}, fmt.Errorf("identity pin %s is incomplete", path)
+	}
+
+	return pin, nil
+}
+
+// savePin atomically replaces the pin file, so a crash never leaves a
+// truncated pin behind that would lock the device out
+func savePin(path string, pin PinnedIdentity) error {
+	data, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode identity pin: %w", err)
+	}
+
+	if err := atomicfile.Write(path, append(data, '\n'), pinFileMode); err != nil {
+		return fmt.Errorf("failed to save identity pin: %w", err)
+	}
+	return nil
+}
+
+// checkIdentityPin compares the chip with its pin. A provisioned chip without a
+// pin is pinned; an unprovisioned one is not, since its configuration is still
+// going to change.
+func (c *Controller) checkIdentityPin(identity DeviceIdentity) error {
+	found, err := pinFromIdentity(identity)
+	if err != nil {
+		return err
+	}
+
+	path := pinPath(c.pinDir, c.name)
+	pinned, err := loadPin(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if !identity.ConfigLocked {
+			logWarn("ATECC608A %s: configuration zone not locked, identity not pinned yet", c.name)
+			return nil
+		}
+		found.PinnedAt = time.Now()
+		if err := savePin(path, found); err != nil {
+			return err
+		}
+		logWarn("ATECC608A %s: pinned identity serial %s to %s", c.name, found.Serial, path)
+		c.setPinned(&found)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	c.setPinned(&pinned)
+	if !pinned.matches(found) {
+		return &IdentityMismatchError{Pinned: pinned, Found: found}
+	}
+
+	return nil
+}
+
+// setPinned publishes the identity the device is pinned to
+func (c *Controller) setPinned(pin *PinnedIdentity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pinned = pin
+}
+
+// PinnedIdentity returns the identity the device is pinned to, and false if
+// pinning is disabled or no chip has been pinned yet
+func (c *Controller) PinnedIdentity() (PinnedIdentity, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pinned == nil {
+		return PinnedIdentity{}, false
+	}
+	return *c.pinned, true
+}
+
+// ApprovePhrase is the phrase an operator must type to pin a chip in place of
+// the one pinned before
+func ApprovePhrase(serial string) string {
+	return "APPROVE " + serial
+}
+
+// ApproveIdentity pins a provisioned chip, replacing the pin of the chip that
+// was there before, e.g. after a deliberate replacement. It is meant for a
+// device opened with NewOfflineController while the service is stopped, after
+// the operator has checked the identity read with ReadIdentity.
+func (c *Controller) ApproveIdentity(identity DeviceIdentity, confirmation string) (PinnedIdentity, error) {
+	if c.pinDir == "" {
+		return PinnedIdentity{}, fmt.Errorf("identity pinning is disabled, set IDENTITY_PIN_DIR")
+	}
+	if !identity.ConfigLocked {
+		return PinnedIdentity{}, ErrConfigUnlocked
+	}
+	if confirmation != ApprovePhrase(identity.Serial) {
+		return PinnedIdentity{}, ErrNotConfirmed
+	}
+
+	found, err := pinFromIdentity(identity)
+	if err != nil {
+		return PinnedIdentity{}, err
+	}
+	found.PinnedAt = time.Now()
+
+	path := pinPath(c.pinDir, c.name)
+	if err := savePin(path, found); err != nil {
+		return PinnedIdentity{}, err
+	}
+	c.setPinned(&found)
+
+	logWarn("ATECC608A %s: operator approved identity serial %s, pinned to %s", c.name, found.Serial, path)
+	return found, nil
+}
+
+// checkPinDir checks that the identity pin directory exists and is writable,
+// so that a misconfiguration fails at start-up instead of sending every
+// provisioned device into recovery when its pin cannot be saved
+func checkPinDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".identity-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	_ = tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// VerifyPin compares an identity with the pin of the device without pinning
+// anything, e.g. for diagnostics. It returns false if pinning is disabled or no
+// chip has been pinned yet, and an *IdentityMismatchError if the identity does
+// not match the pin.
+func (c *Controller) VerifyPin(identity DeviceIdentity) (PinnedIdentity, bool, error) {
+	if c.pinDir == "" {
+		return PinnedIdentity{}, false, nil
+	}
+
+	pinned, err := loadPin(pinPath(c.pinDir, c.name))
+	if errors.Is(err, os.ErrNotExist) {
+		return PinnedIdentity{}, false, nil
+	} else if err != nil {
+		return PinnedIdentity{}, false, err
+	}
+
+	found, err := pinFromIdentity(identity)
+	if err != nil {
+		return pinned, true, err
+	}
+	if !pinned.matches(found) {
+		return pinned, true, &IdentityMismatchError{Pinned: pinned, Found: found}
+	}
+	return pinned, true, nil
+}
@@ -0,0 +1,229 @@
+package atecc608a
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pinDir enables identity pinning in a temporary directory for controllers created by the test
+func pinDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("IDENTITY_PIN_DIR", dir)
+	return dir
+}
+
+func TestIdentityPinnedOnFirstStart(t *testing.T) {
+	dir := pinDir(t)
+	emulator := provisionedEmulator(t)
+
+	controller := newTestController(t, emulator)
+	if state := controller.GetState(); state != DeviceStateHealthy {
+		t.Fatalf("device is %s, want healthy", state)
+	}
+
+	pinned, ok := controller.PinnedIdentity()
+	if !ok || pinned.Serial != emulatorSerial || pinned.PinnedAt.IsZero() {
+		t.Fatalf("pinned identity is %+v, %v", pinned, ok)
+	}
+
+	path := filepath.Join(dir, "identity-test.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != pinFileMode {
+		t.Errorf("pin file mode is %v, want %v", info.Mode().Perm(), pinFileMode)
+	}
+	saved, err := loadPin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.matches(pinned) {
+		t.Errorf("saved pin %+v does not match %+v", saved, pinned)
+	}
+
+	// The same chip starts again against its pin
+	if err := controller.Close(); err != nil {
+		t.Fatal(err)
+	}
+	controller = newTestController(t, emulator)
+	if state := controller.GetState(); state != DeviceStateHealthy {
+		t.Errorf("pinned chip is %s on restart, want healthy", state)
+	}
+}
+
+func TestIdentityNotPinnedBeforeProvisioning(t *testing.T) {
+	dir := pinDir(t)
+
+	// The configuration of an unlocked chip is still going to change
+	controller := newTestController(t, NewEmulator())
+	if _, ok := controller.PinnedIdentity(); ok {
+		t.Error("an unprovisioned chip was pinned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "identity-test.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pin file of an unprovisioned chip: %v", err)
+	}
+}
+
+func TestIdentityMismatchRefusesStart(t *testing.T) {
+	fastRecovery(t)
+
+	changedConfig := append([]byte(nil), CFG_TLS...)
+	changedConfig[slotConfigOffset] ^= 0x80
+
+	for name, tc := range map[string]struct {
+		change func(*Emulator) error
+		reason string
+	}{
+		"swapped chip": {
+			change: func(e *Emulator) error {
+				return e.SetSerialNumber([]byte{0x01, 0x23, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xEE})
+			},
+			reason: "serial 0123000000000002ee, pinned " + emulatorSerial,
+		},
+		"new revision": {
+			change: func(e *Emulator) error {
+				e.SetRevision([4]byte{0x00, 0x00, 0x60, 0x03})
+				return nil
+			},
+			reason: "revision 00006003, pinned 00006002",
+		},
+		"reconfigured chip": {
+			change: func(e *Emulator) error {
+				return e.Provision(changedConfig)
+			},
+			reason: "configuration zone changed",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pinDir(t)
+			emulator := provisionedEmulator(t)
+
+			controller := newTestController(t, emulator)
+			pinned, _ := controller.PinnedIdentity()
+			if err := controller.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tc.change(emulator); err != nil {
+				t.Fatal(err)
+			}
+			controller = newTestController(t, emulator)
+
+			if state := controller.GetState(); state != DeviceStateIdentityMismatch {
+				t.Fatalf("device is %s, want %s", state, DeviceStateIdentityMismatch)
+			}
+			if _, err := controller.GenerateRandom(); err == nil {
+				t.Error("a chip that does not match its pin served data")
+			}
+			if events := controller.Events(); !strings.Contains(events[len(events)-1].Reason, tc.reason) {
+				t.Errorf("mismatch reason is %q, want it to report %q", events[len(events)-1].Reason, tc.reason)
+			}
+
+			// A mismatch is not retried, and the pin is left alone
+			time.Sleep(20 * time.Millisecond)
+			if state := controller.GetState(); state != DeviceStateIdentityMismatch {
+				t.Errorf("device is %s after the recovery delays, want %s", state, DeviceStateIdentityMismatch)
+			}
+			if got, _ := controller.PinnedIdentity(); !got.matches(pinned) {
+				t.Errorf("pin changed to %+v", got)
+			}
+		})
+	}
+}
+
+func TestIdentityPinIgnoresCountersAndLocks(t *testing.T) {
+	revision := []byte{0x00, 0x00, 0x60, 0x02}
+	pinFor := func(config []byte) PinnedIdentity {
+		t.Helper()
+		identity, err := decodeIdentity(revision, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pin, err := pinFromIdentity(identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pin
+	}
+	pinned := pinFor(CFG_TLS)
+
+	// Counters, UserExtra, the lock bytes and SlotLocked change after
+	// provisioning; the rest of the static configuration does not
+	for offset := 16; offset < configZoneSize; offset++ {
+		config := append([]byte(nil), CFG_TLS...)
+		config[offset] ^= 0xFF
+
+		ignored := offset >= 52 && offset < 68 || offset >= 84 && offset < 90
+		if got := pinFor(config).matches(pinned); got != ignored {
+			t.Errorf("changing byte %d: pin matches %v, want %v", offset, got, ignored)
+		}
+	}
+}
+
+func TestApproveIdentity(t *testing.T) {
+	pinDir(t)
+	emulator := provisionedEmulator(t)
+
+	controller := newTestController(t, emulator)
+	if err := controller.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	const serial = "0123000000000002ee"
+	if err := emulator.SetSerialNumber([]byte{0x01, 0x23, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xEE}); err != nil {
+		t.Fatal(err)
+	}
+
+	offline := newOfflineTestController(t, emulator)
+	identity, err := offline.ReadIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := offline.VerifyPin(identity); !errors.Is(err, ErrIdentityMismatch) {
+		t.Fatalf("VerifyPin of the new chip returned %v, want %v", err, ErrIdentityMismatch)
+	}
+
+	if _, err := offline.ApproveIdentity(identity, ApprovePhrase(emulatorSerial)); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("approval with the old serial returned %v, want %v", err, ErrNotConfirmed)
+	}
+	pinned, err := offline.ApproveIdentity(identity, ApprovePhrase(serial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.Serial != serial {
+		t.Errorf("pinned serial %s, want %s", pinned.Serial, serial)
+	}
+	if _, ok, err := offline.VerifyPin(identity); !ok || err != nil {
+		t.Errorf("VerifyPin after approval returned %v, %v", ok, err)
+	}
+	if err := offline.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart the approved chip is used
+	controller = newTestController(t, emulator)
+	if state := controller.GetState(); state != DeviceStateHealthy {
+		t.Errorf("approved chip is %s, want healthy", state)
+	}
+}
+
+func TestInvalidPinDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{filepath.Join(t.TempDir(), "missing"), file} {
+		t.Setenv("IDENTITY_PIN_DIR", dir)
+		if _, err := NewControllerWithBus("test", func() (Bus, error) { return provisionedEmulator(t), nil }); err == nil {
+			t.Errorf("controller started with IDENTITY_PIN_DIR=%s", dir)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package atecc608a
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Lock modes: bits 0-1 select the zone, bit 7 skips the summary CRC check
+	lockModeConfig     = 0x00
+	lockModeDataNoCRC  = 0x81
+	userExtraOffset    = 84 // first byte of the word that Write cannot change
+	readOnlyConfigSize = 16 // serial number, revision and I2C enable are read-only
+)
+
+// Errors returned when provisioning is refused
+var (
+	ErrConfigLocked     = errors.New("configuration zone is already locked")
+	ErrConfigUnlocked   = errors.New("configuration zone is not locked")
+	ErrDataLocked       = errors.New("data zone is already locked")
+	ErrNotConfirmed     = errors.New("confirmation phrase does not match")
+	ErrProfileInvalid   = errors.New("invalid provisioning profile")
+	ErrConfigReadBack   = errors.New("configuration read back differs from what was written")
+	ErrLockNotConfirmed = errors.New("lock not confirmed by the device")
+	ErrProfileVariant   = errors.New("profile is not written for the chip variant")
+)
+
+// Profiles are the built-in provisioning profiles, by name
+var Profiles = map[string]Profile{
+	"tls": {
+		Name:        "tls",
+		Description: "TLS configuration based on the Adafruit library, formerly written by the service with FORCE_CONFIG=true",
+		Config:      CFG_TLS,
+		Variants:    []string{VariantATECC608A, VariantATECC608B},
+		// The first 16 bytes were copied from an example chip, so the serial
+		// number, revision and I2C enable differ on every real one
+		DontCare: tlsDontCare,
+	},
+}
+
+// tlsDontCare marks bytes 0-15 of the tls profile as don't-care
+var tlsDontCare = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Profile is a configuration zone image to provision a device with. Only bytes
+// 16-83 and 88-127 are written; the read-only bytes 0-15 and the word at 84,
+// which holds UserExtra and the lock bytes, are kept from the device. The
+// profile must match the device in those bytes unless it marks them as
+// don't-care. The lock bytes are set by the Lock command and never compared.
+type Profile struct {
+	Name        string
+	Description string
+	Config      []byte
+	// Variants are the chip variants whose configuration layout the profile
+	// is written for; a profile without variants may be written to any known one
+	Variants []string
+	// DontCare are the offsets of read-only bytes that may differ from the device
+	DontCare []int
+}
+
+// profileFile is the JSON form of a profile. CRC is the CRC-16 of all 128
+// config bytes as four hex digits and guards against editing mistakes.
+type profileFile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Config      string   `json:"config"`
+	CRC         string   `json:"crc"`
+	Variants    []string `json:"variants,omitempty"`
+	DontCare    []int    `json:"dont_care,omitempty"`
+}
+
+// LoadProfile returns the built-in profile called name, or reads a profile
+// file if name is not a built-in one
+func LoadProfile(name string) (Profile, error) {
+	if profile, ok := Profiles[name]; ok {
+		return profile, nil
+	}
+
+	data, err := os.ReadFile(name) // #nosec G304 - path given by the operator
+	if err != nil {
+		return Profile{}, fmt.Errorf("%q is not a built-in profile and could not be read: %w", name, err)
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Profile{}, fmt.Errorf("%w: %s: %v", ErrProfileInvalid, name, err)
+	}
+
+	// Allow the config to be laid out in rows
+	config, err := hex.DecodeString(strings.Join(strings.Fields(file.Config), ""))
+	if err != nil {
+		return Profile{}, fmt.Errorf("%w: %s: config is not hex: %v", ErrProfileInvalid, name, err)
+	}
+
+	if file.CRC == "" {
+		return Profile{}, fmt.Errorf("%w: %s: crc is missing", ErrProfileInvalid, name)
+	}
+	if len(config) == configZoneSize {
+		want, err := strconv.ParseUint(file.CRC, 16, 16)
+		if err != nil {
+			return Profile{}, fmt.Errorf("%w: %s: crc is not a 16-bit hex value", ErrProfileInvalid, name)
+		}
+		if got := calculateCRC(config); uint16(want) != got {
+			return Profile{}, fmt.Errorf("%w: %s: config CRC is %04x, file says %04x", ErrProfileInvalid, name, got, want)
+		}
+	}
+
+	if file.Name == "" {
+		file.Name = name
+	}
+
+	return Profile{Name: file.Name, Description: file.Description, Config: config, Variants: file.Variants, DontCare: file.DontCare}, nil
+}
+
+// Validate checks that the profile can be written to a device with config
+// current. Bytes that are not written (see Profile) must match the device
+// unless the profile marks them as don't-care.
+func (p Profile) Validate(current []byte) error {
+	if len(p.Config) != configZoneSize {
+		return fmt.Errorf("%w: config must be %d bytes long, got %d", ErrProfileInvalid, configZoneSize, len(p.Config))
+	}
+	if len(current) != configZoneSize {
+		return fmt.Errorf("device config must be %d bytes long, got %d", configZoneSize, len(current))
+	}
+
+	for _, offset := range p.DontCare {
+		if offset < 0 || offset >= configZoneSize || configWritable(offset) {
+			return fmt.Errorf("%w: byte %d is written, only read-only bytes can be don't-care", ErrProfileInvalid, offset)
+		}
+	}
+
+	var mismatched []string
+	for offset := range configZoneSize {
+		if !configWritable(offset) && !p.dontCare(offset) && current[offset] != p.Config[offset] {
+			mismatched = append(mismatched, strconv.Itoa(offset))
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%w: read-only bytes %s differ from the device and are not marked don't-care",
+			ErrProfileInvalid, strings.Join(mismatched, ", "))
+	}
+
+	if address := p.Config[i2cAddressOffset] >> 1; address < minI2CAddress || address > maxI2CAddress {
+		return fmt.Errorf("%w: I2C address 0x%02x (byte 16 = 0x%02x) is outside 0x%02x-0x%02x",
+			ErrProfileInvalid, address, p.Config[i2cAddressOffset], minI2CAddress, maxI2CAddress)
+	}
+
+	for _, variant := range p.Variants {
+		if variantByName(variant) == variantUnsupported {
+			return fmt.Errorf("%w: unknown chip variant %q", ErrProfileInvalid, variant)
+		}
+	}
+
+	return nil
+}
+
+// CheckVariant returns ErrProfileVariant if the profile is not written for
+// the chip variant, and ErrUnsupportedVariant for a chip of no known variant
+func (p Profile) CheckVariant(variant string) error {
+	if variantByName(variant) == variantUnsupported {
+		return fmt.Errorf("%w: refusing to write a configuration to an unknown chip", ErrUnsupportedVariant)
+	}
+	if len(p.Variants) > 0 && !slices.Contains(p.Variants, variant) {
+		return fmt.Errorf("%w: %s is written for %s, the chip is an %s",
+			ErrProfileVariant, p.Name, strings.Join(p.Variants, ", "), variant)
+	}
+	return nil
+}
+
+// configWritable reports whether Write can change the config byte at offset
+func configWritable(offset int) bool {
+	return offset >= readOnlyConfigSize && (offset < userExtraOffset || offset >= userExtraOffset+4)
+}
+
+// dontCare reports whether the read-only byte at offset may differ from the device
+func (p Profile) dontCare(offset int) bool {
+	return offset == lockValueOffset || offset == lockConfigOffset || slices.Contains(p.DontCare, offset)
+}
+
+// ExpectedConfig returns the configuration zone a device with config current
+// holds after the profile has been written: the written bytes come from the
+// profile, the others from the device
+func (p Profile) ExpectedConfig(current []byte) []byte {
+	expected := append([]byte(nil), current...)
+	for offset := range configZoneSize {
+		if configWritable(offset) {
+			expected[offset] = p.Config[offset]
+		}
+	}
+	return expected
+}
+
+// LockCRC returns the summary CRC the Lock command carries when the profile
+// is written to a device with config current
+func (p Profile) LockCRC(current []byte) uint16 {
+	return calculateCRC(p.ExpectedConfig(current))
+}
+
+// ConfigDiff is one configuration zone byte that differs between a device and a profile
+type ConfigDiff struct {
+	Offset   int
+	Field    string
+	Device   byte
+	Profile  byte
+	Writable bool // false for bytes that are kept from the device
+	DontCare bool // a kept byte the profile allows to differ
+}
+
+// Diff lists the bytes in which the device configuration current differs from
+// the profile. Fields are named after the layout of the variant whose
+// revision current holds in RevNum.
+func (p Profile) Diff(current []byte) []ConfigDiff {
+	variant := variantUndetected
+	if len(current) >= 8 {
+		variant = variantForRevision(current[4:8])
+	}
+
+	var diffs []ConfigDiff
+	for offset := range min(len(current), len(p.Config)) {
+		if current[offset] != p.Config[offset] {
+			diffs = append(diffs, ConfigDiff{
+				Offset:   offset,
+				Field:    variant.configFieldName(offset),
+				Device:   current[offset],
+				Profile:  p.Config[offset],
+				Writable: configWritable(offset),
+				DontCare: !configWritable(offset) && p.dontCare(offset),
+			})
+		}
+	}
+	return diffs
+}
+
+// ConfigLockPhrase is the phrase an operator must type to lock the
+// configuration zone of the chip with the given serial number
+func ConfigLockPhrase(serial string) string {
+	return "LOCK CONFIG " + serial
+}
+
+// DataLockPhrase is the phrase an operator must type to lock the data zone of
+// the chip with the given serial number
+func DataLockPhrase(serial string) string {
+	return "LOCK DATA " + serial
+}
+
+// ReadIdentity reads the Info revision and the configuration zone of the device
+func (c *Controller) ReadIdentity() (DeviceIdentity, error) {
+	var identity DeviceIdentity
+	var readErr error
+	err := c.do(func() {
+		identity, readErr = c.readIdentity()
+		c.idle()
+	})
+	if err != nil {
+		return DeviceIdentity{}, err
+	}
+	return identity, readErr
+}
+
+// readIdentity wakes the device and reads its identity; the caller idles it
+func (c *Controller) readIdentity() (DeviceIdentity, error) {
+	c.wakeup()
+
+	revision, err := c.executeWithRetry(cmdInfo, 0x00, 0x0000, nil, 4)
+	if err != nil {
+		return DeviceIdentity{}, fmt.Errorf("info command failed: %w", err)
+	}
+
+	// An unsupported chip can still be read, so that it can be identified;
+	// the commands that change it are refused
+	if err := c.selectVariant(revision); err != nil {
+		logWarn("ATECC608A %s: %v", c.name, err)
+	}
+
+	config, err := c.readConfigZone()
+	if err != nil {
+		return DeviceIdentity{}, err
+	}
+
+	identity, err := decodeIdentity(revision, config)
+	if err != nil {
+		return DeviceIdentity{}, err
+	}
+
+	c.mutex.Lock()
+	c.identity = &identity
+	c.mutex.Unlock()
+
+	return identity, nil
+}
+
+// ProvisionConfig writes the profile to an unlocked device, reads it back and
+// locks the configuration zone. This is irreversible, so confirmation must be
+// the ConfigLockPhrase of the chip. The Lock command carries the CRC of the
+// expected contents, so the device refuses to lock anything else.
+func (c *Controller) ProvisionConfig(profile Profile, confirmation string) error {
+	var provisionErr error
+	err := c.do(func() {
+		provisionErr = c.provisionConfig(profile, confirmation)
+		c.idle()
+	})
+	if err != nil {
+		return err
+	}
+	return provisionErr
+}
+
+func (c *Controller) provisionConfig(profile Profile, confirmation string) error {
+	identity, err := c.readIdentity()
+	if err != nil {
+		return err
+	}
+	if identity.ConfigLocked {
+		return ErrConfigLocked
+	}
+	if err := profile.CheckVariant(identity.Variant); err != nil {
+		return err
+	}
+	if confirmation != ConfigLockPhrase(identity.Serial) {
+		return ErrNotConfirmed
+	}
+
+	current, err := hex.DecodeString(identity.Config)
+	if err != nil {
+		return err
+	}
+	if err := profile.Validate(current); err != nil {
+		return err
+	}
+	expected := profile.ExpectedConfig(current)
+
+	logWarn("ATECC608A %s: writing profile %s to serial %s", c.name, profile.Name, identity.Serial)
+
+	// Write the 4-byte words from byte 16 on, skipping the word at 84
+	for offset := readOnlyConfigSize; offset < configZoneSize; offset += 4 {
+		if offset == userExtraOffset {
+			continue
+		}
+
+		word := uint16(offset / 4) // #nosec G115 - offset is below 128
+		logDebug("Writing config word at byte %d: %x", offset, expected[offset:offset+4])
+
+		// Writing the same word again is harmless, so transient errors are retried
+		if _, err := c.executeWithRetry(cmdWrite, zoneConfig, word, expected[offset:offset+4], 1); err != nil {
+			return fmt.Errorf("failed to write config word at byte %d: %w", offset, err)
+		}
+	}
+
+	written, err := c.readConfigZone()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(written, expected) {
+		return fmt.Errorf("%w: %x, expected %x", ErrConfigReadBack, written, expected)
+	}
+
+	// The lock is not retried: a lock that succeeded but whose response was
+	// corrupted would fail the second time
+	summary := profile.LockCRC(current)
+	logWarn("ATECC608A %s: locking the configuration zone (CRC %04x)", c.name, summary)
+	if _, err := c.execute(cmdLock, lockModeConfig, summary, nil, 1); err != nil {
+		return fmt.Errorf("lock command failed: %w", err)
+	}
+
+	locked, err := c.isDeviceLocked()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return ErrLockNotConfirmed
+	}
+
+	logWarn("ATECC608A %s: configuration zone locked", c.name)
+	return nil
+}
+
+// LockDataZone locks the data zone of a device whose configuration zone is
+// locked. This is irreversible, so confirmation must be the DataLockPhrase of the chip.
+func (c *Controller) LockDataZone(confirmation string) error {
+	var lockErr error
+	err := c.do(func() {
+		lockErr = c.lockDataZone(confirmation)
+		c.idle()
+	})
+	if err != nil {
+		return err
+	}
+	return lockErr
+}
+
+func (c *Controller) lockDataZone(confirmation string) error {
+	identity, err := c.readIdentity()
+	if err != nil {
+		return err
+	}
+	if !identity.ConfigLocked {
+		return ErrConfigUnlocked
+	}
+	if identity.DataLocked {
+		return ErrDataLocked
+	}
+	if confirmation != DataLockPhrase(identity.Serial) {
+		return ErrNotConfirmed
+	}
+
+	// The data zone is not read back, so the summary CRC is skipped
+	logWarn("ATECC608A %s: locking the data zone of serial %s", c.name, identity.Serial)
+	if _, err := c.execute(cmdLock, lockModeDataNoCRC, 0x0000, nil, 1); err != nil {
+		return fmt.Errorf("lock command failed: %w", err)
+	}
+
+	identity, err = c.readIdentity()
+	if err != nil {
+		return err
+	}
+	if !identity.DataLocked {
+		return ErrLockNotConfirmed
+	}
+
+	logWarn("ATECC608A %s: data zone locked", c.name)
+	return nil
+}
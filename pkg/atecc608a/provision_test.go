@@ -0,0 +1,138 @@
+package atecc608a
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// emulatorProfile returns a profile that matches a new emulator in every read-only byte
+func emulatorProfile() Profile {
+	config := NewEmulator().ConfigZone()
+	copy(config[readOnlyConfigSize:userExtraOffset], CFG_TLS[readOnlyConfigSize:userExtraOffset])
+	copy(config[userExtraOffset+4:], CFG_TLS[userExtraOffset+4:])
+	return Profile{Name: "test", Config: config}
+}
+
+func TestProfileValidateReadOnlyBytes(t *testing.T) {
+	current := NewEmulator().ConfigZone()
+
+	if err := emulatorProfile().Validate(current); err != nil {
+		t.Fatalf("profile matching the device rejected: %v", err)
+	}
+
+	for _, offset := range []int{0, 4, 12, 14, userExtraOffset, userExtraOffset + 1} {
+		profile := emulatorProfile()
+		profile.Config[offset] ^= 0xFF
+
+		if err := profile.Validate(current); !errors.Is(err, ErrProfileInvalid) {
+			t.Errorf("byte %d differs: got %v, want %v", offset, err, ErrProfileInvalid)
+		}
+
+		profile.DontCare = []int{offset}
+		if err := profile.Validate(current); err != nil {
+			t.Errorf("byte %d marked don't-care: %v", offset, err)
+		}
+	}
+
+	// The lock bytes are set by the Lock command
+	profile := emulatorProfile()
+	profile.Config[lockValueOffset] = 0x00
+	profile.Config[lockConfigOffset] = 0x00
+	if err := profile.Validate(current); err != nil {
+		t.Errorf("lock bytes compared: %v", err)
+	}
+
+	// Written bytes cannot be don't-care
+	profile = emulatorProfile()
+	profile.DontCare = []int{i2cAddressOffset}
+	if err := profile.Validate(current); !errors.Is(err, ErrProfileInvalid) {
+		t.Errorf("written byte marked don't-care: got %v, want %v", err, ErrProfileInvalid)
+	}
+
+	// The tls profile was taken from another chip and only works because it
+	// does not care about bytes 0-15
+	tls := Profiles["tls"]
+	if err := tls.Validate(current); err != nil {
+		t.Errorf("tls profile rejected: %v", err)
+	}
+	tls.DontCare = nil
+	if err := tls.Validate(current); !errors.Is(err, ErrProfileInvalid) {
+		t.Errorf("tls profile without don't-care bytes: got %v, want %v", err, ErrProfileInvalid)
+	}
+}
+
+func TestProfileDiffMarksDontCare(t *testing.T) {
+	profile := emulatorProfile()
+	profile.Config[0] ^= 0xFF
+	profile.Config[1] ^= 0xFF
+	profile.Config[i2cAddressOffset+1] ^= 0xFF
+	profile.DontCare = []int{0}
+
+	diffs := profile.Diff(NewEmulator().ConfigZone())
+	want := []ConfigDiff{
+		{Offset: 0, Writable: false, DontCare: true},
+		{Offset: 1, Writable: false, DontCare: false},
+		{Offset: i2cAddressOffset + 1, Writable: true, DontCare: false},
+	}
+	if len(diffs) < len(want) {
+		t.Fatalf("got %d differences, want at least %d", len(diffs), len(want))
+	}
+	for i, w := range want {
+		if d := diffs[i]; d.Offset != w.Offset || d.Writable != w.Writable || d.DontCare != w.DontCare {
+			t.Errorf("difference %d is %+v, want offset %d writable %t don't-care %t", i, d, w.Offset, w.Writable, w.DontCare)
+		}
+	}
+}
+
+func TestProvisionConfigRefusesReadOnlyMismatch(t *testing.T) {
+	emulator := NewEmulator()
+	controller := newOfflineTestController(t, emulator)
+
+	profile := emulatorProfile()
+	profile.Config[0] ^= 0xFF
+
+	before := emulator.ConfigZone()
+	err := controller.ProvisionConfig(profile, ConfigLockPhrase(emulatorSerial))
+	if !errors.Is(err, ErrProfileInvalid) {
+		t.Fatalf("got %v, want %v", err, ErrProfileInvalid)
+	}
+	if !bytes.Equal(emulator.ConfigZone(), before) {
+		t.Error("configuration zone written for a rejected profile")
+	}
+}
+
+func TestLoadProfileRequiresCRC(t *testing.T) {
+	config := emulatorProfile().Config
+	dir := t.TempDir()
+
+	write := func(name, crc string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		data := fmt.Sprintf(`{"name": %q, "config": "%x"%s}`, name, config, crc)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if _, err := LoadProfile(write("missing", "")); !errors.Is(err, ErrProfileInvalid) {
+		t.Errorf("profile without crc: got %v, want %v", err, ErrProfileInvalid)
+	}
+
+	crc := calculateCRC(config)
+	if _, err := LoadProfile(write("wrong", fmt.Sprintf(`, "crc": "%04x"`, crc^1))); !errors.Is(err, ErrProfileInvalid) {
+		t.Errorf("profile with a wrong crc: got %v, want %v", err, ErrProfileInvalid)
+	}
+
+	profile, err := LoadProfile(write("good", fmt.Sprintf(`, "crc": "%04x", "dont_care": [0, 1]`, crc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "good" || len(profile.DontCare) != 2 {
+		t.Errorf("loaded profile %s with don't-care bytes %v", profile.Name, profile.DontCare)
+	}
+}
@@ -0,0 +1,198 @@
+package atecc608a
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+// startupSamples is the number of 8-bit samples the start-up test draws before
+// the device is declared healthy, the minimum required by SP 800-90B section 4.3
+const startupSamples = 1024
+
+// Names of the start-up tests, as reported in SelfTestResult
+const (
+	selfTestCRC                = "crc"
+	selfTestCommandFraming     = "command_framing"
+	selfTestResponseFraming    = "response_framing"
+	selfTestRepetitionCount    = "repetition_count"
+	selfTestAdaptiveProportion = "adaptive_proportion"
+	selfTestRepeatedBlock      = "repeated_block"
+	selfTestSHA256             = "sha256"
+)
+
+// SelfTestError is returned when a start-up test fails. Unlike a
+// communication error it is not retried: a device that produces degenerate
+// output stays unavailable.
+type SelfTestError struct {
+	Test string
+	Err  error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("start-up self-test %s failed: %v", e.Test, e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// SelfTestResult describes the outcome of the last start-up self-test
+type SelfTestResult struct {
+	Passed  bool      `json:"passed"`
+	Test    string    `json:"failed_test,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Samples int       `json:"samples"`
+	Time    time.Time `json:"time"`
+}
+
+// crcKnownAnswers are packets from the ATECC608A datasheet with their CRC-16
+var crcKnownAnswers = []struct {
+	data []byte
+	crc  uint16
+}{
+	{data: []byte{0x04, 0x11}, crc: 0x4333},                   // wake token
+	{data: []byte{0x04, 0x00}, crc: 0x4003},                   // success status
+	{data: []byte{0x07, 0x30, 0x00, 0x00, 0x00}, crc: 0x5D03}, // Info
+	{data: []byte{0x07, 0x1B, 0x00, 0x00, 0x00}, crc: 0xCD24}, // Random
+}
+
+// commandKnownAnswers are complete command packets, including the word address
+var commandKnownAnswers = []struct {
+	opcode byte
+	param1 byte
+	param2 uint16
+	data   []byte
+	packet []byte
+}{
+	{
+		opcode: cmdInfo,
+		packet: []byte{0x03, 0x07, 0x30, 0x00, 0x00, 0x00, 0x03, 0x5D},
+	},
+	{
+		opcode: cmdRandom,
+		packet: []byte{0x03, 0x07, 0x1B, 0x00, 0x00, 0x00, 0x24, 0xCD},
+	},
+	{
+		opcode: cmdRead,
+		param2: 0x0015,
+		packet: []byte{0x03, 0x07, 0x02, 0x00, 0x15, 0x00, 0x17, 0x5D},
+	},
+}
+
+// responseKnownAnswers are response packets with the data or error parseResponse must return
+var responseKnownAnswers = []struct {
+	packet         []byte
+	expectedLength int
+	data           []byte
+	err            error
+}{
+	{packet: []byte{0x04, 0x00, 0x03, 0x40}, expectedLength: 1, data: []byte{0x00}},
+	{packet: []byte{0x04, 0x11, 0x33, 0x43}, expectedLength: 32, err: ErrWakeToken},
+	{packet: []byte{0x04, 0x11, 0x33, 0x44}, expectedLength: 32, err: ErrResponseCRC},
+	{packet: []byte{0x05, 0x11, 0x33, 0x43, 0xFF}, expectedLength: 32, err: ErrResponseLength},
+	{packet: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, expectedLength: 4, err: ErrResponseLength},
+}
+
+// SelfTestFraming checks the CRC-16 and the command and response framing
+// against known answers. It does not talk to a device.
+func SelfTestFraming() error {
+	for _, kat := range crcKnownAnswers {
+		if crc := calculateCRC(kat.data); crc != kat.crc {
+			return &SelfTestError{
+				Test: selfTestCRC,
+				Err:  fmt.Errorf("CRC of %x is %04x, expected %04x", kat.data, crc, kat.crc),
+			}
+		}
+	}
+
+	for _, kat := range commandKnownAnswers {
+		if packet := buildCommandPacket(kat.opcode, kat.param1, kat.param2, kat.data); !bytes.Equal(packet, kat.packet) {
+			return &SelfTestError{
+				Test: selfTestCommandFraming,
+				Err:  fmt.Errorf("opcode 0x%02x framed as %x, expected %x", kat.opcode, packet, kat.packet),
+			}
+		}
+	}
+
+	for _, kat := range responseKnownAnswers {
+		data, err := parseResponse(kat.packet, kat.expectedLength)
+		if (kat.err == nil && (err != nil || !bytes.Equal(data, kat.data))) ||
+			(kat.err != nil && !errors.Is(err, kat.err)) {
+			return &SelfTestError{
+				Test: selfTestResponseFraming,
+				Err:  fmt.Errorf("response %x parsed as %x, %v", kat.packet, data, err),
+			}
+		}
+	}
+
+	return nil
+}
+
+// startupTest draws startupSamples bytes from the device and runs the
+// continuous health tests over them, starting from a reset state. The samples
+// are discarded. Communication errors are returned as they are; a failing
+// health test is returned as a *SelfTestError.
+func (c *Controller) startupTest() (int, error) {
+	c.healthTests.Reset()
+
+	samples := 0
+	for samples < startupSamples {
+		// Idle between commands, so the watchdog does not expire during the test.
+		// The caller idles the device after the last one.
+		if samples > 0 {
+			c.idle()
+		}
+
+		block, err := c.executeWithRetry(cmdRandom, 0x00, 0x0000, nil, 32)
+		if err != nil {
+			return samples, fmt.Errorf("start-up test random command failed: %w", err)
+		}
+		samples += len(block)
+
+		if err := c.healthTests.Test(block); err != nil {
+			test := selfTestRepeatedBlock
+			switch {
+			case errors.Is(err, healthtest.ErrRepetitionCount):
+				test = selfTestRepetitionCount
+			case errors.Is(err, healthtest.ErrAdaptiveProportion):
+				test = selfTestAdaptiveProportion
+			}
+			clear(block)
+			return samples, &SelfTestError{Test: test, Err: err}
+		}
+		clear(block)
+	}
+
+	return samples, nil
+}
+
+// recordSelfTest stores the outcome of a start-up self-test
+func (c *Controller) recordSelfTest(samples int, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.selfTest = SelfTestResult{
+		Passed:  err == nil,
+		Samples: samples,
+		Time:    time.Now(),
+	}
+
+	var selfTestErr *SelfTestError
+	if errors.As(err, &selfTestErr) {
+		c.selfTest.Test = selfTestErr.Test
+		c.selfTest.Error = selfTestErr.Err.Error()
+	} else if err != nil {
+		c.selfTest.Error = err.Error()
+	}
+}
+
+// SelfTestResult returns the outcome of the last start-up self-test
+func (c *Controller) SelfTestResult() SelfTestResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.selfTest
+}
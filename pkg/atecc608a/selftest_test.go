@@ -0,0 +1,120 @@
+package atecc608a
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// repeatReader returns pattern over and over
+type repeatReader struct {
+	pattern []byte
+	offset  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.pattern[r.offset]
+		r.offset = (r.offset + 1) % len(r.pattern)
+	}
+	return len(p), nil
+}
+
+func TestSelfTestFraming(t *testing.T) {
+	if err := SelfTestFraming(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestStartupTestFailsOnDegenerateOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		emulator func(t *testing.T) *Emulator
+		test     string
+	}{
+		{
+			name: "constant output",
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.SetRandomSource(bytes.NewReader(make([]byte, startupSamples)))
+				return e
+			},
+			test: selfTestRepetitionCount,
+		},
+		{
+			name: "biased output",
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.SetRandomSource(&repeatReader{pattern: []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00}})
+				return e
+			},
+			test: selfTestAdaptiveProportion,
+		},
+		{
+			name: "stuck output",
+			emulator: func(t *testing.T) *Emulator {
+				e := provisionedEmulator(t)
+				e.InjectFault(FaultStuckRandom)
+				return e
+			},
+			test: selfTestRepeatedBlock,
+		},
+		{
+			// An unlocked chip returns the same FF FF 00 00 pattern every time
+			name:     "unlocked chip",
+			emulator: func(*testing.T) *Emulator { return NewEmulator() },
+			test:     selfTestRepeatedBlock,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			controller := newTestController(t, tc.emulator(t))
+
+			if state := controller.GetState(); state != DeviceStateSelfTestFailed {
+				t.Fatalf("device is %s, want %s", state, DeviceStateSelfTestFailed)
+			}
+
+			result := controller.SelfTestResult()
+			if result.Passed || result.Test != tc.test {
+				t.Errorf("self-test result is %+v, want %s failed", result, tc.test)
+			}
+			if result.Samples == 0 || result.Samples > startupSamples {
+				t.Errorf("self-test failed after %d samples", result.Samples)
+			}
+
+			if _, err := controller.GenerateRandom(); err == nil {
+				t.Error("request served after a failed start-up test")
+			}
+		})
+	}
+}
+
+func TestStartupTestDrawsSamplesBeforeHealthy(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	source := &countingReader{r: rand.Reader}
+	emulator.SetRandomSource(source)
+
+	controller := newTestController(t, emulator)
+
+	if !controller.IsHealthy() {
+		t.Fatalf("device is %s, want healthy", controller.GetState())
+	}
+	if result := controller.SelfTestResult(); !result.Passed || result.Samples != startupSamples {
+		t.Errorf("self-test result is %+v, want passed on %d samples", result, startupSamples)
+	}
+	if source.n != startupSamples {
+		t.Errorf("%d bytes drawn before the device became healthy, want %d", source.n, startupSamples)
+	}
+}
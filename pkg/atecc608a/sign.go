@@ -0,0 +1,227 @@
+package atecc608a
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Command modes used for signing
+	nonceModePassThrough = 0x03 // Nonce: load the 32 input bytes into TempKey as they are
+	signModeExternal     = 0x80 // Sign: sign the digest in TempKey, computed outside the chip
+	genKeyModePublic     = 0x00 // GenKey: return the public key of the private key in a slot
+	genKeyModeCreate     = 0x04 // GenKey: create a new private key in a slot
+	counterModeRead      = 0x00 // Counter: read the value
+	counterModeIncrement = 0x01 // Counter: increment and return the new value
+
+	// PublicKeySize is the size of a public key as the chip returns it, X || Y
+	PublicKeySize = 64
+	// SignatureSize is the size of a signature, R || S
+	SignatureSize = 64
+	// DigestSize is the size of the digest Sign takes, a SHA-256
+	DigestSize = 32
+
+	// NumCounters is the number of monotonic counters in the chip
+	NumCounters = 2
+	// MaxCounterValue is the highest value a monotonic counter reaches
+	MaxCounterValue = 2097151
+)
+
+// Errors returned by the key and counter commands
+var (
+	ErrInvalidSlot    = errors.New("invalid key slot")
+	ErrInvalidCounter = errors.New("invalid counter")
+	ErrNotKeySlot     = errors.New("slot is not configured for a P-256 private key")
+	errNotConnected   = errors.New("device not connected")
+)
+
+// GenKeyPhrase is the phrase an operator must type to replace the private key
+// in a slot of the chip with the given serial number
+func GenKeyPhrase(serial string, slot int) string {
+	return fmt.Sprintf("GENKEY %d %s", slot, serial)
+}
+
+// checkSlot returns ErrInvalidSlot unless slot is one of the data zone slots
+func checkSlot(slot int) error {
+	if slot < 0 || slot >= numSlots {
+		return fmt.Errorf("%w: %d, must be 0-%d", ErrInvalidSlot, slot, numSlots-1)
+	}
+	return nil
+}
+
+// PublicKey returns the public key X || Y of the P-256 private key in slot.
+// GenKey computes it from the private key, which never leaves the chip.
+func (c *Controller) PublicKey(slot int) ([]byte, error) {
+	if err := checkSlot(slot); err != nil {
+		return nil, err
+	}
+
+	var publicKey []byte
+	var cmdErr error
+	err := c.do(func() {
+		if c.bus == nil {
+			cmdErr = errNotConnected
+			return
+		}
+		// Computing the public key again gives the same result, so it can be retried
+		publicKey, cmdErr = c.executeWithRetry(cmdGenKey, genKeyModePublic, uint16(slot), nil, PublicKeySize) // #nosec G115 - slot checked above
+		c.idle()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cmdErr != nil {
+		return nil, fmt.Errorf("genkey command for the public key of slot %d failed: %w", slot, cmdErr)
+	}
+	return publicKey, nil
+}
+
+// SignDigest signs a SHA-256 digest with the P-256 private key in slot and
+// returns R || S. The device must be healthy. A failed signature is returned
+// to the caller but does not take the device out of service: the random
+// output does not depend on the key slot.
+func (c *Controller) SignDigest(slot int, digest []byte) ([]byte, error) {
+	if err := checkSlot(slot); err != nil {
+		return nil, err
+	}
+	if len(digest) != DigestSize {
+		return nil, fmt.Errorf("digest must be %d bytes long, got %d", DigestSize, len(digest))
+	}
+	if state := c.getState(); state != DeviceStateHealthy {
+		return nil, fmt.Errorf("ATECC608A device not healthy (state: %s)", state)
+	}
+
+	var signature []byte
+	var signErr error
+	err := c.do(func() {
+		// The device may have failed while the request was queued
+		if state := c.getState(); state != DeviceStateHealthy {
+			signErr = fmt.Errorf("ATECC608A device not healthy (state: %s)", state)
+			return
+		}
+		signature, signErr = c.sign(slot, digest)
+		c.idle()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if signErr != nil {
+		signErr = fmt.Errorf("sign command failed: %w", signErr)
+		logWarn("ATECC608A %s: %v", c.name, signErr)
+		return nil, signErr
+	}
+	return signature, nil
+}
+
+// sign loads the digest into TempKey with Nonce and signs it. TempKey does not
+// survive sleep, so after a transient error both commands are sent again.
+func (c *Controller) sign(slot int, digest []byte) ([]byte, error) {
+	var signature []byte
+	var err error
+	for retry := 0; retry <= maxCommandRetries; retry++ {
+		if retry > 0 {
+			logDebug("Nonce and sign retry %d after transient error: %v", retry, err)
+			c.errorCounts.recordRetry()
+			if errors.Is(err, ErrWatchdog) {
+				c.sleep()
+			}
+		}
+
+		if _, err = c.execute(cmdNonce, nonceModePassThrough, 0x0000, digest, 1); err != nil {
+			err = fmt.Errorf("nonce: %w", err)
+		} else {
+			signature, err = c.execute(cmdSign, signModeExternal, uint16(slot), nil, SignatureSize) // #nosec G115 - slot checked by the caller
+		}
+
+		if err == nil || !isTransient(err) {
+			break
+		}
+	}
+	return signature, err
+}
+
+// IncrementCounter increments a monotonic counter of the chip and returns its
+// new value. The counters cannot be reset and stop at MaxCounterValue, so they
+// suit events such as service starts rather than individual requests.
+func (c *Controller) IncrementCounter(counter int) (uint32, error) {
+	return c.counter(counter, counterModeIncrement)
+}
+
+// ReadCounter returns the value of a monotonic counter of the chip
+func (c *Controller) ReadCounter(counter int) (uint32, error) {
+	return c.counter(counter, counterModeRead)
+}
+
+func (c *Controller) counter(counter int, mode byte) (uint32, error) {
+	if counter < 0 || counter >= NumCounters {
+		return 0, fmt.Errorf("%w: %d, must be 0-%d", ErrInvalidCounter, counter, NumCounters-1)
+	}
+
+	var value []byte
+	var cmdErr error
+	err := c.do(func() {
+		if c.bus == nil {
+			cmdErr = errNotConnected
+			return
+		}
+		// An increment whose response was lost is sent again; the counter then
+		// skips a value, which keeps it monotonic
+		value, cmdErr = c.executeWithRetry(cmdCounter, mode, uint16(counter), nil, 4) // #nosec G115 - counter checked above
+		c.idle()
+	})
+	if err != nil {
+		return 0, err
+	}
+	if cmdErr != nil {
+		return 0, fmt.Errorf("counter command failed: %w", cmdErr)
+	}
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+// GenerateKey creates a new P-256 private key in slot of an offline device
+// and returns its public key. Any key already in the slot is lost, so
+// confirmation must be the GenKeyPhrase of the chip and slot. The
+// configuration zone must be locked and the slot configured for a private key.
+func (c *Controller) GenerateKey(slot int, confirmation string) ([]byte, error) {
+	if err := checkSlot(slot); err != nil {
+		return nil, err
+	}
+
+	var publicKey []byte
+	var genErr error
+	err := c.do(func() {
+		publicKey, genErr = c.generateKey(slot, confirmation)
+		c.idle()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publicKey, genErr
+}
+
+func (c *Controller) generateKey(slot int, confirmation string) ([]byte, error) {
+	identity, err := c.readIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if !identity.ConfigLocked {
+		return nil, ErrConfigUnlocked
+	}
+	if config := identity.Slots[slot]; !config.Private || config.KeyType != keyTypeName(keyTypeP256) {
+		return nil, fmt.Errorf("%w: slot %d has key type %s, private %t", ErrNotKeySlot, slot, config.KeyType, config.Private)
+	}
+	if confirmation != GenKeyPhrase(identity.Serial, slot) {
+		return nil, ErrNotConfirmed
+	}
+
+	// Not retried: the first key may have been created even if its response was lost
+	logWarn("ATECC608A %s: creating a new private key in slot %d of serial %s", c.name, slot, identity.Serial)
+	publicKey, err := c.execute(cmdGenKey, genKeyModeCreate, uint16(slot), nil, PublicKeySize) // #nosec G115 - slot checked by the caller
+	if err != nil {
+		return nil, fmt.Errorf("genkey command failed: %w", err)
+	}
+
+	logWarn("ATECC608A %s: private key created in slot %d", c.name, slot)
+	return publicKey, nil
+}
@@ -0,0 +1,205 @@
+package atecc608a
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Status codes returned in single-byte response packets
+const (
+	statusSuccess         = 0x00
+	statusCheckMacVerify  = 0x01
+	statusParseError      = 0x03
+	statusECCFault        = 0x05
+	statusExecutionError  = 0x0F
+	statusAfterWake       = 0x11
+	statusWatchdogExpired = 0xEE
+	statusCRCError        = 0xFF
+)
+
+// StatusError is a non-zero status code reported by the device
+type StatusError struct {
+	Code byte
+}
+
+// Errors for the status codes of the ATECC608A, for use with errors.Is
+var (
+	// ErrCheckMacVerify means a CheckMac or Verify comparison did not match
+	ErrCheckMacVerify = &StatusError{Code: statusCheckMacVerify}
+	// ErrParse means the command was malformed, e.g. an illegal opcode, parameter or zone state
+	ErrParse = &StatusError{Code: statusParseError}
+	// ErrECCFault means an ECC computation produced an invalid result. It is not
+	// resent like a communication error; the device is reinitialized instead.
+	ErrECCFault = &StatusError{Code: statusECCFault}
+	// ErrExecution means the command was understood but could not be executed
+	ErrExecution = &StatusError{Code: statusExecutionError}
+	// ErrWakeToken means the device has just woken up and did not run the command
+	ErrWakeToken = &StatusError{Code: statusAfterWake}
+	// ErrWatchdog means the watchdog is about to expire and the command was not run
+	ErrWatchdog = &StatusError{Code: statusWatchdogExpired}
+	// ErrCommunication means the device received a command packet with a bad CRC
+	ErrCommunication = &StatusError{Code: statusCRCError}
+)
+
+// Framing errors detected by the controller on response packets
+var (
+	// ErrResponseCRC means the CRC-16 of a response packet did not match its contents
+	ErrResponseCRC = errors.New("response CRC mismatch")
+	// ErrResponseLength means the count byte of a response did not match the expected length
+	ErrResponseLength = errors.New("unexpected response length")
+)
+
+func (e *StatusError) Error() string {
+	switch e.Code {
+	case statusCheckMacVerify:
+		return "ATECC608A status 0x01: checkmac or verify mismatch"
+	case statusParseError:
+		return "ATECC608A status 0x03: parse error"
+	case statusECCFault:
+		return "ATECC608A status 0x05: ECC fault"
+	case statusExecutionError:
+		return "ATECC608A status 0x0F: execution error"
+	case statusAfterWake:
+		return "ATECC608A status 0x11: wake token received instead of a response"
+	case statusWatchdogExpired:
+		return "ATECC608A status 0xEE: watchdog about to expire"
+	case statusCRCError:
+		return "ATECC608A status 0xFF: command CRC or communication error"
+	default:
+		return fmt.Sprintf("ATECC608A status 0x%02X: unknown status", e.Code)
+	}
+}
+
+// Is reports whether target is a StatusError with the same code
+func (e *StatusError) Is(target error) bool {
+	t, ok := target.(*StatusError)
+	return ok && t.Code == e.Code
+}
+
+// isTransient reports whether err is a communication problem that is expected
+// to go away when the command is sent again: a corrupted packet in either
+// direction, or a device that fell asleep or woke up between command and response
+func isTransient(err error) bool {
+	return errors.Is(err, ErrResponseCRC) ||
+		errors.Is(err, ErrResponseLength) ||
+		errors.Is(err, ErrCommunication) ||
+		errors.Is(err, ErrWakeToken) ||
+		errors.Is(err, ErrWatchdog)
+}
+
+// isPermanent reports whether err means the command itself is wrong for this
+// device, so neither retrying it nor reinitializing the device can help
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrParse) || errors.Is(err, ErrCheckMacVerify)
+}
+
+// ErrorCounts holds how often each kind of error was seen in device responses
+type ErrorCounts struct {
+	CheckMacVerify uint64 `json:"checkmac_verify"`
+	Parse          uint64 `json:"parse"`
+	ECCFault       uint64 `json:"ecc_fault"`
+	Execution      uint64 `json:"execution"`
+	WakeToken      uint64 `json:"wake_token"`
+	Watchdog       uint64 `json:"watchdog"`
+	Communication  uint64 `json:"communication"`
+	UnknownStatus  uint64 `json:"unknown_status"`
+	ResponseCRC    uint64 `json:"response_crc"`
+	ResponseLength uint64 `json:"response_length"`
+	ReadFailures   uint64 `json:"read_failures"`
+	Retries        uint64 `json:"retries"`
+}
+
+// errorCounter counts errors under its own mutex, so it can be updated while
+// the controller mutex is held and read without it
+type errorCounter struct {
+	mutex  sync.Mutex
+	counts ErrorCounts
+}
+
+// record counts err by kind. Errors of other kinds are not counted.
+func (ec *errorCounter) record(err error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		switch statusErr.Code {
+		case statusCheckMacVerify:
+			ec.counts.CheckMacVerify++
+		case statusParseError:
+			ec.counts.Parse++
+		case statusECCFault:
+			ec.counts.ECCFault++
+		case statusExecutionError:
+			ec.counts.Execution++
+		case statusAfterWake:
+			ec.counts.WakeToken++
+		case statusWatchdogExpired:
+			ec.counts.Watchdog++
+		case statusCRCError:
+			ec.counts.Communication++
+		default:
+			ec.counts.UnknownStatus++
+		}
+	case errors.Is(err, ErrResponseCRC):
+		ec.counts.ResponseCRC++
+	case errors.Is(err, ErrResponseLength):
+		ec.counts.ResponseLength++
+	}
+}
+
+// recordReadFailure counts a response that could not be read from the bus at all
+func (ec *errorCounter) recordReadFailure() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.counts.ReadFailures++
+}
+
+// recordRetry counts a command that was sent again after a transient error
+func (ec *errorCounter) recordRetry() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.counts.Retries++
+}
+
+func (ec *errorCounter) snapshot() ErrorCounts {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.counts
+}
+
+// parseResponse checks the framing of a response packet (count, data, CRC-16)
+// and returns its data. A four-byte packet carrying a non-zero status instead
+// of the expected expectedLength data bytes is returned as a *StatusError.
+func parseResponse(packet []byte, expectedLength int) ([]byte, error) {
+	if len(packet) < 4 {
+		return nil, fmt.Errorf("%w: read %d bytes", ErrResponseLength, len(packet))
+	}
+
+	count := int(packet[0])
+	if count != expectedLength+3 && count != 4 {
+		return nil, fmt.Errorf("%w: count byte %d, expected %d", ErrResponseLength, count, expectedLength+3)
+	}
+	if count > len(packet) {
+		return nil, fmt.Errorf("%w: count byte %d exceeds %d bytes read", ErrResponseLength, count, len(packet))
+	}
+
+	crc := calculateCRC(packet[:count-2])
+	if packet[count-2] != byte(crc&0xFF) || packet[count-1] != byte(crc>>8) {
+		return nil, fmt.Errorf("%w: got %02x%02x, calculated %04x", ErrResponseCRC, packet[count-1], packet[count-2], crc)
+	}
+
+	// A single data byte is a status, either expected (Write, Lock) or in place of a longer response
+	if count == 4 {
+		if packet[1] != statusSuccess {
+			return nil, &StatusError{Code: packet[1]}
+		}
+		if expectedLength != 1 {
+			return nil, fmt.Errorf("%w: status packet without error, expected %d data bytes", ErrResponseLength, expectedLength)
+		}
+	}
+
+	return packet[1 : count-2], nil
+}
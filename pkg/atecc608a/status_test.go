@@ -0,0 +1,144 @@
+package atecc608a
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// statusPacket returns a four-byte response packet carrying status
+func statusPacket(status byte) []byte {
+	packet := []byte{4, status, 0, 0}
+	crc := calculateCRC(packet[:2])
+	packet[2], packet[3] = byte(crc&0xFF), byte(crc>>8)
+	return packet
+}
+
+func TestStatusDecoding(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		packet    []byte
+		want      error
+		transient bool
+		permanent bool
+		counts    ErrorCounts
+	}{
+		{"checkmac/verify", statusPacket(0x01), ErrCheckMacVerify, false, true, ErrorCounts{CheckMacVerify: 1}},
+		{"parse", statusPacket(0x03), ErrParse, false, true, ErrorCounts{Parse: 1}},
+		{"ECC fault", statusPacket(0x05), ErrECCFault, false, false, ErrorCounts{ECCFault: 1}},
+		{"execution", statusPacket(0x0F), ErrExecution, false, false, ErrorCounts{Execution: 1}},
+		{"wake token", statusPacket(0x11), ErrWakeToken, true, false, ErrorCounts{WakeToken: 1}},
+		{"watchdog", statusPacket(0xEE), ErrWatchdog, true, false, ErrorCounts{Watchdog: 1}},
+		{"communication", statusPacket(0xFF), ErrCommunication, true, false, ErrorCounts{Communication: 1}},
+		{"unknown status", statusPacket(0x42), &StatusError{Code: 0x42}, false, false, ErrorCounts{UnknownStatus: 1}},
+		{"response CRC", []byte{4, 0x0F, 0, 0}, ErrResponseCRC, true, false, ErrorCounts{ResponseCRC: 1}},
+		{"response length", []byte{7, 0, 0, 0}, ErrResponseLength, true, false, ErrorCounts{ResponseLength: 1}},
+		{"short read", []byte{4, 0x0F}, ErrResponseLength, true, false, ErrorCounts{ResponseLength: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := parseResponse(tc.packet, 32)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %x, %v, want %v", data, err, tc.want)
+			}
+			if got := isTransient(err); got != tc.transient {
+				t.Errorf("transient is %v, want %v", got, tc.transient)
+			}
+			if got := isPermanent(err); got != tc.permanent {
+				t.Errorf("permanent is %v, want %v", got, tc.permanent)
+			}
+
+			var ec errorCounter
+			ec.record(err)
+			if got := ec.snapshot(); got != tc.counts {
+				t.Errorf("counts are %+v, want %+v", got, tc.counts)
+			}
+		})
+	}
+}
+
+func TestStatusErrorMessages(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, err := range []error{
+		ErrCheckMacVerify, ErrParse, ErrECCFault, ErrExecution,
+		ErrWakeToken, ErrWatchdog, ErrCommunication, &StatusError{Code: 0x42},
+	} {
+		msg := err.Error()
+		if seen[msg] {
+			t.Errorf("message %q is used for two status codes", msg)
+		}
+		seen[msg] = true
+	}
+
+	if got := (&StatusError{Code: 0x42}).Error(); got != "ATECC608A status 0x42: unknown status" {
+		t.Errorf("unknown status message is %q", got)
+	}
+	if errors.Is(ErrParse, ErrExecution) || !errors.Is(&StatusError{Code: 0x03}, ErrParse) {
+		t.Error("status errors are not compared by code")
+	}
+}
+
+func TestStatusSuccess(t *testing.T) {
+	// A success status is the data of a one-byte response
+	data, err := parseResponse(statusPacket(statusSuccess), 1)
+	if err != nil || len(data) != 1 || data[0] != statusSuccess {
+		t.Errorf("got %x, %v, want 00", data, err)
+	}
+
+	// but not of a longer one
+	if _, err := parseResponse(statusPacket(statusSuccess), 32); !errors.Is(err, ErrResponseLength) {
+		t.Errorf("got %v, want %v", err, ErrResponseLength)
+	}
+}
+
+func TestErrorCountsAccumulate(t *testing.T) {
+	var ec errorCounter
+	for _, err := range []error{ErrParse, ErrParse, ErrResponseCRC, errors.New("bus error")} {
+		ec.record(err)
+	}
+	ec.recordReadFailure()
+	ec.recordRetry()
+	ec.recordRetry()
+
+	want := ErrorCounts{Parse: 2, ResponseCRC: 1, ReadFailures: 1, Retries: 2}
+	if got := ec.snapshot(); got != want {
+		t.Errorf("counts are %+v, want %+v", got, want)
+	}
+}
+
+func TestControllerErrorCounts(t *testing.T) {
+	// Keep recovery from sending more commands while the counts are checked
+	policy := DefaultRecoveryPolicy
+	t.Cleanup(func() { DefaultRecoveryPolicy = policy })
+	DefaultRecoveryPolicy.InitialDelay = time.Hour
+	DefaultRecoveryPolicy.SlowRetryInterval = time.Hour
+
+	for _, tc := range []struct {
+		fault Fault
+		want  error
+		check func(ErrorCounts) bool
+	}{
+		// Corrupted responses are resent maxCommandRetries times
+		{FaultCRC, ErrResponseCRC, func(c ErrorCounts) bool {
+			return c.ResponseCRC == maxCommandRetries+1 && c.Retries == maxCommandRetries && c.Execution == 0
+		}},
+		// An execution error is not resent
+		{FaultExecutionError, ErrExecution, func(c ErrorCounts) bool {
+			return c.Execution == 1 && c.Retries == 0 && c.ResponseCRC == 0
+		}},
+	} {
+		emulator := provisionedEmulator(t)
+		controller := newTestController(t, emulator)
+		before := controller.ErrorCounts()
+		if before != (ErrorCounts{}) {
+			t.Fatalf("fault %d: counts before the fault are %+v", tc.fault, before)
+		}
+
+		emulator.InjectFault(tc.fault)
+		if _, err := controller.GenerateRandom(); !errors.Is(err, tc.want) {
+			t.Errorf("fault %d: got %v, want %v", tc.fault, err, tc.want)
+		}
+		if counts := controller.ErrorCounts(); !tc.check(counts) {
+			t.Errorf("fault %d: counts are %+v", tc.fault, counts)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package atecc608a
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultProbeInterval is how often the supervisor checks a healthy device
+	DefaultProbeInterval = 30 * time.Second
+
+	// maxStateEvents is the number of state transitions kept in the history
+	maxStateEvents = 100
+)
+
+// ErrControllerClosed is returned by requests made after Close
+var ErrControllerClosed = errors.New("ATECC608A controller closed")
+
+// RecoveryPolicy controls how the supervisor brings a failed device back. The
+// first MaxRetries attempts back off exponentially from InitialDelay to
+// MaxDelay; transient communication errors retry at the current delay without
+// backing off. After that the supervisor keeps trying every SlowRetryInterval
+// for as long as the process runs.
+type RecoveryPolicy struct {
+	MaxRetries        int
+	InitialDelay      time.Duration
+	MaxDelay          time.Duration
+	SlowRetryInterval time.Duration
+}
+
+// DefaultRecoveryPolicy is the recovery policy used unless overridden by the environment
+var DefaultRecoveryPolicy = RecoveryPolicy{
+	MaxRetries:        maxRetries,
+	InitialDelay:      initialRetryDelay,
+	MaxDelay:          maxRetryDelay,
+	SlowRetryInterval: slowRetryInterval,
+}
+
+// StateEvent is a device state transition
+type StateEvent struct {
+	Time   time.Time   `json:"time"`
+	From   DeviceState `json:"from"`
+	To     DeviceState `json:"to"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// ProbeResult is the outcome of the last background health probe
+type ProbeResult struct {
+	Time  time.Time `json:"time"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+// request is device I/O queued for the supervisor goroutine. The queue is an
+// unbuffered channel, so waiting callers can still give up once the
+// supervisor has stopped.
+type request struct {
+	run  func()
+	done chan struct{}
+}
+
+// configureSupervisorFromEnv reads the probe interval and recovery policy
+func (c *Controller) configureSupervisorFromEnv() {
+	if val, ok := os.LookupEnv("HEALTH_PROBE_INTERVAL"); ok {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			c.probeInterval = d
+		} else {
+			logWarn("Invalid HEALTH_PROBE_INTERVAL %q, using default: %v", val, DefaultProbeInterval)
+		}
+	}
+
+	if val, ok := os.LookupEnv("RECOVERY_MAX_RETRIES"); ok {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			c.policy.MaxRetries = n
+		} else {
+			logWarn("Invalid RECOVERY_MAX_RETRIES %q, using default: %d", val, DefaultRecoveryPolicy.MaxRetries)
+		}
+	}
+
+	if val, ok := os.LookupEnv("RECOVERY_SLOW_INTERVAL"); ok {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			c.policy.SlowRetryInterval = d
+		} else {
+			logWarn("Invalid RECOVERY_SLOW_INTERVAL %q, using default: %v", val, DefaultRecoveryPolicy.SlowRetryInterval)
+		}
+	}
+}
+
+// supervise owns the device: it runs queued requests one at a time, probes
+// a healthy device in the background and runs scheduled recovery attempts
+func (c *Controller) supervise() {
+	defer close(c.stopped)
+
+	probes := time.NewTicker(c.probeInterval)
+	defer probes.Stop()
+
+	for {
+		var retry <-chan time.Time
+		if c.recoveryTimer != nil {
+			retry = c.recoveryTimer.C
+		}
+
+		select {
+		case req := <-c.requests:
+			req.run()
+			close(req.done)
+			if c.closing {
+				return
+			}
+		case <-probes.C:
+			if c.getState() == DeviceStateHealthy {
+				c.probe()
+			}
+		case <-retry:
+			c.recoveryTimer = nil
+			c.attemptRecovery()
+		}
+	}
+}
+
+// do runs fn on the supervisor goroutine and waits for it to finish
+func (c *Controller) do(fn func()) error {
+	req := request{run: fn, done: make(chan struct{})}
+
+	select {
+	case c.requests <- req:
+	case <-c.stopped:
+		return ErrControllerClosed
+	}
+
+	<-req.done
+	return nil
+}
+
+// probe checks that a healthy device still answers Info
+func (c *Controller) probe() {
+	_, err := c.executeWithRetry(cmdInfo, 0x00, 0x0000, nil, 4)
+	c.recordProbe(err)
+	if err != nil {
+		err = fmt.Errorf("health probe failed: %w", err)
+		c.setLastError(err)
+		c.handleCommandError(err)
+		return
+	}
+
+	// Put device back to idle
+	c.idle()
+}
+
+// recordProbe stores the outcome of a health probe
+func (c *Controller) recordProbe(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastProbe = ProbeResult{Time: time.Now(), OK: err == nil}
+	if err != nil {
+		c.lastProbe.Error = err.Error()
+	}
+}
+
+// fail marks a healthy device as failed and starts recovery. Failures of a
+// device that is already failed or recovering are ignored.
+func (c *Controller) fail(reason error) {
+	c.mutex.Lock()
+	if c.state != DeviceStateHealthy {
+		c.mutex.Unlock()
+		return
+	}
+	c.setStateUnlocked(DeviceStateFailed, reason.Error())
+	c.mutex.Unlock()
+
+	c.startRecovery()
+}
+
+// startRecovery schedules the first attempt of a new recovery
+func (c *Controller) startRecovery() {
+	c.recoveryAttempt = 0
+	c.recoveryDelay = c.policy.InitialDelay
+	c.scheduleRecovery(c.recoveryDelay)
+}
+
+// scheduleRecovery arranges for the next recovery attempt to run after delay
+func (c *Controller) scheduleRecovery(delay time.Duration) {
+	c.stopRecovery()
+	c.recoveryTimer = time.NewTimer(delay)
+}
+
+// stopRecovery cancels a scheduled recovery attempt
+func (c *Controller) stopRecovery() {
+	if c.recoveryTimer != nil {
+		c.recoveryTimer.Stop()
+		c.recoveryTimer = nil
+	}
+}
+
+// attemptRecovery reinitializes the device and schedules the next attempt if
+// that fails. Requests queued meanwhile are served between attempts and fail
+// fast, since the device is not healthy.
+func (c *Controller) attemptRecovery() {
+	c.recoveryAttempt++
+	fast := c.recoveryAttempt <= c.policy.MaxRetries
+
+	if fast {
+		logWarn("ATECC608A %s recovery attempt %d/%d (delay: %v)", c.name, c.recoveryAttempt, c.policy.MaxRetries, c.recoveryDelay)
+		c.setState(DeviceStateRecovering, fmt.Sprintf("recovery attempt %d", c.recoveryAttempt))
+	} else {
+		logInfo("ATECC608A %s slow recovery attempt %d", c.name, c.recoveryAttempt)
+	}
+
+	err := c.reinitialize()
+	if err == nil {
+		logWarn("ATECC608A %s recovery successful on attempt %d", c.name, c.recoveryAttempt)
+		c.recordProbe(nil)
+		c.setState(DeviceStateHealthy, fmt.Sprintf("recovered on attempt %d", c.recoveryAttempt))
+		return
+	}
+
+	logWarn("ATECC608A %s recovery attempt %d failed: %v", c.name, c.recoveryAttempt, err)
+
+	var selfTestErr *SelfTestError
+	if errors.As(err, &selfTestErr) {
+		// The device answers but its output is degenerate: keep it unavailable
+		// so /health can report the failing test
+		logError("ATECC608A %s failed its start-up self-test, device stays unavailable", c.name)
+		c.setState(DeviceStateSelfTestFailed, err.Error())
+		return
+	}
+
+	var mismatchErr *IdentityMismatchError
+	if errors.As(err, &mismatchErr) {
+		// Retrying cannot turn one chip into another; an operator has to approve it
+		logError("ATECC608A %s is not the pinned chip, device stays unavailable until approved and restarted", c.name)
+		c.setState(DeviceStateIdentityMismatch, err.Error())
+		return
+	}
+
+	var variantErr *UnsupportedVariantError
+	if errors.As(err, &variantErr) {
+		// A different chip was fitted, and it is not one the controller knows how to drive
+		logError("ATECC608A %s is an unsupported chip variant, device stays unavailable", c.name)
+		c.setState(DeviceStateUnsupported, err.Error())
+		return
+	}
+
+	switch {
+	case !fast:
+		c.scheduleRecovery(c.policy.SlowRetryInterval)
+	case c.recoveryAttempt == c.policy.MaxRetries || isPermanent(err):
+		// Fast retries are over, or the device refused and fast retries cannot help
+		logError("ATECC608A %s recovery failed, retrying every %v", c.name, c.policy.SlowRetryInterval)
+		c.recoveryAttempt = max(c.recoveryAttempt, c.policy.MaxRetries)
+		c.setState(DeviceStateFailed, err.Error())
+		c.scheduleRecovery(c.policy.SlowRetryInterval)
+	case isTransient(err):
+		c.scheduleRecovery(c.recoveryDelay)
+	default:
+		c.recoveryDelay = min(c.recoveryDelay*2, c.policy.MaxDelay) // Exponential backoff
+		c.scheduleRecovery(c.recoveryDelay)
+	}
+}
+
+// recordEventUnlocked appends a state transition to the history
+func (c *Controller) recordEventUnlocked(from, to DeviceState, reason string) {
+	if len(c.events) == maxStateEvents {
+		c.events = c.events[1:]
+	}
+	c.events = append(c.events, StateEvent{
+		Time:   time.Now(),
+		From:   from,
+		To:     to,
+		Reason: reason,
+	})
+}
+
+// Events returns the most recent device state transitions, oldest first
+func (c *Controller) Events() []StateEvent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]StateEvent(nil), c.events...)
+}
+
+// LastProbe returns the outcome of the last background health probe
+func (c *Controller) LastProbe() ProbeResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastProbe
+}
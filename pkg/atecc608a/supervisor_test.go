@@ -0,0 +1,211 @@
+package atecc608a
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newUnsupervisedController sets up a controller on the emulator without
+// initializing it or starting its supervisor, so the test owns the device and
+// can run supervisor steps itself
+func newUnsupervisedController(t *testing.T, emulator *Emulator) *Controller {
+	t.Helper()
+
+	controller, err := newController("test", func() (Bus, error) { return emulator, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(controller.stopRecovery)
+	return controller
+}
+
+// runRecoveryAttempt runs the scheduled recovery attempt as the supervisor does when its timer fires
+func runRecoveryAttempt(c *Controller) {
+	c.stopRecovery()
+	c.attemptRecovery()
+}
+
+func TestSupervisorConfigFromEnv(t *testing.T) {
+	t.Setenv("HEALTH_PROBE_INTERVAL", "5ms")
+	t.Setenv("RECOVERY_MAX_RETRIES", "2")
+	t.Setenv("RECOVERY_SLOW_INTERVAL", "1m")
+
+	controller := newUnsupervisedController(t, provisionedEmulator(t))
+	if controller.probeInterval != 5*time.Millisecond {
+		t.Errorf("probe interval is %v, want 5ms", controller.probeInterval)
+	}
+	if controller.policy.MaxRetries != 2 || controller.policy.SlowRetryInterval != time.Minute {
+		t.Errorf("policy is %+v, want 2 retries and a 1m slow interval", controller.policy)
+	}
+	if controller.policy.InitialDelay != DefaultRecoveryPolicy.InitialDelay {
+		t.Errorf("initial delay is %v, want the default", controller.policy.InitialDelay)
+	}
+
+	// Invalid values keep the defaults
+	t.Setenv("HEALTH_PROBE_INTERVAL", "0s")
+	t.Setenv("RECOVERY_MAX_RETRIES", "-1")
+	t.Setenv("RECOVERY_SLOW_INTERVAL", "soon")
+
+	controller = newUnsupervisedController(t, provisionedEmulator(t))
+	if controller.probeInterval != DefaultProbeInterval || controller.policy != DefaultRecoveryPolicy {
+		t.Errorf("invalid values give probe interval %v and policy %+v", controller.probeInterval, controller.policy)
+	}
+}
+
+func TestSupervisorProbe(t *testing.T) {
+	fastRecovery(t)
+	t.Setenv("HEALTH_PROBE_INTERVAL", "2ms")
+
+	emulator := provisionedEmulator(t)
+	controller := newTestController(t, emulator)
+
+	// An idle device that stops answering is found by the probe, without any request
+	emulator.InjectFault(FaultNACK)
+	waitForState(t, controller, DeviceStateFailed)
+
+	probe := controller.LastProbe()
+	if probe.OK || probe.Error == "" {
+		t.Errorf("last probe is %+v, want a failure", probe)
+	}
+
+	emulator.ClearFaults()
+	waitForState(t, controller, DeviceStateHealthy)
+	if probe := controller.LastProbe(); !probe.OK || probe.Error != "" {
+		t.Errorf("last probe after recovery is %+v, want OK", probe)
+	}
+
+	var probeFailure bool
+	for _, event := range controller.Events() {
+		probeFailure = probeFailure || event.From == DeviceStateHealthy && event.To == DeviceStateFailed
+	}
+	if !probeFailure {
+		t.Errorf("events %+v do not show the probe failure", controller.Events())
+	}
+}
+
+func TestRecoveryPolicyBackoff(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	controller := newUnsupervisedController(t, emulator)
+	controller.policy = RecoveryPolicy{
+		MaxRetries:        4,
+		InitialDelay:      10 * time.Millisecond,
+		MaxDelay:          25 * time.Millisecond,
+		SlowRetryInterval: time.Hour,
+	}
+	controller.setState(DeviceStateHealthy, "initialized")
+
+	// An execution error backs off exponentially up to MaxDelay
+	emulator.InjectFault(FaultExecutionError)
+	controller.fail(errors.New("request failed"))
+	if controller.getState() != DeviceStateFailed || controller.recoveryTimer == nil {
+		t.Fatalf("device is %s after a failure, want failed with recovery scheduled", controller.getState())
+	}
+	if controller.recoveryDelay != 10*time.Millisecond {
+		t.Errorf("first delay is %v, want 10ms", controller.recoveryDelay)
+	}
+
+	for attempt, want := range []time.Duration{20 * time.Millisecond, 25 * time.Millisecond, 25 * time.Millisecond} {
+		runRecoveryAttempt(controller)
+		if controller.getState() != DeviceStateRecovering {
+			t.Errorf("attempt %d: device is %s, want recovering", attempt+1, controller.getState())
+		}
+		if controller.recoveryDelay != want || controller.recoveryTimer == nil {
+			t.Errorf("attempt %d: delay is %v, want %v", attempt+1, controller.recoveryDelay, want)
+		}
+	}
+
+	// The last fast attempt fails the device and moves on to slow retries
+	runRecoveryAttempt(controller)
+	if controller.getState() != DeviceStateFailed || controller.recoveryTimer == nil {
+		t.Errorf("device is %s after the last fast attempt, want failed with a slow retry", controller.getState())
+	}
+	runRecoveryAttempt(controller)
+	if controller.recoveryAttempt != 5 || controller.getState() != DeviceStateFailed || controller.recoveryTimer == nil {
+		t.Errorf("slow attempt %d left the device %s", controller.recoveryAttempt, controller.getState())
+	}
+
+	// A slow attempt that succeeds makes the device healthy again
+	emulator.ClearFaults()
+	runRecoveryAttempt(controller)
+	if controller.getState() != DeviceStateHealthy || controller.recoveryTimer != nil {
+		t.Errorf("device is %s after a successful attempt, want healthy", controller.getState())
+	}
+	if probe := controller.LastProbe(); !probe.OK {
+		t.Errorf("last probe is %+v after recovery, want OK", probe)
+	}
+}
+
+func TestRecoveryPolicyTransientErrors(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	controller := newUnsupervisedController(t, emulator)
+	controller.policy = RecoveryPolicy{
+		MaxRetries:        3,
+		InitialDelay:      10 * time.Millisecond,
+		MaxDelay:          time.Second,
+		SlowRetryInterval: time.Hour,
+	}
+	controller.setState(DeviceStateHealthy, "initialized")
+
+	// Corrupted responses retry at the same delay
+	emulator.InjectFault(FaultCRC)
+	controller.fail(errors.New("request failed"))
+	for attempt := 1; attempt < 3; attempt++ {
+		runRecoveryAttempt(controller)
+		if controller.recoveryDelay != 10*time.Millisecond {
+			t.Errorf("attempt %d: delay is %v, want 10ms", attempt, controller.recoveryDelay)
+		}
+	}
+	runRecoveryAttempt(controller)
+	if controller.getState() != DeviceStateFailed {
+		t.Errorf("device is %s after the fast attempts, want failed", controller.getState())
+	}
+}
+
+func TestRecoveryStopsOnFailedSelfTest(t *testing.T) {
+	emulator := provisionedEmulator(t)
+	controller := newUnsupervisedController(t, emulator)
+	controller.setState(DeviceStateHealthy, "initialized")
+
+	// Degenerate output is not retried
+	emulator.InjectFault(FaultStuckRandom)
+	controller.fail(errors.New("health test failed"))
+	runRecoveryAttempt(controller)
+
+	if controller.getState() != DeviceStateSelfTestFailed {
+		t.Errorf("device is %s, want %s", controller.getState(), DeviceStateSelfTestFailed)
+	}
+	if controller.recoveryTimer != nil {
+		t.Error("recovery is still scheduled after a failed self-test")
+	}
+}
+
+func TestFailIgnoresUnhealthyDevice(t *testing.T) {
+	controller := newUnsupervisedController(t, provisionedEmulator(t))
+	controller.setState(DeviceStateSelfTestFailed, "self-test failed")
+
+	controller.fail(errors.New("request failed"))
+	if controller.getState() != DeviceStateSelfTestFailed || controller.recoveryTimer != nil {
+		t.Errorf("device is %s after a second failure, want it left alone", controller.getState())
+	}
+}
+
+func TestEventHistoryIsBounded(t *testing.T) {
+	controller := newUnsupervisedController(t, provisionedEmulator(t))
+
+	for i := range maxStateEvents + 10 {
+		if i%2 == 0 {
+			controller.setState(DeviceStateHealthy, "up")
+		} else {
+			controller.setState(DeviceStateFailed, "down")
+		}
+	}
+
+	events := controller.Events()
+	if len(events) != maxStateEvents {
+		t.Fatalf("%d events kept, want %d", len(events), maxStateEvents)
+	}
+	if last := events[len(events)-1]; last.To != DeviceStateFailed || last.Reason != "down" {
+		t.Errorf("last event is %+v", last)
+	}
+}
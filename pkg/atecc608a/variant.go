@@ -0,0 +1,281 @@
+package atecc608a
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned for chips and commands outside the variant tables
+var (
+	ErrUnsupportedVariant = errors.New("unsupported chip variant")
+	ErrUnsupportedCommand = errors.New("command not supported by the chip variant")
+)
+
+// UnsupportedVariantError is returned when the Info revision of a chip matches
+// no variant table. Unlike a communication error it does not go away by
+// retrying: the command timings and configuration layout of an unknown chip
+// would be guesses, so the device is not used.
+type UnsupportedVariantError struct {
+	Revision []byte
+}
+
+func (e *UnsupportedVariantError) Error() string {
+	return fmt.Sprintf("%v: Info revision %x is not an ATECC508A, ATECC608A or ATECC608B", ErrUnsupportedVariant, e.Revision)
+}
+
+func (e *UnsupportedVariantError) Unwrap() error {
+	return ErrUnsupportedVariant
+}
+
+// Variant is what the controller needs to know to drive one chip variant: the
+// commands it supports, how long each takes and the layout of its
+// configuration zone. The fields DeviceIdentity decodes are at the same
+// offsets in every variant.
+type Variant struct {
+	Name string
+	// execTimes is the maximum execution time of each supported command, by
+	// opcode; commands that are not listed are refused before they are sent
+	execTimes map[byte]time.Duration
+	// configFields names the configuration zone fields by first byte, in order
+	configFields []configField
+}
+
+// configField is a configuration zone field and its first byte
+type configField struct {
+	start int
+	name  string
+}
+
+// VariantInfo describes the variant table a controller drives its chip with
+type VariantInfo struct {
+	Variant string `json:"variant"`
+	// Detected is false until the Info revision has been read
+	Detected bool `json:"detected"`
+	// ExecTimes is the execution time waited for each supported command, by name
+	ExecTimes map[string]string `json:"exec_times"`
+}
+
+// commandNames names the opcodes the controller sends
+var commandNames = map[byte]string{
+	cmdInfo:    "info",
+	cmdRandom:  "random",
+	cmdRead:    "read",
+	cmdLock:    "lock",
+	cmdWrite:   "write",
+	cmdNonce:   "nonce",
+	cmdSign:    "sign",
+	cmdGenKey:  "genkey",
+	cmdCounter: "counter",
+	cmdSHA:     "sha",
+}
+
+// Maximum execution times from the datasheets, as in Microchip's
+// CryptoAuthLib. The 608 times are those at the default clock divider.
+var (
+	atecc508aExecTimes = map[byte]time.Duration{
+		cmdInfo:    1 * time.Millisecond,
+		cmdRandom:  23 * time.Millisecond,
+		cmdRead:    1 * time.Millisecond,
+		cmdLock:    32 * time.Millisecond,
+		cmdWrite:   26 * time.Millisecond,
+		cmdNonce:   7 * time.Millisecond,
+		cmdSign:    50 * time.Millisecond,
+		cmdGenKey:  115 * time.Millisecond,
+		cmdCounter: 20 * time.Millisecond,
+		cmdSHA:     9 * time.Millisecond,
+	}
+
+	atecc608ExecTimes = map[byte]time.Duration{
+		cmdInfo:    5 * time.Millisecond,
+		cmdRandom:  23 * time.Millisecond,
+		cmdRead:    5 * time.Millisecond,
+		cmdLock:    35 * time.Millisecond,
+		cmdWrite:   45 * time.Millisecond,
+		cmdNonce:   20 * time.Millisecond,
+		cmdSign:    220 * time.Millisecond,
+		cmdGenKey:  115 * time.Millisecond,
+		cmdCounter: 25 * time.Millisecond,
+		cmdSHA:     36 * time.Millisecond,
+	}
+)
+
+// Configuration zone layouts. The 508A has OTPmode, LastKeyUse and Selector
+// where the 608 has CountMatch, the secure boot and KDF fields, UserExtraAdd
+// and ChipOptions.
+var (
+	atecc508aConfigFields = []configField{
+		{0, "SN[0:3]"}, {4, "RevNum"}, {8, "SN[4:8]"}, {13, "Reserved"}, {14, "I2C_Enable"}, {15, "Reserved"},
+		{16, "I2C_Address"}, {17, "Reserved"}, {18, "OTPmode"}, {19, "ChipMode"}, {20, "SlotConfig"},
+		{52, "Counter0"}, {60, "Counter1"}, {68, "LastKeyUse"}, {84, "UserExtra"}, {85, "Selector"},
+		{86, "LockValue"}, {87, "LockConfig"}, {88, "SlotLocked"}, {90, "RFU"}, {92, "X509format"},
+		{96, "KeyConfig"},
+	}
+
+	atecc608ConfigFields = []configField{
+		{0, "SN[0:3]"}, {4, "RevNum"}, {8, "SN[4:8]"}, {13, "AES_Enable"}, {14, "I2C_Enable"}, {15, "Reserved"},
+		{16, "I2C_Address"}, {17, "Reserved"}, {18, "CountMatch"}, {19, "ChipMode"}, {20, "SlotConfig"},
+		{52, "Counter0"}, {60, "Counter1"}, {68, "UseLock"}, {69, "VolatileKeyPermission"}, {70, "SecureBoot"},
+		{72, "KdflvLoc"}, {73, "KdflvStr"}, {75, "Reserved"}, {84, "UserExtra"}, {85, "UserExtraAdd"},
+		{86, "LockValue"}, {87, "LockConfig"}, {88, "SlotLocked"}, {90, "ChipOptions"}, {92, "X509format"},
+		{96, "KeyConfig"},
+	}
+)
+
+// The variant tables. The 608B is a new revision of the 608 silicon with the
+// same command timings and configuration layout as the 608A.
+var (
+	variantATECC508A = &Variant{Name: VariantATECC508A, execTimes: atecc508aExecTimes, configFields: atecc508aConfigFields}
+	variantATECC608A = &Variant{Name: VariantATECC608A, execTimes: atecc608ExecTimes, configFields: atecc608ConfigFields}
+	variantATECC608B = &Variant{Name: VariantATECC608B, execTimes: atecc608ExecTimes, configFields: atecc608ConfigFields}
+
+	// variantUndetected is used until the Info revision has been read: the
+	// commands every variant supports, with the longest time of any of them
+	variantUndetected = &Variant{
+		Name:         VariantUnknown,
+		execTimes:    longestExecTimes(variantATECC508A, variantATECC608A, variantATECC608B),
+		configFields: atecc608ConfigFields,
+	}
+
+	// variantUnsupported is used for a chip whose revision matches no table.
+	// Info and Read are still sent, so that the chip can be identified.
+	variantUnsupported = &Variant{
+		Name: VariantUnknown,
+		execTimes: map[byte]time.Duration{
+			cmdInfo: variantUndetected.execTimes[cmdInfo],
+			cmdRead: variantUndetected.execTimes[cmdRead],
+		},
+		configFields: atecc608ConfigFields,
+	}
+)
+
+// longestExecTimes returns the commands all variants support, each with the
+// longest execution time of any of them
+func longestExecTimes(variants ...*Variant) map[byte]time.Duration {
+	execTimes := make(map[byte]time.Duration)
+	for opcode, execTime := range variants[0].execTimes {
+		supported := true
+		for _, variant := range variants[1:] {
+			other, ok := variant.execTimes[opcode]
+			if !ok {
+				supported = false
+				break
+			}
+			execTime = max(execTime, other)
+		}
+		if supported {
+			execTimes[opcode] = execTime
+		}
+	}
+	return execTimes
+}
+
+// variantForRevision returns the variant table for an Info revision, or
+// variantUnsupported if none matches
+func variantForRevision(revision []byte) *Variant {
+	return variantByName(variantFromRevision(revision))
+}
+
+// variantByName returns the variant table of a variant name, or
+// variantUnsupported for VariantUnknown and names of no variant
+func variantByName(name string) *Variant {
+	switch name {
+	case VariantATECC508A:
+		return variantATECC508A
+	case VariantATECC608A:
+		return variantATECC608A
+	case VariantATECC608B:
+		return variantATECC608B
+	default:
+		return variantUnsupported
+	}
+}
+
+// configFieldName returns the datasheet name of the configuration zone field
+// holding the byte at offset, e.g. "SlotConfig[3]" for the slot 3 SlotConfig
+func (v *Variant) configFieldName(offset int) string {
+	field := v.configFields[0]
+	for _, f := range v.configFields {
+		if f.start <= offset {
+			field = f
+		}
+	}
+
+	switch field.name {
+	case "SlotConfig", "KeyConfig":
+		return fmt.Sprintf("%s[%d]", field.name, (offset-field.start)/2)
+	}
+	return field.name
+}
+
+// currentVariant returns the variant table commands are sent with
+func (c *Controller) currentVariant() *Variant {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.variant == nil {
+		return variantUndetected
+	}
+	return c.variant
+}
+
+// selectVariant loads the variant table for the Info revision the chip
+// returned. A revision that matches no table leaves only Info and Read
+// available and returns an *UnsupportedVariantError.
+func (c *Controller) selectVariant(revision []byte) error {
+	variant := variantForRevision(revision)
+
+	c.mutex.Lock()
+	changed := c.variant != variant
+	c.variant = variant
+	c.mutex.Unlock()
+
+	if variant == variantUnsupported {
+		return &UnsupportedVariantError{Revision: append([]byte(nil), revision...)}
+	}
+	if changed {
+		logInfo("ATECC608A %s: Info revision %x, using the %s command timings", c.name, revision, variant.Name)
+	}
+	return nil
+}
+
+// execTime returns how long to wait for a command before reading its
+// response, and ErrUnsupportedCommand if the chip variant does not support it
+func (c *Controller) execTime(opcode byte) (time.Duration, error) {
+	variant := c.currentVariant()
+	execTime, ok := variant.execTimes[opcode]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s on %s", ErrUnsupportedCommand, commandName(opcode), variant.Name)
+	}
+	return execTime, nil
+}
+
+// commandName returns the name of an opcode for messages
+func commandName(opcode byte) string {
+	if name, ok := commandNames[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", opcode)
+}
+
+// Variant returns the variant table the controller drives its chip with
+func (c *Controller) Variant() VariantInfo {
+	c.mutex.Lock()
+	variant := c.variant
+	c.mutex.Unlock()
+
+	detected := variant != nil
+	if !detected {
+		variant = variantUndetected
+	}
+
+	info := VariantInfo{
+		Variant:   variant.Name,
+		Detected:  detected,
+		ExecTimes: make(map[string]string, len(variant.execTimes)),
+	}
+	for opcode, execTime := range variant.execTimes {
+		info.ExecTimes[commandName(opcode)] = execTime.String()
+	}
+	return info
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/lokey/rng-service/pkg/signing"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -20,6 +22,9 @@ var (
 	usageStatsBucket  = []byte("usage_stats")
 	countersBucket    = []byte("counters")
 	configBucket      = []byte("config")
+
+	signedBatchBucket      = []byte("signed_batches")
+	signedBatchIndexBucket = []byte("signed_batch_index") // value -> ID of the batch holding it
 )
 
 // BoltDBHandler implements the database interface using BoltDB
@@ -65,6 +70,8 @@ func NewBoltDBHandler(dbPath string, trngQueueSize, fortunaQueueSize int) (*Bolt
 			usageStatsBucket,
 			countersBucket,
 			configBucket,
+			signedBatchBucket,
+			signedBatchIndexBucket,
 		}
 		for _, bucket := range buckets {
 			_, err := tx.CreateBucketIfNotExists(bucket)
@@ -86,6 +93,7 @@ func NewBoltDBHandler(dbPath string, trngQueueSize, fortunaQueueSize int) (*Bolt
 			{[]byte("fortuna_dropped_count"), 0},
 			{[]byte("trng_consumed_count"), 0},
 			{[]byte("fortuna_consumed_count"), 0},
+			{[]byte("signed_batch_next_id"), 0},
 		}
 
 		b := tx.Bucket(countersBucket)
@@ -479,6 +487,117 @@ func (h *BoltDBHandler) trimFortunaDataIfNeeded(tx *bolt.Tx) error {
 	return nil
 }
 
+//---------------------- Signed Batch Operations ----------------------
+
+// StoreSignedBatch stores a signed batch and indexes it by each of its values
+func (h *BoltDBHandler) StoreSignedBatch(batch signing.SignedBatch) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		id, err := h.getNextID(tx, []byte("signed_batch_next_id"))
+		if err != nil {
+			return err
+		}
+
+		jsonData, err := json.Marshal(batch)
+		if err != nil {
+			return fmt.Errorf("serialize signed batch: %w", err)
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, id)
+		if err := tx.Bucket(signedBatchBucket).Put(key, jsonData); err != nil {
+			return fmt.Errorf("store signed batch: %w", err)
+		}
+
+		index := tx.Bucket(signedBatchIndexBucket)
+		for _, value := range batch.Data {
+			raw, err := hex.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("decode batch value: %w", err)
+			}
+			if err := index.Put(raw, key); err != nil {
+				return fmt.Errorf("index signed batch: %w", err)
+			}
+		}
+
+		return h.trimSignedBatchesIfNeeded(tx)
+	})
+}
+
+// GetSignedBatch returns the stored batch that holds value, or nil if there is none
+func (h *BoltDBHandler) GetSignedBatch(value []byte) (*signing.SignedBatch, error) {
+	var batch *signing.SignedBatch
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(signedBatchIndexBucket).Get(value)
+		if key == nil {
+			return nil
+		}
+
+		jsonData := tx.Bucket(signedBatchBucket).Get(key)
+		if jsonData == nil {
+			return nil
+		}
+
+		batch = &signing.SignedBatch{}
+		if err := json.Unmarshal(jsonData, batch); err != nil {
+			return fmt.Errorf("deserialize signed batch: %w", err)
+		}
+		return nil
+	})
+
+	return batch, err
+}
+
+// trimSignedBatchesIfNeeded caps the signed batches at as many as the TRNG
+// queue holds values. Like trimTRNGDataIfNeeded it keeps the oldest entries,
+// so the batches of the values still in the queue are kept.
+func (h *BoltDBHandler) trimSignedBatchesIfNeeded(tx *bolt.Tx) error {
+	b := tx.Bucket(signedBatchBucket)
+	index := tx.Bucket(signedBatchIndexBucket)
+
+	h.mu.RLock()
+	maxSize := h.trngQueueSize
+	h.mu.RUnlock()
+
+	// Count total batches and collect those beyond the limit
+	var count int
+	var excessKeys [][]byte
+	var excessBatches [][]byte
+
+	cursor := b.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		count++
+		if count > maxSize {
+			excessKeys = append(excessKeys, append([]byte{}, k...))
+			excessBatches = append(excessBatches, append([]byte{}, v...))
+		}
+	}
+
+	for i, key := range excessKeys {
+		var batch signing.SignedBatch
+		if err := json.Unmarshal(excessBatches[i], &batch); err == nil {
+			for _, value := range batch.Data {
+				raw, err := hex.DecodeString(value)
+				if err != nil {
+					continue
+				}
+				// Another batch may hold the same value; keep its index entry
+				if string(index.Get(raw)) == string(key) {
+					if err := index.Delete(raw); err != nil {
+						return fmt.Errorf("delete index entry: %w", err)
+					}
+				}
+			}
+		}
+
+		if err := b.Delete(key); err != nil {
+			return fmt.Errorf("delete signed batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
 //---------------------- Enhanced Statistics Operations ----------------------
 
 // IncrementPollingCount increments the polling counter for a data source
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/lokey/rng-service/pkg/signing"
 )
 
 // DataItem represents a single item in the queue
@@ -142,6 +145,11 @@ type ChannelDBHandler struct {
 	nextFortunaID atomic.Uint64
 	// Note: No mutex needed here - CircularQueue handles its own synchronization
 	// and atomic fields are self-synchronized
+
+	// Signed batches, oldest first, indexed by value (hex)
+	signedMutex   sync.Mutex
+	signedBatches []*signing.SignedBatch
+	signedIndex   map[string]*signing.SignedBatch
 }
 
 // NewChannelDBHandler creates a new channel-based database handler
@@ -149,6 +157,7 @@ func NewChannelDBHandler(dbPath string, trngQueueSize, fortunaQueueSize int) (*C
 	return &ChannelDBHandler{
 		trngQueue:    NewCircularQueue(trngQueueSize),
 		fortunaQueue: NewCircularQueue(fortunaQueueSize),
+		signedIndex:  make(map[string]*signing.SignedBatch),
 	}, nil
 }
 
@@ -199,6 +208,48 @@ func (h *ChannelDBHandler) GetFortunaData(limit, offset int, consume bool) ([][]
 	return h.fortunaQueue.Get(limit, offset, consume), nil
 }
 
+//---------------------- Signed Batch Operations ----------------------
+
+// StoreSignedBatch stores a signed batch, dropping the oldest once there are
+// as many batches as the TRNG queue holds values
+func (h *ChannelDBHandler) StoreSignedBatch(batch signing.SignedBatch) error {
+	h.signedMutex.Lock()
+	defer h.signedMutex.Unlock()
+
+	stored := &batch
+	h.signedBatches = append(h.signedBatches, stored)
+	for _, value := range batch.Data {
+		h.signedIndex[value] = stored
+	}
+
+	for len(h.signedBatches) > h.trngQueue.Capacity() {
+		oldest := h.signedBatches[0]
+		h.signedBatches[0] = nil
+		h.signedBatches = h.signedBatches[1:]
+		for _, value := range oldest.Data {
+			// A later batch may hold the same value; keep its entry
+			if h.signedIndex[value] == oldest {
+				delete(h.signedIndex, value)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetSignedBatch returns the stored batch that holds value, or nil if there is none
+func (h *ChannelDBHandler) GetSignedBatch(value []byte) (*signing.SignedBatch, error) {
+	h.signedMutex.Lock()
+	defer h.signedMutex.Unlock()
+
+	stored, ok := h.signedIndex[hex.EncodeToString(value)]
+	if !ok {
+		return nil, nil
+	}
+	batch := *stored
+	return &batch, nil
+}
+
 //---------------------- Enhanced Statistics Operations ----------------------
 
 // IncrementPollingCount increments the polling counter for a data source
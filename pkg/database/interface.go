@@ -1,6 +1,10 @@
 package database
 
-import "time"
+import (
+	"time"
+
+	"github.com/lokey/rng-service/pkg/signing"
+)
 
 // UsageStat represents usage statistics
 type UsageStat struct {
@@ -62,6 +66,10 @@ type DBHandler interface {
 	StoreFortunaData(data []byte) error
 	GetFortunaData(limit, offset int, consume bool) ([][]byte, error)
 
+	// Signed batches of TRNG data, retained like the TRNG queue and capped at as many batches as it holds values
+	StoreSignedBatch(batch signing.SignedBatch) error
+	GetSignedBatch(value []byte) (*signing.SignedBatch, error) // nil if no stored batch holds value
+
 	// Enhanced statistics
 	GetDetailedStats() (*DetailedStats, error)
 	IncrementPollingCount(source string) error
@@ -0,0 +1,283 @@
+package drbg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// CTRSeedLength is the CTR_DRBG seed length for AES-256: key plus one block
+	CTRSeedLength = ctrKeySize + aes.BlockSize
+	// CTRMaxRequestSize is the maximum number of bytes per CTR_DRBG request (2^19 bits)
+	CTRMaxRequestSize = (1 << 19) / 8
+	// ctrKeySize is the AES-256 key size in bytes
+	ctrKeySize = 32
+)
+
+// CTR is a CTR_DRBG instantiated with AES-256 as specified in SP 800-90A
+// Rev. 1, section 10.2.1. NewCTRWithDF instantiates it with the
+// Block_Cipher_df derivation function, which takes input of any length;
+// NewCTR without one, which takes exactly CTRSeedLength bytes of full-entropy
+// input and personalization and additional input at most that long.
+// It is not safe for concurrent use.
+type CTR struct {
+	cipher        cipher.Block
+	v             [aes.BlockSize]byte
+	block         [aes.BlockSize]byte // scratch space for partial output blocks
+	reseedCounter uint64
+	df            bool // inputs go through Block_Cipher_df
+}
+
+// NewCTR instantiates a CTR_DRBG without a derivation function (section 10.2.1.3.1)
+func NewCTR(entropy, personalization []byte) (*CTR, error) {
+	seed, err := ctrSeedMaterial(entropy, personalization)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCTR(&seed, false)
+}
+
+// NewCTRWithDF instantiates a CTR_DRBG with the derivation function (section
+// 10.2.1.3.2). The entropy input must hold at least SecurityStrength bytes and
+// the nonce at least half of that.
+func NewCTRWithDF(entropy, nonce, personalization []byte) (*CTR, error) {
+	if len(entropy) < SecurityStrength {
+		return nil, fmt.Errorf("entropy input must be at least %d bytes long, got %d", SecurityStrength, len(entropy))
+	}
+
+	if len(nonce) < SecurityStrength/2 {
+		return nil, fmt.Errorf("nonce must be at least %d bytes long, got %d", SecurityStrength/2, len(nonce))
+	}
+
+	seed, err := blockCipherDF(concat(entropy, nonce, personalization))
+	if err != nil {
+		return nil, err
+	}
+
+	return newCTR(&seed, true)
+}
+
+// newCTR sets Key and V to all zeros and updates them with the seed material
+func newCTR(seed *[CTRSeedLength]byte, df bool) (*CTR, error) {
+	defer clear(seed[:])
+
+	d := &CTR{df: df}
+	if err := d.rekey(make([]byte, ctrKeySize)); err != nil {
+		return nil, err
+	}
+
+	if err := d.update(seed); err != nil {
+		return nil, err
+	}
+	d.reseedCounter = 1
+
+	return d, nil
+}
+
+// Reseed mixes fresh entropy and optional additional input into the state
+// (sections 10.2.1.4.1 and 10.2.1.4.2)
+func (d *CTR) Reseed(entropy, additional []byte) error {
+	var seed [CTRSeedLength]byte
+	var err error
+	if d.df {
+		if len(entropy) < SecurityStrength {
+			return fmt.Errorf("entropy input must be at least %d bytes long, got %d", SecurityStrength, len(entropy))
+		}
+		seed, err = blockCipherDF(concat(entropy, additional))
+	} else {
+		seed, err = ctrSeedMaterial(entropy, additional)
+	}
+	if err != nil {
+		return err
+	}
+	defer clear(seed[:])
+
+	if err := d.update(&seed); err != nil {
+		return err
+	}
+	d.reseedCounter = 1
+
+	return nil
+}
+
+// Generate fills dst with at most CTRMaxRequestSize bytes (section 10.2.1.5.1).
+// It returns ErrReseedRequired once ReseedInterval requests have been served.
+func (d *CTR) Generate(dst, additional []byte) error {
+	if len(dst) > CTRMaxRequestSize {
+		return fmt.Errorf("request of %d bytes exceeds maximum of %d", len(dst), CTRMaxRequestSize)
+	}
+
+	if !d.df && len(additional) > CTRSeedLength {
+		return fmt.Errorf("additional input must be at most %d bytes long, got %d", CTRSeedLength, len(additional))
+	}
+
+	if d.reseedCounter > ReseedInterval {
+		return ErrReseedRequired
+	}
+
+	// Missing additional input is treated as all zeros for the final update
+	var input [CTRSeedLength]byte
+	if len(additional) > 0 {
+		if d.df {
+			var err error
+			if input, err = blockCipherDF(additional); err != nil {
+				return err
+			}
+		} else {
+			copy(input[:], additional)
+		}
+		if err := d.update(&input); err != nil {
+			return err
+		}
+	}
+
+	for len(dst) > 0 {
+		increment(&d.v)
+		if len(dst) >= aes.BlockSize {
+			d.cipher.Encrypt(dst[:aes.BlockSize], d.v[:])
+			dst = dst[aes.BlockSize:]
+		} else {
+			// Encrypt into scratch space and keep only the bytes requested
+			d.cipher.Encrypt(d.block[:], d.v[:])
+			dst = dst[copy(dst, d.block[:]):]
+		}
+	}
+	clear(d.block[:])
+
+	if err := d.update(&input); err != nil {
+		return err
+	}
+	d.reseedCounter++
+
+	return nil
+}
+
+// ReseedCounter returns the number of requests served since the last reseed, plus one
+func (d *CTR) ReseedCounter() uint64 {
+	return d.reseedCounter
+}
+
+// update is CTR_DRBG_Update (section 10.2.1.2)
+func (d *CTR) update(provided *[CTRSeedLength]byte) error {
+	var temp [CTRSeedLength]byte
+	for i := 0; i < CTRSeedLength; i += aes.BlockSize {
+		increment(&d.v)
+		d.cipher.Encrypt(temp[i:i+aes.BlockSize], d.v[:])
+	}
+
+	subtle.XORBytes(temp[:], temp[:], provided[:])
+
+	copy(d.v[:], temp[ctrKeySize:])
+	err := d.rekey(temp[:ctrKeySize])
+	clear(temp[:])
+
+	return err
+}
+
+// rekey replaces the block cipher key
+func (d *CTR) rekey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	d.cipher = block
+	return nil
+}
+
+// ctrSeedMaterial returns entropy XOR input, with input padded with zeros to CTRSeedLength
+func ctrSeedMaterial(entropy, input []byte) ([CTRSeedLength]byte, error) {
+	var seed [CTRSeedLength]byte
+
+	if len(entropy) != CTRSeedLength {
+		return seed, fmt.Errorf("entropy input must be exactly %d bytes long, got %d", CTRSeedLength, len(entropy))
+	}
+
+	if len(input) > CTRSeedLength {
+		return seed, fmt.Errorf("personalization or additional input must be at most %d bytes long, got %d", CTRSeedLength, len(input))
+	}
+
+	copy(seed[:], input)
+	subtle.XORBytes(seed[:], seed[:], entropy)
+
+	return seed, nil
+}
+
+// blockCipherDF is Block_Cipher_df with AES-256 (section 10.3.2), returning
+// CTRSeedLength bytes derived from input
+func blockCipherDF(input []byte) ([CTRSeedLength]byte, error) {
+	var out [CTRSeedLength]byte
+
+	if uint64(len(input)) > math.MaxUint32 {
+		return out, fmt.Errorf("derivation function input of %d bytes is too long", len(input))
+	}
+
+	// S = L || N || input || 0x80, padded with zeros to a multiple of the block size
+	s := make([]byte, 8, 8+len(input)+aes.BlockSize)
+	binary.BigEndian.PutUint32(s[0:4], uint32(len(input))) // #nosec G115 - checked above
+	binary.BigEndian.PutUint32(s[4:8], CTRSeedLength)
+	s = append(s, input...)
+	s = append(s, 0x80)
+	for len(s)%aes.BlockSize != 0 {
+		s = append(s, 0x00)
+	}
+	defer clear(s)
+
+	// The key for BCC is 00 01 02 ... 1F
+	key := make([]byte, ctrKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return out, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	// temp = BCC(K, IV_i || S) for i = 0, 1, 2, where IV_i is the 32-bit i padded with zeros
+	var temp [CTRSeedLength]byte
+	var iv [aes.BlockSize]byte
+	for i := 0; i < CTRSeedLength/aes.BlockSize; i++ {
+		binary.BigEndian.PutUint32(iv[:4], uint32(i)) // #nosec G115 - i is at most 2
+		bcc(block, iv[:], s, temp[i*aes.BlockSize:(i+1)*aes.BlockSize])
+	}
+	defer clear(temp[:])
+
+	// Encrypt X repeatedly under the derived key
+	if block, err = aes.NewCipher(temp[:ctrKeySize]); err != nil {
+		return out, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	x := temp[ctrKeySize:]
+	for i := 0; i < CTRSeedLength; i += aes.BlockSize {
+		block.Encrypt(out[i:i+aes.BlockSize], x)
+		x = out[i : i+aes.BlockSize]
+	}
+
+	return out, nil
+}
+
+// bcc is the BCC function (section 10.3.3): the CBC-MAC with a zero IV of the
+// blocks of iv || data, written to out. data is a multiple of the block size.
+func bcc(block cipher.Block, iv, data, out []byte) {
+	var chain [aes.BlockSize]byte
+	block.Encrypt(chain[:], iv)
+	for i := 0; i < len(data); i += aes.BlockSize {
+		subtle.XORBytes(chain[:], chain[:], data[i:i+aes.BlockSize])
+		block.Encrypt(chain[:], chain[:])
+	}
+	copy(out, chain[:])
+}
+
+// increment adds one to the 128-bit big-endian block V
+func increment(v *[aes.BlockSize]byte) {
+	for i := len(v) - 1; i >= 0; i-- {
+		v[i]++
+		if v[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,416 @@
+// Package drbg implements the HMAC_DRBG and CTR_DRBG mechanisms of NIST
+// SP 800-90A Rev. 1 and a Generator that runs either of them behind the same
+// interface as the Fortuna generator.
+package drbg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/fortuna"
+)
+
+const (
+	// SecurityStrength is the security strength of both mechanisms in bytes (256 bits)
+	SecurityStrength = 32
+	// ReseedInterval is the maximum number of requests between reseeds allowed by SP 800-90A
+	ReseedInterval = 1 << 48
+	// maxPendingEntropy bounds the entropy input collected between two reseeds
+	maxPendingEntropy = 4096
+)
+
+// ErrReseedRequired is returned by Generate once ReseedInterval requests have been served
+var ErrReseedRequired = errors.New("drbg reseed required")
+
+// Algorithm names a DRBG mechanism
+type Algorithm string
+
+// Supported mechanisms
+const (
+	HMACSHA256 Algorithm = "hmac-drbg" // HMAC_DRBG with SHA-256
+	CTRAES256  Algorithm = "ctr-drbg"  // CTR_DRBG with AES-256 and the Block_Cipher_df derivation function
+)
+
+// mechanism is implemented by HMAC and CTR
+type mechanism interface {
+	Reseed(entropy, additional []byte) error
+	Generate(dst, additional []byte) error
+	ReseedCounter() uint64
+}
+
+// Generator runs a DRBG mechanism as a service generator. Entropy from the
+// registered sources is collected until at least SecurityStrength credited
+// bytes are available and then used to instantiate or reseed the mechanism.
+type Generator struct {
+	algorithm       Algorithm
+	personalization []byte
+	mechanism       mechanism // nil until the first reseed instantiates it
+	mutex           sync.Mutex
+	pending         []byte // entropy input collected since the last reseed
+	pendingCredited uint64 // bytes of pending from credited sources
+	lastReseed      time.Time
+	reseedCount     uint64 // number of reseeds from collected entropy
+	reseedEvents    uint64 // number of reseeds of any kind, identifies each reseed event
+	instantiations  uint64 // distinguishes nonces of instantiations within the same nanosecond
+	sources         map[fortuna.SourceID]*sourceState
+	seeded          bool   // set once a reseed carried at least seedThreshold bytes of real entropy
+	seedThreshold   uint64 // credited entropy bytes required to become seeded
+	isHealthy       bool
+}
+
+// sourceState tracks how much a source has contributed
+type sourceState struct {
+	events uint64
+	bytes  uint64
+}
+
+// NewGenerator creates a generator for the given mechanism. The mechanism is
+// instantiated with the personalization string on the first reseed, so the
+// generator refuses output until it has received real entropy.
+func NewGenerator(algorithm Algorithm, personalization []byte) (*Generator, error) {
+	if algorithm != HMACSHA256 && algorithm != CTRAES256 {
+		return nil, fmt.Errorf("unknown DRBG mechanism %q", algorithm)
+	}
+
+	return &Generator{
+		algorithm:       algorithm,
+		personalization: append([]byte(nil), personalization...),
+		sources:         make(map[fortuna.SourceID]*sourceState),
+		seedThreshold:   fortuna.DefaultSeedThreshold,
+		isHealthy:       true,
+	}, nil
+}
+
+// Name returns the mechanism name
+func (g *Generator) Name() string {
+	return string(g.algorithm)
+}
+
+// AddRandomData collects entropy input from a registered source for the next reseed
+func (g *Generator) AddRandomData(source fortuna.SourceID, data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("cannot add empty random data")
+	}
+
+	if !source.Registered() {
+		return fmt.Errorf("unknown entropy source %d", byte(source))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.pending)+len(data) > maxPendingEntropy {
+		return fmt.Errorf("entropy buffer full, %d bytes pending", len(g.pending))
+	}
+
+	g.pending = append(g.pending, data...)
+	if source.Credited() {
+		g.pendingCredited += uint64(len(data))
+	}
+	g.recordSourceUnlocked(source, len(data))
+
+	return nil
+}
+
+// Reseed reseeds the mechanism directly, instantiating it if needed. The seeds
+// are treated as real entropy, as with fortuna.Generator.Reseed.
+func (g *Generator) Reseed(seeds [][]byte) error {
+	if len(seeds) == 0 {
+		return fmt.Errorf("cannot reseed with empty seed list")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var seed []byte
+	for _, s := range seeds {
+		seed = append(seed, s...)
+	}
+	defer clear(seed)
+
+	return g.reseedUnlocked(seed, uint64(len(seed)))
+}
+
+// ReseedFromPools reseeds from the collected entropy. It returns
+// fortuna.ErrReseedNotDue if fewer than SecurityStrength credited bytes have
+// been collected or the last reseed happened less than fortuna.MinReseedInterval ago.
+func (g *Generator) ReseedFromPools() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.reseedDueUnlocked() {
+		return fortuna.ErrReseedNotDue
+	}
+
+	return g.reseedFromPendingUnlocked()
+}
+
+// reseedDueUnlocked reports whether enough credited entropy has been collected
+// and enough time has passed since the last reseed
+func (g *Generator) reseedDueUnlocked() bool {
+	return g.pendingCredited >= SecurityStrength && time.Since(g.lastReseed) >= fortuna.MinReseedInterval
+}
+
+// reseedFromPendingUnlocked reseeds with the collected entropy and discards it
+func (g *Generator) reseedFromPendingUnlocked() error {
+	g.reseedCount++
+
+	err := g.reseedUnlocked(g.pending, g.pendingCredited)
+
+	clear(g.pending)
+	g.pending = g.pending[:0]
+	g.pendingCredited = 0
+
+	return err
+}
+
+// reseedUnlocked instantiates the mechanism on the first call and reseeds it
+// afterwards. entropy is the number of bytes of real entropy the seed is credited with.
+func (g *Generator) reseedUnlocked(seed []byte, entropy uint64) error {
+	if len(seed) < SecurityStrength {
+		return fmt.Errorf("entropy input must be at least %d bytes long, got %d", SecurityStrength, len(seed))
+	}
+
+	if g.mechanism == nil {
+		m, err := g.instantiateUnlocked(seed)
+		if err != nil {
+			return fmt.Errorf("failed to instantiate %s: %w", g.algorithm, err)
+		}
+		g.mechanism = m
+	} else if err := g.mechanism.Reseed(seed, nil); err != nil {
+		return fmt.Errorf("failed to reseed %s: %w", g.algorithm, err)
+	}
+
+	g.reseedEvents++
+	g.lastReseed = time.Now()
+
+	if entropy > 0 && entropy >= g.seedThreshold {
+		g.seeded = true
+	}
+
+	return nil
+}
+
+// instantiateUnlocked creates the mechanism from the first entropy input
+func (g *Generator) instantiateUnlocked(seed []byte) (mechanism, error) {
+	// A timestamp and an instantiation counter form the nonce (SP 800-90A section 8.6.7)
+	g.instantiations++
+	nonce := make([]byte, SecurityStrength/2)
+	binary.BigEndian.PutUint64(nonce[:8], uint64(time.Now().UnixNano())) // #nosec G115
+	binary.BigEndian.PutUint64(nonce[8:], g.instantiations)
+
+	if g.algorithm == CTRAES256 {
+		return NewCTRWithDF(seed, nonce, g.personalization)
+	}
+	return NewHMAC(seed, nonce, g.personalization)
+}
+
+// maxRequestSize returns the largest request the mechanism serves at once
+func (g *Generator) maxRequestSize() int {
+	if g.algorithm == CTRAES256 {
+		return CTRMaxRequestSize
+	}
+	return HMACMaxRequestSize
+}
+
+// GenerateRandomData generates random data of the specified length
+func (g *Generator) GenerateRandomData(length int) ([]byte, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("length must be positive, got %d", length)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.generateUnlocked(length, nil)
+}
+
+// GeneratePredictionResistant reseeds with fresh entropy and generates length
+// bytes while holding the lock, so the output cannot be predicted from any
+// state observed before the call. It returns the number of the reseed event
+// that preceded the output.
+func (g *Generator) GeneratePredictionResistant(entropy []byte, length int) ([]byte, uint64, error) {
+	if len(entropy) < SecurityStrength {
+		return nil, 0, fmt.Errorf("prediction resistance needs at least %d bytes of fresh entropy, got %d", SecurityStrength, len(entropy))
+	}
+
+	if length <= 0 {
+		return nil, 0, fmt.Errorf("length must be positive, got %d", length)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if err := g.reseedUnlocked(entropy, uint64(len(entropy))); err != nil {
+		return nil, 0, fmt.Errorf("failed to reseed with fresh entropy: %w", err)
+	}
+	reseedEvent := g.reseedEvents
+
+	data, err := g.generateUnlocked(length, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, reseedEvent, nil
+}
+
+// AmplifyRandomData generates outputLength bytes with the client seed as
+// additional input, so the output depends on both the seed and the DRBG state
+func (g *Generator) AmplifyRandomData(seed []byte, outputLength int) ([]byte, error) {
+	if len(seed) == 0 {
+		return nil, fmt.Errorf("seed cannot be empty")
+	}
+
+	if outputLength <= 0 {
+		return nil, fmt.Errorf("output length must be positive, got %d", outputLength)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.recordSourceUnlocked(fortuna.SourceClient, len(seed))
+
+	return g.generateUnlocked(outputLength, seed)
+}
+
+// generateUnlocked generates random data in requests of at most the mechanism's
+// maximum size. The additional input is only passed with the first request.
+func (g *Generator) generateUnlocked(length int, additional []byte) ([]byte, error) {
+	// Reseed first if enough entropy has been collected
+	if g.reseedDueUnlocked() {
+		if err := g.reseedFromPendingUnlocked(); err != nil {
+			return nil, fmt.Errorf("failed to reseed from collected entropy: %w", err)
+		}
+	}
+
+	if g.mechanism == nil || !g.seeded {
+		return nil, fortuna.ErrNotSeeded
+	}
+
+	result := make([]byte, length)
+	chunkSize := g.maxRequestSize()
+	for offset := 0; offset < length; offset += chunkSize {
+		end := offset + chunkSize
+		if end > length {
+			end = length
+		}
+
+		if err := g.mechanism.Generate(result[offset:end], additional); err != nil {
+			return nil, fmt.Errorf("failed to generate random data: %w", err)
+		}
+		additional = nil
+	}
+
+	return result, nil
+}
+
+// recordSourceUnlocked adds a contribution to the statistics of a source
+func (g *Generator) recordSourceUnlocked(source fortuna.SourceID, n int) {
+	state, ok := g.sources[source]
+	if !ok {
+		state = &sourceState{}
+		g.sources[source] = state
+	}
+	state.events++
+	state.bytes += uint64(n) // #nosec G115 - n is a slice length
+}
+
+// HealthCheck returns whether the generator is healthy
+func (g *Generator) HealthCheck() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Haven't been reseeded in a day, might be a problem
+	if g.mechanism != nil && time.Since(g.lastReseed) > 24*time.Hour {
+		return false
+	}
+
+	return g.isHealthy
+}
+
+// GetLastReseedTime returns the time of the last reseed operation
+func (g *Generator) GetLastReseedTime() time.Time {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.lastReseed
+}
+
+// GetSourceStats returns the number of contributions made by each entropy source
+func (g *Generator) GetSourceStats() []fortuna.SourceStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stats := make([]fortuna.SourceStats, 0, len(g.sources))
+	for id, state := range g.sources {
+		stats = append(stats, fortuna.SourceStats{
+			Source: id.String(),
+			ID:     byte(id),
+			Events: state.events,
+			Bytes:  state.bytes,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+
+	return stats
+}
+
+// IsSeeded reports whether the generator has been reseeded with real entropy
+func (g *Generator) IsSeeded() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.seeded
+}
+
+// SetSeedThreshold sets the number of credited entropy bytes a reseed needs to mark the generator seeded
+func (g *Generator) SetSeedThreshold(bytes uint64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.seedThreshold = bytes
+}
+
+// GetReseedEvents returns the total number of reseeds, including direct and prediction-resistant ones
+func (g *Generator) GetReseedEvents() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.reseedEvents
+}
+
+// GetReseedCount returns the number of reseeds from collected entropy
+func (g *Generator) GetReseedCount() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.reseedCount
+}
+
+// GetReseedCounter returns the SP 800-90A reseed counter of the mechanism,
+// or zero if it has not been instantiated yet
+func (g *Generator) GetReseedCounter() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.mechanism == nil {
+		return 0
+	}
+	return g.mechanism.ReseedCounter()
+}
+
+// concat returns the concatenation of parts in a new slice
+func concat(parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+
+	out := make([]byte, 0, n)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
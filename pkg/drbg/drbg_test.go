@@ -0,0 +1,227 @@
+package drbg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+// cavpVector is one COUNT record of a NIST CAVP DRBG response file, with the
+// section header it appears under
+type cavpVector struct {
+	section          string
+	params           string
+	count            string
+	entropy          []byte
+	nonce            []byte
+	personalization  []byte
+	reseedEntropy    []byte
+	reseedAdditional []byte
+	additional       [][]byte
+	returnedBits     []byte
+}
+
+// readCAVP parses the vectors of a CAVP DRBG .rsp file (testdata holds the
+// prediction resistance = False sections for the mechanisms this package
+// implements, from CAVS 14.3 drbgvectors_pr_false)
+func readCAVP(t *testing.T, name string) []cavpVector {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var vectors []cavpVector
+	var section string
+	var params []string
+	var v *cavpVector
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			line = strings.Trim(line, "[]")
+			if !strings.Contains(line, "=") {
+				section = line
+				params = nil
+			} else {
+				params = append(params, line)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("%s: malformed line %q", name, line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if key == "COUNT" {
+			vectors = append(vectors, cavpVector{section: section, params: strings.Join(params, ", "), count: value})
+			v = &vectors[len(vectors)-1]
+			continue
+		}
+		if v == nil {
+			t.Fatalf("%s: %s before the first COUNT", name, key)
+		}
+
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			t.Fatalf("%s: %s COUNT %s: %s: %v", name, section, v.count, key, err)
+		}
+
+		switch key {
+		case "EntropyInput":
+			v.entropy = b
+		case "Nonce":
+			v.nonce = b
+		case "PersonalizationString":
+			v.personalization = b
+		case "EntropyInputReseed":
+			v.reseedEntropy = b
+		case "AdditionalInputReseed":
+			v.reseedAdditional = b
+		case "AdditionalInput":
+			v.additional = append(v.additional, b)
+		case "ReturnedBits":
+			v.returnedBits = b
+		default:
+			t.Fatalf("%s: unknown field %q", name, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vectors) == 0 {
+		t.Fatalf("%s: no vectors", name)
+	}
+	return vectors
+}
+
+// runCAVP reseeds, generates twice and compares the second output with the
+// returned bits, as the CAVP DRBG test procedure does
+func runCAVP(t *testing.T, d mechanism, v cavpVector) {
+	t.Helper()
+
+	if len(v.additional) != 2 {
+		t.Fatalf("got %d additional inputs, want 2", len(v.additional))
+	}
+
+	if err := d.Reseed(v.reseedEntropy, v.reseedAdditional); err != nil {
+		t.Fatalf("reseed failed: %v", err)
+	}
+
+	got := make([]byte, len(v.returnedBits))
+	if err := d.Generate(got, v.additional[0]); err != nil {
+		t.Fatalf("first generate failed: %v", err)
+	}
+	if err := d.Generate(got, v.additional[1]); err != nil {
+		t.Fatalf("second generate failed: %v", err)
+	}
+
+	if !bytes.Equal(got, v.returnedBits) {
+		t.Fatalf("got %x, want %x", got, v.returnedBits)
+	}
+
+	if d.ReseedCounter() != 3 {
+		t.Fatalf("reseed counter is %d after two requests, want 3", d.ReseedCounter())
+	}
+}
+
+func TestCTRCAVP(t *testing.T) {
+	var withDF, withoutDF int
+	for _, v := range readCAVP(t, "testdata/CTR_DRBG.rsp") {
+		t.Run(v.section+" "+v.params+" COUNT "+v.count, func(t *testing.T) {
+			var d *CTR
+			var err error
+			switch v.section {
+			case "AES-256 use df":
+				withDF++
+				d, err = NewCTRWithDF(v.entropy, v.nonce, v.personalization)
+			case "AES-256 no df":
+				withoutDF++
+				d, err = NewCTR(v.entropy, v.personalization)
+			default:
+				t.Fatalf("unexpected section %q", v.section)
+			}
+			if err != nil {
+				t.Fatalf("instantiate failed: %v", err)
+			}
+			runCAVP(t, d, v)
+		})
+	}
+
+	if withDF == 0 || withoutDF == 0 {
+		t.Fatalf("ran %d vectors with and %d without the derivation function", withDF, withoutDF)
+	}
+}
+
+func TestHMACCAVP(t *testing.T) {
+	for _, v := range readCAVP(t, "testdata/HMAC_DRBG.rsp") {
+		t.Run(v.section+" "+v.params+" COUNT "+v.count, func(t *testing.T) {
+			if v.section != "SHA-256" {
+				t.Fatalf("unexpected section %q", v.section)
+			}
+			d, err := NewHMAC(v.entropy, v.nonce, v.personalization)
+			if err != nil {
+				t.Fatalf("instantiate failed: %v", err)
+			}
+			runCAVP(t, d, v)
+		})
+	}
+}
+
+func TestCTRWithoutDFInputLengths(t *testing.T) {
+	entropy := make([]byte, CTRSeedLength)
+
+	if _, err := NewCTR(entropy[:CTRSeedLength-1], nil); err == nil {
+		t.Error("instantiated with a short entropy input")
+	}
+	if _, err := NewCTR(append(entropy, 0), nil); err == nil {
+		t.Error("instantiated with a long entropy input")
+	}
+
+	d, err := NewCTR(entropy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Generate(make([]byte, 16), make([]byte, CTRSeedLength+1)); err == nil {
+		t.Error("generated with additional input longer than the seed length")
+	}
+}
+
+func TestCTRWithDFInputLengths(t *testing.T) {
+	if _, err := NewCTRWithDF(make([]byte, SecurityStrength-1), make([]byte, SecurityStrength/2), nil); err == nil {
+		t.Error("instantiated with a short entropy input")
+	}
+	if _, err := NewCTRWithDF(make([]byte, SecurityStrength), make([]byte, SecurityStrength/2-1), nil); err == nil {
+		t.Error("instantiated with a short nonce")
+	}
+
+	// The derivation function takes inputs of any length
+	d, err := NewCTRWithDF(make([]byte, 100), make([]byte, SecurityStrength/2), make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reseed(make([]byte, 100), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Generate(make([]byte, 16), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package drbg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	// HMACMaxRequestSize is the maximum number of bytes per HMAC_DRBG request (2^19 bits)
+	HMACMaxRequestSize = (1 << 19) / 8
+)
+
+// HMAC is an HMAC_DRBG instantiated with SHA-256 as specified in
+// SP 800-90A Rev. 1, section 10.1.2. It is not safe for concurrent use.
+type HMAC struct {
+	key           [sha256.Size]byte
+	v             [sha256.Size]byte
+	reseedCounter uint64
+}
+
+// NewHMAC instantiates an HMAC_DRBG (section 10.1.2.3). The entropy input must
+// hold at least SecurityStrength bytes and the nonce at least half of that.
+func NewHMAC(entropy, nonce, personalization []byte) (*HMAC, error) {
+	if len(entropy) < SecurityStrength {
+		return nil, fmt.Errorf("entropy input must be at least %d bytes long, got %d", SecurityStrength, len(entropy))
+	}
+
+	if len(nonce) < SecurityStrength/2 {
+		return nil, fmt.Errorf("nonce must be at least %d bytes long, got %d", SecurityStrength/2, len(nonce))
+	}
+
+	// Key starts as all zeros, V as all ones
+	d := &HMAC{}
+	for i := range d.v {
+		d.v[i] = 0x01
+	}
+
+	d.update(concat(entropy, nonce, personalization))
+	d.reseedCounter = 1
+
+	return d, nil
+}
+
+// Reseed mixes fresh entropy and optional additional input into the state (section 10.1.2.4)
+func (d *HMAC) Reseed(entropy, additional []byte) error {
+	if len(entropy) < SecurityStrength {
+		return fmt.Errorf("entropy input must be at least %d bytes long, got %d", SecurityStrength, len(entropy))
+	}
+
+	d.update(concat(entropy, additional))
+	d.reseedCounter = 1
+
+	return nil
+}
+
+// Generate fills dst with at most HMACMaxRequestSize bytes (section 10.1.2.5).
+// It returns ErrReseedRequired once ReseedInterval requests have been served.
+func (d *HMAC) Generate(dst, additional []byte) error {
+	if len(dst) > HMACMaxRequestSize {
+		return fmt.Errorf("request of %d bytes exceeds maximum of %d", len(dst), HMACMaxRequestSize)
+	}
+
+	if d.reseedCounter > ReseedInterval {
+		return ErrReseedRequired
+	}
+
+	if len(additional) > 0 {
+		d.update(additional)
+	}
+
+	for len(dst) > 0 {
+		d.v = d.mac(d.v[:])
+		dst = dst[copy(dst, d.v[:]):]
+	}
+
+	d.update(additional)
+	d.reseedCounter++
+
+	return nil
+}
+
+// ReseedCounter returns the number of requests served since the last reseed, plus one
+func (d *HMAC) ReseedCounter() uint64 {
+	return d.reseedCounter
+}
+
+// update is HMAC_DRBG_Update (section 10.1.2.2)
+func (d *HMAC) update(provided []byte) {
+	d.key = d.mac(d.v[:], []byte{0x00}, provided)
+	d.v = d.mac(d.v[:])
+
+	if len(provided) == 0 {
+		return
+	}
+
+	d.key = d.mac(d.v[:], []byte{0x01}, provided)
+	d.v = d.mac(d.v[:])
+}
+
+// mac returns HMAC-SHA-256 of the concatenated parts under the current key
+func (d *HMAC) mac(parts ...[]byte) [sha256.Size]byte {
+	h := hmac.New(sha256.New, d.key[:])
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,106 @@
+package drbg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// CTR_DRBG known-answer vector for AES-256 with the derivation function, with
+// personalization, reseed and additional input, from the NIST CAVP DRBG test
+// vectors (CAVS 14.3 drbgvectors_pr_false, CTR_DRBG.rsp, [AES-256 use df],
+// PersonalizationStringLen = 256, AdditionalInputLen = 256, COUNT = 0).
+const (
+	ctrKATEntropy          = "174b46250051a9e3d80c56ae7163dafe7e54481a56cafd3b8625f99bbb29c442"
+	ctrKATNonce            = "98ffd99c466e0e94a45da7e0e82dbc6b"
+	ctrKATPersonalization  = "7095268e99938b3e042734b9176c9aa051f00a5f8d2a89ada214b89beef18ebf"
+	ctrKATReseedEntropy    = "e88be1967c5503f65d23867bbc891bd679db03b4878663f6c877592df25f0d9a"
+	ctrKATReseedAdditional = "cdf6ad549e45b6aa5cd67d024931c33cd133d52d5ae500c3015020beb30da063"
+	ctrKATAdditional1      = "c7228e90c62f896a09e11684530102f926ec90a3255f6c21b857883c75800143"
+	ctrKATAdditional2      = "76a94f224178fe4cbf9e2b8acc53c9dc3e50bb613aac8936601453cda3293b17"
+	ctrKATReturnedBits     = "1a6d8dbd642076d13916e5e23038b60b26061f13dd4e006277e0268698ffb2c87e453bae1251631ac90c701a9849d933995e8b0221fe9aca1985c546c2079027"
+)
+
+// HMAC_DRBG known-answer vector from RFC 6979, appendix A.2.5 (ECDSA P-256,
+// SHA-256, message "sample"). The nonce k is the first 32-byte output of an
+// HMAC_DRBG instantiated with the private key as entropy input and the hashed
+// message as nonce, which exercises instantiation, HMAC_DRBG_Update and generation.
+const (
+	hmacKATEntropy = "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"
+	hmacKATNonce   = "AF2BDBE1AA9B6EC1E2ADE1D694F41FC71A831D0268E9891562113D8A62ADD1BF"
+	hmacKATOutput  = "A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60"
+)
+
+// SelfTest runs the HMAC_DRBG and CTR_DRBG known-answer tests and returns an
+// error if either mechanism deviates from the published vectors
+func SelfTest() error {
+	if err := selfTestCTR(); err != nil {
+		return fmt.Errorf("self-test %s: %w", CTRAES256, err)
+	}
+
+	if err := selfTestHMAC(); err != nil {
+		return fmt.Errorf("self-test %s: %w", HMACSHA256, err)
+	}
+
+	return nil
+}
+
+// selfTestCTR instantiates, reseeds and generates twice, checking the second output
+func selfTestCTR() error {
+	d, err := NewCTRWithDF(mustDecode(ctrKATEntropy), mustDecode(ctrKATNonce), mustDecode(ctrKATPersonalization))
+	if err != nil {
+		return fmt.Errorf("instantiate failed: %w", err)
+	}
+
+	if err := d.Reseed(mustDecode(ctrKATReseedEntropy), mustDecode(ctrKATReseedAdditional)); err != nil {
+		return fmt.Errorf("reseed failed: %w", err)
+	}
+
+	want := mustDecode(ctrKATReturnedBits)
+	got := make([]byte, len(want))
+	if err := d.Generate(got, mustDecode(ctrKATAdditional1)); err != nil {
+		return fmt.Errorf("first generate failed: %w", err)
+	}
+	if err := d.Generate(got, mustDecode(ctrKATAdditional2)); err != nil {
+		return fmt.Errorf("second generate failed: %w", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("got %x, want %x", got, want)
+	}
+
+	if d.ReseedCounter() != 3 {
+		return fmt.Errorf("reseed counter is %d after two requests, want 3", d.ReseedCounter())
+	}
+
+	return nil
+}
+
+// selfTestHMAC instantiates without personalization and checks the first output
+func selfTestHMAC() error {
+	d, err := NewHMAC(mustDecode(hmacKATEntropy), mustDecode(hmacKATNonce), nil)
+	if err != nil {
+		return fmt.Errorf("instantiate failed: %w", err)
+	}
+
+	want := mustDecode(hmacKATOutput)
+	got := make([]byte, len(want))
+	if err := d.Generate(got, nil); err != nil {
+		return fmt.Errorf("generate failed: %w", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("got %x, want %x", got, want)
+	}
+
+	return nil
+}
+
+// mustDecode decodes a hex-encoded vector, panicking on malformed constants
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(fmt.Sprintf("drbg: invalid test vector %q: %v", s, err))
+	}
+	return b
+}
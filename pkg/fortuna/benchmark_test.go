@@ -0,0 +1,42 @@
+package fortuna
+
+import (
+	"strconv"
+	"testing"
+)
+
+// benchmarkSizes are a single API queue item, a typical client request and a
+// full 2^20-byte chunk
+var benchmarkSizes = []int{32, 4096, MaxRequestSize}
+
+func BenchmarkAESCTR(b *testing.B) {
+	benchmarkCore(b, CoreAESCTR)
+}
+
+func BenchmarkChaCha20(b *testing.B) {
+	benchmarkCore(b, CoreChaCha20)
+}
+
+// benchmarkCore measures GenerateRandomData throughput of a core for each request size
+func benchmarkCore(b *testing.B, c Core) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			g, err := NewGeneratorWithCore(katSeed, c)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// Benchmarks measure the generator construction, not the seeding policy
+			g.seeded = true
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if _, err := g.GenerateRandomData(size); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
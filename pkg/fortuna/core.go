@@ -0,0 +1,148 @@
+package fortuna
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// Core names the keystream construction the generator produces output with
+type Core string
+
+// Available generator cores
+const (
+	// CoreAESCTR encrypts the 128-bit counter with AES-256, as in the Fortuna design
+	CoreAESCTR Core = "aes-ctr"
+	// CoreChaCha20 uses the ChaCha20 keystream with the counter as nonce, which is
+	// faster than AES on CPUs without AES instructions such as the Raspberry Pi Zero 2W
+	CoreChaCha20 Core = "chacha20"
+)
+
+// Cores lists the available generator cores
+var Cores = []Core{CoreAESCTR, CoreChaCha20}
+
+// ParseCore returns the core with the given name
+func ParseCore(name string) (Core, error) {
+	for _, c := range Cores {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown generator core %q", name)
+}
+
+// core produces keystream under the current generator key. Both cores follow
+// the same construction: the output of a request is followed by keySize bytes
+// that become the next key.
+type core interface {
+	// setKey switches to a new key
+	setKey(key []byte) error
+	// generate fills dst and then newKey with keystream, advancing the counter
+	generate(counter *[aes.BlockSize]byte, dst, newKey []byte) error
+}
+
+// newCore creates an unkeyed core
+func newCore(c Core) (core, error) {
+	switch c {
+	case CoreAESCTR:
+		return &aesCore{}, nil
+	case CoreChaCha20:
+		return &chachaCore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator core %q", c)
+	}
+}
+
+// aesCore encrypts successive values of the 128-bit little-endian counter
+type aesCore struct {
+	cipher cipher.Block
+	block  [aes.BlockSize]byte // scratch space for partial output blocks
+}
+
+func (a *aesCore) setKey(key []byte) error {
+	// Create new cipher with updated key
+	aesCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	a.cipher = aesCipher
+	return nil
+}
+
+func (a *aesCore) generate(counter *[aes.BlockSize]byte, dst, newKey []byte) error {
+	a.generateBlocks(counter, dst)
+	a.generateBlocks(counter, newKey)
+
+	// Clear the scratch block so no output remains in the generator state
+	clear(a.block[:])
+
+	return nil
+}
+
+// generateBlocks fills dst with encrypted counter blocks, incrementing the counter per block
+func (a *aesCore) generateBlocks(counter *[aes.BlockSize]byte, dst []byte) {
+	for len(dst) > 0 {
+		if len(dst) >= aes.BlockSize {
+			a.cipher.Encrypt(dst[:aes.BlockSize], counter[:])
+			dst = dst[aes.BlockSize:]
+		} else {
+			// Encrypt into scratch space and keep only the bytes requested
+			a.cipher.Encrypt(a.block[:], counter[:])
+			dst = dst[copy(dst, a.block[:]):]
+		}
+		incrementCounter(counter)
+	}
+}
+
+// chachaCore runs one ChaCha20 keystream per request, with the low 96 bits of
+// the counter as nonce. The key changes after every request, so each key
+// drives exactly one keystream and the 32-bit block counter inside ChaCha20
+// starts at zero each time. The keystream state lives in the core, so a
+// request does not allocate.
+type chachaCore struct {
+	key    [keySize]byte
+	stream chacha20.Cipher
+}
+
+func (c *chachaCore) setKey(key []byte) error {
+	if len(key) != keySize {
+		return fmt.Errorf("ChaCha20 key must be %d bytes long, got %d", keySize, len(key))
+	}
+
+	copy(c.key[:], key)
+	return nil
+}
+
+func (c *chachaCore) generate(counter *[aes.BlockSize]byte, dst, newKey []byte) error {
+	stream, err := chacha20.NewUnauthenticatedCipher(c.key[:], counter[:chacha20.NonceSize])
+	if err != nil {
+		return fmt.Errorf("failed to create ChaCha20 cipher: %w", err)
+	}
+	c.stream = *stream
+
+	// XORKeyStream over zeros yields the keystream itself
+	clear(dst)
+	c.stream.XORKeyStream(dst, dst)
+	clear(newKey)
+	c.stream.XORKeyStream(newKey, newKey)
+
+	// Clear the keystream state so the old key does not remain in the generator
+	c.stream = chacha20.Cipher{}
+
+	incrementCounter(counter)
+
+	return nil
+}
+
+// incrementCounter adds one to the 128-bit little-endian counter
+func incrementCounter(counter *[aes.BlockSize]byte) {
+	for i := range counter {
+		counter[i]++
+		if counter[i] != 0 {
+			return
+		}
+	}
+}
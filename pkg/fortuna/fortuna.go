@@ -2,9 +2,9 @@ package fortuna
 
 import (
 	"crypto/aes"
-	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -13,81 +13,161 @@ import (
 const (
 	// MinimumSeedLength is the minimum required seed length in bytes
 	MinimumSeedLength = 32
-	// MaxPoolSize is the maximum size of an entropy pool
-	MaxPoolSize = 1024
+	// MaxEventSize is the maximum size of a single random event in bytes
+	MaxEventSize = 32
 	// NumberOfPools is the number of entropy pools
 	NumberOfPools = 32
+	// MinPoolSize is the number of event bytes pool 0 must hold before a reseed
+	// from the pools, as suggested by the Fortuna design. The framing of each
+	// event does not count.
+	MinPoolSize = 64
+	// MinReseedInterval is the minimum time between two reseeds from the pools
+	MinReseedInterval = 100 * time.Millisecond
+	// DefaultSeedThreshold is the number of credited entropy bytes a reseed needs to mark the generator as seeded
+	DefaultSeedThreshold = MinimumSeedLength
+	// MaxRequestSize is the maximum number of bytes generated under a single key
+	MaxRequestSize = 1 << 20
+	// keySize is the size of the AES-256 or ChaCha20 generator key in bytes
+	keySize = 32
+	// readBufferSize is the amount of output buffered for Read and the Source adapter
+	readBufferSize = 4096
 )
 
+// ErrReseedNotDue is returned by ReseedFromPools when pool 0 holds less than
+// MinPoolSize bytes or the last reseed from the pools happened less than
+// MinReseedInterval ago
+var ErrReseedNotDue = errors.New("reseed not due")
+
+// ErrNotSeeded is returned when output is requested before the generator has
+// been reseeded with at least the seed threshold of real entropy
+var ErrNotSeeded = errors.New("fortuna generator not yet seeded")
+
 // Generator implements the Fortuna algorithm for random number generation
 type Generator struct {
-	key        []byte
-	counter    uint64
-	blockSize  int
-	cipher     cipher.Block
-	mutex      sync.Mutex
-	lastReseed time.Time
-	pools      [NumberOfPools][]byte
-	isHealthy  bool
+	key            [keySize]byte
+	nextKey        [keySize]byte       // scratch space for the key generated after each request
+	counter        [aes.BlockSize]byte // 128-bit little-endian counter
+	coreName       Core
+	core           core
+	keyed          bool // set once the core has been given a key
+	mutex          sync.Mutex
+	lastReseed     time.Time
+	lastPoolReseed time.Time // last reseed from the pools, which MinReseedInterval applies to
+	reseedCount    uint64    // number of reseeds from the pools, drives pool selection
+	reseedEvents   uint64    // number of reseeds of any kind, identifies each reseed event
+	pools          [NumberOfPools]*pool
+	sources        map[SourceID]*sourceState
+	seeded         bool   // set once a reseed carried at least seedThreshold bytes of real entropy
+	seedThreshold  uint64 // credited entropy bytes required to become seeded
+	isHealthy      bool
+	readBuffer     [readBufferSize]byte // output generated ahead for small reads
+	readAvailable  int                  // unread bytes at the end of readBuffer
 }
 
-// NewGenerator creates a new Fortuna generator
+// NewGenerator creates a new Fortuna generator with the AES-CTR core. The seed
+// only sets the initial key; the generator refuses output until it is reseeded
+// with real entropy.
 func NewGenerator(seed []byte) (*Generator, error) {
+	return NewGeneratorWithCore(seed, CoreAESCTR)
+}
+
+// NewGeneratorWithCore creates a new Fortuna generator that produces output with the given core
+func NewGeneratorWithCore(seed []byte, c Core) (*Generator, error) {
 	if len(seed) < MinimumSeedLength {
 		return nil, fmt.Errorf("seed must be at least %d bytes long, got %d", MinimumSeedLength, len(seed))
 	}
 
-	// Initialize with zero key
-	initKey := make([]byte, MinimumSeedLength)
-	copy(initKey, seed)
-
-	// Create AES cipher
-	aesCipher, err := aes.NewCipher(initKey)
+	keystream, err := newCore(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, err
 	}
 
+	// Start from the all-zero key and counter, then reseed with the seed
 	g := &Generator{
-		key:        initKey,
-		counter:    0,
-		blockSize:  aesCipher.BlockSize(),
-		cipher:     aesCipher,
-		mutex:      sync.Mutex{},
-		lastReseed: time.Now(),
-		isHealthy:  true,
+		coreName:      c,
+		core:          keystream,
+		mutex:         sync.Mutex{},
+		sources:       make(map[SourceID]*sourceState),
+		seedThreshold: DefaultSeedThreshold,
+		isHealthy:     true,
+	}
+
+	if err := g.reseedUnlocked(seed, 0); err != nil {
+		return nil, err
 	}
 
 	// Initialize pools
 	for i := 0; i < NumberOfPools; i++ {
-		g.pools[i] = make([]byte, 0, 64)
+		g.pools[i] = newPool()
 	}
 
 	return g, nil
 }
 
-// AddRandomEvent adds random data to the entropy pools
-func (g *Generator) AddRandomEvent(source byte, value []byte) error {
+// Name returns the name of the construction
+func (g *Generator) Name() string {
+	return "fortuna"
+}
+
+// Core returns the keystream core the generator produces output with
+func (g *Generator) Core() Core {
+	return g.coreName
+}
+
+// AddRandomEvent adds a random event of at most MaxEventSize bytes to the entropy pools.
+// Events from each source are spread over all pools in round-robin order.
+func (g *Generator) AddRandomEvent(source SourceID, value []byte) error {
 	if len(value) == 0 {
 		return fmt.Errorf("cannot add empty random event")
 	}
 
+	if len(value) > MaxEventSize {
+		return fmt.Errorf("random event of %d bytes exceeds maximum of %d", len(value), MaxEventSize)
+	}
+
+	if !source.Registered() {
+		return fmt.Errorf("unknown entropy source %d", byte(source))
+	}
+
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	poolIndex := source % NumberOfPools
+	state, ok := g.sources[source]
+	if !ok {
+		state = &sourceState{}
+		g.sources[source] = state
+	}
+
+	// Absorb into the source's next pool
+	g.pools[state.nextPool].add(byte(source), value, source.Credited())
+	state.nextPool = (state.nextPool + 1) % NumberOfPools
+	state.events++
+	state.bytes += uint64(len(value))
 
-	// Add to the selected pool
-	g.pools[poolIndex] = append(g.pools[poolIndex], value...)
+	return nil
+}
+
+// AddRandomData splits data into events of at most MaxEventSize bytes and adds them to the pools
+func (g *Generator) AddRandomData(source SourceID, data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("cannot add empty random data")
+	}
 
-	// Trim pool if it grows too large
-	if len(g.pools[poolIndex]) > MaxPoolSize {
-		g.pools[poolIndex] = g.pools[poolIndex][len(g.pools[poolIndex])-MaxPoolSize:]
+	for i := 0; i < len(data); i += MaxEventSize {
+		end := i + MaxEventSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := g.AddRandomEvent(source, data[i:end]); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Reseed reseeds the generator using available entropy
+// Reseed reseeds the generator directly. The seeds are treated as real entropy,
+// so a reseed with at least the seed threshold of bytes marks the generator seeded.
 func (g *Generator) Reseed(seeds [][]byte) error {
 	if len(seeds) == 0 {
 		return fmt.Errorf("cannot reseed with empty seed list")
@@ -96,33 +176,74 @@ func (g *Generator) Reseed(seeds [][]byte) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	// Create a hash of all seeds
+	var seed []byte
+	for _, s := range seeds {
+		seed = append(seed, s...)
+	}
+
+	return g.reseedUnlocked(seed, uint64(len(seed)))
+}
+
+// reseedUnlocked sets the key to SHAd-256(key || seed) and increments the counter.
+// entropy is the number of bytes of real entropy the seed is credited with.
+func (g *Generator) reseedUnlocked(seed []byte, entropy uint64) error {
+	// Create a hash of the current key and the seed
 	h := sha256.New()
-	h.Write(g.key) // Include current key
+	h.Write(g.key[:]) // Include current key
+	h.Write(seed)
+	first := h.Sum(nil)
 
-	for _, seed := range seeds {
-		if len(seed) > 0 {
-			h.Write(seed)
-		}
+	// Hash again (SHAd-256) to avoid length-extension properties
+	newKey := sha256.Sum256(first)
+
+	if err := g.rekey(newKey[:]); err != nil {
+		return err
 	}
 
-	// Update the key
-	newKey := h.Sum(nil)
+	incrementCounter(&g.counter)
+	g.reseedEvents++
 
-	// Create new cipher with updated key
-	aesCipher, err := aes.NewCipher(newKey)
-	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
+	// Output buffered under the previous key must not be served after a reseed
+	g.discardBufferedUnlocked()
+	g.lastReseed = time.Now()
+
+	if entropy > 0 && entropy >= g.seedThreshold {
+		g.seeded = true
 	}
 
-	g.key = newKey
-	g.cipher = aesCipher
-	g.counter++ // Increment counter on reseed
-	g.lastReseed = time.Now()
+	return nil
+}
+
+// rekey replaces the generator key
+func (g *Generator) rekey(newKey []byte) error {
+	if err := g.core.setKey(newKey); err != nil {
+		return err
+	}
+
+	copy(g.key[:], newKey)
+	g.keyed = true
 
 	return nil
 }
 
+// pseudoRandomData fills dst (at most MaxRequestSize bytes) and then switches
+// to a fresh key so that the output cannot be recomputed from later state
+func (g *Generator) pseudoRandomData(dst []byte) error {
+	if len(dst) > MaxRequestSize {
+		return fmt.Errorf("request of %d bytes exceeds maximum of %d", len(dst), MaxRequestSize)
+	}
+
+	// Generate the output followed by keySize extra bytes to use as the new key
+	if err := g.core.generate(&g.counter, dst, g.nextKey[:]); err != nil {
+		return err
+	}
+
+	err := g.rekey(g.nextKey[:])
+	clear(g.nextKey[:])
+
+	return err
+}
+
 // GenerateRandomData generates random data of the specified length
 func (g *Generator) GenerateRandomData(length int) ([]byte, error) {
 	if length <= 0 {
@@ -132,68 +253,124 @@ func (g *Generator) GenerateRandomData(length int) ([]byte, error) {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	// Check if we have a valid cipher
-	if g.cipher == nil {
-		return nil, fmt.Errorf("generator not properly initialized")
+	return g.generateUnlocked(length)
+}
+
+// GeneratePredictionResistant reseeds the generator directly with fresh entropy
+// and generates length bytes under the new key while holding the lock, so the
+// output cannot be predicted from any state observed before the call. It
+// returns the number of the reseed event that preceded the output.
+func (g *Generator) GeneratePredictionResistant(entropy []byte, length int) ([]byte, uint64, error) {
+	if len(entropy) < MinimumSeedLength {
+		return nil, 0, fmt.Errorf("prediction resistance needs at least %d bytes of fresh entropy, got %d", MinimumSeedLength, len(entropy))
+	}
+
+	if length <= 0 {
+		return nil, 0, fmt.Errorf("length must be positive, got %d", length)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if err := g.reseedUnlocked(entropy, uint64(len(entropy))); err != nil {
+		return nil, 0, fmt.Errorf("failed to reseed with fresh entropy: %w", err)
 	}
+	reseedEvent := g.reseedEvents
 
-	// Generate random data
+	data, err := g.generateUnlocked(length)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, reseedEvent, nil
+}
+
+// generateUnlocked generates random data without acquiring the mutex
+func (g *Generator) generateUnlocked(length int) ([]byte, error) {
 	result := make([]byte, length)
-	blocks := (length + g.blockSize - 1) / g.blockSize
-	temp := make([]byte, g.blockSize)
+	if err := g.fillUnlocked(result); err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < blocks; i++ {
-		// Convert counter to bytes
-		ctrBytes := make([]byte, 16) // AES block size
-		binary.BigEndian.PutUint64(ctrBytes[8:], g.counter)
+	return result, nil
+}
 
-		// Encrypt counter to generate random block
-		g.cipher.Encrypt(temp, ctrBytes)
+// fillUnlocked fills dst with random data without acquiring the mutex
+func (g *Generator) fillUnlocked(dst []byte) error {
+	// Check if the core has been keyed
+	if !g.keyed {
+		return fmt.Errorf("generator not properly initialized")
+	}
 
-		// Copy to result
-		copySize := g.blockSize
-		if i == blocks-1 && length%g.blockSize != 0 {
-			copySize = length % g.blockSize
+	// Reseed from the pools first if enough entropy has been collected
+	if g.reseedDueUnlocked() {
+		if err := g.reseedFromPoolsUnlocked(); err != nil {
+			return fmt.Errorf("failed to reseed from pools: %w", err)
 		}
+	}
 
-		copy(result[i*g.blockSize:], temp[:copySize])
+	if !g.seeded {
+		return ErrNotSeeded
+	}
 
-		// Increment counter
-		g.counter++
+	// Generate random data in chunks, rekeying after each chunk
+	for offset := 0; offset < len(dst); offset += MaxRequestSize {
+		end := offset + MaxRequestSize
+		if end > len(dst) {
+			end = len(dst)
+		}
+
+		if err := g.pseudoRandomData(dst[offset:end]); err != nil {
+			return fmt.Errorf("failed to generate random data: %w", err)
+		}
 	}
 
-	return result, nil
+	return nil
 }
 
-// ReseedFromPools reseeds using available entropy pools
+// ReseedFromPools reseeds using available entropy pools. It returns
+// ErrReseedNotDue if the reseed thresholds have not been reached yet.
 func (g *Generator) ReseedFromPools() error {
 	g.mutex.Lock()
-	// Determine which pools to use based on the reseed count
-	reseedCount := g.counter
-	var poolsToUse [][]byte
+	defer g.mutex.Unlock()
+
+	if !g.reseedDueUnlocked() {
+		return ErrReseedNotDue
+	}
+
+	return g.reseedFromPoolsUnlocked()
+}
+
+// reseedDueUnlocked reports whether pool 0 holds enough entropy and enough
+// time has passed since the last reseed from the pools. Direct reseeds do not
+// delay it, so they cannot hold back the entropy collected in the pools.
+func (g *Generator) reseedDueUnlocked() bool {
+	return g.pools[0].bytes >= MinPoolSize && time.Since(g.lastPoolReseed) >= MinReseedInterval
+}
+
+// reseedFromPoolsUnlocked drains the pools scheduled for the next reseed:
+// pool i is used on every reseed whose number is divisible by 2^i
+func (g *Generator) reseedFromPoolsUnlocked() error {
+	g.reseedCount++
 
+	var seed []byte
+	var entropy uint64
 	for i := 0; i < NumberOfPools; i++ {
-		// Use a pool if its corresponding bit in reseedCount is 1
-		if (reseedCount & (1 << i)) != 0 {
-			if len(g.pools[i]) > 0 {
-				// Create a copy of the pool
-				poolCopy := make([]byte, len(g.pools[i]))
-				copy(poolCopy, g.pools[i])
-				poolsToUse = append(poolsToUse, poolCopy)
-
-				// Clear the used pool
-				g.pools[i] = g.pools[i][:0]
-			}
+		if g.reseedCount%(uint64(1)<<i) != 0 {
+			break
 		}
+
+		// Append the pool digest and clear the pool
+		entropy += g.pools[i].creditedBytes
+		seed = append(seed, g.pools[i].drain()...)
 	}
-	g.mutex.Unlock()
 
-	// Only reseed if we have entropy
-	if len(poolsToUse) > 0 {
-		return g.Reseed(poolsToUse)
+	if err := g.reseedUnlocked(seed, entropy); err != nil {
+		return err
 	}
+	g.lastPoolReseed = g.lastReseed
 
-	return fmt.Errorf("no entropy available in pools for reseeding")
+	return nil
 }
 
 // AmplifyRandomData takes a seed and generates a larger random output
@@ -206,20 +383,13 @@ func (g *Generator) AmplifyRandomData(seed []byte, outputLength int) ([]byte, er
 		return nil, fmt.Errorf("output length must be positive, got %d", outputLength)
 	}
 
-	// Add the seed to pools
-	seedLen := len(seed)
-	for i := 0; i < seedLen; i += 32 {
-		end := i + 32
-		if end > seedLen {
-			end = seedLen
-		}
-		if err := g.AddRandomEvent(byte(i%NumberOfPools), seed[i:end]); err != nil {
-			return nil, fmt.Errorf("failed to add random event: %w", err)
-		}
+	// Add the client-provided seed to pools
+	if err := g.AddRandomData(SourceClient, seed); err != nil {
+		return nil, fmt.Errorf("failed to add random event: %w", err)
 	}
 
-	// Reseed from pools
-	if err := g.ReseedFromPools(); err != nil {
+	// Reseed from pools if the thresholds have been reached
+	if err := g.ReseedFromPools(); err != nil && !errors.Is(err, ErrReseedNotDue) {
 		return nil, fmt.Errorf("failed to reseed from pools: %w", err)
 	}
 
@@ -249,9 +419,63 @@ func (g *Generator) GetLastReseedTime() time.Time {
 	return g.lastReseed
 }
 
-// GetCounter returns the current counter value
+// GetPoolStats returns the amount of entropy absorbed by each pool
+func (g *Generator) GetPoolStats() []PoolStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	stats := make([]PoolStats, NumberOfPools)
+	for i, p := range g.pools {
+		stats[i] = PoolStats{
+			Pool:       i,
+			Bytes:      p.bytes,
+			Credited:   p.creditedBytes,
+			Events:     p.events,
+			TotalBytes: p.totalBytes,
+		}
+	}
+
+	return stats
+}
+
+// GetSourceStats returns the number of events contributed by each entropy source
+func (g *Generator) GetSourceStats() []SourceStats {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.sourceStatsUnlocked()
+}
+
+// IsSeeded reports whether the generator has been reseeded with real entropy
+func (g *Generator) IsSeeded() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.seeded
+}
+
+// SetSeedThreshold sets the number of credited entropy bytes a reseed needs to mark the generator seeded
+func (g *Generator) SetSeedThreshold(bytes uint64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.seedThreshold = bytes
+}
+
+// GetReseedEvents returns the total number of reseeds, including direct and prediction-resistant ones
+func (g *Generator) GetReseedEvents() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.reseedEvents
+}
+
+// GetReseedCount returns the number of reseeds from the entropy pools
+func (g *Generator) GetReseedCount() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.reseedCount
+}
+
+// GetCounter returns the low 64 bits of the current block counter
 func (g *Generator) GetCounter() uint64 {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
-	return g.counter
+	return binary.LittleEndian.Uint64(g.counter[:8])
 }
@@ -0,0 +1,530 @@
+package fortuna
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// referenceGenerator is a direct transcription of the generator construction
+// in Ferguson & Schneier, "Practical Cryptography", section 9.4, used to check
+// the Generator and the vectors in selftest.go independently of each other
+type referenceGenerator struct {
+	core    Core
+	key     [keySize]byte
+	counter [aes.BlockSize]byte // little-endian
+}
+
+// reseed sets K = SHAd-256(K || s) and increments the counter
+func (r *referenceGenerator) reseed(seed []byte) {
+	first := sha256.Sum256(append(r.key[:], seed...))
+	r.key = sha256.Sum256(first[:])
+	incrementCounter(&r.counter)
+}
+
+// generateBlocks returns n bytes of keystream under the current key
+func (r *referenceGenerator) generateBlocks(t *testing.T, n int) []byte {
+	t.Helper()
+
+	out := make([]byte, n)
+	switch r.core {
+	case CoreAESCTR:
+		block, err := aes.NewCipher(r.key[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf [aes.BlockSize]byte
+		for i := 0; i < n; i += aes.BlockSize {
+			block.Encrypt(buf[:], r.counter[:])
+			copy(out[i:], buf[:])
+			incrementCounter(&r.counter)
+		}
+	case CoreChaCha20:
+		stream, err := chacha20.NewUnauthenticatedCipher(r.key[:], r.counter[:chacha20.NonceSize])
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.XORKeyStream(out, out)
+		incrementCounter(&r.counter)
+	default:
+		t.Fatalf("unknown core %q", r.core)
+	}
+	return out
+}
+
+// pseudoRandomData generates a request of at most 2^20 bytes followed by the
+// next key. ChaCha20 produces both from one keystream; AES-CTR uses whole
+// blocks for each.
+func (r *referenceGenerator) pseudoRandomData(t *testing.T, n int) []byte {
+	t.Helper()
+
+	var out []byte
+	if r.core == CoreChaCha20 {
+		stream := r.generateBlocks(t, n+keySize)
+		out = stream[:n]
+		copy(r.key[:], stream[n:])
+		return out
+	}
+
+	blocks := (n + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	out = r.generateBlocks(t, blocks)[:n]
+	copy(r.key[:], r.generateBlocks(t, keySize))
+	return out
+}
+
+// generate splits a request into 2^20-byte chunks with a new key after each
+func (r *referenceGenerator) generate(t *testing.T, n int) []byte {
+	t.Helper()
+
+	var out []byte
+	for n > 0 {
+		chunk := min(n, MaxRequestSize)
+		out = append(out, r.pseudoRandomData(t, chunk)...)
+		n -= chunk
+	}
+	return out
+}
+
+// newSeededGenerator returns a generator keyed with seed and marked seeded,
+// and a reference generator in the same state
+func newSeededGenerator(t *testing.T, c Core, seed []byte) (*Generator, *referenceGenerator) {
+	t.Helper()
+
+	g, err := NewGeneratorWithCore(seed, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.seeded = true
+
+	r := &referenceGenerator{core: c}
+	r.reseed(seed)
+
+	return g, r
+}
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKnownAnswers checks the vectors in selftest.go against the reference
+// construction, so a change to both the generator and its vectors is caught
+func TestKnownAnswers(t *testing.T) {
+	for _, c := range Cores {
+		t.Run(string(c), func(t *testing.T) {
+			r := &referenceGenerator{core: c}
+			r.reseed(katSeed)
+
+			for _, kat := range knownAnswers[c] {
+				if kat.reseed != nil {
+					r.reseed(kat.reseed)
+				}
+
+				got := r.generate(t, kat.length)
+				if kat.hashed {
+					sum := sha256.Sum256(got)
+					got = sum[:]
+				}
+				if hex.EncodeToString(got) != kat.want {
+					t.Errorf("%s: reference construction gives %x, vector is %s", kat.name, got, kat.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputSequence(t *testing.T) {
+	steps := []struct {
+		reseed []byte
+		length int
+	}{
+		{length: 1},
+		{length: 15},
+		{length: 16},
+		{length: 17},
+		{reseed: []byte("reseed"), length: 64},
+		{length: MaxRequestSize},
+		{length: MaxRequestSize + 1},
+		{length: 2*MaxRequestSize + 33},
+		{reseed: katSeed, length: 5},
+	}
+
+	for _, c := range Cores {
+		t.Run(string(c), func(t *testing.T) {
+			g, r := newSeededGenerator(t, c, katSeed)
+
+			for i, step := range steps {
+				if step.reseed != nil {
+					if err := g.Reseed([][]byte{step.reseed}); err != nil {
+						t.Fatal(err)
+					}
+					r.reseed(step.reseed)
+				}
+
+				got, err := g.GenerateRandomData(step.length)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if want := r.generate(t, step.length); !bytes.Equal(got, want) {
+					t.Fatalf("step %d: %d bytes differ from the reference construction", i, step.length)
+				}
+				if g.key != r.key || g.counter != r.counter {
+					t.Fatalf("step %d: key %x counter %x, want key %x counter %x", i, g.key, g.counter, r.key, r.counter)
+				}
+			}
+		})
+	}
+}
+
+func TestRekeyAfterEachRequest(t *testing.T) {
+	for _, c := range Cores {
+		t.Run(string(c), func(t *testing.T) {
+			one, _ := newSeededGenerator(t, c, katSeed)
+			two, _ := newSeededGenerator(t, c, katSeed)
+
+			whole, err := one.GenerateRandomData(64)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := two.GenerateRandomData(32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, whole[:32]) {
+				t.Fatal("first request differs from the start of the keystream")
+			}
+
+			// The 32 bytes after a request are the next key, never output
+			if !bytes.Equal(two.key[:], whole[32:]) {
+				t.Errorf("key after the request is %x, want the next keystream bytes %x", two.key, whole[32:])
+			}
+
+			second, err := two.GenerateRandomData(32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(second, whole[32:]) {
+				t.Error("second request revealed the key that followed the first")
+			}
+		})
+	}
+}
+
+func TestRequestChunking(t *testing.T) {
+	for _, c := range Cores {
+		t.Run(string(c), func(t *testing.T) {
+			one, _ := newSeededGenerator(t, c, katSeed)
+			two, _ := newSeededGenerator(t, c, katSeed)
+
+			// A request over 2^20 bytes is served as consecutive requests of at most 2^20
+			whole, err := one.GenerateRandomData(MaxRequestSize + 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chunk, err := two.GenerateRandomData(MaxRequestSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest, err := two.GenerateRandomData(16)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(whole, append(chunk, rest...)) {
+				t.Error("chunked request differs from two requests of 2^20 and 16 bytes")
+			}
+			if one.key != two.key || one.counter != two.counter {
+				t.Error("state after the chunked request differs")
+			}
+		})
+	}
+}
+
+func TestReseed(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The seed given to the constructor is not credited as entropy
+	if _, err := g.GenerateRandomData(32); !errors.Is(err, ErrNotSeeded) {
+		t.Fatalf("unseeded generator returned %v, want %v", err, ErrNotSeeded)
+	}
+
+	if err := g.Reseed([][]byte{katReseed[:MinimumSeedLength-1]}); err != nil {
+		t.Fatal(err)
+	}
+	if g.IsSeeded() {
+		t.Fatal("seeded by fewer bytes than the seed threshold")
+	}
+
+	before := g.key
+	counter := g.counter
+	if err := g.Reseed([][]byte{katReseed[:16], katReseed[16:]}); err != nil {
+		t.Fatal(err)
+	}
+	if !g.IsSeeded() {
+		t.Fatal("not seeded after a reseed with the seed threshold of entropy")
+	}
+
+	// K = SHAd-256(K || s) over the concatenated seeds, and the counter moves on
+	first := sha256.Sum256(append(before[:], katReseed...))
+	if want := sha256.Sum256(first[:]); g.key != want {
+		t.Errorf("key after reseed is %x, want %x", g.key, want)
+	}
+	incrementCounter(&counter)
+	if g.counter != counter {
+		t.Errorf("counter after reseed is %x, want %x", g.counter, counter)
+	}
+
+	if _, err := g.GenerateRandomData(32); err != nil {
+		t.Fatal(err)
+	}
+	if g.GetReseedEvents() != 3 {
+		t.Errorf("%d reseed events, want 3", g.GetReseedEvents())
+	}
+}
+
+// TestDirectReseedDoesNotDelayPoolReseed checks that MinReseedInterval is
+// measured from the last reseed from the pools only
+func TestDirectReseedDoesNotDelayPoolReseed(t *testing.T) {
+	g, _ := newSeededGenerator(t, CoreAESCTR, katSeed)
+
+	// Output would reseed from full pools itself, so fill them afterwards
+	if _, _, err := g.GeneratePredictionResistant(katReseed, 32); err != nil {
+		t.Fatal(err)
+	}
+	event := bytes.Repeat([]byte{0x42}, MaxEventSize)
+	for g.pools[0].bytes < MinPoolSize {
+		if err := g.AddRandomEvent(SourceATECC608A, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Reseed([][]byte{katReseed}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.ReseedFromPools(); err != nil {
+		t.Fatalf("reseed from the pools after direct reseeds: %v", err)
+	}
+	if g.reseedCount != 1 {
+		t.Errorf("%d reseeds from the pools, want 1", g.reseedCount)
+	}
+
+	// A second reseed from the pools within the interval is not due
+	for g.pools[0].bytes < MinPoolSize {
+		if err := g.AddRandomEvent(SourceATECC608A, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.ReseedFromPools(); !errors.Is(err, ErrReseedNotDue) {
+		t.Errorf("got %v, want %v", err, ErrReseedNotDue)
+	}
+}
+
+// fillPool adds credited events to pool i until it holds n bytes of event data
+func fillPool(g *Generator, i int, n uint64) {
+	for g.pools[i].bytes < n {
+		g.pools[i].add(byte(SourceATECC608A), []byte{byte(i), byte(g.pools[i].events)}, true)
+	}
+}
+
+// poolDigest returns SHAd-256 of what pool i has absorbed, without draining it
+func poolDigest(g *Generator, i int) []byte {
+	digest := sha256.Sum256(g.pools[i].hash.Sum(nil))
+	return digest[:]
+}
+
+func TestReseedFromPoolsSchedule(t *testing.T) {
+	g, _ := newSeededGenerator(t, CoreAESCTR, katSeed)
+
+	for reseed := uint64(1); reseed <= 64; reseed++ {
+		for i := range NumberOfPools {
+			fillPool(g, i, MinPoolSize)
+		}
+		events := make([]uint64, NumberOfPools)
+		var seed []byte
+		for i := range NumberOfPools {
+			events[i] = g.pools[i].events
+			if reseed%(uint64(1)<<i) == 0 {
+				seed = append(seed, poolDigest(g, i)...)
+			}
+		}
+		first := sha256.Sum256(append(g.key[:], seed...))
+		want := sha256.Sum256(first[:])
+
+		g.lastPoolReseed = time.Now().Add(-MinReseedInterval)
+		if err := g.ReseedFromPools(); err != nil {
+			t.Fatalf("reseed %d: %v", reseed, err)
+		}
+
+		// Pool i is drained on every reseed whose number is divisible by 2^i
+		if g.GetReseedCount() != reseed {
+			t.Fatalf("reseed count is %d, want %d", g.GetReseedCount(), reseed)
+		}
+		if g.key != want {
+			t.Errorf("reseed %d: key is not SHAd-256 of the key and the scheduled pool digests", reseed)
+		}
+		for i := range NumberOfPools {
+			used := reseed%(uint64(1)<<i) == 0
+			if drained := g.pools[i].events == 0; drained != used {
+				t.Errorf("reseed %d: pool %d drained %v, want %v", reseed, i, drained, used)
+			}
+			if !used && g.pools[i].events != events[i] {
+				t.Errorf("reseed %d: pool %d changed without being used", reseed, i)
+			}
+		}
+	}
+}
+
+func TestReseedFromPoolsNotDue(t *testing.T) {
+	g, _ := newSeededGenerator(t, CoreAESCTR, katSeed)
+	g.lastPoolReseed = time.Now().Add(-MinReseedInterval)
+
+	// Pool 0 below the minimum size
+	fillPool(g, 0, MinPoolSize-2)
+	if g.pools[0].bytes != MinPoolSize-2 {
+		t.Fatalf("pool 0 holds %d bytes, want %d", g.pools[0].bytes, MinPoolSize-2)
+	}
+	if err := g.ReseedFromPools(); !errors.Is(err, ErrReseedNotDue) {
+		t.Errorf("pool 0 below the minimum size: got %v, want %v", err, ErrReseedNotDue)
+	}
+	if _, err := g.GenerateRandomData(32); err != nil {
+		t.Fatal(err)
+	}
+	if g.GetReseedCount() != 0 {
+		t.Errorf("output reseeded from pool 0 below the minimum size")
+	}
+
+	// Within the minimum interval of the last reseed from the pools
+	fillPool(g, 0, MinPoolSize)
+	g.lastPoolReseed = time.Now()
+	if err := g.ReseedFromPools(); !errors.Is(err, ErrReseedNotDue) {
+		t.Errorf("within the minimum interval: got %v, want %v", err, ErrReseedNotDue)
+	}
+	if _, err := g.GenerateRandomData(32); err != nil {
+		t.Fatal(err)
+	}
+	if g.GetReseedCount() != 0 {
+		t.Errorf("output reseeded within the minimum interval")
+	}
+
+	// Both thresholds reached: output reseeds first
+	g.lastPoolReseed = time.Now().Add(-MinReseedInterval)
+	events := g.GetReseedEvents()
+	if _, err := g.GenerateRandomData(32); err != nil {
+		t.Fatal(err)
+	}
+	if g.GetReseedCount() != 1 || g.GetReseedEvents() != events+1 {
+		t.Errorf("got %d reseeds from the pools and %d reseed events, want 1 and %d", g.GetReseedCount(), g.GetReseedEvents(), events+1)
+	}
+	if !g.GetLastReseedTime().Equal(g.lastPoolReseed) {
+		t.Error("the reseed from the pools was not recorded")
+	}
+}
+
+func TestReseedFromPoolsSeedsGenerator(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Uncredited events fill the pool but do not seed the generator
+	for g.pools[0].bytes < MinPoolSize {
+		g.pools[0].add(byte(SourceClient), katReseed, false)
+	}
+	if err := g.ReseedFromPools(); err != nil {
+		t.Fatal(err)
+	}
+	if g.IsSeeded() {
+		t.Fatal("seeded from uncredited events")
+	}
+
+	fillPool(g, 0, MinPoolSize)
+	g.lastPoolReseed = time.Now().Add(-MinReseedInterval)
+	if err := g.ReseedFromPools(); err != nil {
+		t.Fatal(err)
+	}
+	if !g.IsSeeded() {
+		t.Error("not seeded after a reseed with credited events")
+	}
+}
+
+func TestGeneratePredictionResistant(t *testing.T) {
+	for _, c := range []Core{CoreAESCTR, CoreChaCha20} {
+		t.Run(string(c), func(t *testing.T) {
+			g, r := newSeededGenerator(t, c, katSeed)
+			if _, err := g.GenerateRandomData(16); err != nil {
+				t.Fatal(err)
+			}
+			r.generate(t, 16)
+
+			// The output comes from the key after SHAd-256(K || entropy)
+			events := g.GetReseedEvents()
+			data, reseedEvent, err := g.GeneratePredictionResistant(katReseed, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.reseed(katReseed)
+			if want := r.generate(t, 100); !bytes.Equal(data, want) {
+				t.Errorf("output is %x, want %x", data, want)
+			}
+
+			if reseedEvent != events+1 || g.GetReseedEvents() != reseedEvent {
+				t.Errorf("reseed event is %d with %d events, want %d", reseedEvent, g.GetReseedEvents(), events+1)
+			}
+
+			// Each call reseeds again
+			if _, next, err := g.GeneratePredictionResistant(katReseed, 1); err != nil || next != reseedEvent+1 {
+				t.Errorf("next reseed event is %d (%v), want %d", next, err, reseedEvent+1)
+			}
+		})
+	}
+}
+
+func TestGeneratePredictionResistantRefusals(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := g.GetReseedEvents()
+
+	for name, entropy := range map[string][]byte{
+		"no entropy":    nil,
+		"short entropy": katReseed[:MinimumSeedLength-1],
+	} {
+		if data, _, err := g.GeneratePredictionResistant(entropy, 32); err == nil {
+			t.Errorf("%s: returned %x", name, data)
+		}
+	}
+	if _, _, err := g.GeneratePredictionResistant(katReseed, 0); err == nil {
+		t.Error("zero length was accepted")
+	}
+	if g.IsSeeded() || g.GetReseedEvents() != events {
+		t.Error("a refused request reseeded the generator")
+	}
+
+	// Fresh entropy below the seed threshold does not seed the generator
+	g.SetSeedThreshold(uint64(len(katReseed)) + 1)
+	if data, _, err := g.GeneratePredictionResistant(katReseed, 32); !errors.Is(err, ErrNotSeeded) {
+		t.Errorf("got %x, %v, want %v", data, err, ErrNotSeeded)
+	}
+
+	// Enough fresh entropy seeds it
+	g.SetSeedThreshold(uint64(len(katReseed)))
+	if _, _, err := g.GeneratePredictionResistant(katReseed, 32); err != nil {
+		t.Fatal(err)
+	}
+	if !g.IsSeeded() {
+		t.Error("not seeded by a prediction-resistant request with enough entropy")
+	}
+}
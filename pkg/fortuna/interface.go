@@ -0,0 +1,29 @@
+package fortuna
+
+import "time"
+
+// RandomGenerator defines the operations the Fortuna service needs from a
+// generator. It is implemented by Generator and by the SP 800-90A DRBGs in pkg/drbg.
+type RandomGenerator interface {
+	// Name identifies the construction, e.g. "fortuna" or "hmac-drbg"
+	Name() string
+
+	// Entropy input
+	AddRandomData(source SourceID, data []byte) error
+	ReseedFromPools() error
+	Reseed(seeds [][]byte) error
+
+	// Output
+	GenerateRandomData(length int) ([]byte, error)
+	GeneratePredictionResistant(entropy []byte, length int) ([]byte, uint64, error)
+	AmplifyRandomData(seed []byte, outputLength int) ([]byte, error)
+
+	// Health and statistics
+	HealthCheck() bool
+	IsSeeded() bool
+	SetSeedThreshold(bytes uint64)
+	GetLastReseedTime() time.Time
+	GetReseedCount() uint64
+	GetReseedEvents() uint64
+	GetSourceStats() []SourceStats
+}
@@ -0,0 +1,62 @@
+package fortuna
+
+import (
+	"crypto/sha256"
+	"hash"
+)
+
+// pool is an entropy pool backed by a running SHA-256 context, so every event
+// is absorbed without loss no matter how much data the pool receives
+type pool struct {
+	hash          hash.Hash
+	bytes         uint64 // event data absorbed since the pool was last drained
+	creditedBytes uint64 // event data from credited sources since the pool was last drained
+	events        uint64 // events absorbed since the pool was last drained
+	totalBytes    uint64 // event data absorbed since the generator was created
+}
+
+// PoolStats describes the entropy absorbed by a single pool
+type PoolStats struct {
+	Pool       int    `json:"pool"`
+	Bytes      uint64 `json:"bytes"`
+	Credited   uint64 `json:"credited_bytes"`
+	Events     uint64 `json:"events"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// newPool creates an empty entropy pool
+func newPool() *pool {
+	return &pool{hash: sha256.New()}
+}
+
+// add absorbs an event framed as source ID, length and data. Only the data
+// counts towards the pool size; data from credited sources also counts
+// towards the entropy needed to seed the generator.
+func (p *pool) add(source byte, value []byte, credited bool) {
+	// len(value) is bounded by MaxEventSize, so it fits in a single byte
+	header := [2]byte{source, byte(len(value))} // #nosec G115
+	p.hash.Write(header[:])
+	p.hash.Write(value)
+
+	n := uint64(len(value))
+	p.bytes += n
+	p.totalBytes += n
+	p.events++
+
+	if credited {
+		p.creditedBytes += uint64(len(value))
+	}
+}
+
+// drain returns SHAd-256 of everything absorbed and empties the pool
+func (p *pool) drain() []byte {
+	first := p.hash.Sum(nil)
+	digest := sha256.Sum256(first)
+
+	p.hash.Reset()
+	p.bytes = 0
+	p.creditedBytes = 0
+	p.events = 0
+
+	return digest[:]
+}
@@ -0,0 +1,138 @@
+package fortuna
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestPoolFraming(t *testing.T) {
+	p := newPool()
+	p.add(byte(SourceATECC608A), []byte{0x01, 0x02, 0x03}, true)
+	p.add(byte(SourceClient), []byte{0x04}, false)
+
+	// Each event is absorbed as source ID || length || data
+	framed := []byte{
+		byte(SourceATECC608A), 3, 0x01, 0x02, 0x03,
+		byte(SourceClient), 1, 0x04,
+	}
+	first := sha256.Sum256(framed)
+	want := sha256.Sum256(first[:])
+
+	if p.bytes != 4 || p.creditedBytes != 3 || p.events != 2 || p.totalBytes != 4 {
+		t.Errorf("got %d bytes, %d credited, %d events, %d total, want 4, 3, 2, 4", p.bytes, p.creditedBytes, p.events, p.totalBytes)
+	}
+	if got := p.drain(); !bytes.Equal(got, want[:]) {
+		t.Errorf("pool digest is %x, want %x", got, want)
+	}
+
+	// Draining empties the pool but keeps the running total
+	if p.bytes != 0 || p.creditedBytes != 0 || p.events != 0 || p.totalBytes != 4 {
+		t.Errorf("after drain got %d bytes, %d credited, %d events, %d total, want 0, 0, 0, 4", p.bytes, p.creditedBytes, p.events, p.totalBytes)
+	}
+	empty := sha256.Sum256(nil)
+	want = sha256.Sum256(empty[:])
+	if got := p.drain(); !bytes.Equal(got, want[:]) {
+		t.Errorf("drained pool digest is %x, want %x", got, want)
+	}
+}
+
+// TestPoolFramingSeparatesEvents checks that events cannot be split or joined
+// without changing the pool digest
+func TestPoolFramingSeparatesEvents(t *testing.T) {
+	digest := func(source SourceID, events ...[]byte) []byte {
+		p := newPool()
+		for _, event := range events {
+			p.add(byte(source), event, source.Credited())
+		}
+		return p.drain()
+	}
+
+	joined := digest(SourceATECC608A, []byte("abc"))
+	for name, got := range map[string][]byte{
+		"split":  digest(SourceATECC608A, []byte("ab"), []byte("c")),
+		"moved":  digest(SourceATECC608A, []byte("a"), []byte("bc")),
+		"source": digest(SourceOSRNG, []byte("abc")),
+	} {
+		if bytes.Equal(got, joined) {
+			t.Errorf("%s events give the same digest", name)
+		}
+	}
+}
+
+func TestGetPoolStats(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 40 events of 1 to 32 bytes: pools 0-7 receive two events, the rest one
+	for i := range 40 {
+		if err := g.AddRandomEvent(SourceATECC608A, make([]byte, i%MaxEventSize+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.AddRandomEvent(SourceClient, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := g.GetPoolStats()
+	if len(stats) != NumberOfPools {
+		t.Fatalf("got stats for %d pools, want %d", len(stats), NumberOfPools)
+	}
+	for i, s := range stats {
+		want := PoolStats{Pool: i, Bytes: uint64(i + 1), Credited: uint64(i + 1), Events: 1}
+		if i < 8 {
+			want.Bytes += uint64(i + 1)
+			want.Credited = want.Bytes
+			want.Events = 2
+		}
+		if i == 0 {
+			want.Bytes += 5
+			want.Events++
+		}
+		want.TotalBytes = want.Bytes
+		if s != want {
+			t.Errorf("pool %d stats are %+v, want %+v", i, s, want)
+		}
+	}
+
+	// Pool 0 holds 7 bytes, below the minimum, so force the reseed
+	g.pools[0].bytes = MinPoolSize
+	if err := g.ReseedFromPools(); err != nil {
+		t.Fatal(err)
+	}
+	stats = g.GetPoolStats()
+	if s := stats[0]; s.Bytes != 0 || s.Credited != 0 || s.Events != 0 || s.TotalBytes != 7 {
+		t.Errorf("pool 0 stats after the reseed are %+v", s)
+	}
+	if s := stats[1]; s.Events != 2 {
+		t.Errorf("pool 1 was drained by the first reseed: %+v", s)
+	}
+}
+
+func TestAddRandomEventRejectsInvalidEvents(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		source SourceID
+		value  []byte
+	}{
+		"empty":          {SourceATECC608A, nil},
+		"too large":      {SourceATECC608A, make([]byte, MaxEventSize+1)},
+		"unknown source": {SourceID(250), []byte{1}},
+	} {
+		if err := g.AddRandomEvent(tc.source, tc.value); err == nil {
+			t.Errorf("%s event was accepted", name)
+		}
+	}
+
+	for i, s := range g.GetPoolStats() {
+		if s.Events != 0 {
+			t.Errorf("pool %d absorbed %d rejected events", i, s.Events)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package fortuna
+
+import "encoding/binary"
+
+// Read fills p with random data, so the generator can be used wherever an
+// io.Reader such as crypto/rand.Reader is accepted. Small reads are served from
+// an internal buffer that is refilled by a single generator request, so they
+// do not allocate; the buffer is discarded on every reseed. Read returns
+// ErrNotSeeded until the generator has been seeded and is safe for concurrent use.
+func (g *Generator) Read(p []byte) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Large reads bypass the buffer
+	if len(p) >= readBufferSize {
+		if err := g.fillUnlocked(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	n := 0
+	for n < len(p) {
+		buffered, err := g.bufferedUnlocked(len(p) - n)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], buffered)
+		clear(buffered)
+	}
+
+	return n, nil
+}
+
+// readUint64 returns 8 bytes of buffered output as a uint64
+func (g *Generator) readUint64() (uint64, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	buffered, err := g.bufferedUnlocked(8)
+	if err != nil {
+		return 0, err
+	}
+
+	// A short tail is left over when earlier reads were not multiples of 8
+	if len(buffered) < 8 {
+		clear(buffered)
+		if buffered, err = g.bufferedUnlocked(8); err != nil {
+			return 0, err
+		}
+	}
+
+	v := binary.LittleEndian.Uint64(buffered)
+	clear(buffered)
+
+	return v, nil
+}
+
+// bufferedUnlocked takes up to n unread bytes from the read buffer, refilling it
+// when it is empty. The caller must clear the returned bytes after copying them.
+func (g *Generator) bufferedUnlocked(n int) ([]byte, error) {
+	// Apply a due reseed before serving output generated under the old key
+	if g.reseedDueUnlocked() {
+		if err := g.reseedFromPoolsUnlocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.readAvailable == 0 {
+		if err := g.fillUnlocked(g.readBuffer[:]); err != nil {
+			return nil, err
+		}
+		g.readAvailable = readBufferSize
+	}
+
+	start := readBufferSize - g.readAvailable
+	if n > g.readAvailable {
+		n = g.readAvailable
+	}
+	g.readAvailable -= n
+
+	return g.readBuffer[start : start+n], nil
+}
+
+// discardBufferedUnlocked clears any unread output in the read buffer
+func (g *Generator) discardBufferedUnlocked() {
+	clear(g.readBuffer[readBufferSize-g.readAvailable:])
+	g.readAvailable = 0
+}
+
+// Source adapts a Generator to math/rand/v2.Source and math/rand.Source64 for
+// code that wants generator output through the math/rand APIs. It is safe for
+// concurrent use and does not allocate per call.
+type Source struct {
+	generator *Generator
+}
+
+// NewSource returns a Source that draws from g
+func NewSource(g *Generator) *Source {
+	return &Source{generator: g}
+}
+
+// Uint64 returns a uniformly distributed 64-bit value. The math/rand
+// interfaces cannot report errors, so Uint64 panics if the generator fails,
+// for example because it has not been seeded yet; check IsSeeded first.
+func (s *Source) Uint64() uint64 {
+	v, err := s.generator.readUint64()
+	if err != nil {
+		panic("fortuna: source read failed: " + err.Error())
+	}
+	return v
+}
+
+// Int63 returns a non-negative 63-bit value, as required by math/rand.Source
+func (s *Source) Int63() int64 {
+	return int64(s.Uint64() >> 1) // #nosec G115 - the shift leaves 63 bits
+}
+
+// Seed is a no-op. The output comes from the generator and cannot be made
+// reproducible; use a math/rand/v2 PCG or ChaCha8 source for repeatable sequences.
+func (s *Source) Seed(int64) {}
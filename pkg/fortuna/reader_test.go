@@ -0,0 +1,49 @@
+package fortuna
+
+import "testing"
+
+func TestReadDoesNotAllocate(t *testing.T) {
+	for _, c := range Cores {
+		t.Run(string(c), func(t *testing.T) {
+			g, _ := newSeededGenerator(t, c, katSeed)
+			source := NewSource(g)
+			buf := make([]byte, 32)
+
+			// Small reads are served from the read buffer
+			if n := testing.AllocsPerRun(1000, func() {
+				if _, err := g.Read(buf); err != nil {
+					t.Fatal(err)
+				}
+			}); n != 0 {
+				t.Errorf("Read allocates %v times per call", n)
+			}
+
+			if n := testing.AllocsPerRun(1000, func() { source.Uint64() }); n != 0 {
+				t.Errorf("Uint64 allocates %v times per call", n)
+			}
+		})
+	}
+}
+
+// TestChaCha20RefillDoesNotAllocate reads just under a buffer at a time, so
+// every read refills the buffer through the core
+func TestChaCha20RefillDoesNotAllocate(t *testing.T) {
+	g, _ := newSeededGenerator(t, CoreChaCha20, katSeed)
+	source := NewSource(g)
+
+	for _, size := range []int{readBufferSize - 1, readBufferSize, 2 * readBufferSize} {
+		buf := make([]byte, size)
+		if n := testing.AllocsPerRun(100, func() {
+			if _, err := g.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+		}); n != 0 {
+			t.Errorf("Read of %d bytes allocates %v times per call", size, n)
+		}
+	}
+
+	// Every readBufferSize / 8 calls refill the buffer
+	if n := testing.AllocsPerRun(readBufferSize, func() { source.Uint64() }); n != 0 {
+		t.Errorf("Uint64 allocates %v times per call", n)
+	}
+}
@@ -0,0 +1,93 @@
+package fortuna
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lokey/rng-service/internal/atomicfile"
+)
+
+const (
+	// SeedFileSize is the number of bytes stored in the seed file
+	SeedFileSize = 64
+	// seedFileMode restricts the seed file to the owner
+	seedFileMode os.FileMode = 0o600
+)
+
+// UpdateSeedFile mixes the seed file into the generator and immediately
+// replaces it with fresh output. The file is removed before it is credited, so
+// if the rewrite fails the same seed is not used again on the next start.
+func UpdateSeedFile(g RandomGenerator, path string) error {
+	seed, err := readSeedFile(path)
+	if err != nil {
+		return err
+	}
+	defer clear(seed)
+
+	if err := removeSeedFile(path); err != nil {
+		return err
+	}
+
+	if err := g.Reseed([][]byte{seed}); err != nil {
+		return fmt.Errorf("failed to reseed from seed file: %w", err)
+	}
+
+	return WriteSeedFile(g, path)
+}
+
+// WriteSeedFile atomically replaces the seed file with SeedFileSize bytes of generator output
+func WriteSeedFile(g RandomGenerator, path string) error {
+	data, err := g.GenerateRandomData(SeedFileSize)
+	if err != nil {
+		return fmt.Errorf("failed to generate seed file contents: %w", err)
+	}
+	defer clear(data)
+
+	// A crash never leaves a truncated seed file behind
+	if err := atomicfile.Write(path, data, seedFileMode); err != nil {
+		return fmt.Errorf("failed to write seed file: %w", err)
+	}
+	return nil
+}
+
+// readSeedFile reads the seed file, refusing files that other users could read or replace
+func readSeedFile(path string) ([]byte, error) {
+	file, err := os.Open(path) // #nosec G304 - path comes from service configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to open seed file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat seed file: %w", err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("seed file %s is not a regular file", path)
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("seed file %s has insecure permissions %s, expected %s", path, info.Mode().Perm(), seedFileMode)
+	}
+
+	seed := make([]byte, SeedFileSize)
+	if _, err := io.ReadFull(file, seed); err != nil {
+		return nil, fmt.Errorf("failed to read seed file: %w", err)
+	}
+
+	return seed, nil
+}
+
+// removeSeedFile deletes a seed file that has been read, so it cannot be
+// credited twice
+func removeSeedFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove used seed file: %w", err)
+	}
+
+	atomicfile.SyncDir(filepath.Dir(path))
+	return nil
+}
@@ -0,0 +1,90 @@
+package fortuna
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingWriter is a generator whose output cannot be used to rewrite the seed file
+type failingWriter struct {
+	*Generator
+}
+
+func (failingWriter) GenerateRandomData(int) ([]byte, error) {
+	return nil, errors.New("generator failed")
+}
+
+func TestUpdateSeedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fortuna.seed")
+	seed := bytes.Repeat([]byte{0x5a}, SeedFileSize)
+	if err := os.WriteFile(path, seed, seedFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSeedFile(g, path); err != nil {
+		t.Fatal(err)
+	}
+	if !g.IsSeeded() {
+		t.Error("not seeded from the seed file")
+	}
+
+	rewritten, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewritten) != SeedFileSize || bytes.Equal(rewritten, seed) {
+		t.Errorf("seed file not replaced: %x", rewritten)
+	}
+}
+
+func TestUpdateSeedFileFailedRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fortuna.seed")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0x5a}, SeedFileSize), seedFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSeedFile(failingWriter{g}, path); err == nil {
+		t.Fatal("rewrite failure not reported")
+	}
+
+	// The used seed must not be found again on the next start
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("seed file still present after a failed rewrite: %v", err)
+	}
+}
+
+func TestReadSeedFileRejectsLoosePermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fortuna.seed")
+	if err := os.WriteFile(path, make([]byte, SeedFileSize), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile is subject to the umask
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSeedFile(g, path); err == nil {
+		t.Fatal("accepted a seed file readable by other users")
+	}
+	if g.IsSeeded() {
+		t.Error("seeded from a rejected seed file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("rejected seed file was removed: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package fortuna
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Known-answer vectors for the generator, computed from the construction in
+// Ferguson & Schneier, "Practical Cryptography", section 9.4: key and counter
+// start at zero, reseed sets K = SHAd-256(K || s), every request is followed by
+// two extra blocks that become the new key, and requests are split at 2^20 bytes.
+// The ChaCha20 vectors follow the same construction with the RFC 8439 block
+// function, the low 96 bits of the counter as nonce and one counter increment
+// per request.
+var (
+	katSeed = []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
+		0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
+	}
+	katReseed = []byte("LoKey Fortuna known-answer reseed")
+)
+
+// knownAnswer describes one step of the known-answer sequence
+type knownAnswer struct {
+	name   string
+	reseed []byte // reseed before generating, if set
+	length int    // number of bytes to request
+	hashed bool   // compare SHA-256 of the output instead of the output itself
+	want   string // hex-encoded expected value
+}
+
+var knownAnswers = map[Core][]knownAnswer{
+	CoreAESCTR: {
+		{name: "first request", length: 32, want: "d57190d367659b221953f81dcd12b9603d608874564881a102574d3537ed30ed"},
+		{name: "rekeyed request", length: 32, want: "09777c49238afc6379b451a6a29b00002bebc4616a68be685c367acba59e2575"},
+		{name: "partial block after reseed", reseed: katReseed, length: 20, want: "e64143b989766932cbb740124ffeaf8f6c351ebf"},
+		{name: "chunked request", length: MaxRequestSize + 16, hashed: true, want: "2c45f27dab7dfaae267b8c11f2dbf1f1273fb780542d3e2b4eca15382f66a8c5"},
+		{name: "request after chunking", length: 16, want: "583bdb8be1a9ddc500d1daed16b50a0f"},
+	},
+	CoreChaCha20: {
+		{name: "first request", length: 32, want: "e0a254703831c3d19ee7ddcb0d2c3ec5efb64b93bfc72db914329f59b01fbb40"},
+		{name: "rekeyed request", length: 32, want: "deac5e46acc4fa97c71584c2ec475c7e3c430ed9fb6ffd19f6eb4af6b0fb4851"},
+		{name: "partial block after reseed", reseed: katReseed, length: 20, want: "c6c440b35fe6d652c30a0b15a7af422a16d230aa"},
+		{name: "chunked request", length: MaxRequestSize + 16, hashed: true, want: "78ecbf6173ee79f2860395bf09bbe74374a8fec96ad9338379c0eca27ae6e88e"},
+		{name: "request after chunking", length: 16, want: "05978ba8686aeffd726fd422108b98b5"},
+	},
+}
+
+// SelfTest runs the known-answer tests for every core and returns an error if
+// an output sequence differs from the pinned vectors
+func SelfTest() error {
+	for _, c := range Cores {
+		if err := selfTestCore(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selfTestCore runs the known-answer sequence of a single core
+func selfTestCore(c Core) error {
+	g, err := NewGeneratorWithCore(katSeed, c)
+	if err != nil {
+		return fmt.Errorf("self-test %s: failed to create generator: %w", c, err)
+	}
+
+	// The vectors exercise the generator construction, not the seeding policy
+	g.seeded = true
+
+	for _, kat := range knownAnswers[c] {
+		if kat.reseed != nil {
+			if err := g.Reseed([][]byte{kat.reseed}); err != nil {
+				return fmt.Errorf("self-test %s %q: reseed failed: %w", c, kat.name, err)
+			}
+		}
+
+		got, err := g.GenerateRandomData(kat.length)
+		if err != nil {
+			return fmt.Errorf("self-test %s %q: generation failed: %w", c, kat.name, err)
+		}
+
+		if kat.hashed {
+			sum := sha256.Sum256(got)
+			got = sum[:]
+		}
+
+		want, err := hex.DecodeString(kat.want)
+		if err != nil {
+			return fmt.Errorf("self-test %s %q: invalid vector: %w", c, kat.name, err)
+		}
+
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("self-test %s %q: got %x, want %s", c, kat.name, got, kat.want)
+		}
+	}
+
+	return nil
+}
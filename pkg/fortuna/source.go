@@ -0,0 +1,136 @@
+package fortuna
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SourceID identifies an entropy source that feeds events into the pools
+type SourceID byte
+
+// Well-known entropy sources
+const (
+	SourceATECC608A SourceID = iota // ATECC608A hardware TRNG via the controller service
+	SourceOSRNG                     // operating system random number generator
+	SourceClient                    // entropy contributed by clients, e.g. through /amplify
+	SourceHWRNG                     // Linux hardware RNG device via the controller service
+	SourceJitter                    // CPU timing jitter via the controller service, lower assurance
+	SourceEmulator                  // emulated ATECC608A via the controller service, output of the host's crypto/rand
+)
+
+// sourceInfo describes a registered entropy source
+type sourceInfo struct {
+	name     string
+	credited bool // events count as real entropy towards seeding the generator
+}
+
+var (
+	sourceMutex sync.RWMutex
+	sources     = map[SourceID]sourceInfo{
+		SourceATECC608A: {name: "atecc608a", credited: true},
+		SourceOSRNG:     {name: "os-rng", credited: true},
+		SourceClient:    {name: "client", credited: false},
+		SourceHWRNG:     {name: "hwrng", credited: true},
+		SourceJitter:    {name: "jitter", credited: false},
+		SourceEmulator:  {name: "emulator", credited: true},
+	}
+)
+
+// RegisterSource registers an additional entropy source under a stable ID.
+// Events from credited sources count as real entropy when seeding the generator.
+func RegisterSource(id SourceID, name string, credited bool) error {
+	if name == "" {
+		return fmt.Errorf("source name cannot be empty")
+	}
+
+	sourceMutex.Lock()
+	defer sourceMutex.Unlock()
+
+	if existing, ok := sources[id]; ok {
+		return fmt.Errorf("source ID %d already registered as %q", id, existing.name)
+	}
+	for existingID, existing := range sources {
+		if existing.name == name {
+			return fmt.Errorf("source %q already registered with ID %d", name, existingID)
+		}
+	}
+
+	sources[id] = sourceInfo{name: name, credited: credited}
+	return nil
+}
+
+// SourceByName returns the ID of a registered entropy source
+func SourceByName(name string) (SourceID, bool) {
+	sourceMutex.RLock()
+	defer sourceMutex.RUnlock()
+
+	for id, existing := range sources {
+		if existing.name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// String returns the registered name of the source
+func (s SourceID) String() string {
+	sourceMutex.RLock()
+	defer sourceMutex.RUnlock()
+
+	if info, ok := sources[s]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(s))
+}
+
+// Registered reports whether the source has been registered
+func (s SourceID) Registered() bool {
+	sourceMutex.RLock()
+	defer sourceMutex.RUnlock()
+
+	_, ok := sources[s]
+	return ok
+}
+
+// Credited reports whether events from the source count as real entropy
+func (s SourceID) Credited() bool {
+	sourceMutex.RLock()
+	defer sourceMutex.RUnlock()
+
+	return sources[s].credited
+}
+
+// sourceState tracks where a source's next event goes and how much it has contributed
+type sourceState struct {
+	nextPool int
+	events   uint64
+	bytes    uint64
+}
+
+// SourceStats describes the events contributed by a single entropy source
+type SourceStats struct {
+	Source string `json:"source"`
+	ID     byte   `json:"id"`
+	Events uint64 `json:"events"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// sourceStatsUnlocked returns per-source statistics ordered by source ID
+func (g *Generator) sourceStatsUnlocked() []SourceStats {
+	stats := make([]SourceStats, 0, len(g.sources))
+	for id, state := range g.sources {
+		stats = append(stats, SourceStats{
+			Source: id.String(),
+			ID:     byte(id),
+			Events: state.events,
+			Bytes:  state.bytes,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+
+	return stats
+}
@@ -0,0 +1,143 @@
+package fortuna
+
+import (
+	"testing"
+)
+
+func TestSourceRoundRobin(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each source starts at pool 0 and moves on by one pool per event,
+	// independently of the other sources
+	for i := range 2*NumberOfPools + 3 {
+		if err := g.AddRandomEvent(SourceATECC608A, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.AddRandomEvent(SourceClient, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range g.GetPoolStats() {
+		want := uint64(2)
+		if i < 3 {
+			want++
+		}
+		if i == 0 {
+			want++
+		}
+		if s.Events != want {
+			t.Errorf("pool %d absorbed %d events, want %d", i, s.Events, want)
+		}
+	}
+
+	stats := g.GetSourceStats()
+	want := []SourceStats{
+		{Source: "atecc608a", ID: byte(SourceATECC608A), Events: 2*NumberOfPools + 3, Bytes: 2*NumberOfPools + 3},
+		{Source: "client", ID: byte(SourceClient), Events: 1, Bytes: 2},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got stats for %d sources, want %d: %+v", len(stats), len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("source stats %d are %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+}
+
+// TestSingleSampleReachesAllPools checks that one-sample seeding calls from a
+// source reach the pools used only on late reseeds
+func TestSingleSampleReachesAllPools(t *testing.T) {
+	g, err := NewGenerator(katSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := katReseed[:MaxEventSize]
+	for range NumberOfPools {
+		if err := g.AddRandomData(SourceATECC608A, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, s := range g.GetPoolStats() {
+		if s.Credited != MaxEventSize {
+			t.Errorf("pool %d holds %d credited bytes, want %d", i, s.Credited, MaxEventSize)
+		}
+	}
+}
+
+func TestRegisterSource(t *testing.T) {
+	const id = SourceID(200)
+	t.Cleanup(func() {
+		sourceMutex.Lock()
+		delete(sources, id)
+		sourceMutex.Unlock()
+	})
+
+	if id.Registered() {
+		t.Fatalf("source %d is already registered", id)
+	}
+	if err := RegisterSource(id, "test-source", true); err != nil {
+		t.Fatal(err)
+	}
+	if !id.Registered() || !id.Credited() || id.String() != "test-source" {
+		t.Errorf("registered source reports %q, credited %v", id, id.Credited())
+	}
+	if got, ok := SourceByName("test-source"); !ok || got != id {
+		t.Errorf("SourceByName returned %d, %v", got, ok)
+	}
+
+	for name, tc := range map[string]struct {
+		id   SourceID
+		name string
+	}{
+		"duplicate ID":    {id, "other-source"},
+		"duplicate name":  {id + 1, "test-source"},
+		"well-known ID":   {SourceATECC608A, "other-source"},
+		"well-known name": {id + 1, "atecc608a"},
+		"empty name":      {id + 1, ""},
+	} {
+		if err := RegisterSource(tc.id, tc.name, false); err == nil {
+			t.Errorf("%s was registered", name)
+		}
+	}
+
+	// Failed registrations leave the registry unchanged
+	if (id + 1).Registered() || !id.Credited() || SourceATECC608A.String() != "atecc608a" {
+		t.Error("a rejected registration changed the registry")
+	}
+}
+
+func TestWellKnownSources(t *testing.T) {
+	for _, tc := range []struct {
+		id       SourceID
+		name     string
+		credited bool
+	}{
+		{SourceATECC608A, "atecc608a", true},
+		{SourceOSRNG, "os-rng", true},
+		{SourceClient, "client", false},
+		{SourceHWRNG, "hwrng", true},
+		{SourceJitter, "jitter", false},
+		{SourceEmulator, "emulator", true},
+	} {
+		if got, ok := SourceByName(tc.name); !ok || got != tc.id {
+			t.Errorf("%q resolves to %d, %v, want %d", tc.name, got, ok, tc.id)
+		}
+		if tc.id.String() != tc.name || tc.id.Credited() != tc.credited {
+			t.Errorf("source %d is %q, credited %v, want %q, %v", tc.id, tc.id, tc.id.Credited(), tc.name, tc.credited)
+		}
+	}
+
+	if _, ok := SourceByName("unknown"); ok {
+		t.Error("an unknown name resolved")
+	}
+	if SourceID(250).String() != "unknown(250)" || SourceID(250).Credited() {
+		t.Errorf("unregistered source reports %q, credited %v", SourceID(250), SourceID(250).Credited())
+	}
+}
@@ -0,0 +1,229 @@
+// Package healthtest implements the continuous health tests of NIST
+// SP 800-90B section 4.4, the Repetition Count Test and the Adaptive
+// Proportion Test, for a noise source producing 8-bit samples.
+package healthtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultMinEntropy is the min-entropy per 8-bit sample claimed when none is configured
+	DefaultMinEntropy = 7.0
+	// MaxMinEntropy is the largest min-entropy an 8-bit sample can have
+	MaxMinEntropy = 8.0
+
+	// FalsePositiveExponent sets the false positive probability per sample,
+	// alpha = 2^-FalsePositiveExponent. SP 800-90B recommends 2^-20 to 2^-40;
+	// 2^-30 keeps false alarms rare at the sample rates the service sees.
+	FalsePositiveExponent = 30
+
+	// APTWindowSize is the Adaptive Proportion Test window for non-binary samples
+	APTWindowSize = 512
+)
+
+// Errors returned when a continuous health test fails
+var (
+	ErrRepetitionCount    = errors.New("repetition count test failed")
+	ErrAdaptiveProportion = errors.New("adaptive proportion test failed")
+	ErrRepeatedBlock      = errors.New("repeated block test failed")
+)
+
+// RCTCutoff returns the Repetition Count Test cutoff for a claimed
+// min-entropy of h bits per sample: C = 1 + ceil(-log2(alpha) / h)
+func RCTCutoff(h float64) int {
+	return 1 + int(math.Ceil(FalsePositiveExponent/h))
+}
+
+// APTCutoff returns the Adaptive Proportion Test cutoff for a claimed
+// min-entropy of h bits per sample: C = 1 + CRITBINOM(W, 2^-h, 1 - alpha)
+func APTCutoff(h float64) int {
+	return 1 + critBinom(APTWindowSize, math.Exp2(-h), 1-math.Exp2(-FalsePositiveExponent))
+}
+
+// critBinom returns the smallest k for which the binomial distribution with n
+// trials and success probability p has a cumulative probability of at least q
+func critBinom(n int, p, q float64) int {
+	pmf := math.Pow(1-p, float64(n))
+	cdf := pmf
+	for k := 0; k < n; k++ {
+		if cdf >= q {
+			return k
+		}
+		pmf *= float64(n-k) / float64(k+1) * p / (1 - p)
+		cdf += pmf
+	}
+	return n
+}
+
+// Stats describes the state and results of the health tests
+type Stats struct {
+	MinEntropy    float64 `json:"min_entropy"`
+	RCTCutoff     int     `json:"rct_cutoff"`
+	APTCutoff     int     `json:"apt_cutoff"`
+	APTWindow     int     `json:"apt_window"`
+	Samples       uint64  `json:"samples"`
+	RCTFailures   uint64  `json:"rct_failures"`
+	APTFailures   uint64  `json:"apt_failures"`
+	BlockRepeats  uint64  `json:"block_repeats"`
+	LongestRun    int     `json:"longest_run"`    // longest run of identical samples seen
+	MaxProportion int     `json:"max_proportion"` // highest count of one value in an APT window
+}
+
+// Tester runs both continuous health tests over a stream of 8-bit samples.
+// The tests keep their state between calls to Test, so a run of identical
+// samples or an APT window can span blocks. Each block is also compared with
+// the previous one, which catches a source stuck on the same output block;
+// RCT and APT only see that once the block is short compared to the window.
+// A Tester is safe for concurrent use.
+type Tester struct {
+	mutex      sync.Mutex
+	minEntropy float64
+	rctCutoff  int
+	aptCutoff  int
+
+	// Repetition Count Test state
+	rctValue byte
+	rctCount int
+
+	// Adaptive Proportion Test state
+	aptValue    byte
+	aptCount    int
+	aptPosition int // samples seen in the current window, 0 when a new window starts
+
+	lastBlock []byte // previous block, nil after a reset
+
+	samples       uint64
+	rctFailures   uint64
+	aptFailures   uint64
+	blockRepeats  uint64
+	longestRun    int
+	maxProportion int
+}
+
+// New creates a Tester for a noise source with the claimed min-entropy in
+// bits per 8-bit sample
+func New(minEntropy float64) (*Tester, error) {
+	if math.IsNaN(minEntropy) || minEntropy <= 0 || minEntropy > MaxMinEntropy {
+		return nil, fmt.Errorf("claimed min-entropy must be in (0, %g] bits per sample, got %g", MaxMinEntropy, minEntropy)
+	}
+
+	return &Tester{
+		minEntropy: minEntropy,
+		rctCutoff:  RCTCutoff(minEntropy),
+		aptCutoff:  APTCutoff(minEntropy),
+	}, nil
+}
+
+// Test feeds every byte of block to both tests. It returns ErrRepetitionCount,
+// ErrAdaptiveProportion or ErrRepeatedBlock, wrapped with details, as soon as
+// a test fails; the test state is then reset, so later samples are tested
+// from scratch.
+func (t *Tester) Test(block []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(block) > 1 && bytes.Equal(block, t.lastBlock) {
+		t.blockRepeats++
+		t.resetUnlocked()
+		return fmt.Errorf("%w: %d-byte block identical to the previous one", ErrRepeatedBlock, len(block))
+	}
+	t.lastBlock = append(t.lastBlock[:0], block...)
+
+	for _, sample := range block {
+		t.samples++
+
+		if err := t.repetitionCountUnlocked(sample); err != nil {
+			t.rctFailures++
+			t.resetUnlocked()
+			return err
+		}
+
+		if err := t.adaptiveProportionUnlocked(sample); err != nil {
+			t.aptFailures++
+			t.resetUnlocked()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repetitionCountUnlocked implements SP 800-90B section 4.4.1
+func (t *Tester) repetitionCountUnlocked(sample byte) error {
+	if t.rctCount > 0 && sample == t.rctValue {
+		t.rctCount++
+	} else {
+		t.rctValue = sample
+		t.rctCount = 1
+	}
+
+	t.longestRun = max(t.longestRun, t.rctCount)
+
+	if t.rctCount >= t.rctCutoff {
+		return fmt.Errorf("%w: value 0x%02x repeated %d times (cutoff %d)", ErrRepetitionCount, sample, t.rctCount, t.rctCutoff)
+	}
+	return nil
+}
+
+// adaptiveProportionUnlocked implements SP 800-90B section 4.4.2: the first
+// sample of each window is counted against the rest of the window
+func (t *Tester) adaptiveProportionUnlocked(sample byte) error {
+	if t.aptPosition == 0 {
+		t.aptValue = sample
+		t.aptCount = 1
+	} else if sample == t.aptValue {
+		t.aptCount++
+	}
+
+	t.aptPosition++
+	t.maxProportion = max(t.maxProportion, t.aptCount)
+
+	if t.aptCount >= t.aptCutoff {
+		return fmt.Errorf("%w: value 0x%02x seen %d times in %d samples (cutoff %d)", ErrAdaptiveProportion, t.aptValue, t.aptCount, t.aptPosition, t.aptCutoff)
+	}
+
+	if t.aptPosition == APTWindowSize {
+		t.aptPosition = 0
+	}
+	return nil
+}
+
+// Reset discards the running test state, e.g. after the noise source has been
+// restarted. Counters are kept.
+func (t *Tester) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.resetUnlocked()
+}
+
+func (t *Tester) resetUnlocked() {
+	t.rctCount = 0
+	t.aptCount = 0
+	t.aptPosition = 0
+	clear(t.lastBlock)
+	t.lastBlock = nil
+}
+
+// Stats returns the cutoffs and counters of the tests
+func (t *Tester) Stats() Stats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return Stats{
+		MinEntropy:    t.minEntropy,
+		RCTCutoff:     t.rctCutoff,
+		APTCutoff:     t.aptCutoff,
+		APTWindow:     APTWindowSize,
+		Samples:       t.samples,
+		RCTFailures:   t.rctFailures,
+		APTFailures:   t.aptFailures,
+		BlockRepeats:  t.blockRepeats,
+		LongestRun:    t.longestRun,
+		MaxProportion: t.maxProportion,
+	}
+}
@@ -0,0 +1,237 @@
+package healthtest
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomBytes returns n bytes from a fixed-seed ChaCha8 stream
+func randomBytes(n int) []byte {
+	out := make([]byte, n)
+	_, _ = rand.NewChaCha8([32]byte{1}).Read(out)
+	return out
+}
+
+func newTester(t *testing.T, minEntropy float64) *Tester {
+	t.Helper()
+
+	tester, err := New(minEntropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tester
+}
+
+// TestCutoffs pins the cutoffs at alpha = 2^-30 for W = 512. The APT values
+// were computed with exact binomial arithmetic.
+func TestCutoffs(t *testing.T) {
+	for _, tc := range []struct {
+		minEntropy float64
+		rct, apt   int
+	}{
+		{0.5, 61, 422},
+		{1, 31, 325},
+		{2, 16, 190},
+		{3, 11, 114},
+		{4, 9, 71},
+		{6, 6, 31},
+		{DefaultMinEntropy, 6, 22},
+		{7.5, 5, 19},
+		{MaxMinEntropy, 5, 16},
+	} {
+		if got := RCTCutoff(tc.minEntropy); got != tc.rct {
+			t.Errorf("RCT cutoff at H = %g is %d, want %d", tc.minEntropy, got, tc.rct)
+		}
+		if got := APTCutoff(tc.minEntropy); got != tc.apt {
+			t.Errorf("APT cutoff at H = %g is %d, want %d", tc.minEntropy, got, tc.apt)
+		}
+
+		stats := newTester(t, tc.minEntropy).Stats()
+		if stats.RCTCutoff != tc.rct || stats.APTCutoff != tc.apt || stats.APTWindow != APTWindowSize {
+			t.Errorf("stats at H = %g report cutoffs %d and %d/%d", tc.minEntropy, stats.RCTCutoff, stats.APTCutoff, stats.APTWindow)
+		}
+	}
+}
+
+// TestCritBinom checks the binomial quantile against the non-binary APT
+// cutoffs of SP 800-90B table 2, which are given for alpha = 2^-20
+func TestCritBinom(t *testing.T) {
+	for _, tc := range []struct {
+		minEntropy float64
+		cutoff     int
+	}{
+		{1, 311},
+		{2, 177},
+		{4, 62},
+		{8, 13},
+	} {
+		if got := 1 + critBinom(APTWindowSize, math.Exp2(-tc.minEntropy), 1-math.Exp2(-20)); got != tc.cutoff {
+			t.Errorf("cutoff at H = %g is %d, want %d", tc.minEntropy, got, tc.cutoff)
+		}
+	}
+}
+
+func TestNewRejectsInvalidMinEntropy(t *testing.T) {
+	for _, h := range []float64{0, -1, MaxMinEntropy + 0.1, math.NaN(), math.Inf(1)} {
+		if _, err := New(h); err == nil {
+			t.Errorf("accepted a claimed min-entropy of %g", h)
+		}
+	}
+}
+
+func TestRandomInputPasses(t *testing.T) {
+	tester := newTester(t, DefaultMinEntropy)
+
+	data := randomBytes(1 << 16)
+	for i := 0; i < len(data); i += 32 {
+		if err := tester.Test(data[i : i+32]); err != nil {
+			t.Fatalf("block %d: %v", i/32, err)
+		}
+	}
+
+	stats := tester.Stats()
+	if stats.Samples != uint64(len(data)) || stats.RCTFailures+stats.APTFailures+stats.BlockRepeats != 0 {
+		t.Errorf("stats after random input: %+v", stats)
+	}
+}
+
+func TestStuckInputTripsRCT(t *testing.T) {
+	for _, h := range []float64{1, 4, DefaultMinEntropy, MaxMinEntropy} {
+		tester := newTester(t, h)
+		cutoff := RCTCutoff(h)
+
+		// A run shorter than the cutoff passes, even across blocks
+		for i := 0; i < cutoff-1; i++ {
+			if err := tester.Test([]byte{0x42}); err != nil {
+				t.Fatalf("H = %g: sample %d: %v", h, i, err)
+			}
+		}
+
+		err := tester.Test([]byte{0x42})
+		if !errors.Is(err, ErrRepetitionCount) {
+			t.Fatalf("H = %g: run of %d returned %v, want %v", h, cutoff, err, ErrRepetitionCount)
+		}
+
+		stats := tester.Stats()
+		if stats.RCTFailures != 1 || stats.LongestRun != cutoff {
+			t.Errorf("H = %g: %d RCT failures, longest run %d, want 1 and %d", h, stats.RCTFailures, stats.LongestRun, cutoff)
+		}
+	}
+}
+
+func TestConstantBlockTripsRCT(t *testing.T) {
+	tester := newTester(t, DefaultMinEntropy)
+
+	if err := tester.Test(make([]byte, 32)); !errors.Is(err, ErrRepetitionCount) {
+		t.Fatalf("all-zero block returned %v, want %v", err, ErrRepetitionCount)
+	}
+	if stats := tester.Stats(); stats.Samples != uint64(RCTCutoff(DefaultMinEntropy)) {
+		t.Errorf("failure after %d samples, want %d", stats.Samples, RCTCutoff(DefaultMinEntropy))
+	}
+}
+
+// biased returns n samples in which value is every second one, so that no
+// run exceeds one sample but value makes up half of every window
+func biased(value byte, n int) []byte {
+	out := randomBytes(n)
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = value
+		} else if out[i] == value {
+			out[i] = ^value
+		}
+	}
+	return out
+}
+
+func TestBiasedInputTripsAPT(t *testing.T) {
+	for _, h := range []float64{2, 4, DefaultMinEntropy, MaxMinEntropy} {
+		tester := newTester(t, h)
+		cutoff := APTCutoff(h)
+
+		err := tester.Test(biased(0xA5, APTWindowSize))
+		if !errors.Is(err, ErrAdaptiveProportion) {
+			t.Fatalf("H = %g: biased input returned %v, want %v", h, err, ErrAdaptiveProportion)
+		}
+
+		stats := tester.Stats()
+		if stats.APTFailures != 1 || stats.RCTFailures != 0 || stats.MaxProportion != cutoff {
+			t.Errorf("H = %g: stats %+v, want one APT failure at a proportion of %d", h, stats, cutoff)
+		}
+		// The cutoff-th occurrence of the value is sample 2 * (cutoff - 1)
+		if stats.Samples != uint64(2*(cutoff-1)+1) {
+			t.Errorf("H = %g: failure after %d samples, want %d", h, stats.Samples, 2*(cutoff-1)+1)
+		}
+	}
+}
+
+func TestAPTWindowRestarts(t *testing.T) {
+	tester := newTester(t, DefaultMinEntropy)
+	cutoff := APTCutoff(DefaultMinEntropy)
+
+	// The first value of every window occurs cutoff - 1 times in it, which
+	// passes; counting across windows would fail in the second one
+	for window := range 4 {
+		data := randomBytes(APTWindowSize)
+		value := byte(window)
+		for i := range data {
+			switch {
+			case i%8 == 0 && i/8 < cutoff-1:
+				data[i] = value
+			case data[i] == value:
+				data[i] = ^value
+			}
+		}
+
+		if err := tester.Test(data); err != nil {
+			t.Fatalf("window %d: %v", window, err)
+		}
+	}
+
+	if stats := tester.Stats(); stats.MaxProportion != cutoff-1 {
+		t.Errorf("highest proportion is %d, want %d", stats.MaxProportion, cutoff-1)
+	}
+}
+
+func TestRepeatedBlock(t *testing.T) {
+	tester := newTester(t, DefaultMinEntropy)
+	block := randomBytes(32)
+
+	if err := tester.Test(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.Test(block); !errors.Is(err, ErrRepeatedBlock) {
+		t.Fatalf("repeated block returned %v, want %v", err, ErrRepeatedBlock)
+	}
+
+	// The state was reset, so the same block is not compared with itself again
+	if err := tester.Test(block); err != nil {
+		t.Fatalf("block after the reset: %v", err)
+	}
+
+	if stats := tester.Stats(); stats.BlockRepeats != 1 {
+		t.Errorf("%d block repeats, want 1", stats.BlockRepeats)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tester := newTester(t, DefaultMinEntropy)
+
+	for i := 0; i < RCTCutoff(DefaultMinEntropy)-1; i++ {
+		if err := tester.Test([]byte{0x00}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restarted source starts a new run
+	tester.Reset()
+	if err := tester.Test([]byte{0x00}); err != nil {
+		t.Fatalf("run continued across a reset: %v", err)
+	}
+
+	if stats := tester.Stats(); stats.Samples != uint64(RCTCutoff(DefaultMinEntropy)) {
+		t.Errorf("reset cleared the sample counter: %d samples", stats.Samples)
+	}
+}
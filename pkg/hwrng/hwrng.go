@@ -0,0 +1,319 @@
+// Package hwrng reads random data from a Linux hardware RNG character device
+// such as /dev/hwrng, which the kernel exposes for on-SoC generators (e.g.
+// bcm2835-rng) and USB TRNGs. Output is checked with the same SP 800-90B
+// health tests as the ATECC608A.
+package hwrng
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+const (
+	// DefaultPath is the character device of the kernel's current hardware RNG
+	DefaultPath = "/dev/hwrng"
+
+	// BlockSize is the number of bytes returned by GenerateRandom, the same
+	// as an ATECC608A Random command
+	BlockSize = 32
+
+	// startupSamples is the number of samples the start-up test draws before
+	// the source is declared healthy, the minimum required by SP 800-90B section 4.3
+	startupSamples = 1024
+)
+
+// recoveryInterval is how long a failed source waits before it is reopened
+var recoveryInterval = 30 * time.Second
+
+// State is the state of a hardware RNG source
+type State string
+
+// States of a source. A source whose start-up self-test fails stays in
+// StateSelfTestFailed; one that fails later is reopened every recoveryInterval.
+const (
+	StateHealthy        State = "healthy"
+	StateFailed         State = "failed"
+	StateSelfTestFailed State = "self_test_failed"
+)
+
+// ErrClosed is returned by requests made after Close
+var ErrClosed = errors.New("hardware RNG source closed")
+
+// SelfTestError is returned when the start-up health tests fail
+type SelfTestError struct {
+	Err error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("start-up self-test failed: %v", e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// Source reads blocks from a hardware RNG device. The path may be any file
+// that can be read in a stream, so a regular file or a FIFO can stand in for
+// the device in tests; a FIFO blocks in Open until it has a writer.
+type Source struct {
+	path string
+
+	// Reads from the device, one at a time; they may block while the kernel
+	// gathers more output
+	ioMutex     sync.Mutex
+	file        *os.File
+	healthTests *healthtest.Tester
+
+	// Shared with callers, protected by mutex
+	mutex         sync.Mutex
+	state         State
+	lastError     error
+	failures      uint64 // transitions into a failed state since startup
+	quarantined   uint64 // blocks that failed a health test since startup
+	recoveryTimer *time.Timer
+	closed        bool
+}
+
+// Open opens the device at path and runs the start-up self-test.
+// minEntropy is the claimed min-entropy of the output in bits per byte, which
+// sets the health test cutoffs. A source that fails its self-test is still
+// returned, in StateSelfTestFailed, so its state can be reported.
+func Open(path string, minEntropy float64) (*Source, error) {
+	healthTests, err := healthtest.New(minEntropy)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Source{
+		path:        path,
+		healthTests: healthTests,
+	}
+
+	s.ioMutex.Lock()
+	defer s.ioMutex.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	if err := s.startupTest(); err != nil {
+		s.setFailed(err)
+	} else {
+		s.setState(StateHealthy, nil)
+	}
+
+	return s, nil
+}
+
+// open opens the device file; the caller holds ioMutex
+func (s *Source) open() error {
+	file, err := os.Open(s.path) // #nosec G304 - path comes from service configuration
+	if err != nil {
+		return fmt.Errorf("failed to open hardware RNG: %w", err)
+	}
+	s.file = file
+	return nil
+}
+
+// read reads one block; the caller holds ioMutex
+func (s *Source) read() ([]byte, error) {
+	block := make([]byte, BlockSize)
+	if _, err := io.ReadFull(s.file, block); err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", s.path, err)
+	}
+	return block, nil
+}
+
+// startupTest runs startupSamples bytes through the health tests from a
+// reset state and discards them; the caller holds ioMutex
+func (s *Source) startupTest() error {
+	s.healthTests.Reset()
+
+	for samples := 0; samples < startupSamples; samples += BlockSize {
+		block, err := s.read()
+		if err != nil {
+			return err
+		}
+		err = s.healthTests.Test(block)
+		clear(block)
+		if err != nil {
+			return &SelfTestError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+// GenerateRandom returns BlockSize bytes that passed the continuous health tests
+func (s *Source) GenerateRandom() ([]byte, error) {
+	if state := s.State(); state != StateHealthy {
+		return nil, fmt.Errorf("hardware RNG %s not healthy (state: %s)", s.path, state)
+	}
+
+	s.ioMutex.Lock()
+	defer s.ioMutex.Unlock()
+
+	// The source may have failed while the request waited
+	if state := s.State(); state != StateHealthy {
+		return nil, fmt.Errorf("hardware RNG %s not healthy (state: %s)", s.path, state)
+	}
+
+	block, err := s.read()
+	if err != nil {
+		s.setFailed(err)
+		return nil, err
+	}
+
+	if err := s.healthTests.Test(block); err != nil {
+		s.mutex.Lock()
+		s.quarantined++
+		s.mutex.Unlock()
+		clear(block)
+
+		err = fmt.Errorf("hardware RNG output failed health test: %w", err)
+		s.setFailed(err)
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// setState records the state and the error that caused it
+func (s *Source) setState(state State, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state = state
+	s.lastError = err
+}
+
+// setFailed takes the source out of service. A failed self-test is final;
+// any other failure schedules a recovery attempt.
+func (s *Source) setFailed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastError = err
+	if s.state == StateHealthy || s.state == "" {
+		s.failures++
+	}
+
+	var selfTestErr *SelfTestError
+	if errors.As(err, &selfTestErr) {
+		log.Printf("[ERROR] Hardware RNG %s failed its start-up self-test, source stays unavailable: %v", s.path, err)
+		s.state = StateSelfTestFailed
+		return
+	}
+
+	if s.state != StateFailed {
+		log.Printf("[ERROR] Hardware RNG %s failed, retrying every %v: %v", s.path, recoveryInterval, err)
+	}
+	s.state = StateFailed
+	if !s.closed && s.recoveryTimer == nil {
+		s.recoveryTimer = time.AfterFunc(recoveryInterval, s.recover)
+	}
+}
+
+// recover reopens a failed source and runs the start-up self-test again.
+// Output from before the failure does not count toward the tests.
+func (s *Source) recover() {
+	s.ioMutex.Lock()
+	defer s.ioMutex.Unlock()
+
+	s.mutex.Lock()
+	s.recoveryTimer = nil
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		return
+	}
+
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+
+	if err := s.open(); err != nil {
+		s.setFailed(err)
+		return
+	}
+	if err := s.startupTest(); err != nil {
+		s.setFailed(err)
+		return
+	}
+
+	log.Printf("[WARN] Hardware RNG %s recovered", s.path)
+	s.setState(StateHealthy, nil)
+}
+
+// Close stops recovery and closes the device
+func (s *Source) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	if s.recoveryTimer != nil {
+		s.recoveryTimer.Stop()
+		s.recoveryTimer = nil
+	}
+	s.state = StateFailed
+	s.lastError = ErrClosed
+	s.mutex.Unlock()
+
+	s.ioMutex.Lock()
+	defer s.ioMutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Path returns the path of the device
+func (s *Source) Path() string {
+	return s.path
+}
+
+// State returns the current state of the source
+func (s *Source) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// IsHealthy returns true if the source can serve requests
+func (s *Source) IsHealthy() bool {
+	return s.State() == StateHealthy
+}
+
+// LastError returns the error that last took the source out of service, or nil
+func (s *Source) LastError() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastError
+}
+
+// Failures returns how often the source has failed since startup
+func (s *Source) Failures() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failures
+}
+
+// Quarantined returns the number of blocks withheld because they failed a health test
+func (s *Source) Quarantined() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.quarantined
+}
+
+// HealthTestStats returns the counters of the continuous health tests
+func (s *Source) HealthTestStats() healthtest.Stats {
+	return s.healthTests.Stats()
+}
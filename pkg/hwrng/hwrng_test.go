@@ -0,0 +1,243 @@
+package hwrng
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+// randomBytes returns n bytes from a fixed-seed ChaCha8 stream
+func randomBytes(n int) []byte {
+	out := make([]byte, n)
+	_, _ = rand.NewChaCha8([32]byte{1}).Read(out)
+	return out
+}
+
+// deviceFile writes data to a regular file that stands in for the device
+func deviceFile(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hwrng")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openSource(t *testing.T, path string) *Source {
+	t.Helper()
+
+	s, err := Open(path, healthtest.DefaultMinEntropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// setRecoveryInterval shortens or lengthens recovery for one test
+func setRecoveryInterval(t *testing.T, d time.Duration) {
+	t.Helper()
+
+	interval := recoveryInterval
+	t.Cleanup(func() { recoveryInterval = interval })
+	recoveryInterval = d
+}
+
+func waitForState(t *testing.T, s *Source, state State) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("state is %s, want %s", s.State(), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func recoveryScheduled(s *Source) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.recoveryTimer != nil
+}
+
+func TestOpenGoodData(t *testing.T) {
+	data := randomBytes(startupSamples + 4*BlockSize)
+	s := openSource(t, deviceFile(t, data))
+
+	if !s.IsHealthy() || s.LastError() != nil {
+		t.Fatalf("state is %s (%v), want healthy", s.State(), s.LastError())
+	}
+
+	// The start-up samples are discarded
+	for i := range 4 {
+		block, err := s.GenerateRandom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := startupSamples + i*BlockSize
+		if !bytes.Equal(block, data[offset:offset+BlockSize]) {
+			t.Errorf("block %d does not follow the start-up samples", i)
+		}
+	}
+	if s.Failures() != 0 || s.Quarantined() != 0 {
+		t.Errorf("got %d failures and %d quarantined blocks, want none", s.Failures(), s.Quarantined())
+	}
+}
+
+func TestOpenConstantData(t *testing.T) {
+	s := openSource(t, deviceFile(t, bytes.Repeat([]byte{0xAA}, startupSamples)))
+
+	if s.State() != StateSelfTestFailed {
+		t.Fatalf("state is %s, want %s", s.State(), StateSelfTestFailed)
+	}
+	var selfTestErr *SelfTestError
+	if !errors.As(s.LastError(), &selfTestErr) {
+		t.Errorf("last error is %v, want a SelfTestError", s.LastError())
+	}
+	if s.Failures() != 1 {
+		t.Errorf("got %d failures, want 1", s.Failures())
+	}
+	if recoveryScheduled(s) {
+		t.Error("a failed self-test scheduled recovery")
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a source that failed its self-test served a block")
+	}
+}
+
+func TestOpenMissingDevice(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing"), healthtest.DefaultMinEntropy); err == nil {
+		t.Error("opened a missing device")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	setRecoveryInterval(t, time.Hour)
+
+	data := append(randomBytes(startupSamples), bytes.Repeat([]byte{0x55}, BlockSize)...)
+	s := openSource(t, deviceFile(t, data))
+
+	block, err := s.GenerateRandom()
+	if err == nil {
+		t.Fatalf("a constant block was served: %x", block)
+	}
+	if s.State() != StateFailed {
+		t.Errorf("state is %s, want %s", s.State(), StateFailed)
+	}
+	if s.Quarantined() != 1 || s.Failures() != 1 {
+		t.Errorf("got %d quarantined blocks and %d failures, want 1 and 1", s.Quarantined(), s.Failures())
+	}
+	if s.HealthTestStats().RCTFailures == 0 {
+		t.Error("the repetition count test did not record the failure")
+	}
+	if !recoveryScheduled(s) {
+		t.Error("no recovery was scheduled")
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a failed source served a block")
+	}
+}
+
+func TestShortReadSchedulesRecovery(t *testing.T) {
+	setRecoveryInterval(t, time.Hour)
+
+	for _, tc := range []struct {
+		name  string
+		extra int
+		err   error
+	}{
+		{"EOF", 0, io.EOF},
+		{"short read", BlockSize / 2, io.ErrUnexpectedEOF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := openSource(t, deviceFile(t, randomBytes(startupSamples+tc.extra)))
+
+			if _, err := s.GenerateRandom(); !errors.Is(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if s.State() != StateFailed || !errors.Is(s.LastError(), tc.err) {
+				t.Errorf("state is %s (%v), want %s", s.State(), s.LastError(), StateFailed)
+			}
+			if s.Quarantined() != 0 {
+				t.Errorf("got %d quarantined blocks, want 0", s.Quarantined())
+			}
+			if !recoveryScheduled(s) {
+				t.Error("no recovery was scheduled")
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	setRecoveryInterval(t, 10*time.Millisecond)
+
+	// The device runs dry, is retried while it is still empty and
+	// recovers once it returns data again
+	path := deviceFile(t, randomBytes(startupSamples))
+	s := openSource(t, path)
+
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Fatal("read past the end of the device")
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * recoveryInterval)
+	if s.State() != StateFailed || !recoveryScheduled(s) {
+		t.Fatalf("state is %s, want %s with a retry scheduled", s.State(), StateFailed)
+	}
+
+	if err := os.WriteFile(path, randomBytes(startupSamples+BlockSize), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, s, StateHealthy)
+
+	if _, err := s.GenerateRandom(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Failures() != 1 {
+		t.Errorf("got %d failures, want 1", s.Failures())
+	}
+}
+
+func TestCloseStopsRecovery(t *testing.T) {
+	setRecoveryInterval(t, 10*time.Millisecond)
+
+	path := deviceFile(t, randomBytes(startupSamples))
+	s := openSource(t, path)
+
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Fatal("read past the end of the device")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if recoveryScheduled(s) {
+		t.Error("recovery is still scheduled after Close")
+	}
+
+	// A device that works again must not bring a closed source back
+	if err := os.WriteFile(path, randomBytes(2*startupSamples), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * recoveryInterval)
+
+	if s.State() != StateFailed || !errors.Is(s.LastError(), ErrClosed) {
+		t.Errorf("state is %s (%v), want %s with ErrClosed", s.State(), s.LastError(), StateFailed)
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a closed source served a block")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
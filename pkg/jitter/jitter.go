@@ -0,0 +1,320 @@
+// Package jitter is a pure-software entropy source in the style of the Linux
+// kernel's jitterentropy. It times a memory-bound workload with the
+// high-resolution clock; the variation in those timings, caused by caches,
+// pipelines, interrupts and frequency scaling, is the noise source. Raw
+// samples are checked with the SP 800-90B health tests and conditioned with
+// SHA-256.
+//
+// The entropy of CPU timing depends on the hardware, the load and the
+// virtualization layer and cannot be verified the way a physical noise source
+// can, so this source is of lower assurance than a hardware TRNG.
+package jitter
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lokey/rng-service/pkg/healthtest"
+)
+
+const (
+	// BlockSize is the number of bytes returned by GenerateRandom, one SHA-256 output
+	BlockSize = sha256.Size
+
+	// DefaultMinEntropy is the min-entropy claimed per raw 8-bit sample. It is
+	// deliberately low: a folded timing delta carries a few bits at best, and
+	// much less on a quiet or virtualized machine.
+	DefaultMinEntropy = 1.0
+
+	// conditioningMargin is the extra entropy, in bits, fed into SHA-256 on top
+	// of its 256-bit output, as SP 800-90B section 3.1.5.1.2 requires for
+	// full-entropy output of a vetted conditioning component
+	conditioningMargin = 64
+
+	// memorySize is the size of the buffer the workload walks through, larger
+	// than a typical L1 cache so accesses miss it now and then
+	memorySize = 64 * 1024
+
+	// startupSamples is the number of raw samples the start-up test draws
+	// before the source is declared healthy, as required by SP 800-90B section 4.3
+	startupSamples = 1024
+)
+
+// recoveryInterval is how long a failed source waits before it is tested again
+var recoveryInterval = 30 * time.Second
+
+// State is the state of a jitter entropy source
+type State string
+
+// States of a source. A source whose start-up self-test fails stays in
+// StateSelfTestFailed; one that fails later is tested again every recoveryInterval.
+const (
+	StateHealthy        State = "healthy"
+	StateFailed         State = "failed"
+	StateSelfTestFailed State = "self_test_failed"
+)
+
+// ErrClosed is returned by requests made after Close
+var ErrClosed = errors.New("jitter entropy source closed")
+
+// SelfTestError is returned when the start-up health tests fail, e.g. on a
+// machine whose clock is too coarse to show any jitter
+type SelfTestError struct {
+	Err error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("start-up self-test failed: %v", e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// Source produces conditioned random blocks from CPU timing jitter
+type Source struct {
+	samplesPerBlock int
+
+	// Noise source state, used by one generation at a time
+	genMutex    sync.Mutex
+	memory      []byte
+	position    int
+	healthTests *healthtest.Tester
+
+	// Shared with callers, protected by mutex
+	mutex         sync.Mutex
+	state         State
+	lastError     error
+	failures      uint64 // transitions into a failed state since startup
+	rawSamples    uint64 // raw samples drawn since startup
+	recoveryTimer *time.Timer
+	closed        bool
+}
+
+// New creates a source and runs its start-up self-test. minEntropy is the
+// min-entropy claimed per raw 8-bit sample; it sets the health test cutoffs
+// and how many samples are conditioned into each output block. A source that
+// fails its self-test is still returned, in StateSelfTestFailed, so its state
+// can be reported.
+func New(minEntropy float64) (*Source, error) {
+	healthTests, err := healthtest.New(minEntropy)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Source{
+		samplesPerBlock: int(math.Ceil((BlockSize*8 + conditioningMargin) / minEntropy)),
+		memory:          make([]byte, memorySize),
+		healthTests:     healthTests,
+	}
+
+	s.genMutex.Lock()
+	defer s.genMutex.Unlock()
+
+	if err := s.startupTest(); err != nil {
+		s.setFailed(err)
+	} else {
+		s.setState(StateHealthy, nil)
+	}
+
+	return s, nil
+}
+
+// sample returns one raw 8-bit sample: the folded duration of a memory walk
+// whose length and path depend on earlier timings; the caller holds genMutex
+func (s *Source) sample() byte {
+	start := time.Now()
+
+	// Vary the amount of work with the low bits of the clock, like
+	// jitterentropy's loop shuffling, so the timings do not settle
+	loops := 16 + int(start.UnixNano()&0x0F)
+	for i := 0; i < loops; i++ {
+		s.position = (s.position + 4093 + int(s.memory[s.position])) % memorySize
+		s.memory[s.position]++
+	}
+
+	// The monotonic clock reading is used, so wall clock changes do not matter
+	delta := uint64(time.Since(start))
+
+	// Fold the delta into 8 bits; the variation lives in the low bits
+	return byte(delta ^ delta>>8 ^ delta>>16 ^ delta>>24)
+}
+
+// collect draws n raw samples and runs them through the health tests; the
+// caller holds genMutex
+func (s *Source) collect(n int) ([]byte, error) {
+	raw := make([]byte, n)
+	for i := range raw {
+		raw[i] = s.sample()
+	}
+
+	s.mutex.Lock()
+	s.rawSamples += uint64(n)
+	s.mutex.Unlock()
+
+	if err := s.healthTests.Test(raw); err != nil {
+		clear(raw)
+		return nil, err
+	}
+	return raw, nil
+}
+
+// startupTest runs startupSamples raw samples through the health tests from a
+// reset state and discards them; the caller holds genMutex
+func (s *Source) startupTest() error {
+	s.healthTests.Reset()
+
+	raw, err := s.collect(startupSamples)
+	if err != nil {
+		return &SelfTestError{Err: err}
+	}
+	clear(raw)
+	return nil
+}
+
+// GenerateRandom returns BlockSize bytes: the SHA-256 of enough raw samples
+// that passed the health tests to carry 320 bits of claimed entropy
+func (s *Source) GenerateRandom() ([]byte, error) {
+	if state := s.State(); state != StateHealthy {
+		return nil, fmt.Errorf("jitter entropy source not healthy (state: %s)", state)
+	}
+
+	s.genMutex.Lock()
+	defer s.genMutex.Unlock()
+
+	// The source may have failed while the request waited
+	if state := s.State(); state != StateHealthy {
+		return nil, fmt.Errorf("jitter entropy source not healthy (state: %s)", state)
+	}
+
+	raw, err := s.collect(s.samplesPerBlock)
+	if err != nil {
+		err = fmt.Errorf("jitter entropy failed health test: %w", err)
+		s.setFailed(err)
+		return nil, err
+	}
+
+	block := sha256.Sum256(raw)
+	clear(raw)
+	return block[:], nil
+}
+
+// setState records the state and the error that caused it
+func (s *Source) setState(state State, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state = state
+	s.lastError = err
+}
+
+// setFailed takes the source out of service. A failed self-test is final;
+// a failing health test schedules a new self-test.
+func (s *Source) setFailed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastError = err
+	if s.state == StateHealthy || s.state == "" {
+		s.failures++
+	}
+
+	var selfTestErr *SelfTestError
+	if errors.As(err, &selfTestErr) {
+		log.Printf("[ERROR] Jitter entropy source failed its start-up self-test, source stays unavailable: %v", err)
+		s.state = StateSelfTestFailed
+		return
+	}
+
+	if s.state != StateFailed {
+		log.Printf("[ERROR] Jitter entropy source failed, retrying every %v: %v", recoveryInterval, err)
+	}
+	s.state = StateFailed
+	if !s.closed && s.recoveryTimer == nil {
+		s.recoveryTimer = time.AfterFunc(recoveryInterval, s.recover)
+	}
+}
+
+// recover runs the start-up self-test again on a failed source
+func (s *Source) recover() {
+	s.genMutex.Lock()
+	defer s.genMutex.Unlock()
+
+	s.mutex.Lock()
+	s.recoveryTimer = nil
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		return
+	}
+
+	if err := s.startupTest(); err != nil {
+		s.setFailed(err)
+		return
+	}
+
+	log.Printf("[WARN] Jitter entropy source recovered")
+	s.setState(StateHealthy, nil)
+}
+
+// Close stops recovery; later requests fail with ErrClosed
+func (s *Source) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	if s.recoveryTimer != nil {
+		s.recoveryTimer.Stop()
+		s.recoveryTimer = nil
+	}
+	s.state = StateFailed
+	s.lastError = ErrClosed
+	return nil
+}
+
+// State returns the current state of the source
+func (s *Source) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// IsHealthy returns true if the source can serve requests
+func (s *Source) IsHealthy() bool {
+	return s.State() == StateHealthy
+}
+
+// LastError returns the error that last took the source out of service, or nil
+func (s *Source) LastError() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastError
+}
+
+// Failures returns how often the source has failed since startup
+func (s *Source) Failures() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failures
+}
+
+// RawSamples returns the number of raw timing samples drawn since startup
+func (s *Source) RawSamples() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rawSamples
+}
+
+// SamplesPerBlock returns the number of raw samples conditioned into each block
+func (s *Source) SamplesPerBlock() int {
+	return s.samplesPerBlock
+}
+
+// HealthTestStats returns the counters of the health tests on the raw samples
+func (s *Source) HealthTestStats() healthtest.Stats {
+	return s.healthTests.Stats()
+}
@@ -0,0 +1,201 @@
+package jitter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newSource(t *testing.T) *Source {
+	t.Helper()
+
+	s, err := New(DefaultMinEntropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	if !s.IsHealthy() {
+		t.Fatalf("start-up self-test failed: %v", s.LastError())
+	}
+	return s
+}
+
+// setRecoveryInterval shortens or lengthens recovery for one test
+func setRecoveryInterval(t *testing.T, d time.Duration) {
+	t.Helper()
+
+	interval := recoveryInterval
+	t.Cleanup(func() { recoveryInterval = interval })
+	recoveryInterval = d
+}
+
+func recoveryScheduled(s *Source) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.recoveryTimer != nil
+}
+
+func TestSamplesPerBlock(t *testing.T) {
+	for _, tc := range []struct {
+		minEntropy float64
+		want       int
+	}{
+		{0.5, 640},
+		{1, 320},
+		{3, 107},
+		{7, 46},
+		{8, 40},
+	} {
+		s, err := New(tc.minEntropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.SamplesPerBlock(); got != tc.want {
+			t.Errorf("H = %g conditions %d samples per block, want %d", tc.minEntropy, got, tc.want)
+		}
+		_ = s.Close()
+	}
+}
+
+func TestNewRejectsInvalidMinEntropy(t *testing.T) {
+	for _, minEntropy := range []float64{0, -1, 8.5} {
+		if _, err := New(minEntropy); err == nil {
+			t.Errorf("New accepted min-entropy %g", minEntropy)
+		}
+	}
+}
+
+func TestGenerateRandom(t *testing.T) {
+	s := newSource(t)
+
+	before := s.RawSamples()
+	if before != startupSamples {
+		t.Errorf("start-up test drew %d samples, want %d", before, startupSamples)
+	}
+
+	first, err := s.GenerateRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.GenerateRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != BlockSize || len(second) != BlockSize {
+		t.Fatalf("got blocks of %d and %d bytes, want %d", len(first), len(second), BlockSize)
+	}
+	if string(first) == string(second) {
+		t.Error("two blocks are equal")
+	}
+	if got, want := s.RawSamples()-before, uint64(2*s.SamplesPerBlock()); got != want {
+		t.Errorf("two blocks drew %d samples, want %d", got, want)
+	}
+}
+
+func TestGenerateRandomRejectsUnhealthySource(t *testing.T) {
+	setRecoveryInterval(t, time.Hour)
+
+	s := newSource(t)
+	failure := errors.New("health test failed")
+	s.setFailed(failure)
+
+	if s.State() != StateFailed || !errors.Is(s.LastError(), failure) {
+		t.Fatalf("state is %s (%v), want %s", s.State(), s.LastError(), StateFailed)
+	}
+	if !recoveryScheduled(s) {
+		t.Error("no recovery was scheduled")
+	}
+
+	samples := s.RawSamples()
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a failed source served a block")
+	}
+	if s.RawSamples() != samples {
+		t.Error("a failed source drew samples")
+	}
+	if s.Failures() != 1 {
+		t.Errorf("got %d failures, want 1", s.Failures())
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	setRecoveryInterval(t, 10*time.Millisecond)
+
+	s := newSource(t)
+	s.setFailed(errors.New("health test failed"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.IsHealthy() {
+		if time.Now().After(deadline) {
+			t.Fatalf("state is %s (%v), want healthy", s.State(), s.LastError())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if s.LastError() != nil {
+		t.Errorf("recovered source reports %v", s.LastError())
+	}
+	if _, err := s.GenerateRandom(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelfTestFailureIsFinal(t *testing.T) {
+	setRecoveryInterval(t, 10*time.Millisecond)
+
+	s := newSource(t)
+	s.setFailed(&SelfTestError{Err: errors.New("repetition count test failed")})
+
+	if s.State() != StateSelfTestFailed {
+		t.Fatalf("state is %s, want %s", s.State(), StateSelfTestFailed)
+	}
+	if recoveryScheduled(s) {
+		t.Error("a failed self-test scheduled recovery")
+	}
+
+	time.Sleep(5 * recoveryInterval)
+	if s.State() != StateSelfTestFailed {
+		t.Errorf("state is %s after waiting, want %s", s.State(), StateSelfTestFailed)
+	}
+	var selfTestErr *SelfTestError
+	if !errors.As(s.LastError(), &selfTestErr) {
+		t.Errorf("last error is %v, want a SelfTestError", s.LastError())
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a source that failed its self-test served a block")
+	}
+}
+
+func TestCloseStopsRecovery(t *testing.T) {
+	setRecoveryInterval(t, 10*time.Millisecond)
+
+	s := newSource(t)
+	s.setFailed(errors.New("health test failed"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if recoveryScheduled(s) {
+		t.Error("recovery is still scheduled after Close")
+	}
+
+	time.Sleep(5 * recoveryInterval)
+	if s.State() != StateFailed || !errors.Is(s.LastError(), ErrClosed) {
+		t.Errorf("state is %s (%v), want %s with ErrClosed", s.State(), s.LastError(), StateFailed)
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a closed source served a block")
+	}
+}
+
+func TestCloseHealthySource(t *testing.T) {
+	s := newSource(t)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsHealthy() || !errors.Is(s.LastError(), ErrClosed) {
+		t.Errorf("state is %s (%v) after Close", s.State(), s.LastError())
+	}
+	if _, err := s.GenerateRandom(); err == nil {
+		t.Error("a closed source served a block")
+	}
+}
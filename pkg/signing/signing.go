@@ -0,0 +1,278 @@
+// Package signing signs batches of random values so that a client can prove
+// which key, and so which chip, produced them. A batch holds the values
+// returned by one controller /generate request together with the serial
+// number of the signer, a monotonic counter and a timestamp. It is signed
+// with ECDSA over P-256, as the ATECC608A does with a key that never leaves
+// the chip.
+package signing
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// Algorithm identifies the signature scheme: ECDSA on P-256 over the
+	// SHA-256 of the batch message, encoded as R || S
+	Algorithm = "ecdsa-p256-sha256"
+
+	// messagePrefix starts every batch message, so a signature over a batch
+	// cannot be passed off as a signature over anything else
+	messagePrefix = "LoKey signed batch v1\x00"
+
+	// SignatureSize is the size of an encoded signature, R and S of 32 bytes each
+	SignatureSize = 64
+
+	// PublicKeySize is the size of an uncompressed P-256 point, 04 || X || Y
+	PublicKeySize = 65
+)
+
+// Errors returned when signing or verifying a batch
+var (
+	ErrInvalidSignature  = errors.New("batch signature does not verify")
+	ErrUnknownAlgorithm  = errors.New("unknown signature algorithm")
+	ErrSequenceExhausted = errors.New("batch sequence exhausted, the signer must be restarted to start a new epoch")
+)
+
+// Batch is the signed content of one /generate response
+type Batch struct {
+	Data   []string `json:"data"`   // random values, hex
+	Serial string   `json:"serial"` // serial number of the signer, e.g. the ATECC608A serial
+	// Counter increases with every batch of a signer, across restarts: the high
+	// 32 bits are the epoch the signer started in, the low 32 bits count the
+	// batches signed since. It is a string in JSON, as it exceeds 2^53.
+	Counter   uint64    `json:"counter,string"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SignedBatch is a batch with its signature
+type SignedBatch struct {
+	Batch
+	Algorithm string `json:"algorithm"`
+	Signature string `json:"signature"` // R || S, hex
+}
+
+// Message returns the byte string that is hashed and signed:
+//
+//	"LoKey signed batch v1" 0x00
+//	uint16 length of serial, serial (ASCII)
+//	uint64 counter
+//	int64  timestamp in nanoseconds since the Unix epoch
+//	uint32 number of values
+//	for each value: uint32 length, value bytes
+//
+// with all integers big-endian
+func (b Batch) Message() ([]byte, error) {
+	if len(b.Serial) > math.MaxUint16 {
+		return nil, fmt.Errorf("serial number too long: %d bytes", len(b.Serial))
+	}
+
+	var message bytes.Buffer
+	message.WriteString(messagePrefix)
+	message.Write(binary.BigEndian.AppendUint16(nil, uint16(len(b.Serial)))) // #nosec G115 - checked above
+	message.WriteString(b.Serial)
+	message.Write(binary.BigEndian.AppendUint64(nil, b.Counter))
+	message.Write(binary.BigEndian.AppendUint64(nil, uint64(b.Timestamp.UnixNano()))) // #nosec G115 - two's complement on purpose
+	message.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b.Data))))            // #nosec G115 - at most 100 values
+
+	for i, value := range b.Data {
+		raw, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("value %d is not hex: %w", i, err)
+		}
+		message.Write(binary.BigEndian.AppendUint32(nil, uint32(len(raw)))) // #nosec G115 - values are a few bytes long
+		message.Write(raw)
+	}
+
+	return message.Bytes(), nil
+}
+
+// Digest returns the SHA-256 of the batch message
+func (b Batch) Digest() ([]byte, error) {
+	message, err := b.Message()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(message)
+	return digest[:], nil
+}
+
+// Contains reports whether value is one of the values of the batch
+func (b Batch) Contains(value []byte) bool {
+	encoded := hex.EncodeToString(value)
+	for _, v := range b.Data {
+		if v == encoded {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of a batch against a public key
+func Verify(batch SignedBatch, publicKey *ecdsa.PublicKey) error {
+	if batch.Algorithm != Algorithm {
+		return fmt.Errorf("%w: %q", ErrUnknownAlgorithm, batch.Algorithm)
+	}
+
+	signature, err := hex.DecodeString(batch.Signature)
+	if err != nil || len(signature) != SignatureSize {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+
+	digest, err := batch.Digest()
+	if err != nil {
+		return err
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(publicKey, digest, r, s) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// EncodePublicKey returns a P-256 public key as an uncompressed point, hex
+func EncodePublicKey(publicKey *ecdsa.PublicKey) string {
+	point := make([]byte, PublicKeySize)
+	point[0] = 0x04
+	publicKey.X.FillBytes(point[1:33])
+	publicKey.Y.FillBytes(point[33:])
+	return hex.EncodeToString(point)
+}
+
+// ParsePublicKey decodes an uncompressed P-256 point, hex, and checks that it
+// is on the curve
+func ParsePublicKey(encoded string) (*ecdsa.PublicKey, error) {
+	point, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not hex: %w", err)
+	}
+	return PublicKeyFromPoint(point)
+}
+
+// PublicKeyFromPoint returns the public key of an uncompressed P-256 point,
+// 04 || X || Y, or of the bare 64-byte X || Y that the ATECC608A returns
+func PublicKeyFromPoint(point []byte) (*ecdsa.PublicKey, error) {
+	if len(point) == PublicKeySize-1 {
+		point = append([]byte{0x04}, point...)
+	}
+	if len(point) != PublicKeySize || point[0] != 0x04 {
+		return nil, fmt.Errorf("public key must be an uncompressed P-256 point of %d bytes, got %d", PublicKeySize, len(point))
+	}
+
+	// crypto/ecdh rejects points that are not on the curve
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid P-256 public key: %w", err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}, nil
+}
+
+// PublicKeyInfo describes a signing key, as published by the controller
+type PublicKeyInfo struct {
+	Serial    string `json:"serial"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // uncompressed point, hex
+	Source    string `json:"source"`     // "atecc608a" or "software"
+	Device    string `json:"device,omitempty"`
+	Slot      *int   `json:"slot,omitempty"` // key slot of an ATECC608A
+}
+
+// Signer signs digests with a P-256 key
+type Signer interface {
+	// Serial identifies the key holder, e.g. the serial number of the chip
+	Serial() string
+	PublicKey() *ecdsa.PublicKey
+	// SignDigest signs a SHA-256 digest and returns R || S
+	SignDigest(digest []byte) ([]byte, error)
+}
+
+// BatchSigner signs batches with a Signer and numbers them
+type BatchSigner struct {
+	signer Signer
+	epoch  uint32
+
+	mutex    sync.Mutex
+	sequence uint64
+}
+
+// NewBatchSigner returns a BatchSigner whose counters start at epoch << 32.
+// epoch must be higher than that of any earlier BatchSigner of the same key,
+// e.g. a monotonic counter of the chip incremented at startup.
+func NewBatchSigner(signer Signer, epoch uint32) *BatchSigner {
+	return &BatchSigner{signer: signer, epoch: epoch}
+}
+
+// Signer returns the signer the batches are signed with
+func (b *BatchSigner) Signer() Signer {
+	return b.signer
+}
+
+// Epoch returns the epoch in the high 32 bits of the counters
+func (b *BatchSigner) Epoch() uint32 {
+	return b.epoch
+}
+
+// Sign signs a batch of values
+func (b *BatchSigner) Sign(values [][]byte) (SignedBatch, error) {
+	b.mutex.Lock()
+	if b.sequence > math.MaxUint32 {
+		b.mutex.Unlock()
+		return SignedBatch{}, ErrSequenceExhausted
+	}
+	counter := uint64(b.epoch)<<32 | b.sequence
+	b.sequence++
+	b.mutex.Unlock()
+
+	batch := Batch{
+		Data:      make([]string, len(values)),
+		Serial:    b.signer.Serial(),
+		Counter:   counter,
+		Timestamp: time.Now().UTC(),
+	}
+	for i, value := range values {
+		batch.Data[i] = hex.EncodeToString(value)
+	}
+
+	digest, err := batch.Digest()
+	if err != nil {
+		return SignedBatch{}, err
+	}
+
+	signature, err := b.signer.SignDigest(digest)
+	if err != nil {
+		return SignedBatch{}, err
+	}
+	if len(signature) != SignatureSize {
+		return SignedBatch{}, fmt.Errorf("signer returned a %d-byte signature, expected %d", len(signature), SignatureSize)
+	}
+
+	signed := SignedBatch{
+		Batch:     batch,
+		Algorithm: Algorithm,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	// A faulty signer must not hand out batches that no one can verify
+	if err := Verify(signed, b.signer.PublicKey()); err != nil {
+		return SignedBatch{}, fmt.Errorf("signer %s produced a bad signature: %w", batch.Serial, err)
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,224 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSoftwareSigner(t *testing.T) *SoftwareSigner {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSoftwareSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+var testValues = [][]byte{
+	bytes.Repeat([]byte{0x01}, 32),
+	bytes.Repeat([]byte{0x02}, 32),
+	bytes.Repeat([]byte{0x03}, 32),
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	signer := newSoftwareSigner(t)
+	batches := NewBatchSigner(signer, 7)
+
+	for i := range 3 {
+		signed, err := batches.Sign(testValues)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := uint64(7)<<32 | uint64(i); signed.Counter != want {
+			t.Errorf("batch %d has counter %d, want %d", i, signed.Counter, want)
+		}
+		if signed.Serial != signer.Serial() || signed.Algorithm != Algorithm || len(signed.Data) != len(testValues) {
+			t.Errorf("batch %d is %+v", i, signed)
+		}
+		for _, value := range testValues {
+			if !signed.Contains(value) {
+				t.Errorf("batch %d does not contain %x", i, value)
+			}
+		}
+
+		// Batches are verified after a trip through JSON, as clients see them
+		encoded, err := json.Marshal(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded SignedBatch
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(decoded, signer.PublicKey()); err != nil {
+			t.Errorf("batch %d: %v", i, err)
+		}
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	signer := newSoftwareSigner(t)
+	signed, err := NewBatchSigner(signer, 1).Sign(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(b *SignedBatch)
+		want   error
+	}{
+		{"value", func(b *SignedBatch) { b.Data[1] = hex.EncodeToString(bytes.Repeat([]byte{0x04}, 32)) }, ErrInvalidSignature},
+		{"value removed", func(b *SignedBatch) { b.Data = b.Data[:2] }, ErrInvalidSignature},
+		{"value added", func(b *SignedBatch) { b.Data = append(b.Data, b.Data[0]) }, ErrInvalidSignature},
+		{"values reordered", func(b *SignedBatch) { b.Data[0], b.Data[1] = b.Data[1], b.Data[0] }, ErrInvalidSignature},
+		{"serial", func(b *SignedBatch) { b.Serial = "01234c4b65790001ee" }, ErrInvalidSignature},
+		{"counter", func(b *SignedBatch) { b.Counter++ }, ErrInvalidSignature},
+		{"timestamp", func(b *SignedBatch) { b.Timestamp = b.Timestamp.Add(time.Nanosecond) }, ErrInvalidSignature},
+		{"signature", func(b *SignedBatch) { b.Signature = "00" + b.Signature[2:] }, ErrInvalidSignature},
+		{"short signature", func(b *SignedBatch) { b.Signature = b.Signature[:2*SignatureSize-2] }, ErrInvalidSignature},
+		{"algorithm", func(b *SignedBatch) { b.Algorithm = "ecdsa-p384-sha384" }, ErrUnknownAlgorithm},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tampered := signed
+			tampered.Data = append([]string(nil), signed.Data...)
+			tc.tamper(&tampered)
+
+			if err := Verify(tampered, signer.PublicKey()); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	if err := Verify(signed, newSoftwareSigner(t).PublicKey()); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verified with another key: got %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+// TestMessage pins the layout of the signed message
+func TestMessage(t *testing.T) {
+	batch := Batch{
+		Data:      []string{"aabb", ""},
+		Serial:    "sn",
+		Counter:   0x0102030405060708,
+		Timestamp: time.Unix(0, 0x1112131415161718),
+	}
+
+	message, err := batch.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := messagePrefix + "\x00\x02sn" +
+		"\x01\x02\x03\x04\x05\x06\x07\x08" +
+		"\x11\x12\x13\x14\x15\x16\x17\x18" +
+		"\x00\x00\x00\x02" +
+		"\x00\x00\x00\x02\xaa\xbb" +
+		"\x00\x00\x00\x00"
+	if string(message) != want {
+		t.Errorf("message is %x, want %x", message, want)
+	}
+
+	batch.Data = []string{"not hex"}
+	if _, err := batch.Message(); err == nil {
+		t.Error("message built from a value that is not hex")
+	}
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	batches := NewBatchSigner(newSoftwareSigner(t), 2)
+	batches.sequence = math.MaxUint32
+
+	signed, err := batches.Sign(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(2)<<32 | math.MaxUint32; signed.Counter != want {
+		t.Errorf("last batch has counter %d, want %d", signed.Counter, want)
+	}
+
+	// The next counter would belong to the next epoch
+	if _, err := batches.Sign(testValues); !errors.Is(err, ErrSequenceExhausted) {
+		t.Errorf("got %v, want %v", err, ErrSequenceExhausted)
+	}
+}
+
+// badSigner signs with a different key than it reports
+type badSigner struct {
+	*SoftwareSigner
+	other *SoftwareSigner
+}
+
+func (b badSigner) SignDigest(digest []byte) ([]byte, error) {
+	return b.other.SignDigest(digest)
+}
+
+func TestSignRejectsBadSigner(t *testing.T) {
+	batches := NewBatchSigner(badSigner{newSoftwareSigner(t), newSoftwareSigner(t)}, 1)
+
+	if _, err := batches.Sign(testValues); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestPublicKeyEncoding(t *testing.T) {
+	publicKey := newSoftwareSigner(t).PublicKey()
+	encoded := EncodePublicKey(publicKey)
+
+	parsed, err := ParsePublicKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(publicKey) {
+		t.Error("parsed key differs")
+	}
+
+	// The ATECC608A returns X || Y without the 04 prefix
+	point, _ := hex.DecodeString(encoded)
+	if parsed, err := PublicKeyFromPoint(point[1:]); err != nil || !parsed.Equal(publicKey) {
+		t.Errorf("bare point: %v", err)
+	}
+
+	point[PublicKeySize-1] ^= 0x01
+	if _, err := PublicKeyFromPoint(point); err == nil {
+		t.Error("accepted a point that is not on the curve")
+	}
+	if _, err := PublicKeyFromPoint(point[:40]); err == nil {
+		t.Error("accepted a short point")
+	}
+}
+
+func TestLoadOrCreateSoftwareSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.pem")
+
+	created, isNew, err := LoadOrCreateSoftwareSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Error("key not reported as created")
+	}
+
+	loaded, isNew, err := LoadOrCreateSoftwareSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isNew || loaded.Serial() != created.Serial() || !loaded.PublicKey().Equal(created.PublicKey()) {
+		t.Error("loaded key differs from the one created")
+	}
+}
@@ -0,0 +1,138 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// softwareSerialPrefix marks the serial of a software key, so it can never be
+// mistaken for a chip serial
+const softwareSerialPrefix = "sw-"
+
+// SoftwareSigner signs with a P-256 key held in memory. It stands in for a
+// chip in tests and development; its key can be copied, so its signatures do
+// not prove that values came from any particular device.
+type SoftwareSigner struct {
+	key    *ecdsa.PrivateKey
+	serial string
+}
+
+// NewSoftwareSigner returns a signer for key. Its serial is derived from the
+// public key.
+func NewSoftwareSigner(key *ecdsa.PrivateKey) (*SoftwareSigner, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("signing key must be on P-256, got %s", key.Curve.Params().Name)
+	}
+
+	point, err := hex.DecodeString(EncodePublicKey(&key.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(point)
+
+	return &SoftwareSigner{
+		key:    key,
+		serial: softwareSerialPrefix + hex.EncodeToString(hash[:9]),
+	}, nil
+}
+
+// LoadOrCreateSoftwareSigner reads a PEM-encoded P-256 key from path. If the
+// file does not exist, a new key is generated and written there, so the
+// public key stays the same across restarts.
+func LoadOrCreateSoftwareSigner(path string) (*SoftwareSigner, bool, error) {
+	encoded, err := os.ReadFile(path) // #nosec G304 - path comes from service configuration
+	if errors.Is(err, fs.ErrNotExist) {
+		signer, err := createSoftwareKey(path)
+		return signer, true, err
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, false, fmt.Errorf("signing key %s is not PEM-encoded", path)
+	}
+
+	var key *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed any
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = parsed.(*ecdsa.PrivateKey); !ok {
+				err = fmt.Errorf("not an ECDSA key")
+			}
+		}
+	default:
+		err = fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+
+	signer, err := NewSoftwareSigner(key)
+	return signer, false, err
+}
+
+// createSoftwareKey generates a key and writes it to path, readable by the owner only
+func createSoftwareKey(path string) (*SoftwareSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// O_EXCL: never overwrite a key another process has just written
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304 - path comes from service configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+
+	return NewSoftwareSigner(key)
+}
+
+// Serial returns "sw-" and the first 9 bytes of the SHA-256 of the public key, hex
+func (s *SoftwareSigner) Serial() string {
+	return s.serial
+}
+
+// PublicKey returns the public key
+func (s *SoftwareSigner) PublicKey() *ecdsa.PublicKey {
+	return &s.key.PublicKey
+}
+
+// SignDigest signs a digest and returns R || S
+func (s *SoftwareSigner) SignDigest(digest []byte) ([]byte, error) {
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest)
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, SignatureSize)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return signature, nil
+}